@@ -0,0 +1,185 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cozystack/boot-to-talos/internal/boot"
+	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/network"
+	"github.com/cozystack/boot-to-talos/internal/source"
+)
+
+// runCommand runs a read-only subcommand given as positional arguments.
+func runCommand(args []string) {
+	switch args[0] {
+	case "detect":
+		if len(args) != 2 {
+			cli.Fatalf(cli.ExitUsage, "usage: boot-to-talos detect <image>")
+		}
+		detectCommand(args[1])
+	case "diagnose":
+		if len(args) != 1 {
+			cli.Fatalf(cli.ExitUsage, "usage: boot-to-talos diagnose")
+		}
+		diagnoseCommand()
+	case "check-kexec":
+		if len(args) != 1 {
+			cli.Fatalf(cli.ExitUsage, "usage: boot-to-talos check-kexec")
+		}
+		checkKexecCommand()
+	case "net-info":
+		if len(args) != 1 {
+			cli.Fatalf(cli.ExitUsage, "usage: boot-to-talos net-info")
+		}
+		netInfoCommand()
+	case "net-snapshot":
+		if len(args) > 2 {
+			cli.Fatalf(cli.ExitUsage, "usage: boot-to-talos net-snapshot [file]")
+		}
+		out := "-"
+		if len(args) == 2 {
+			out = args[1]
+		}
+		netSnapshotCommand(out)
+	default:
+		cli.Fatalf(cli.ExitUsage, "unknown command: %s (available: check-kexec, detect, diagnose, net-info, net-snapshot)", args[0])
+	}
+}
+
+// detectCommand prints how an image reference is classified.
+//
+//nolint:forbidigo
+func detectCommand(ref string) {
+	d, err := source.Detect(ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", ref, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Image:   %s\n", ref)
+	fmt.Printf("Type:    %s\n", d.Type)
+	if d.Ref != "" {
+		fmt.Printf("Pull:    %s\n", d.Ref)
+	}
+	fmt.Printf("Reason:  %s\n", d.Reason)
+	fmt.Printf("Handler: %s\n", d.Handler())
+}
+
+// diagnoseCommand prints a kexec readiness report and exits non-zero if
+// kexec cannot work on this host.
+//
+//nolint:forbidigo
+func diagnoseCommand() {
+	checks := boot.DiagnoseKexec()
+	for _, c := range checks {
+		fmt.Printf("[%-4s] %-20s %s\n", c.Status, c.Name, c.Detail)
+	}
+	if !boot.Ready(checks) {
+		fmt.Println("\nkexec is not available on this host")
+		os.Exit(1)
+	}
+	fmt.Println("\nkexec is ready")
+}
+
+// checkKexecCommand prints one line saying whether boot mode can kexec an
+// unsigned kernel and exits with the code of the first blocker, for
+// scripts choosing between boot and install mode.
+//
+//nolint:forbidigo
+func checkKexecCommand() {
+	code, reason := boot.KexecBlocker(boot.DiagnoseKexec())
+	if code == boot.KexecReady {
+		fmt.Printf("ok: %s\n", reason)
+		return
+	}
+	fmt.Printf("blocked: %s\n", reason)
+	os.Exit(code)
+}
+
+// netInfoCommand prints the links seen via netlink, how the default route
+// device is resolved and the network kernel args generated from it with the
+// default answers.
+//
+//nolint:forbidigo
+func netInfoCommand() {
+	info, err := network.CollectNetworkInfo()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collect network info: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tNAME\tKIND\tSLAVE-KIND\tMASTER\tMTU\tSTATE\tMAC\tDETAILS")
+	for i := range info.Links {
+		l := &info.Links[i]
+		master := "-"
+		if m := info.GetLinkByIndex(l.MasterIndex); l.MasterIndex != 0 && m != nil {
+			master = m.Name
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", l.Index, l.Name, dash(l.Kind), dash(l.SlaveKind),
+			master, l.MTU, network.OperStateString(l.OperationalState), dash(l.HardwareAddr.String()), network.LinkDetails(info, l))
+	}
+	w.Flush()
+
+	fmt.Println()
+	dev, gw, err := network.DefaultRoute()
+	if err != nil {
+		var err6 error
+		if dev, gw, err6 = network.DefaultRoute6(); err6 != nil {
+			fmt.Printf("Default route: none (%v, %v)\n", err, err6)
+			return
+		}
+	}
+	fmt.Printf("Default route:   dev %s via %s\n", dev, dash(gw))
+	link := info.GetLinkByName(dev)
+	if link == nil {
+		fmt.Printf("Default route device %s not found via netlink\n", dev)
+		return
+	}
+	resolved := network.ResolveNetworkDevice(info, link)
+	if resolved == nil {
+		resolved = link
+	}
+	fmt.Printf("Resolved device: %s (%s)\n", resolved.Name, dash(resolved.Kind))
+	if vlans := info.GetVLANChain(link); len(vlans) > 0 {
+		var names []string
+		for _, v := range vlans {
+			names = append(names, v.Name)
+		}
+		fmt.Printf("VLAN chain:      %s\n", strings.Join(names, " -> "))
+	}
+	if vid := network.BridgeUplinkVLAN(link, resolved); vid != 0 {
+		fmt.Printf("Bridge VLAN:     %d, tagged on %s\n", vid, resolved.Name)
+	}
+
+	// Show the prompts with their defaults, which explain the generated args.
+	fmt.Println("\nGenerating kernel args with the default answers:")
+	args := network.CollectKernelArgs(network.Options{HostnameFrom: hostnameFromFlag, Yes: true})
+	fmt.Printf("\nKernel args: %s\n", strings.Join(args, " "))
+}
+
+// netSnapshotCommand writes the network state the kernel args are generated
+// from as JSON to path, or to stdout if path is "-", for -dry-run-network.
+func netSnapshotCommand(path string) {
+	snap, err := network.ReadSnapshot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read network snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	if err := network.WriteSnapshot(snap, path); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// dash returns s, or "-" if it is empty.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -4,122 +4,792 @@ package main
 
 import (
 	"flag"
-	"io/fs"
+	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"path"
+	"runtime"
+	"slices"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
 	"github.com/cozystack/boot-to-talos/internal/boot"
 	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/cmdline"
+	"github.com/cozystack/boot-to-talos/internal/efi"
+	"github.com/cozystack/boot-to-talos/internal/host"
 	"github.com/cozystack/boot-to-talos/internal/install"
 	"github.com/cozystack/boot-to-talos/internal/network"
 	"github.com/cozystack/boot-to-talos/internal/source"
+	"github.com/cozystack/boot-to-talos/internal/status"
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
+	"github.com/cozystack/boot-to-talos/internal/types"
 )
 
 //nolint:gochecknoglobals
 var (
-	imageFlag string
-	diskFlag  string
-	modeFlag  string
+	imageFlag   string
+	diskFlag    cli.MultiFlag
+	modeFlag    string
+	efiVarsFlag string
+
+	importHostCmdlineFlag bool
+	configURLFlag         string
+	hostnameFromFlag      string
+	hostnameArgFlag       string
+	ifaceNameFlag         cli.MultiFlag
+	growImageFlag         bool
+	growSizeGiBFlag       uint64
+	linkWaitFlag          time.Duration
+	tmpfsSizeFlag         string
+	loopMaxPartFlag       int
+	haltIfInstalledFlag   bool
+	shutdownFlag          string
+	noKexecUnsafeFlag     bool
+	forceKexecUnsafeFlag  bool
+	tempDirFlag           string
+	boardFlag             string
+	platformFlag          string
+	clientConfigFlag      string
+	noConsoleFlag         bool
+	machineTypeFlag       string
+	printCmdlineFlag      bool
+	configTemplateFlag    string
+	efiFallbackFlag       bool
+	efiDemoteFlag         bool
+	summaryOnlyFlag       bool
+	noCacheFlag           bool
+	postInstallHookFlag   string
+	hookOnErrorFlag       string
+	nocloudSeedFlag       string
+	imagePlatformFlag     string
+	sourceTypeFlag        string
+	efiBackupFlag         string
+	efiRestoreFlag        string
+	rebootMethodFlag      string
+	secureFlag            bool
+	dryRunNetworkFlag     string
+	cpuLevelFlag          string
+	overlayFlag           string
+	statusAddrFlag        string
+	cmdlineDirFlag        string
+	noPlatformInjectFlag  bool
+	outputCompressFlag    string
 )
 
 func init() {
 	flag.StringVar(&imageFlag, "image",
-		"ghcr.io/cozystack/cozystack/talos:v1.11.6", "Talos installer image")
-	flag.StringVar(&diskFlag, "disk", "", "target disk (will be wiped)")
+		"ghcr.io/cozystack/cozystack/talos:v1.11.6", "Talos installer image, or - for a RAW image on stdin (install mode)")
+	flag.Var(&diskFlag, "disk",
+		"target disk (will be wiped); repeat or separate with commas to write the same image to several disks; a regular file, or a new one outside /dev, gets a disk image instead (install mode)")
+	flag.StringVar(&outputCompressFlag, "output-compress", "",
+		"compress the disk image file written to -disk: xz, zst or gz (install mode only)")
 	flag.BoolVar(&cli.YesFlag, "yes", false, "automatic yes to prompts")
 	flag.StringVar(&modeFlag, "mode", "", "mode: boot or install")
+	flag.StringVar(&efiVarsFlag, "efi-vars", install.EFIVarsAuto,
+		"EFI boot entry handling: auto, update or skip (auto skips on QEMU/Proxmox VMs)")
+	flag.StringVar(&efiBackupFlag, "efi-backup", "",
+		"save BootOrder and the Boot#### entries to this file before creating the Talos boot entry; keep it off the target disk (install mode only)")
+	flag.StringVar(&efiRestoreFlag, "efi-restore", "",
+		"write back the EFI boot entries saved with -efi-backup, then exit")
+	flag.BoolVar(&efiFallbackFlag, "efi-fallback", false,
+		"copy the Talos boot loader to the removable-media path \\EFI\\BOOT\\BOOTX64.EFI on the ESP (install mode only)")
+	flag.BoolVar(&efiDemoteFlag, "efi-demote-removable", false,
+		"move the EFI boot entries of the removable disk this system booted from, e.g. the installer USB stick, to the end of BootOrder (install mode only)")
+	flag.BoolVar(&importHostCmdlineFlag, "import-host-cmdline", false,
+		"offer kernel args of the running system (/proc/cmdline) for inclusion")
+	flag.StringVar(&cmdlineDirFlag, "cmdline-dir", "",
+		"directory of *.conf files with kernel args, read in lexical order and added to the extra kernel args; keys of later files replace those of earlier ones")
+	flag.StringVar(&configURLFlag, "config-url", "",
+		"Talos machine config URL, passed as talos.config= and fetched at boot")
+	flag.BoolVar(&noConsoleFlag, "no-console", false,
+		"do not add any console= kernel arg and skip the console questions")
+	flag.StringVar(&hostnameFromFlag, "hostname-from", "",
+		"generate the hostname from the chassis serial or MAC: serial or mac (default: current hostname)")
+	flag.StringVar(&hostnameArgFlag, "hostname-arg", cmdline.HostnameArgAuto,
+		"pass the hostname as talos.hostname= (talos) or in the ip= hostname field (ip); auto uses talos.hostname= when the image's Talos version supports it")
+	flag.Var(&ifaceNameFlag, "iface-name",
+		"<mac>=<name>: use this Talos name for the interface with the MAC in the generated ip=, bond= and vlan= args (repeatable)")
+	flag.DurationVar(&linkWaitFlag, "link-wait", 0,
+		"wait up to this long for a default route with link carrier before detecting network settings, e.g. 60s")
+	flag.BoolVar(&growImageFlag, "grow-image", false,
+		"after writing, move the backup GPT to the end of the disk and grow the last partition (install mode only)")
+	flag.Uint64Var(&growSizeGiBFlag, "grow-size-gib", 0,
+		"with -grow-image, grow the partition table to this size in GiB instead of the whole disk")
+	flag.IntVar(&loopMaxPartFlag, "loop-max-part", 0,
+		"max_part the loop kernel module is loaded with if it is not loaded yet, 0 for the module default (install mode only)")
+	flag.StringVar(&tmpfsSizeFlag, "tmpfs-size", install.TmpfsAuto,
+		"size of the tmpfs used to unpack the installer, e.g. 4G or 50%, or off for a disk-backed directory (default: sized from image and free memory)")
+	flag.BoolVar(&haltIfInstalledFlag, "halt-if-installed", false,
+		"pass talos.halt_if_installed=1: Talos halts instead of booting if it is already installed on a disk")
+	flag.StringVar(&shutdownFlag, "shutdown-action", "",
+		"pass talos.shutdown=: what Talos does on shutdown or fatal errors, halt or poweroff (default: Talos default)")
+	flag.BoolVar(&source.ForbidRedirectDowngrade, "forbid-redirect-downgrade", false,
+		"fail when an https image URL redirects to plain http")
+	flag.StringVar(&clientConfigFlag, "client-config", "",
+		"file with proxy, CA certificate, registry mirror and registry auth settings for image downloads (default: "+source.DefaultClientConfigPath+" if it exists)")
+	flag.StringVar(&source.HTTPCacheDir, "cache-dir", "",
+		"keep images downloaded over HTTP in this directory and reuse them while the server reports them unchanged; must not be on the target disk (default: no cache)")
+	flag.BoolVar(&noCacheFlag, "no-cache", false,
+		"ignore -cache-dir: download the image again and do not cache it")
+	flag.StringVar(&imagePlatformFlag, "image-platform", "",
+		"platform pulled from multi-arch container images, os/arch[/variant], e.g. linux/arm64 (default: the host platform)")
+	flag.StringVar(&sourceTypeFlag, "source-type", "",
+		"take -image as this type instead of detecting it: container, iso or raw")
+	flag.StringVar(&source.UKIGlob, "uki-glob", "",
+		"pattern of the UKI in container image layers, e.g. 'talos-*.efi' or 'opt/*/uki.efi' (default: vmlinuz.efi below an install directory)")
+	flag.StringVar(&source.UKIPrefer, "uki-prefer", "",
+		"pattern, like -uki-glob, of the UKI to use when the image has several: for the pulled architecture in a container image, or at /EFI/BOOT of an ISO or RAW image; without it you are asked, or with -yes the run fails")
+	flag.BoolVar(&noKexecUnsafeFlag, "no-kexec-unsafe", false,
+		"boot mode: only kexec signed kernels, never retry with KEXEC_FILE_LOAD_UNSAFE")
+	flag.BoolVar(&forceKexecUnsafeFlag, "force-kexec-unsafe", false,
+		"boot mode: set KEXEC_FILE_LOAD_UNSAFE on the first kexec attempt")
+	flag.StringVar(&cpuLevelFlag, "cpu-level", "",
+		"x86-64 microarchitecture level (v1-v4) the image needs from the host CPU; v1 disables the check (default: X86_64_LEVEL from the UKI os-release, else v2)")
+	flag.BoolVar(&noPlatformInjectFlag, "no-platform-inject", false,
+		"boot mode: do not add talos.platform=metal when neither the image's command line nor the extra kernel args name a platform")
+	flag.BoolVar(&secureFlag, "secure", false,
+		"boot mode: only boot a UKI whose signature chains to the UEFI db, never with KEXEC_FILE_LOAD_UNSAFE")
+	flag.StringVar(&boardFlag, "board", "",
+		"install for a single-board computer booting via u-boot, e.g. rpi_generic (default: from the image name)")
+	flag.StringVar(&platformFlag, "platform", cmdline.PlatformMetal,
+		"Talos platform to install for, e.g. nocloud or aws; also offers the platform's conventional kernel args ("+
+			strings.Join(cmdline.Platforms(), ", ")+")")
+	flag.StringVar(&nocloudSeedFlag, "nocloud-seed", "",
+		"with -platform nocloud, write this Talos machine config, or a directory with user-data, meta-data and network-config, to a CIDATA partition after the image (install mode only)")
+	flag.StringVar(&overlayFlag, "overlay", "",
+		"directory copied over the extracted installer rootfs before the installer runs, e.g. for an extra storage driver (install mode only)")
+	flag.StringVar(&postInstallHookFlag, "post-install-hook", "",
+		"script run after the image is written, before the reboot; gets BOOT_TO_TALOS_DISK, BOOT_TO_TALOS_DISKS and the mounted ESP in BOOT_TO_TALOS_ESP (install mode only)")
+	flag.StringVar(&hookOnErrorFlag, "post-install-hook-on-error", install.HookOnErrorAbort,
+		"what to do when the post-install hook fails: abort (do not reboot) or reboot")
+	flag.StringVar(&rebootMethodFlag, "reboot-method", install.RebootAuto,
+		"how to reboot after the install: auto (reboot syscall, then sysrq), syscall, sysrq or none (install mode only)")
+	flag.StringVar(&machineTypeFlag, "machine-type", install.MachineTypeWorker,
+		"machine type written to the config passed to the installer: worker or controlplane (install mode only)")
+	flag.StringVar(&configTemplateFlag, "config-template", "",
+		"Go template file for the machine config piped to the installer, rendered with .Disk, .Hostname, .IP, .MachineType and .CACert (install mode only)")
+	flag.BoolVar(&printCmdlineFlag, "print-cmdline", false,
+		"collect the kernel args, print the kernel command line boot mode would use and exit without booting or installing")
+	flag.StringVar(&dryRunNetworkFlag, "dry-run-network", "",
+		"print only the network kernel args generated with the default answers from this network snapshot (see net-snapshot), or - for the running system, and exit")
+	flag.BoolVar(&summaryOnlyFlag, "summary-only", false,
+		"ask all questions first, then show the complete plan (network, EFI and Secure Boot state) and confirm once")
+	flag.StringVar(&statusAddrFlag, "status-addr", "",
+		"serve the phase, progress and error of the run as JSON at http://ADDR/status, e.g. :8080; stopped before the reboot")
+	flag.StringVar(&tempDirFlag, "temp-dir", "",
+		"directory for downloads and extracted images (default: $"+tempdir.EnvVar+", $TMPDIR or /tmp)")
 }
 
 func main() {
 	var extra cli.MultiFlag
-	sizeGiB := flag.Uint64("image-size-gib", 3, "image.raw size (GiB)")
+	sizeGiB := flag.Uint64("image-size-gib", 3, "image.raw size (GiB), raise for images with many system extensions")
 	flag.Var(&extra, "extra-kernel-arg", "extra kernel arg (repeatable)")
 	flag.Parse()
 
+	// Before the commands, so that detect shows the forced type.
+	if sourceTypeFlag != "" {
+		t, err := source.ParseType(sourceTypeFlag)
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -source-type: %v", err)
+		}
+		source.ForcedType = &t
+	}
+
+	if flag.NArg() > 0 {
+		runCommand(flag.Args())
+		return
+	}
+
+	if dryRunNetworkFlag != "" {
+		dryRunNetwork(dryRunNetworkFlag)
+		return
+	}
+
+	if err := host.CheckPlatform(); err != nil {
+		cli.Fatalf(cli.ExitHost, "%v", err)
+	}
+
+	if efiRestoreFlag != "" {
+		if err := efi.RestoreBootEntries(efiRestoreFlag); err != nil {
+			cli.Fatalf(cli.ExitHost, "failed to restore EFI boot entries: %v", err)
+		}
+		return
+	}
+
+	// Prompts read stdin, which carries the image with -image -.
+	if imageFlag == source.StdinRef {
+		if !cli.YesFlag {
+			cli.Fatalf(cli.ExitUsage, "-image - reads the image from stdin and requires -yes")
+		}
+		switch modeFlag {
+		case "":
+			modeFlag = "install"
+		case "boot":
+			cli.Fatalf(cli.ExitUsage, "-image - is only supported in install mode: boot mode needs a seekable image")
+		}
+	}
+
 	// If mode is not specified, ask as first question
-	if modeFlag == "" {
-		modeFlag = cli.AskMode()
+	if modeFlag == "" && !printCmdlineFlag {
+		modeFlag = cli.AskMode(efi.IsUEFIBoot())
 	} else {
 		// Check validity of specified mode
 		if modeFlag != "boot" && modeFlag != "install" {
-			log.Fatalf("invalid mode: %s (must be 'boot' or 'install')", modeFlag)
+			cli.Fatalf(cli.ExitUsage, "invalid mode: %s (must be 'boot' or 'install')", modeFlag)
+		}
+	}
+
+	switch hostnameFromFlag {
+	case network.HostnameFromSystem, network.HostnameFromSerial, network.HostnameFromMAC:
+	default:
+		cli.Fatalf(cli.ExitUsage, "invalid -hostname-from: %s (must be 'serial' or 'mac')", hostnameFromFlag)
+	}
+
+	if loopMaxPartFlag < 0 || loopMaxPartFlag > 256 {
+		cli.Fatalf(cli.ExitUsage, "invalid -loop-max-part: %d (must be between 0 and 256)", loopMaxPartFlag)
+	}
+	if !install.ValidTmpfsSize(tmpfsSizeFlag) {
+		cli.Fatalf(cli.ExitUsage, "invalid -tmpfs-size: %q (use a size like 4G or 50%%, or off)", tmpfsSizeFlag)
+	}
+	if cmdlineDirFlag != "" {
+		args, err := cmdline.ReadDropIns(cmdlineDirFlag)
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -cmdline-dir: %v", err)
+		}
+		for _, arg := range args {
+			if !slices.Contains(extra, arg) {
+				extra = append(extra, arg)
+			}
+		}
+	}
+	if outputCompressFlag != "" && !slices.Contains(install.OutputCompressions, outputCompressFlag) {
+		cli.Fatalf(cli.ExitUsage, "invalid -output-compress: %s (must be one of %s)", outputCompressFlag, strings.Join(install.OutputCompressions, ", "))
+	}
+	if growSizeGiBFlag != 0 && !growImageFlag {
+		cli.Fatalf(cli.ExitUsage, "-grow-size-gib requires -grow-image")
+	}
+	tempdir.Set(tempDirFlag)
+	if tempdir.Configured() {
+		if fi, err := os.Stat(tempdir.Dir()); err != nil || !fi.IsDir() {
+			cli.Fatalf(cli.ExitUsage, "invalid -temp-dir: %s is not a directory", tempdir.Dir())
+		}
+	}
+	if noCacheFlag {
+		source.HTTPCacheDir = ""
+	}
+	if _, err := path.Match(source.UKIGlob, ""); err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid -uki-glob: %q: %v", source.UKIGlob, err)
+	}
+	if _, err := path.Match(source.UKIPrefer, ""); err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid -uki-prefer: %q: %v", source.UKIPrefer, err)
+	}
+	if imagePlatformFlag != "" {
+		p, err := source.ParseImagePlatform(imagePlatformFlag)
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -image-platform: %v", err)
+		}
+		if p.Architecture != runtime.GOARCH {
+			if modeFlag == "boot" {
+				cli.Fatalf(cli.ExitUsage, "-image-platform %s: boot mode cannot kexec a %s kernel on this %s host", imagePlatformFlag, p.Architecture, runtime.GOARCH)
+			}
+			log.Printf("warning: -image-platform %s differs from this %s host; the installer only runs with binfmt emulation", imagePlatformFlag, runtime.GOARCH)
+		}
+		source.ImagePlatform = p
+	}
+	var x86Level int
+	if cpuLevelFlag != "" {
+		level, err := host.ParseX86Level(cpuLevelFlag)
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -cpu-level: %v", err)
+		}
+		x86Level = level
+	}
+	if noKexecUnsafeFlag && forceKexecUnsafeFlag {
+		cli.Fatalf(cli.ExitUsage, "-no-kexec-unsafe and -force-kexec-unsafe are mutually exclusive")
+	}
+	if secureFlag && forceKexecUnsafeFlag {
+		cli.Fatalf(cli.ExitUsage, "-secure and -force-kexec-unsafe are mutually exclusive")
+	}
+
+	if _, ok := cmdline.PlatformArgs(platformFlag); !ok {
+		cli.Fatalf(cli.ExitUsage, "invalid -platform: %s (supported: %s)", platformFlag, strings.Join(cmdline.Platforms(), ", "))
+	}
+	platformFlag = strings.ToLower(platformFlag)
+
+	switch machineTypeFlag {
+	case install.MachineTypeWorker, install.MachineTypeControlPlane:
+	default:
+		cli.Fatalf(cli.ExitUsage, "invalid -machine-type: %s (must be 'worker' or 'controlplane')", machineTypeFlag)
+	}
+
+	switch hookOnErrorFlag {
+	case install.HookOnErrorAbort, install.HookOnErrorReboot:
+	default:
+		cli.Fatalf(cli.ExitUsage, "invalid -post-install-hook-on-error: %s (must be 'abort' or 'reboot')", hookOnErrorFlag)
+	}
+	if !slices.Contains(install.RebootMethods, rebootMethodFlag) {
+		cli.Fatalf(cli.ExitUsage, "invalid -reboot-method: %s (must be one of %s)", rebootMethodFlag, strings.Join(install.RebootMethods, ", "))
+	}
+	if postInstallHookFlag != "" {
+		if fi, err := os.Stat(postInstallHookFlag); err != nil || fi.IsDir() || fi.Mode()&0o111 == 0 {
+			cli.Fatalf(cli.ExitUsage, "invalid -post-install-hook: %s is not an executable file", postInstallHookFlag)
+		}
+	}
+
+	var nocloudSeed install.NocloudSeed
+	if overlayFlag != "" {
+		if modeFlag != "install" {
+			cli.Fatalf(cli.ExitUsage, "-overlay is only supported in install mode")
+		}
+		if err := install.CheckOverlay(overlayFlag); err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -overlay: %v", err)
+		}
+	}
+
+	if nocloudSeedFlag != "" {
+		switch {
+		case modeFlag != "install":
+			cli.Fatalf(cli.ExitUsage, "-nocloud-seed is only supported in install mode")
+		case platformFlag != "nocloud":
+			cli.Fatalf(cli.ExitUsage, "-nocloud-seed requires -platform nocloud")
+		case growImageFlag:
+			cli.Fatalf(cli.ExitUsage, "-nocloud-seed and -grow-image are mutually exclusive: the seed partition goes after the last image partition")
+		}
+		var err error
+		nocloudSeed, err = install.LoadNocloudSeed(nocloudSeedFlag)
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -nocloud-seed: %v", err)
+		}
+	}
+
+	var configTemplate *template.Template
+	if configTemplateFlag != "" {
+		var err error
+		configTemplate, err = install.ParseConfigTemplate(configTemplateFlag)
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -config-template: %v", err)
 		}
 	}
 
+	switch efiVarsFlag {
+	case install.EFIVarsAuto, install.EFIVarsUpdate, install.EFIVarsSkip:
+	default:
+		cli.Fatalf(cli.ExitUsage, "invalid -efi-vars: %s (must be 'auto', 'update' or 'skip')", efiVarsFlag)
+	}
+
+	if efiBackupFlag != "" && modeFlag != "install" {
+		cli.Fatalf(cli.ExitUsage, "-efi-backup only applies to install mode")
+	}
+	if outputCompressFlag != "" && modeFlag != "install" {
+		cli.Fatalf(cli.ExitUsage, "-output-compress only applies to install mode")
+	}
+	if secureFlag && modeFlag != "boot" {
+		cli.Fatalf(cli.ExitUsage, "-secure only applies to boot mode; in install mode the firmware verifies the installed image")
+	}
+
+	loadClientConfig()
+
 	if imageFlag == flag.Lookup("image").DefValue {
 		imageFlag = cli.Ask("Talos installer image", imageFlag)
 	}
 
-	// Detect image source type
-	imgSource, err := source.DetectImageSource(imageFlag)
-	if err != nil {
-		log.Fatalf("failed to detect image source: %v", err)
+	if statusAddrFlag != "" && !printCmdlineFlag {
+		if err := status.Start(statusAddrFlag); err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -status-addr: %v", err)
+		}
 	}
+
+	imgSource := openImageSource()
 	defer imgSource.Close()
 
+	board := boardForImage()
+	if board != "" && modeFlag == "boot" {
+		log.Printf("warning: -board only applies to install mode")
+	}
+	if platformFlag != cmdline.PlatformMetal && (modeFlag == "boot" || imgSource.Type() != types.ImageSourceContainer) {
+		log.Printf("warning: -platform only selects the installer platform for container images in install mode; otherwise the image's own platform is used")
+	}
+
+	talosOpts := cmdline.TalosOptions{
+		HaltIfInstalled: haltIfInstalledFlag,
+		Shutdown:        shutdownFlag,
+	}
+	talosArgs, err := cmdline.TalosArgs(talosOpts, imageFlag)
+	if err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid Talos options: %v", err)
+	}
+	talosHostname, err := cmdline.HostnameArg(hostnameArgFlag, imageFlag)
+	if err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid -hostname-arg: %v", err)
+	}
+	ifaceNames := parseIfaceNames()
+
 	// For install mode, ask for target disk after image selection
-	if modeFlag == "install" && diskFlag == "" {
-		def := firstDisk()
-		if def == "" {
-			diskFlag = cli.AskRequired("Target disk")
-		} else {
-			diskFlag = cli.Ask("Target disk", def)
+	var disks []string
+	if modeFlag == "install" && !printCmdlineFlag {
+		disks = splitDisks(diskFlag)
+		if len(disks) == 0 {
+			disks = []string{askDisk()}
+		}
+		for i, d := range disks {
+			// Image files are checked by install mode.
+			if install.IsImageFile(d) {
+				continue
+			}
+			if err := blockdev.CheckTarget(d); err != nil {
+				cli.Fatalf(cli.ExitTarget, "refusing to install: %v", err)
+			}
+			// Write to the device node behind /dev/disk/by-id and similar
+			// symlinks; the summary shows the stable name.
+			resolved, err := blockdev.Resolve(d)
+			if err != nil {
+				cli.Fatalf(cli.ExitTarget, "refusing to install: %v", err)
+			}
+			disks[i] = resolved
 		}
 	}
 
 	// Collect kernel args for both modes.
-	for _, e := range network.CollectKernelArgs() {
+	netArgs := network.CollectKernelArgs(network.Options{
+		HostnameFrom:  hostnameFromFlag,
+		LinkWait:      linkWaitFlag,
+		NoConsole:     noConsoleFlag,
+		TalosHostname: talosHostname,
+		IfaceNames:    ifaceNames,
+	})
+	for _, e := range netArgs {
+		// e.g. console=tty0 given with -extra-kernel-arg as well
+		if slices.Contains(extra, e) {
+			continue
+		}
 		extra = append(extra, e)
 	}
+	for _, e := range platformArgs(extra) {
+		if !slices.Contains(extra, e) {
+			extra = append(extra, e)
+		}
+	}
+	if importHostCmdlineFlag {
+		for _, e := range cmdline.CollectHostArgs() {
+			extra = append(extra, e)
+		}
+	}
+	if arg := configURLArg(); arg != "" {
+		extra = append(extra, arg)
+	}
+	extra = append(extra, talosArgs...)
+
+	if printCmdlineFlag {
+		printCmdline(imgSource, extra)
+		return
+	}
 
 	// Run selected mode
 	if modeFlag == "boot" {
-		boot.RunBootMode(imgSource, []string(extra))
+		boot.RunBootMode(imgSource, boot.Options{
+			ExtraArgs:        []string(extra),
+			KexecUnsafe:      kexecUnsafeMode(),
+			Secure:           secureFlag,
+			X86Level:         x86Level,
+			NoPlatformInject: noPlatformInjectFlag,
+			Talos:            talosOpts,
+			Plan:             summaryOnlyFlag,
+		})
 		return
 	}
 
-	// Installation mode
-	install.RunInstallMode(imgSource, diskFlag, []string(extra), *sizeGiB)
+	// Installation mode. The installed image is not read before the
+	// summary, the level is checked against the default.
+	if x86Level == 0 {
+		x86Level = host.DefaultX86Level
+	}
+	if source.ImagePlatform != nil && source.ImagePlatform.Architecture != runtime.GOARCH {
+		x86Level = 0
+	}
+	install.RunInstallMode(imgSource, install.Options{
+		Disk:           disks[0],
+		Mirrors:        disks[1:],
+		ExtraArgs:      []string(extra),
+		SizeGiB:        *sizeGiB,
+		EFIVars:        efiVarsFlag,
+		EFIBackup:      efiBackupFlag,
+		EFIFallback:    efiFallbackFlag,
+		EFIDemote:      efiDemoteFlag,
+		GrowImage:      growImageFlag,
+		GrowSize:       int64(growSizeGiBFlag) << 30,
+		TmpfsSize:      tmpfsSizeFlag,
+		LoopMaxPart:    loopMaxPartFlag,
+		Board:          board,
+		Overlay:        overlayFlag,
+		CacheDir:       source.HTTPCacheDir,
+		X86Level:       x86Level,
+		Platform:       platformFlag,
+		MachineType:    machineTypeFlag,
+		ConfigTemplate: configTemplate,
+		Talos:          talosOpts,
+		Plan:           summaryOnlyFlag,
+		NocloudSeed:    nocloudSeed,
+
+		PostInstallHook:        postInstallHookFlag,
+		PostInstallHookOnError: hookOnErrorFlag,
+		RebootMethod:           rebootMethodFlag,
+		OutputCompress:         outputCompressFlag,
+	})
+}
+
+// loadClientConfig applies -client-config, or the default client config if
+// it exists.
+func loadClientConfig() {
+	path := clientConfigFlag
+	if path == "" {
+		if _, err := os.Stat(source.DefaultClientConfigPath); err != nil {
+			return
+		}
+		path = source.DefaultClientConfigPath
+	}
+	cfg, err := source.LoadClientConfig(path)
+	if err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid client config: %v", err)
+	}
+	if err := source.ApplyClientConfig(cfg); err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid client config %s: %v", path, err)
+	}
+	log.Printf("using client config %s", path)
+}
+
+// openImageSource detects the image source type and checks that the image is
+// reachable. In interactive mode errors are shown and the image is asked for
+// again; with -yes they are fatal.
+func openImageSource() types.ImageSource {
+	for {
+		imgSource, err := source.DetectImageSource(imageFlag)
+		if err == nil {
+			err = source.Probe(imgSource)
+			if err != nil {
+				imgSource.Close()
+			}
+		}
+		if err == nil {
+			return imgSource
+		}
+		if cli.YesFlag {
+			cli.Fatalf(cli.ExitImage, "failed to open image %s: %v", imageFlag, err)
+		}
+		log.Printf("error: failed to open image %s: %v", imageFlag, err)
+		imageFlag = cli.Ask("Talos installer image", imageFlag)
+	}
 }
 
-// firstDisk returns the first non-removable disk device.
-func firstDisk() string {
-	entries, err := os.ReadDir("/sys/block")
+// printCmdline prints the kernel command line for the image and the
+// collected kernel args to stdout.
+//
+//nolint:forbidigo
+func printCmdline(imgSource types.ImageSource, extra []string) {
+	line, err := boot.KernelCmdline(imgSource, extra, noPlatformInjectFlag)
 	if err != nil {
-		log.Printf("warning: failed to read /sys/block: %v", err)
+		cli.Fatalf(cli.ExitImage, "failed to read the kernel command line of %s: %v", imgSource.Reference(), err)
+	}
+	fmt.Println(line)
+}
+
+// parseIfaceNames parses the -iface-name mappings.
+func parseIfaceNames() map[string]string {
+	names, err := network.ParseIfaceNames(ifaceNameFlag)
+	if err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid -iface-name: %v", err)
+	}
+	return names
+}
+
+// dryRunNetwork prints the network kernel args generated from the snapshot
+// at path, or from the running system if path is "-", on one line to
+// stdout. The prompts with their default answers go to stderr, so that the
+// args can be compared against fixtures.
+//
+//nolint:forbidigo
+func dryRunNetwork(path string) {
+	switch hostnameFromFlag {
+	case network.HostnameFromSystem, network.HostnameFromSerial, network.HostnameFromMAC:
+	default:
+		cli.Fatalf(cli.ExitUsage, "invalid -hostname-from: %s (must be 'serial' or 'mac')", hostnameFromFlag)
+	}
+	talosHostname, err := cmdline.HostnameArg(hostnameArgFlag, imageFlag)
+	if err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid -hostname-arg: %v", err)
+	}
+	ifaceNames := parseIfaceNames()
+
+	var snap *network.Snapshot
+	if path == "-" {
+		snap, err = network.ReadSnapshot()
+	} else {
+		snap, err = network.LoadSnapshot(path)
+	}
+	if err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid -dry-run-network: %v", err)
+	}
+
+	args := network.DryRunKernelArgs(snap, network.Options{
+		HostnameFrom:  hostnameFromFlag,
+		TalosHostname: talosHostname,
+		IfaceNames:    ifaceNames,
+		Out:           os.Stderr,
+	})
+	if args == nil {
+		cli.Fatalf(cli.ExitFailure, "no network kernel args generated")
+	}
+	fmt.Println(strings.Join(args, " "))
+}
+
+// boardForImage returns the board to install for: -board, or the board the
+// image was built for according to its name.
+func boardForImage() string {
+	// Talos v1.7+ installers have no --board, the board support comes
+	// from the overlay baked into the installer image.
+	major, minor, ok := cmdline.TalosVersion(imageFlag)
+	overlays := ok && (major > 1 || major == 1 && minor >= 7)
+
+	board := boardFlag
+	if board != "" && overlays {
+		cli.Fatalf(cli.ExitUsage, "-board is not supported by Talos v1.7+ installers, which replaced it with overlays: use an installer image with the board overlay")
+	}
+	if board == "" {
+		board = source.BoardFromImage(imageFlag)
+		switch {
+		case board != "" && overlays:
+			log.Printf("image %s is built for board %s, supported by its overlay", imageFlag, board)
+			return ""
+		case board != "":
+			log.Printf("image %s is built for board %s", imageFlag, board)
+		}
+	}
+	if board == "" {
 		return ""
 	}
-	for _, e := range entries {
-		name := e.Name()
-		// Skip virtual devices
-		if strings.HasPrefix(name, "loop") ||
-			strings.HasPrefix(name, "ram") ||
-			strings.HasPrefix(name, "fd") ||
-			strings.HasPrefix(name, "dm-") ||
-			strings.HasPrefix(name, "sr") {
-			continue
+	if !slices.Contains(source.Boards, board) {
+		cli.Fatalf(cli.ExitUsage, "invalid -board: %s (supported: %s)", board, strings.Join(source.Boards, ", "))
+	}
+	if runtime.GOARCH != "arm64" {
+		log.Printf("warning: board %s is arm64 but this host is %s", board, runtime.GOARCH)
+	}
+	return board
+}
+
+// platformArgs offers the conventional kernel args of -platform as the
+// default answer, so they can be changed or dropped. Its console= args are
+// left out with -no-console or when extra already chooses a console: the
+// last console= is the kernel's primary console, so they would override it.
+func platformArgs(extra []string) []string {
+	def, _ := cmdline.PlatformArgs(platformFlag)
+	hasConsole := slices.ContainsFunc(extra, func(arg string) bool {
+		return cmdline.Key(arg) == "console"
+	})
+	if noConsoleFlag || hasConsole {
+		def = slices.DeleteFunc(def, func(arg string) bool {
+			return cmdline.Key(arg) == "console"
+		})
+	}
+	if len(def) == 0 {
+		return nil
+	}
+	answer := cli.Ask(fmt.Sprintf("Kernel args for platform %s (or 'none')", platformFlag), strings.Join(def, " "))
+	if strings.EqualFold(answer, "none") {
+		return nil
+	}
+	return cmdline.Split(answer)
+}
+
+// kexecUnsafeMode maps -no-kexec-unsafe and -force-kexec-unsafe to the boot
+// mode setting.
+func kexecUnsafeMode() string {
+	switch {
+	case noKexecUnsafeFlag, secureFlag:
+		return boot.KexecUnsafeNever
+	case forceKexecUnsafeFlag:
+		return boot.KexecUnsafeForce
+	default:
+		return boot.KexecUnsafeAuto
+	}
+}
+
+// configURLArg returns the talos.config= argument for -config-url, asking for
+// the URL interactively if the flag is not set. Returns "" if none is wanted.
+//
+//nolint:forbidigo
+func configURLArg() string {
+	configURL := configURLFlag
+	for {
+		if configURL == "" {
+			configURL = cli.Ask("Talos machine config URL (or 'none')", "none")
 		}
-		base := filepath.Join("/sys/block", name)
-		if _, err := os.Stat(filepath.Join(base, "device")); err != nil {
-			log.Printf("debug: skipping %s: no device symlink", name)
-			continue
+		if strings.EqualFold(configURL, "none") {
+			return ""
 		}
-		b, err := os.ReadFile(filepath.Join(base, "removable"))
-		if err != nil {
-			log.Printf("debug: skipping %s: cannot read removable flag: %v", name, err)
-			continue
+		arg, err := cmdline.ConfigURLArg(configURL)
+		if err == nil {
+			fmt.Printf("Note: the machine config will be fetched from %s at boot, it must be reachable from the node.\n", configURL)
+			return arg
 		}
-		if strings.TrimSpace(string(b)) != "0" {
-			log.Printf("debug: skipping %s: removable device", name)
-			continue
+		if configURLFlag != "" || cli.YesFlag {
+			cli.Fatalf(cli.ExitUsage, "invalid -config-url: %v", err)
+		}
+		log.Printf("error: %v", err)
+		configURL = ""
+	}
+}
+
+// splitDisks returns the disks given with -disk, which may be repeated and
+// take comma-separated lists.
+func splitDisks(values []string) []string {
+	var disks []string
+	for _, v := range values {
+		for d := range strings.SplitSeq(v, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				disks = append(disks, d)
+			}
 		}
-		return "/dev/" + name
 	}
-	log.Printf("warning: no suitable disk found")
-	return ""
+	return disks
 }
 
-// Ensure fs.DirEntry is used (for os.ReadDir).
-var _ fs.DirEntry = nil
+// askDisk lists the candidate disks and asks for the target disk.
+//
+//nolint:forbidigo
+func askDisk() string {
+	disks, err := blockdev.List()
+	if err != nil {
+		log.Printf("warning: failed to list disks: %v", err)
+	}
+	if len(disks) > 0 {
+		fmt.Println("Available disks:")
+		for _, d := range disks {
+			line := fmt.Sprintf("  %-20s %-15s %8.1f GiB  %s", d.Path, d.Type, float64(d.Size)/(1<<30), d.Model)
+			switch {
+			case d.Type == blockdev.TypePath:
+				line += " (path of " + d.Holder + ", not selectable)"
+			case d.Zoned == blockdev.ZonedHostManaged:
+				line += " (host-managed zoned, not selectable)"
+			case d.Zoned != "":
+				line += " (" + d.Zoned + " zoned)"
+			}
+			fmt.Println(strings.TrimRight(line, " "))
+			if d.ByID != "" {
+				fmt.Printf("    %s\n", d.ByID)
+			}
+		}
+	}
+
+	def := blockdev.DefaultDisk(disks)
+	if def == "" {
+		log.Printf("warning: no suitable disk found")
+		return cli.AskRequired("Target disk")
+	}
+	// Offer the stable name, so the answer can be reused in scripts.
+	if stable := blockdev.StableName(def); stable != "" {
+		def = stable
+	}
+	return cli.Ask("Target disk", def)
+}
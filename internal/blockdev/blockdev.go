@@ -0,0 +1,44 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+// gptSignature is the magic at the start of a GPT header.
+//
+//nolint:gochecknoglobals
+var gptSignature = []byte("EFI PART")
+
+// LogicalBlockSize returns the logical sector size of a block device
+// (BLKSSZGET), e.g. 512 or 4096 for 4Kn disks.
+func LogicalBlockSize(device string) (int, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return 0, errors.Wrapf(err, "open %s", device)
+	}
+	defer f.Close()
+
+	size, err := unix.IoctlGetInt(int(f.Fd()), unix.BLKSSZGET)
+	if err != nil {
+		return 0, errors.Wrapf(err, "BLKSSZGET %s", device)
+	}
+	return size, nil
+}
+
+// GPTSectorSize returns the logical sector size a GPT disk image was laid out
+// for, by looking for the GPT header at LBA 1. header must hold at least the
+// first 4104 bytes of the image. Returns 0 if no GPT header is found.
+func GPTSectorSize(header []byte) int {
+	for _, size := range []int{512, 4096} {
+		if len(header) >= size+len(gptSignature) && bytes.Equal(header[size:size+len(gptSignature)], gptSignature) {
+			return size
+		}
+	}
+	return 0
+}
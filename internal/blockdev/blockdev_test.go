@@ -0,0 +1,34 @@
+//go:build linux
+
+package blockdev
+
+import "testing"
+
+func TestGPTSectorSize(t *testing.T) {
+	image := func(offset int) []byte {
+		b := make([]byte, 8192)
+		if offset >= 0 {
+			copy(b[offset:], "EFI PART")
+		}
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   int
+	}{
+		{"512-byte sectors", image(512), 512},
+		{"4Kn", image(4096), 4096},
+		{"no GPT", image(-1), 0},
+		{"short header", []byte("EFI PART"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GPTSectorSize(tt.header); got != tt.want {
+				t.Errorf("GPTSectorSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
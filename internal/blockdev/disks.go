@@ -0,0 +1,356 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"cmp"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// sysBlock is the sysfs directory listing block devices.
+const sysBlock = "/sys/block"
+
+// byIDDir holds the stable device symlinks maintained by udev.
+const byIDDir = "/dev/disk/by-id"
+
+// Disk types reported by List.
+const (
+	TypeDisk      = "disk"           // plain disk
+	TypeMultipath = "multipath"      // top-level device-mapper multipath device
+	TypePath      = "multipath-path" // individual path of a multipath device, not selectable
+)
+
+// Volume kinds of installation targets that are not disks, see VolumeKind.
+const (
+	VolumeMD  = "md"  // md software RAID array
+	VolumeLVM = "lvm" // LVM logical volume
+)
+
+// Zoned block device models (queue/zoned), other than "none".
+const (
+	ZonedHostAware   = "host-aware"   // SMR disk accepting random writes, possibly slowly
+	ZonedHostManaged = "host-managed" // SMR/ZNS disk accepting sequential writes only
+)
+
+// Disk describes an installation target candidate.
+type Disk struct {
+	Name   string // kernel name, e.g. sda or dm-0
+	Path   string // device path, /dev/mapper/<name> for device-mapper devices
+	Type   string // TypeDisk, TypeMultipath or TypePath
+	Size   uint64 // size in bytes
+	Model  string // device model, if reported
+	Holder string // for TypePath: the multipath device this disk is a path of
+	Zoned  string // ZonedHostAware, ZonedHostManaged or empty
+	ByID   string // stable /dev/disk/by-id path, if udev created one
+}
+
+// Selectable reports whether the disk may be used as an installation target.
+func (d Disk) Selectable() bool {
+	return d.Type != TypePath && d.Zoned != ZonedHostManaged
+}
+
+// List returns the block devices that can be installation targets, plus the
+// individual paths of multipath devices (which are not Selectable). Virtual
+// devices, removable media and device-mapper devices other than multipath
+// (LVM, dm-crypt, ...) are skipped.
+func List() ([]Disk, error) {
+	disks, err := listDisks(sysBlock)
+	for i := range disks {
+		if disks[i].Type != TypeMultipath {
+			disks[i].ByID = stableName(byIDDir, disks[i].Path)
+		}
+	}
+	return disks, err
+}
+
+func listDisks(root string) ([]Disk, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", root)
+	}
+
+	var disks []Disk
+	for _, e := range entries {
+		name := e.Name()
+		base := filepath.Join(root, name)
+
+		// Skip virtual devices
+		if strings.HasPrefix(name, "loop") ||
+			strings.HasPrefix(name, "ram") ||
+			strings.HasPrefix(name, "fd") ||
+			strings.HasPrefix(name, "sr") {
+			continue
+		}
+
+		if strings.HasPrefix(name, "dm-") {
+			if dmType(base) != TypeMultipath {
+				log.Printf("debug: skipping %s: not a multipath device", name)
+				continue
+			}
+			disks = append(disks, Disk{
+				Name:  name,
+				Path:  dmPath(base, name),
+				Type:  TypeMultipath,
+				Size:  diskSize(base),
+				Model: dmSlavesModel(root, base),
+			})
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(base, "device")); err != nil {
+			log.Printf("debug: skipping %s: no device symlink", name)
+			continue
+		}
+		size := diskSize(base)
+		b, err := os.ReadFile(filepath.Join(base, "removable"))
+		switch {
+		case err != nil && size == 0:
+			log.Printf("debug: skipping %s: cannot read removable flag: %v", name, err)
+			continue
+		case err != nil:
+			// Some virtio and NVMe setups lack the attribute; a whole disk
+			// with a backing device and a size is treated as fixed.
+			log.Printf("debug: %s has no removable flag, assuming fixed disk: %v", name, err)
+		case strings.TrimSpace(string(b)) != "0":
+			log.Printf("debug: skipping %s: removable device", name)
+			continue
+		}
+
+		d := Disk{
+			Name:  name,
+			Path:  "/dev/" + name,
+			Type:  TypeDisk,
+			Size:  size,
+			Model: readTrimmed(filepath.Join(base, "device", "model")),
+			Zoned: zonedModel(base),
+		}
+		if holder := multipathHolder(root, base); holder != "" {
+			d.Type = TypePath
+			d.Holder = holder
+		}
+		disks = append(disks, d)
+	}
+	return disks, nil
+}
+
+// DefaultDisk returns the path of the first selectable disk, preferring
+// multipath devices over plain disks.
+func DefaultDisk(disks []Disk) string {
+	for _, d := range disks {
+		if d.Type == TypeMultipath {
+			return d.Path
+		}
+	}
+	for _, d := range disks {
+		if d.Selectable() {
+			return d.Path
+		}
+	}
+	return ""
+}
+
+// CheckTarget refuses installation targets that are an individual path of a
+// multipath device: writing to it bypasses multipath and corrupts the array.
+// Host-managed zoned disks are refused as well: the partition table and
+// filesystems of Talos need random writes, which these disks reject. md RAID
+// arrays and LVM logical volumes must not be mounted, used as swap or held
+// by another device.
+func CheckTarget(device string) error {
+	mounts, _ := os.ReadFile("/proc/self/mounts")
+	swaps, _ := os.ReadFile("/proc/swaps")
+	return checkTarget(sysBlock, device, string(mounts), string(swaps))
+}
+
+func checkTarget(root, device, mounts, swaps string) error {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return nil //nolint:nilerr // nonexistent devices are reported when opened
+	}
+	name := filepath.Base(resolved)
+	base := filepath.Join(root, name)
+	if holder := multipathHolder(root, base); holder != "" {
+		return errors.Newf("%s is a path of multipath device %s, install to %s instead", device, holder, holder)
+	}
+	switch zonedModel(base) {
+	case ZonedHostManaged:
+		return errors.Newf("%s is a host-managed zoned (SMR) disk that only accepts sequential writes, Talos cannot be installed on it", device)
+	case ZonedHostAware:
+		log.Printf("warning: %s is a host-aware zoned (SMR) disk, random writes may be very slow", device)
+	}
+	if volumeKind(root, name) != "" {
+		if reason := inUse(root, name, mounts, swaps); reason != "" {
+			return errors.Newf("%s is in use: %s", device, reason)
+		}
+	}
+	return nil
+}
+
+// VolumeKind returns VolumeMD if device is an md RAID array, VolumeLVM if it
+// is an LVM logical volume, and "" for disks and other devices.
+func VolumeKind(device string) string {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return ""
+	}
+	return volumeKind(sysBlock, filepath.Base(resolved))
+}
+
+func volumeKind(root, name string) string {
+	base := filepath.Join(root, name)
+	if _, err := os.Stat(filepath.Join(base, "md")); err == nil {
+		return VolumeMD
+	}
+	if strings.HasPrefix(name, "dm-") && dmType(base) == "lvm" {
+		return VolumeLVM
+	}
+	return ""
+}
+
+// zonedModel returns the zoned model of the disk, or "" for regular disks.
+func zonedModel(base string) string {
+	if zoned := readTrimmed(filepath.Join(base, "queue", "zoned")); zoned != "none" {
+		return zoned
+	}
+	return ""
+}
+
+// dmType classifies a device-mapper device by its DM UUID prefix.
+func dmType(base string) string {
+	uuid := readTrimmed(filepath.Join(base, "dm", "uuid"))
+	switch {
+	case strings.HasPrefix(uuid, "mpath-"):
+		return TypeMultipath
+	case strings.HasPrefix(uuid, "LVM-"):
+		return "lvm"
+	case strings.HasPrefix(uuid, "CRYPT-"):
+		return "crypt"
+	default:
+		return "dm"
+	}
+}
+
+// dmPath returns the /dev/mapper path of a device-mapper device.
+func dmPath(base, name string) string {
+	if dmName := readTrimmed(filepath.Join(base, "dm", "name")); dmName != "" {
+		return "/dev/mapper/" + dmName
+	}
+	return "/dev/" + name
+}
+
+// multipathHolder returns the path of the multipath device holding the disk,
+// or an empty string.
+func multipathHolder(root, base string) string {
+	holders, err := os.ReadDir(filepath.Join(base, "holders"))
+	if err != nil {
+		return ""
+	}
+	for _, h := range holders {
+		hbase := filepath.Join(root, h.Name())
+		if dmType(hbase) == TypeMultipath {
+			return dmPath(hbase, h.Name())
+		}
+	}
+	return ""
+}
+
+// dmSlavesModel returns the model of the first underlying device.
+func dmSlavesModel(root, base string) string {
+	slaves, err := os.ReadDir(filepath.Join(base, "slaves"))
+	if err != nil || len(slaves) == 0 {
+		return ""
+	}
+	return readTrimmed(filepath.Join(root, slaves[0].Name(), "device", "model"))
+}
+
+// diskSize returns the disk size in bytes (sysfs reports 512-byte units).
+func diskSize(base string) uint64 {
+	sectors, err := strconv.ParseUint(readTrimmed(filepath.Join(base, "size")), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return sectors * 512
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// IsPartition reports whether device is a partition rather than a whole disk.
+func IsPartition(device string) bool {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join("/sys/class/block", filepath.Base(resolved), "partition"))
+	return err == nil
+}
+
+// Resolve returns the device node that device, e.g. a /dev/disk/by-id or
+// by-path symlink, points to.
+func Resolve(device string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolve %s", device)
+	}
+	return resolved, nil
+}
+
+// StableName returns the /dev/disk/by-id path of device, which unlike
+// /dev/sdX survives reboots and controller changes, or "" if there is none.
+func StableName(device string) string {
+	return stableName(byIDDir, device)
+}
+
+func stableName(dir, device string) string {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return ""
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	var names []string
+	for _, e := range entries {
+		if target, err := filepath.EvalSymlinks(filepath.Join(dir, e.Name())); err == nil && target == resolved {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	// Prefer names with vendor, model and serial (ata-, nvme-, scsi-) over
+	// bare identifiers, and the shortest of them (NVMe namespaces get a
+	// second link with an _1 suffix).
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(
+			cmp.Compare(opaqueID(a), opaqueID(b)),
+			cmp.Compare(len(a), len(b)),
+			strings.Compare(a, b),
+		)
+	})
+	return filepath.Join(dir, names[0])
+}
+
+// opaqueID returns 1 for by-id names that only carry an identifier such as a
+// WWN or EUI, 0 for descriptive names.
+func opaqueID(name string) int {
+	for _, prefix := range []string{"wwn-", "nvme-eui.", "nvme-nvme.", "nvme-uuid.", "dm-", "lvm-", "md-uuid-"} {
+		if strings.HasPrefix(name, prefix) {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,369 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sysfsFixture builds a fake /sys/block tree.
+type sysfsFixture struct {
+	t    *testing.T
+	root string
+}
+
+func (f sysfsFixture) write(path, content string) {
+	f.t.Helper()
+	full := filepath.Join(f.root, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		f.t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(full, []byte(content+"\n"), 0o644); err != nil {
+		f.t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func (f sysfsFixture) disk(name, model string, removable string) {
+	f.write(name+"/removable", removable)
+	f.write(name+"/size", "2097152")
+	f.write(name+"/device/model", model)
+}
+
+func (f sysfsFixture) dm(name, dmName, uuid string, slaves ...string) {
+	f.write(name+"/dm/name", dmName)
+	f.write(name+"/dm/uuid", uuid)
+	f.write(name+"/size", "2097152")
+	for _, s := range slaves {
+		f.write(name+"/slaves/"+s, "")
+		f.write(s+"/holders/"+name, "")
+	}
+}
+
+func newMultipathFixture(t *testing.T) sysfsFixture {
+	f := sysfsFixture{t: t, root: t.TempDir()}
+	f.disk("sda", "LOGICAL VOLUME", "0")
+	f.disk("sdb", "SAN LUN", "0")
+	f.disk("sdc", "SAN LUN", "0")
+	f.disk("sdd", "USB Stick", "1")
+	f.dm("dm-0", "mpatha", "mpath-3600508b400105e210000900000490000", "sdb", "sdc")
+	f.dm("dm-1", "vg-root", "LVM-abcdef", "sda")
+	f.write("loop0/size", "0")
+	return f
+}
+
+func TestListDisks(t *testing.T) {
+	f := newMultipathFixture(t)
+
+	disks, err := listDisks(f.root)
+	if err != nil {
+		t.Fatalf("listDisks error: %v", err)
+	}
+
+	want := []Disk{
+		{Name: "dm-0", Path: "/dev/mapper/mpatha", Type: TypeMultipath, Size: 1 << 30, Model: "SAN LUN"},
+		{Name: "sda", Path: "/dev/sda", Type: TypeDisk, Size: 1 << 30, Model: "LOGICAL VOLUME"},
+		{Name: "sdb", Path: "/dev/sdb", Type: TypePath, Size: 1 << 30, Model: "SAN LUN", Holder: "/dev/mapper/mpatha"},
+		{Name: "sdc", Path: "/dev/sdc", Type: TypePath, Size: 1 << 30, Model: "SAN LUN", Holder: "/dev/mapper/mpatha"},
+	}
+	if len(disks) != len(want) {
+		t.Fatalf("listDisks() returned %d disks, want %d: %+v", len(disks), len(want), disks)
+	}
+	for i := range want {
+		if disks[i] != want[i] {
+			t.Errorf("disk %d = %+v, want %+v", i, disks[i], want[i])
+		}
+	}
+
+	if got := DefaultDisk(disks); got != "/dev/mapper/mpatha" {
+		t.Errorf("DefaultDisk() = %q, want /dev/mapper/mpatha", got)
+	}
+}
+
+func TestListDisksMissingRemovable(t *testing.T) {
+	f := sysfsFixture{t: t, root: t.TempDir()}
+	f.disk("vda", "", "0")
+	f.write("nvme0n1/size", "2097152")
+	f.write("nvme0n1/device/model", "NVMe SSD")
+	f.write("nvme1n1/size", "0")
+	f.write("nvme1n1/device/model", "Empty")
+
+	disks, err := listDisks(f.root)
+	if err != nil {
+		t.Fatalf("listDisks error: %v", err)
+	}
+
+	want := []Disk{
+		{Name: "nvme0n1", Path: "/dev/nvme0n1", Type: TypeDisk, Size: 1 << 30, Model: "NVMe SSD"},
+		{Name: "vda", Path: "/dev/vda", Type: TypeDisk, Size: 1 << 30},
+	}
+	if len(disks) != len(want) {
+		t.Fatalf("listDisks() returned %d disks, want %d: %+v", len(disks), len(want), disks)
+	}
+	for i := range want {
+		if disks[i] != want[i] {
+			t.Errorf("disk %d = %+v, want %+v", i, disks[i], want[i])
+		}
+	}
+}
+
+func TestDefaultDiskPlain(t *testing.T) {
+	disks := []Disk{
+		{Path: "/dev/sda", Type: TypePath},
+		{Path: "/dev/sdb", Type: TypeDisk},
+	}
+	if got := DefaultDisk(disks); got != "/dev/sdb" {
+		t.Errorf("DefaultDisk() = %q, want /dev/sdb", got)
+	}
+	if got := DefaultDisk(nil); got != "" {
+		t.Errorf("DefaultDisk(nil) = %q, want empty", got)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	f := newMultipathFixture(t)
+
+	// checkTarget resolves the device path; use files named like the devices.
+	dev := t.TempDir()
+	for _, name := range []string{"sda", "sdb"} {
+		if err := os.WriteFile(filepath.Join(dev, name), nil, 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if err := checkTarget(f.root, filepath.Join(dev, "sdb"), "", ""); err == nil {
+		t.Error("expected error for multipath path member")
+	}
+	if err := checkTarget(f.root, filepath.Join(dev, "sda"), "", ""); err != nil {
+		t.Errorf("unexpected error for plain disk: %v", err)
+	}
+}
+
+func TestVolumeTargets(t *testing.T) {
+	f := newMultipathFixture(t)
+	f.write("md0/md/level", "raid1")
+	f.write("md0/size", "2097152")
+	f.write("md1/md/level", "raid1")
+	f.write("md1/md1p1/partition", "1")
+	f.dm("dm-2", "vg-data", "LVM-ghijkl", "sdb")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"md0", VolumeMD},
+		{"dm-1", VolumeLVM},
+		{"dm-0", ""},
+		{"sda", ""},
+	}
+	for _, tt := range tests {
+		if got := volumeKind(f.root, tt.name); got != tt.want {
+			t.Errorf("volumeKind(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	dev := t.TempDir()
+	for _, name := range []string{"md0", "md1", "dm-1", "dm-2"} {
+		if err := os.WriteFile(filepath.Join(dev, name), nil, 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	mounts := "/dev/md1p1 /srv ext4 rw 0 0\n"
+	swaps := "Filename Type Size Used Priority\n/dev/dm-2 partition 1048572 0 -2\n"
+	for name, wantErr := range map[string]bool{"md0": false, "md1": true, "dm-1": false, "dm-2": true} {
+		if err := checkTarget(f.root, filepath.Join(dev, name), mounts, swaps); (err != nil) != wantErr {
+			t.Errorf("checkTarget(%s) error = %v, want error %v", name, err, wantErr)
+		}
+	}
+}
+
+func TestInUse(t *testing.T) {
+	f := sysfsFixture{t: t, root: t.TempDir()}
+	f.disk("sda", "SSD", "0")
+	f.write("sda/sda1/partition", "1")
+	f.write("sda/sda2/partition", "2")
+	f.disk("sdb", "SSD", "0")
+	f.write("sdb/sdb1/partition", "1")
+	f.write("sdb/sdb1/holders/md0", "")
+	f.disk("sdc", "SSD", "0")
+	f.disk("nvme0n1", "NVMe", "0")
+	f.write("nvme0n1/nvme0n1p1/partition", "1")
+
+	mounts := "/dev/sda2 / ext4 rw 0 0\nproc /proc proc rw 0 0\n"
+	swaps := "Filename Type Size Used Priority\n/dev/nvme0n1p1 partition 1048572 0 -2\n"
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"sda", "/dev/sda2 is mounted at /"},
+		{"sdb", "sdb1 is held by md0"},
+		{"sdc", ""},
+		{"nvme0n1", "/dev/nvme0n1p1 is used as swap"},
+	}
+	for _, tt := range tests {
+		if got := inUse(f.root, tt.name, mounts, swaps); got != tt.want {
+			t.Errorf("inUse(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHolds(t *testing.T) {
+	f := sysfsFixture{t: t, root: t.TempDir()}
+	f.disk("sda", "SSD", "0")
+	f.write("sda/sda1/partition", "1")
+	f.write("sda/sda2/partition", "2")
+	f.disk("sdb", "SSD", "0")
+	f.write("sdb/sdb1/partition", "1")
+	f.dm("dm-0", "vg-data", "LVM-abcdef", "sdb1")
+	f.dm("dm-1", "crypt-data", "CRYPT-LUKS2-abcdef", "dm-0")
+
+	mounts := "/dev/sda2 / ext4 rw 0 0\n" +
+		"tmpfs /tmp tmpfs rw 0 0\n" +
+		"/dev/dm-1 /srv ext4 rw 0 0\n" +
+		"/dev/sda1 /srv/boot vfat rw 0 0\n"
+
+	tests := []struct {
+		disk string
+		path string
+		want string
+	}{
+		{"sda", "/var/tmp", "/var/tmp is on /dev/sda2"},
+		{"sda", "/tmp", ""},
+		{"sda", "/tmpdir", "/tmpdir is on /dev/sda2"},
+		{"sda", "/srv/cache", ""},
+		{"sda", "/srv/boot/x", "/srv/boot/x is on /dev/sda1"},
+		{"sdb", "/srv/cache", "/srv/cache is on /dev/dm-1"},
+		{"sdb", "/var/tmp", ""},
+	}
+	for _, tt := range tests {
+		if got := holds(f.root, tt.disk, tt.path, mounts); got != tt.want {
+			t.Errorf("holds(%s, %s) = %q, want %q", tt.disk, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRemovableRoot(t *testing.T) {
+	f := sysfsFixture{t: t, root: t.TempDir()}
+	f.disk("sda", "SSD", "0")
+	f.write("sda/sda2/partition", "2")
+	f.disk("sdb", "USB Stick", "1")
+	f.write("sdb/sdb1/partition", "1")
+	f.disk("sdc", "USB SSD", "0")
+	f.write("sdc/sdc3/partition", "3")
+	f.write("dm-0/dm/name", "vg-root")
+	f.write("dm-0/slaves/sdc3", "")
+	// USB disks without the removable flag are recognized by their path.
+	usb := filepath.Join(t.TempDir(), "pci0000:00", "usb2", "block", "sdc")
+	if err := os.MkdirAll(filepath.Dir(usb), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(f.root, "sdc"), usb); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(usb, filepath.Join(f.root, "sdc")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mounts string
+		want   string
+	}{
+		{"fixed disk", "/dev/sda2 / ext4 rw 0 0\n", ""},
+		{"USB stick", "/dev/sdb1 / ext4 rw 0 0\n", "/dev/sdb"},
+		{"LVM on USB disk", "/dev/dm-0 / ext4 rw 0 0\n", "/dev/sdc"},
+		{"live system", "overlay / overlay rw 0 0\n/dev/sdb1 /run/live/medium iso9660 ro 0 0\n", "/dev/sdb"},
+		{"in memory", "rootfs / rootfs rw 0 0\n", ""},
+	}
+	for _, tt := range tests {
+		if got := removableRoot(f.root, tt.mounts); got != tt.want {
+			t.Errorf("%s: removableRoot() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestZonedDisks(t *testing.T) {
+	f := sysfsFixture{t: t, root: t.TempDir()}
+	f.disk("sda", "SMR HM", "0")
+	f.write("sda/queue/zoned", ZonedHostManaged)
+	f.disk("sdb", "SMR HA", "0")
+	f.write("sdb/queue/zoned", ZonedHostAware)
+	f.disk("sdc", "CMR", "0")
+	f.write("sdc/queue/zoned", "none")
+
+	disks, err := listDisks(f.root)
+	if err != nil {
+		t.Fatalf("listDisks error: %v", err)
+	}
+	zoned := map[string]string{}
+	for _, d := range disks {
+		zoned[d.Name] = d.Zoned
+	}
+	if zoned["sda"] != ZonedHostManaged || zoned["sdb"] != ZonedHostAware || zoned["sdc"] != "" {
+		t.Errorf("zoned models = %v", zoned)
+	}
+	if got := DefaultDisk(disks); got != "/dev/sdb" {
+		t.Errorf("DefaultDisk() = %q, want /dev/sdb (host-managed disks are not selectable)", got)
+	}
+
+	dev := t.TempDir()
+	for _, name := range []string{"sda", "sdb"} {
+		if err := os.WriteFile(filepath.Join(dev, name), nil, 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := checkTarget(f.root, filepath.Join(dev, "sda"), "", ""); err == nil {
+		t.Error("expected error for host-managed zoned disk")
+	}
+	if err := checkTarget(f.root, filepath.Join(dev, "sdb"), "", ""); err != nil {
+		t.Errorf("unexpected error for host-aware zoned disk: %v", err)
+	}
+}
+
+func TestStableName(t *testing.T) {
+	dev := t.TempDir()
+	byID := filepath.Join(dev, "by-id")
+	if err := os.Mkdir(byID, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"sda", "sda3", "nvme0n1", "sdb"} {
+		if err := os.WriteFile(filepath.Join(dev, name), nil, 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	links := map[string]string{
+		"wwn-0x5002538e40a1b2c3":                   "sda",
+		"ata-Samsung_SSD_860_S3Z9NB0K123456":       "sda",
+		"ata-Samsung_SSD_860_S3Z9NB0K123456-part3": "sda3",
+		"nvme-eui.0025385b71b0a1b2":                "nvme0n1",
+		"nvme-Samsung_SSD_970_S4EWNX0N123456_1":    "nvme0n1",
+		"nvme-Samsung_SSD_970_S4EWNX0N123456":      "nvme0n1",
+	}
+	for link, target := range links {
+		if err := os.Symlink(filepath.Join("..", target), filepath.Join(byID, link)); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+	}
+
+	tests := []struct {
+		device string
+		want   string
+	}{
+		{"sda", "ata-Samsung_SSD_860_S3Z9NB0K123456"},
+		{"sda3", "ata-Samsung_SSD_860_S3Z9NB0K123456-part3"},
+		{"nvme0n1", "nvme-Samsung_SSD_970_S4EWNX0N123456"},
+		{"by-id/wwn-0x5002538e40a1b2c3", "ata-Samsung_SSD_860_S3Z9NB0K123456"},
+		{"sdb", ""},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if want != "" {
+			want = filepath.Join(byID, want)
+		}
+		if got := stableName(byID, filepath.Join(dev, tt.device)); got != want {
+			t.Errorf("stableName(%s) = %q, want %q", tt.device, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InUse returns why the whole disk, md RAID array or LVM logical volume
+// device is in use: a partition or the device itself is mounted, used as
+// swap or held by another device (LVM, RAID, dm-crypt, ...). It returns ""
+// if the device appears to be unused.
+func InUse(device string) string {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return ""
+	}
+	mounts, _ := os.ReadFile("/proc/self/mounts")
+	swaps, _ := os.ReadFile("/proc/swaps")
+	return inUse(sysBlock, filepath.Base(resolved), string(mounts), string(swaps))
+}
+
+func inUse(root, name, mounts, swaps string) string {
+	devs := devices(root, name)
+	for dev, dir := range devs {
+		if holders, _ := os.ReadDir(filepath.Join(dir, "holders")); len(holders) > 0 {
+			return dev + " is held by " + holders[0].Name()
+		}
+	}
+	for line := range strings.SplitSeq(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && devs[devName(fields[0])] != "" {
+			return fields[0] + " is mounted at " + fields[1]
+		}
+	}
+	for line := range strings.SplitSeq(swaps, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 1 && devs[devName(fields[0])] != "" {
+			return fields[0] + " is used as swap"
+		}
+	}
+	return ""
+}
+
+// devices returns the sysfs directories of the named device and its
+// partitions, by kernel name.
+func devices(root, name string) map[string]string {
+	base := filepath.Join(root, name)
+	devs := map[string]string{name: base}
+	entries, _ := os.ReadDir(base)
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), name) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(base, e.Name(), "partition")); err == nil {
+			devs[e.Name()] = filepath.Join(base, e.Name())
+		}
+	}
+	return devs
+}
+
+// Holds returns why the filesystem of path is stored on device, a whole
+// disk, md RAID array or LVM logical volume: the filesystem is on the device,
+// one of its partitions or a device stacked on them, such as an LVM volume
+// or a dm-crypt mapping. It returns "" for filesystems elsewhere, including
+// memory-backed ones such as tmpfs.
+func Holds(device, path string) string {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return ""
+	}
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	mounts, _ := os.ReadFile("/proc/self/mounts")
+	return holds(sysBlock, filepath.Base(resolved), path, string(mounts))
+}
+
+func holds(root, name, path, mounts string) string {
+	// The last of the longest mount points containing path is mounted on top.
+	var source, mountPoint string
+	for line := range strings.SplitSeq(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !underDir(path, fields[1]) || len(fields[1]) < len(mountPoint) {
+			continue
+		}
+		source, mountPoint = fields[0], fields[1]
+	}
+	if !strings.HasPrefix(source, "/dev/") {
+		return ""
+	}
+	if stackedOn(root, devName(source), devices(root, name), 0) {
+		return path + " is on " + source
+	}
+	return ""
+}
+
+// stackedOn reports whether the named device is one of devs or is built on
+// one of them, following the slaves of device-mapper and md devices.
+func stackedOn(root, name string, devs map[string]string, depth int) bool {
+	if devs[name] != "" {
+		return true
+	}
+	if depth > 8 {
+		return false
+	}
+	slaves, _ := os.ReadDir(filepath.Join(root, name, "slaves"))
+	for _, slave := range slaves {
+		if stackedOn(root, slave.Name(), devs, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// underDir reports whether path is dir or lies below it.
+func underDir(path, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// devName returns the kernel name of the device at path, following
+// symlinks such as /dev/mapper/<vg>-<lv> to /dev/dm-N.
+func devName(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return strings.TrimPrefix(path, "/dev/")
+}
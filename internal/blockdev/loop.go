@@ -0,0 +1,147 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+// loopSyscaller wraps the device access used to set up loop devices so that
+// tests can replace it.
+type loopSyscaller interface {
+	// OpenFile opens a device or file read-write.
+	OpenFile(path string) (*os.File, error)
+	// Ioctl issues an ioctl and returns its result and errno (0 on success).
+	Ioctl(fd, req, arg uintptr) (uintptr, syscall.Errno)
+	// IoctlPtr is Ioctl with a pointer argument.
+	IoctlPtr(fd, req uintptr, arg unsafe.Pointer) syscall.Errno
+	// LoadModule loads a kernel module with the given parameters.
+	LoadModule(name, params string) error
+}
+
+//nolint:gochecknoglobals
+var loopSys loopSyscaller = linuxLoopSyscaller{}
+
+type linuxLoopSyscaller struct{}
+
+func (linuxLoopSyscaller) OpenFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+func (linuxLoopSyscaller) Ioctl(fd, req, arg uintptr) (uintptr, syscall.Errno) {
+	r, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, arg)
+	return r, errno
+}
+
+func (linuxLoopSyscaller) LoadModule(name, params string) error {
+	return loadKernelModule(name, params)
+}
+
+func (linuxLoopSyscaller) IoctlPtr(fd, req uintptr, arg unsafe.Pointer) syscall.Errno {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, uintptr(arg))
+	return errno
+}
+
+// LoopConfig describes the loop device AttachLoop sets up.
+type LoopConfig struct {
+	// Offset and Size select a byte range of the backing file; a Size of 0
+	// extends the range to the end of the file.
+	Offset, Size int64
+	// BlockSize is the logical sector size the loop device presents if it
+	// is neither 0 nor 512, e.g. so that a GPT written through it matches
+	// a 4Kn disk.
+	BlockSize int
+	// PartScan makes the kernel create device nodes for the partitions.
+	PartScan bool
+	// MaxPart, if above 0, is the max_part the loop module is loaded with
+	// if /dev/loop-control is missing.
+	MaxPart int
+}
+
+// AttachLoop attaches a free loop device to path as described by cfg, loading
+// the loop kernel module first if needed, and returns its device node and an
+// open handle. The device is detached by DetachLoop, or once the handle is
+// closed and the device is not in use.
+func AttachLoop(path string, cfg LoopConfig) (string, *os.File, error) {
+	return attachLoop(loopSys, path, cfg)
+}
+
+// DetachLoop detaches the backing file from the loop device and closes it.
+func DetachLoop(lf *os.File) {
+	_, _ = loopSys.Ioctl(lf.Fd(), unix.LOOP_CLR_FD, 0)
+	lf.Close()
+}
+
+func attachLoop(sys loopSyscaller, path string, cfg LoopConfig) (string, *os.File, error) {
+	ctrl, err := sys.OpenFile("/dev/loop-control")
+	if errors.Is(err, fs.ErrNotExist) {
+		// Minimal systems such as rescue images do not preload loop.
+		log.Print("/dev/loop-control is missing, loading the loop kernel module")
+		var params string
+		if cfg.MaxPart > 0 {
+			params = fmt.Sprintf("max_part=%d", cfg.MaxPart)
+		}
+		if merr := sys.LoadModule("loop", params); merr != nil {
+			return "", nil, errors.Newf("loop device support unavailable, load the 'loop' kernel module: %v", merr)
+		}
+		ctrl, err = sys.OpenFile("/dev/loop-control")
+	}
+	if err != nil {
+		return "", nil, errors.Wrap(err, "open loop-control")
+	}
+	num, errno := sys.Ioctl(ctrl.Fd(), unix.LOOP_CTL_GET_FREE, 0)
+	ctrl.Close()
+	if errno != 0 {
+		return "", nil, errors.Newf("LOOP_CTL_GET_FREE: %v", errno)
+	}
+
+	loop := fmt.Sprintf("/dev/loop%d", num)
+	lf, err := sys.OpenFile(loop)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "open loop")
+	}
+	bf, err := sys.OpenFile(path)
+	if err != nil {
+		lf.Close()
+		return "", nil, errors.Wrapf(err, "open %s", path)
+	}
+	// The loop device holds its own reference to the backing file.
+	defer bf.Close()
+
+	if _, errno := sys.Ioctl(lf.Fd(), unix.LOOP_SET_FD, bf.Fd()); errno != 0 {
+		lf.Close()
+		return "", nil, errors.Newf("LOOP_SET_FD: %v", errno)
+	}
+
+	fail := func(err error) (string, *os.File, error) {
+		_, _ = sys.Ioctl(lf.Fd(), unix.LOOP_CLR_FD, 0)
+		lf.Close()
+		return "", nil, err
+	}
+
+	info := unix.LoopInfo64{
+		Offset:    uint64(cfg.Offset),
+		Sizelimit: uint64(cfg.Size),
+		Flags:     unix.LO_FLAGS_AUTOCLEAR,
+	}
+	if cfg.PartScan {
+		info.Flags |= unix.LO_FLAGS_PARTSCAN
+	}
+	if errno := sys.IoctlPtr(lf.Fd(), unix.LOOP_SET_STATUS64, unsafe.Pointer(&info)); errno != 0 {
+		return fail(errors.Newf("LOOP_SET_STATUS64: %v", errno))
+	}
+	if cfg.BlockSize != 0 && cfg.BlockSize != 512 {
+		if _, errno := sys.Ioctl(lf.Fd(), unix.LOOP_SET_BLOCK_SIZE, uintptr(cfg.BlockSize)); errno != 0 {
+			return fail(errors.Newf("LOOP_SET_BLOCK_SIZE %d: %v", cfg.BlockSize, errno))
+		}
+	}
+	return loop, lf, nil
+}
@@ -0,0 +1,186 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeLoopSyscaller opens regular files in place of devices and records the
+// ioctls issued.
+type fakeLoopSyscaller struct {
+	dir    string
+	free   uintptr
+	failOn uintptr // ioctl request that fails with EINVAL
+	reqs   []uintptr
+	status unix.LoopInfo64
+
+	noControl bool  // /dev/loop-control is missing until the loop module is loaded
+	loadErr   error // returned by LoadModule
+	loaded    []string
+}
+
+func (f *fakeLoopSyscaller) OpenFile(path string) (*os.File, error) {
+	if path == "/dev/loop-control" && f.noControl {
+		return nil, os.ErrNotExist
+	}
+	name := filepath.Join(f.dir, strings.ReplaceAll(path, "/", "_"))
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+}
+
+func (f *fakeLoopSyscaller) Ioctl(_, req, _ uintptr) (uintptr, syscall.Errno) {
+	f.reqs = append(f.reqs, req)
+	if req == f.failOn {
+		return 0, unix.EINVAL
+	}
+	if req == unix.LOOP_CTL_GET_FREE {
+		return f.free, 0
+	}
+	return 0, 0
+}
+
+func (f *fakeLoopSyscaller) LoadModule(name, params string) error {
+	f.loaded = append(f.loaded, strings.TrimSpace(name+" "+params))
+	if f.loadErr != nil {
+		return f.loadErr
+	}
+	f.noControl = false
+	return nil
+}
+
+func (f *fakeLoopSyscaller) IoctlPtr(_, req uintptr, arg unsafe.Pointer) syscall.Errno {
+	f.reqs = append(f.reqs, req)
+	if req == f.failOn {
+		return unix.EINVAL
+	}
+	if req == unix.LOOP_SET_STATUS64 {
+		f.status = *(*unix.LoopInfo64)(arg)
+	}
+	return 0
+}
+
+func TestAttachLoop(t *testing.T) {
+	tests := []struct {
+		name      string
+		blockSize int
+		want      []uintptr
+	}{
+		{"512-byte sectors", 512, []uintptr{unix.LOOP_CTL_GET_FREE, unix.LOOP_SET_FD, unix.LOOP_SET_STATUS64}},
+		{"4Kn", 4096, []uintptr{unix.LOOP_CTL_GET_FREE, unix.LOOP_SET_FD, unix.LOOP_SET_STATUS64, unix.LOOP_SET_BLOCK_SIZE}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeLoopSyscaller{dir: t.TempDir(), free: 7}
+			loop, lf, err := attachLoop(fake, "/image.raw", LoopConfig{BlockSize: tt.blockSize, PartScan: true})
+			if err != nil {
+				t.Fatalf("attachLoop error: %v", err)
+			}
+			defer lf.Close()
+
+			if loop != "/dev/loop7" {
+				t.Errorf("loop = %q, want /dev/loop7", loop)
+			}
+			if !slices.Equal(fake.reqs, tt.want) {
+				t.Errorf("ioctls = %#x, want %#x", fake.reqs, tt.want)
+			}
+			if fake.status.Flags&unix.LO_FLAGS_AUTOCLEAR == 0 {
+				t.Error("loop device not set to autoclear")
+			}
+			if fake.status.Flags&unix.LO_FLAGS_PARTSCAN == 0 {
+				t.Error("loop device not set to scan partitions")
+			}
+		})
+	}
+}
+
+func TestAttachLoop_Range(t *testing.T) {
+	fake := &fakeLoopSyscaller{dir: t.TempDir(), free: 3}
+	loop, lf, err := attachLoop(fake, "/disk.raw", LoopConfig{Offset: 1 << 20, Size: 100 << 20, BlockSize: 4096})
+	if err != nil {
+		t.Fatalf("attachLoop error: %v", err)
+	}
+	defer lf.Close()
+
+	if loop != "/dev/loop3" {
+		t.Errorf("loop = %q, want /dev/loop3", loop)
+	}
+	if fake.status.Offset != 1<<20 || fake.status.Sizelimit != 100<<20 {
+		t.Errorf("offset, size limit = %d, %d, want %d, %d", fake.status.Offset, fake.status.Sizelimit, 1<<20, 100<<20)
+	}
+	if fake.status.Flags != unix.LO_FLAGS_AUTOCLEAR {
+		t.Errorf("flags = %#x, want only autoclear", fake.status.Flags)
+	}
+}
+
+func TestAttachLoop_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		failOn uintptr
+		want   string
+		clear  bool // LOOP_CLR_FD expected after the failure
+	}{
+		{"no free device", unix.LOOP_CTL_GET_FREE, "LOOP_CTL_GET_FREE", false},
+		{"set fd", unix.LOOP_SET_FD, "LOOP_SET_FD", false},
+		{"set status", unix.LOOP_SET_STATUS64, "LOOP_SET_STATUS64", true},
+		{"block size", unix.LOOP_SET_BLOCK_SIZE, "LOOP_SET_BLOCK_SIZE 4096", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeLoopSyscaller{dir: t.TempDir(), failOn: tt.failOn}
+			_, _, err := attachLoop(fake, "/image.raw", LoopConfig{BlockSize: 4096})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("attachLoop error = %v, want %q", err, tt.want)
+			}
+			cleared := fake.reqs[len(fake.reqs)-1] == unix.LOOP_CLR_FD
+			if cleared != tt.clear {
+				t.Errorf("LOOP_CLR_FD after failure = %v, want %v (ioctls %#x)", cleared, tt.clear, fake.reqs)
+			}
+		})
+	}
+}
+
+func TestAttachLoop_LoadsModule(t *testing.T) {
+	fake := &fakeLoopSyscaller{dir: t.TempDir(), free: 0, noControl: true}
+	loop, lf, err := attachLoop(fake, "/image.raw", LoopConfig{MaxPart: 16})
+	if err != nil {
+		t.Fatalf("attachLoop error: %v", err)
+	}
+	lf.Close()
+	if loop != "/dev/loop0" || !slices.Equal(fake.loaded, []string{"loop max_part=16"}) {
+		t.Errorf("loop = %q, modules loaded = %v, want /dev/loop0 after loading loop with max_part=16", loop, fake.loaded)
+	}
+
+	fake = &fakeLoopSyscaller{dir: t.TempDir(), noControl: true, loadErr: os.ErrNotExist}
+	if _, _, err := attachLoop(fake, "/image.raw", LoopConfig{}); err == nil || !strings.Contains(err.Error(), "load the 'loop' kernel module") {
+		t.Errorf("attachLoop error = %v, want a hint to load the loop module", err)
+	}
+}
+
+func TestFindModule(t *testing.T) {
+	dir := t.TempDir()
+	dep := "kernel/drivers/block/loop.ko.zst:\nkernel/drivers/md/dm-mod.ko: kernel/lib/foo.ko\n"
+	if err := os.WriteFile(filepath.Join(dir, "modules.dep"), []byte(dep), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"loop":   "kernel/drivers/block/loop.ko.zst",
+		"dm_mod": "kernel/drivers/md/dm-mod.ko",
+	} {
+		if got, err := findModule(dir, name); err != nil || got != filepath.Join(dir, want) {
+			t.Errorf("findModule(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := findModule(dir, "nbd"); err == nil {
+		t.Error("findModule() found a module missing from modules.dep")
+	}
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+// loadKernelModule loads the named kernel module with modprobe or, on
+// systems without it, with finit_module from /lib/modules. params are the
+// space-separated module parameters, e.g. "max_part=16". Modules the loaded
+// one depends on are not resolved without modprobe.
+func loadKernelModule(name, params string) error {
+	if modprobe, err := exec.LookPath("modprobe"); err == nil {
+		out, err := exec.Command(modprobe, append([]string{name}, strings.Fields(params)...)...).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		return errors.Newf("modprobe %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return errors.Wrap(err, "uname")
+	}
+	dir := filepath.Join("/lib/modules", unix.ByteSliceToString(uts.Release[:]))
+	path, err := findModule(dir, name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open module")
+	}
+	defer f.Close()
+	flags := 0
+	if !strings.HasSuffix(path, ".ko") {
+		flags = unix.MODULE_INIT_COMPRESSED_FILE
+	}
+	if err := unix.FinitModule(int(f.Fd()), params, flags); err != nil && !errors.Is(err, unix.EEXIST) {
+		return errors.Wrapf(err, "finit_module %s", path)
+	}
+	return nil
+}
+
+// findModule returns the file of the named module listed in modules.dep of
+// the module directory dir.
+func findModule(dir, name string) (string, error) {
+	f, err := os.Open(filepath.Join(dir, "modules.dep"))
+	if err != nil {
+		return "", errors.Wrap(err, "open modules.dep")
+	}
+	defer f.Close()
+
+	want := strings.ReplaceAll(name, "-", "_")
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		path, _, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		base, _, _ := strings.Cut(filepath.Base(path), ".ko")
+		if strings.ReplaceAll(base, "-", "_") == want {
+			return filepath.Join(dir, path), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", errors.Wrap(err, "read modules.dep")
+	}
+	return "", errors.Newf("module %s not found in %s", name, dir)
+}
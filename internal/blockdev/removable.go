@@ -0,0 +1,86 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// liveMedia are the mount points of the boot medium on live systems, whose
+// root filesystem is an overlay or lives in memory: Debian live, dracut
+// (Fedora), archiso and casper (Ubuntu).
+//
+//nolint:gochecknoglobals
+var liveMedia = []string{"/run/live/medium", "/run/initramfs/live", "/run/archiso/bootmnt", "/cdrom"}
+
+// RemovableRoot returns the removable disk the running system was booted
+// from, e.g. /dev/sdb for a USB installer stick: the disk holding the root
+// filesystem or, on live systems, the boot medium. It returns "" if that is
+// a fixed disk or cannot be determined.
+func RemovableRoot() string {
+	mounts, _ := os.ReadFile("/proc/self/mounts")
+	return removableRoot(sysBlock, string(mounts))
+}
+
+func removableRoot(root, mounts string) string {
+	for _, dir := range append([]string{"/"}, liveMedia...) {
+		source := mountedAt(dir, mounts)
+		if !strings.HasPrefix(source, "/dev/") {
+			continue
+		}
+		for _, disk := range disksOf(root, devName(source), 0) {
+			if removable(root, disk) {
+				return "/dev/" + disk
+			}
+		}
+	}
+	return ""
+}
+
+// mountedAt returns the source of the filesystem mounted on top at dir, ""
+// if there is none.
+func mountedAt(dir, mounts string) string {
+	var source string
+	for line := range strings.SplitSeq(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == dir {
+			source = fields[0]
+		}
+	}
+	return source
+}
+
+// disksOf returns the disks the named device is on: the disk of a
+// partition, or the disks below a device-mapper or md device.
+func disksOf(root, name string, depth int) []string {
+	if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+		// A partition, listed below its disk.
+		matches, _ := filepath.Glob(filepath.Join(root, "*", name, "partition"))
+		if len(matches) == 0 {
+			return nil
+		}
+		return []string{filepath.Base(filepath.Dir(filepath.Dir(matches[0])))}
+	}
+	slaves, _ := os.ReadDir(filepath.Join(root, name, "slaves"))
+	if len(slaves) == 0 || depth > 8 {
+		return []string{name}
+	}
+	var disks []string
+	for _, slave := range slaves {
+		disks = append(disks, disksOf(root, slave.Name(), depth+1)...)
+	}
+	return disks
+}
+
+// removable reports whether the named disk is removable media or attached
+// via USB; USB sticks do not always set the removable flag.
+func removable(root, name string) bool {
+	base := filepath.Join(root, name)
+	if readTrimmed(filepath.Join(base, "removable")) == "1" {
+		return true
+	}
+	resolved, err := filepath.EvalSymlinks(base)
+	return err == nil && strings.Contains(resolved, "/usb")
+}
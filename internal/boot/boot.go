@@ -7,24 +7,28 @@ import (
 	"io"
 	"log"
 	"os"
+	"slices"
 	"strings"
 	"syscall"
-	"unsafe"
 
 	"github.com/cockroachdb/errors"
 	"golang.org/x/sys/unix"
 
 	"github.com/cozystack/boot-to-talos/internal/cli"
+	kernelcmdline "github.com/cozystack/boot-to-talos/internal/cmdline"
 	"github.com/cozystack/boot-to-talos/internal/efi"
+	"github.com/cozystack/boot-to-talos/internal/host"
+	"github.com/cozystack/boot-to-talos/internal/network"
+	"github.com/cozystack/boot-to-talos/internal/status"
 	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/cozystack/boot-to-talos/internal/uki"
 )
 
 // CreateMemfdFromReader creates an anonymous file in memory via memfd_create and copies data from reader.
 func CreateMemfdFromReader(name string, reader io.Reader) (*os.File, error) {
 	const MFD_CLOEXEC = 0x0001
 
-	nameBytes := []byte(name + "\x00")
-	fd, _, errno := unix.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(&nameBytes[0])), MFD_CLOEXEC, 0)
+	fd, errno := sys.MemfdCreate(name, MFD_CLOEXEC)
 	if errno != 0 {
 		return nil, errors.Newf("memfd_create failed: %v", errno)
 	}
@@ -49,8 +53,17 @@ func CreateMemfdFromReader(name string, reader io.Reader) (*os.File, error) {
 	return file, nil
 }
 
+// Handling of KEXEC_FILE_LOAD_UNSAFE, which skips kernel signature
+// verification.
+const (
+	KexecUnsafeAuto  = "auto"  // retry with the flag if the signed load fails with EPERM
+	KexecUnsafeNever = "never" // never set the flag, fail on EPERM
+	KexecUnsafeForce = "force" // set the flag on the first attempt
+)
+
 // KexecLoadFromAssets loads kernel via kexec_file_load syscall from BootAssets.
-func KexecLoadFromAssets(assets *types.BootAssets, extraCmdline string) error {
+// unsafeMode is KexecUnsafeAuto, KexecUnsafeNever or KexecUnsafeForce.
+func KexecLoadFromAssets(assets *types.BootAssets, extraCmdline, unsafeMode string) error {
 	log.Printf("using KexecFileLoad")
 
 	// Create memfd for kernel from reader
@@ -68,58 +81,34 @@ func KexecLoadFromAssets(assets *types.BootAssets, extraCmdline string) error {
 	defer initrdFile.Close()
 	initrdFD := int(initrdFile.Fd())
 
-	// Combine cmdline from assets with additional arguments
-	cmdlineParts := []string{}
-	if assets.Cmdline != "" {
-		cmdlineParts = append(cmdlineParts, assets.Cmdline)
-	}
-	if extraCmdline != "" {
-		cmdlineParts = append(cmdlineParts, extraCmdline)
-	}
-	cmdline := strings.Join(cmdlineParts, " ")
+	cmdline := joinCmdline(assets.Cmdline, extraCmdline)
 
 	log.Printf("cmdline: %s", cmdline)
 
-	// Call kexec_file_load via syscall
-	// long kexec_file_load(int kernel_fd, int initrd_fd, unsigned long cmdline_len, const char *cmdline, unsigned long flags)
+	maxLength := kernelcmdline.KernelMaxLength(kernelFile)
+	if err := kernelcmdline.CheckLength(cmdline, maxLength); err != nil {
+		return err
+	}
+	if kernelcmdline.NearLimit(cmdline, maxLength) {
+		log.Printf("warning: kernel command line is %d bytes, close to the kernel limit of %d bytes", len(cmdline)+1, maxLength)
+	}
+
 	// KEXEC_FILE_LOAD_UNSAFE = 0x00000001 - skip signature verification (if lockdown is not enabled)
 	const KEXEC_FILE_LOAD_UNSAFE = 0x00000001
 
-	cmdlineBytes := []byte(cmdline)
-	if len(cmdlineBytes) > 0 {
-		cmdlineBytes = append(cmdlineBytes, 0) // null terminator
-	}
-
-	var cmdlinePtr uintptr
-	if len(cmdlineBytes) > 0 {
-		cmdlinePtr = uintptr(unsafe.Pointer(&cmdlineBytes[0]))
+	var flags uintptr
+	if unsafeMode == KexecUnsafeForce {
+		log.Printf("skipping kernel signature verification (KEXEC_FILE_LOAD_UNSAFE)")
+		flags = KEXEC_FILE_LOAD_UNSAFE
 	}
 
 	// Try first without flags (requires signed kernel)
-	var flags uintptr = 0
-	_, _, errno := unix.Syscall6(
-		sysKexecFileLoad,
-		uintptr(kernelFile.Fd()),   // kernel_fd
-		uintptr(initrdFD),          // initrd_fd (-1 if none)
-		uintptr(len(cmdlineBytes)), // cmdline_len
-		cmdlinePtr,                 // cmdline
-		flags,                      // flags
-		0,                          // unused
-	)
+	errno := sys.KexecFileLoad(kernelFile.Fd(), uintptr(initrdFD), cmdline, flags)
 
 	// If we got EPERM and it's not due to sysctl, try with flag to skip signature verification
-	if errno == unix.EPERM {
+	if errno == unix.EPERM && unsafeMode == KexecUnsafeAuto {
 		log.Printf("kexec_file_load failed with EPERM, trying with KEXEC_FILE_LOAD_UNSAFE flag (may require lockdown=off)")
-		flags = KEXEC_FILE_LOAD_UNSAFE
-		_, _, errno = unix.Syscall6(
-			sysKexecFileLoad,
-			uintptr(kernelFile.Fd()),   // kernel_fd
-			uintptr(initrdFD),          // initrd_fd (-1 if none)
-			uintptr(len(cmdlineBytes)), // cmdline_len
-			cmdlinePtr,                 // cmdline
-			flags,                      // flags
-			0,                          // unused
-		)
+		errno = sys.KexecFileLoad(kernelFile.Fd(), uintptr(initrdFD), cmdline, KEXEC_FILE_LOAD_UNSAFE)
 	}
 
 	if errno != 0 {
@@ -127,20 +116,12 @@ func KexecLoadFromAssets(assets *types.BootAssets, extraCmdline string) error {
 	}
 
 	log.Printf("kexec loaded successfully, rebooting...")
+	status.SetPhase(status.PhaseRebooting, "kexec")
+	status.Stop()
 
 	// Call reboot with LINUX_REBOOT_CMD_KEXEC
 	const LINUX_REBOOT_CMD_KEXEC = 0x45584543
-	const LINUX_REBOOT_MAGIC1 = 0xfee1dead
-	const LINUX_REBOOT_MAGIC2 = 672274793
-	_, _, errno2 := unix.Syscall6(
-		sysReboot,              // arch-specific syscall number
-		LINUX_REBOOT_MAGIC1,    // magic1
-		LINUX_REBOOT_MAGIC2,    // magic2
-		LINUX_REBOOT_CMD_KEXEC, // cmd
-		0,                      // arg (unused)
-		0,                      // unused
-		0,                      // unused
-	)
+	errno2 := sys.Reboot(LINUX_REBOOT_CMD_KEXEC)
 	if errno2 != 0 {
 		return errors.Newf("reboot with kexec failed: %v", errno2)
 	}
@@ -149,6 +130,68 @@ func KexecLoadFromAssets(assets *types.BootAssets, extraCmdline string) error {
 	return nil
 }
 
+// joinCmdline combines the command line from the image with additional
+// arguments.
+func joinCmdline(imageCmdline, extraCmdline string) string {
+	cmdlineParts := []string{}
+	if imageCmdline != "" {
+		cmdlineParts = append(cmdlineParts, imageCmdline)
+	}
+	if extraCmdline != "" {
+		cmdlineParts = append(cmdlineParts, extraCmdline)
+	}
+	return strings.Join(cmdlineParts, " ")
+}
+
+// KernelCmdline returns the command line boot mode would pass to the kernel
+// of the image: its built-in command line followed by extraArgs and, unless
+// noPlatformInject, the platform arg (see withPlatformArg). Nothing is
+// loaded.
+func KernelCmdline(source types.ImageSource, extraArgs []string, noPlatformInject bool) (string, error) {
+	assets, err := source.GetBootAssets(nil)
+	if err != nil {
+		return "", errors.Wrap(err, "get boot assets")
+	}
+	defer assets.Close()
+	if !noPlatformInject {
+		extraArgs = withPlatformArg(assets.Cmdline, extraArgs)
+	}
+	return joinCmdline(assets.Cmdline, strings.Join(extraArgs, " ")), nil
+}
+
+// withPlatformArg appends talos.platform=metal to extraArgs if neither they
+// nor the image's command line name a platform. UKIs carry it in their
+// built-in command line, a separate kernel and initrd come without one and
+// Talos does not start without it.
+func withPlatformArg(imageCmdline string, extraArgs []string) []string {
+	for _, arg := range append(kernelcmdline.Split(imageCmdline), extraArgs...) {
+		if kernelcmdline.Key(arg) == "talos.platform" {
+			return extraArgs
+		}
+	}
+	log.Printf("the image has no talos.platform= kernel arg, adding talos.platform=%s (-no-platform-inject to skip)", kernelcmdline.PlatformMetal)
+	return append(slices.Clip(extraArgs), "talos.platform="+kernelcmdline.PlatformMetal)
+}
+
+// unloadStagedKernel checks for a kexec kernel left staged by an earlier,
+// aborted run and offers to unload it (like kexec -u) before a new one is
+// loaded. confirmed skips the question, e.g. when the plan already said so.
+func unloadStagedKernel(confirmed bool) error {
+	if !kexecLoaded() {
+		return nil
+	}
+	log.Printf("warning: a kexec kernel is already loaded, probably by an earlier aborted run")
+	if !confirmed && !cli.AskYesNo("Unload the staged kernel?", true) {
+		return errors.New("a kexec kernel is already loaded, unload it with 'kexec -u' and retry")
+	}
+	badFD := ^uintptr(0) // -1, the fds are ignored on unload
+	if errno := sys.KexecFileLoad(badFD, badFD, "", unix.KEXEC_FILE_UNLOAD); errno != 0 {
+		return errors.Wrap(handleKexecError(errno), "unload staged kexec kernel")
+	}
+	log.Printf("unloaded the staged kexec kernel")
+	return nil
+}
+
 // handleKexecError translates errno to descriptive error message.
 func handleKexecError(errno syscall.Errno) error {
 	switch errno { //nolint:exhaustive
@@ -159,17 +202,14 @@ func handleKexecError(errno syscall.Errno) error {
 		// 1. sysctl is disabled
 		// 2. lockdown mode is enabled (caused by Secure Boot)
 		// 3. kernel signature is required
-		lockdownData, _ := os.ReadFile("/sys/kernel/security/lockdown")
-		lockdown := strings.TrimSpace(string(lockdownData))
-		if strings.Contains(lockdown, "[confidentiality]") || strings.Contains(lockdown, "[integrity]") {
+		if lockdown := lockdownMode(); lockdown != "" && lockdown != "none" {
 			sbHint := ""
 			if sbState, err := efi.GetSecureBootState(); err == nil && sbState.Enabled {
 				sbHint = "\n  Note: Secure Boot is enabled, which activates kernel lockdown"
 			}
 			return errors.Newf("kexec blocked: kernel is in lockdown mode (%s).%s\nSolutions:\n  1. Disable Secure Boot in BIOS/UEFI settings\n  2. Boot with 'lockdown=none' kernel parameter", lockdown, sbHint)
 		}
-		sysctlData, _ := os.ReadFile("/proc/sys/kernel/kexec_load_disabled")
-		if strings.TrimSpace(string(sysctlData)) == "1" {
+		if kexecLoadDisabled() {
 			return errors.New("kexec is disabled via sysctl. Run: sudo sysctl -w kernel.kexec_load_disabled=0")
 		}
 		return errors.New("kexec blocked: permission denied. Possible causes:\n  1. Kernel requires signed image (try booting with 'lockdown=none')\n  2. Secure Boot is enabled\n  3. Check /proc/sys/kernel/kexec_load_disabled")
@@ -184,10 +224,37 @@ func handleKexecError(errno syscall.Errno) error {
 	}
 }
 
+// Options configures boot mode.
+type Options struct {
+	ExtraArgs   []string // extra kernel arguments
+	KexecUnsafe string   // KEXEC_FILE_LOAD_UNSAFE handling (KexecUnsafeAuto, KexecUnsafeNever or KexecUnsafeForce)
+	Secure      bool     // only boot UKIs signed by a key in the UEFI db
+
+	// X86Level is the x86-64 microarchitecture level the host CPU must
+	// support, 0 for the level in the UKI's os-release or else
+	// host.DefaultX86Level.
+	X86Level int
+
+	// NoPlatformInject leaves the command line without talos.platform= if
+	// the image and ExtraArgs have none, see withPlatformArg.
+	NoPlatformInject bool
+
+	// Talos lists the Talos kernel arguments included in ExtraArgs, for the
+	// summary.
+	Talos kernelcmdline.TalosOptions
+
+	// Plan extends the summary with the source type, network topology,
+	// Secure Boot and lockdown state and a staged kexec kernel, so that the
+	// boot is confirmed once.
+	Plan bool
+}
+
 // RunBootMode executes boot mode: shows summary, asks confirmation, loads kernel via kexec.
 //
 //nolint:forbidigo
-func RunBootMode(source types.ImageSource, extraArgs []string) {
+func RunBootMode(source types.ImageSource, opts Options) {
+	extraArgs := opts.ExtraArgs
+
 	// Check for 5-level paging incompatibility (LA57 on amd64).
 	// Talos kernel is compiled without CONFIG_X86_5LEVEL, so kexec from a host
 	// with 5-level paging active will triple-fault during the paging transition.
@@ -199,6 +266,9 @@ func RunBootMode(source types.ImageSource, extraArgs []string) {
 	// First show summary and ask for confirmation
 	fmt.Println("\nBoot Summary:")
 	fmt.Printf("  Image: %s\n", source.Reference())
+	if opts.Plan {
+		fmt.Printf("  Source type: %s\n", source.Type())
+	}
 	fmt.Printf("  Extra kernel args: %s\n",
 		func() string {
 			if len(extraArgs) == 0 {
@@ -206,23 +276,102 @@ func RunBootMode(source types.ImageSource, extraArgs []string) {
 			}
 			return strings.Join(extraArgs, " ")
 		}())
+	fmt.Printf("  Talos behavior: %s\n", opts.Talos)
+	fmt.Printf("  Unsigned kernel fallback: %s\n", opts.KexecUnsafe)
+	if opts.Secure {
+		fmt.Println("  Signature: the UKI must be signed by a key in the UEFI db (-secure)")
+	}
+	if opts.Plan {
+		printBootPlan(extraArgs)
+	}
 	fmt.Println()
 
 	if !cli.AskYesNo("Continue with boot?", true) {
-		log.Fatal("aborted by user")
+		cli.Fatalf(cli.ExitAborted, "aborted by user")
 	}
 	fmt.Println()
 
-	// Get boot assets from image source
+	cli.Must("boot", bootFromSource(source, opts))
+}
+
+// bootFromSource extracts the kernel and initramfs from source and loads them
+// with kexec, replacing a kernel staged by an earlier run (without asking
+// after the plan of opts.Plan was confirmed). With opts.Secure, the UKI must
+// be signed by a key in the UEFI db. On success the system reboots into the
+// new kernel.
+func bootFromSource(source types.ImageSource, opts Options) error {
+	var trust *uki.TrustStore
+	if opts.Secure {
+		var err error
+		if trust, err = efi.ReadTrustStore(); err != nil {
+			return cli.WithExitCode(cli.ExitHost, errors.Wrap(err, "-secure: cannot verify UKI signatures"))
+		}
+	}
+
 	log.Printf("boot mode: extracting kernel and initramfs from image")
+	status.SetPhase(status.PhaseExtracting, source.Reference())
 
-	assets, err := source.GetBootAssets()
-	cli.Must("get boot assets", err)
+	assets, err := source.GetBootAssets(trust)
+	if err != nil {
+		return cli.WithExitCode(cli.ExitImage, errors.Wrap(err, "get boot assets"))
+	}
 	defer assets.Close()
 
-	// Collect additional kernel arguments into a string
-	extraCmdline := strings.Join(extraArgs, " ")
+	// An image built for a newer CPU dies with an invalid opcode right
+	// after kexec, without any output.
+	x86Level := opts.X86Level
+	if x86Level == 0 {
+		x86Level = osReleaseX86Level(assets.OSRelease)
+	}
+	if err := host.CheckX86Level(x86Level); err != nil {
+		return cli.WithExitCode(cli.ExitHost, errors.Wrap(err, "use an image built for this CPU, or -cpu-level to boot anyway"))
+	}
+
+	if err := unloadStagedKernel(opts.Plan); err != nil {
+		return cli.WithExitCode(cli.ExitKexec, errors.Wrap(err, "unload staged kernel"))
+	}
+
+	extraArgs := opts.ExtraArgs
+	if !opts.NoPlatformInject {
+		extraArgs = withPlatformArg(assets.Cmdline, extraArgs)
+	}
 
 	log.Print("loading kernel with kexec")
-	cli.Must("kexec", KexecLoadFromAssets(assets, extraCmdline))
+	status.SetPhase(status.PhaseBooting, "")
+	return cli.WithExitCode(cli.ExitKexec, errors.Wrap(KexecLoadFromAssets(assets, strings.Join(extraArgs, " "), opts.KexecUnsafe), "kexec"))
+}
+
+// osReleaseX86Level returns the x86-64 microarchitecture level an os-release
+// file declares in X86_64_LEVEL, host.DefaultX86Level if it declares none.
+func osReleaseX86Level(osrel string) int {
+	for line := range strings.SplitSeq(osrel, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "X86_64_LEVEL=")
+		if !ok {
+			continue
+		}
+		level, err := host.ParseX86Level(strings.Trim(value, `"'`))
+		if err != nil {
+			log.Printf("warning: ignoring os-release of the image: %v", err)
+			break
+		}
+		return level
+	}
+	return host.DefaultX86Level
+}
+
+// printBootPlan prints the parts of the boot plan that are otherwise only
+// reported when they get in the way.
+//
+//nolint:forbidigo
+func printBootPlan(extraArgs []string) {
+	network.PrintSummary(extraArgs)
+	if sbState, err := efi.GetSecureBootState(); err == nil {
+		fmt.Printf("  Secure Boot: %s\n", sbState)
+	}
+	if lockdown := lockdownMode(); lockdown != "" {
+		fmt.Printf("  Kernel lockdown: %s\n", lockdown)
+	}
+	if kexecLoaded() {
+		fmt.Println("  Staged kexec kernel: loaded by an earlier run, will be unloaded")
+	}
 }
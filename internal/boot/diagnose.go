@@ -0,0 +1,232 @@
+//go:build linux
+
+package boot
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cozystack/boot-to-talos/internal/efi"
+)
+
+// Kernel state files consulted for kexec; variables so tests can point them
+// at fixtures.
+//
+//nolint:gochecknoglobals
+var (
+	lockdownPath          = "/sys/kernel/security/lockdown"
+	kexecLoadDisabledPath = "/proc/sys/kernel/kexec_load_disabled"
+	kexecLoadedPath       = "/sys/kernel/kexec_loaded"
+)
+
+// CheckStatus is the outcome of a single diagnostic check.
+type CheckStatus string
+
+const (
+	CheckOK   CheckStatus = "ok"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+)
+
+// Check is a single line of the kexec readiness report.
+type Check struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+}
+
+// Ready reports whether none of the checks failed.
+func Ready(checks []Check) bool {
+	for _, c := range checks {
+		if c.Status == CheckFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Exit codes of boot-to-talos check-kexec, one per kind of blocker. They
+// start at 10, above the cli.Exit codes check-kexec exits with on usage and
+// other errors, so that scripts can tell them apart. Never renumber them
+// either.
+const (
+	KexecReady       = 0
+	KexecNotRoot     = 10 // not running as root
+	KexecUnsupported = 11 // kernel without kexec or kexec_file_load
+	KexecDisabled    = 12 // kernel.kexec_load_disabled is set
+	KexecLockdown    = 13 // kernel lockdown only allows signed kernels
+	KexecSecureBoot  = 14 // Secure Boot is enabled
+	KexecDenied      = 15 // kexec_file_load denied for another reason
+)
+
+// kexecBlockers maps check results that block an unsigned kexec to exit
+// codes, most fundamental first: Secure Boot only matters through the
+// lockdown it usually enables.
+//
+//nolint:gochecknoglobals
+var kexecBlockers = []struct {
+	name   string
+	status CheckStatus
+	code   int
+}{
+	{"privileges", CheckFail, KexecNotRoot},
+	{"syscalls", CheckFail, KexecUnsupported},
+	{"CONFIG_KEXEC", CheckFail, KexecUnsupported},
+	{"CONFIG_KEXEC_FILE", CheckFail, KexecUnsupported},
+	{"kexec_load_disabled", CheckFail, KexecDisabled},
+	{"lockdown", CheckWarn, KexecLockdown},
+	{"Secure Boot", CheckWarn, KexecSecureBoot},
+	{"CONFIG_KEXEC_FILE", CheckWarn, KexecDenied},
+}
+
+// KexecBlocker returns the exit code and a one-line reason for the first
+// blocker among checks, or KexecReady.
+func KexecBlocker(checks []Check) (int, string) {
+	for _, b := range kexecBlockers {
+		for _, c := range checks {
+			if c.Name == b.name && c.Status == b.status {
+				return b.code, c.Name + ": " + c.Detail
+			}
+		}
+	}
+	return KexecReady, "kexec of an unsigned kernel is possible"
+}
+
+// DiagnoseKexec checks everything kexec needs on this host without loading
+// anything. It only reads kernel state and probes syscalls with invalid
+// arguments.
+func DiagnoseKexec() []Check {
+	return []Check{
+		checkPrivileges(),
+		checkSyscalls(),
+		checkKexecCore(),
+		checkKexecFile(),
+		checkKexecSysctl(),
+		checkLockdown(),
+		checkSecureBoot(),
+	}
+}
+
+func checkPrivileges() Check {
+	c := Check{Name: "privileges"}
+	if os.Geteuid() != 0 {
+		c.Status, c.Detail = CheckFail, "not running as root (CAP_SYS_BOOT is required)"
+		return c
+	}
+	c.Status, c.Detail = CheckOK, "running as root"
+	return c
+}
+
+func checkSyscalls() Check {
+	c := Check{Name: "syscalls"}
+	f, err := CreateMemfdFromReader("diagnose", bytes.NewReader(nil))
+	if err != nil {
+		c.Status, c.Detail = CheckFail, "memfd_create unavailable on "+runtime.GOARCH+": "+err.Error()
+		return c
+	}
+	f.Close()
+	c.Status, c.Detail = CheckOK, "memfd_create, kexec_file_load and reboot resolved for "+runtime.GOARCH
+	return c
+}
+
+func checkKexecCore() Check {
+	c := Check{Name: "CONFIG_KEXEC"}
+	if _, err := os.Stat(kexecLoadedPath); err != nil {
+		c.Status, c.Detail = CheckFail, kexecLoadedPath+" missing, kernel built without kexec support"
+		return c
+	}
+	c.Status, c.Detail = CheckOK, "kexec core is available"
+	return c
+}
+
+// checkKexecFile calls kexec_file_load with an invalid kernel fd: a kernel
+// with CONFIG_KEXEC_FILE rejects the fd, one without it returns ENOSYS.
+func checkKexecFile() Check {
+	c := Check{Name: "CONFIG_KEXEC_FILE"}
+	badFD := ^uintptr(0) // -1
+	errno := sys.KexecFileLoad(badFD, badFD, "", 0)
+	switch errno { //nolint:exhaustive
+	case unix.ENOSYS:
+		c.Status, c.Detail = CheckFail, "kexec_file_load not implemented by the kernel"
+	case unix.EPERM:
+		c.Status, c.Detail = CheckWarn, "kexec_file_load present but denied (see privileges, sysctl and lockdown)"
+	default:
+		c.Status, c.Detail = CheckOK, "kexec_file_load is available"
+	}
+	return c
+}
+
+func checkKexecSysctl() Check {
+	c := Check{Name: "kexec_load_disabled"}
+	if kexecLoadDisabled() {
+		c.Status, c.Detail = CheckFail, "kexec disabled via sysctl; it cannot be re-enabled without a reboot"
+		return c
+	}
+	c.Status, c.Detail = CheckOK, "kexec not disabled via sysctl"
+	return c
+}
+
+func checkLockdown() Check {
+	c := Check{Name: "lockdown"}
+	mode := lockdownMode()
+	switch mode {
+	case "":
+		c.Status, c.Detail = CheckOK, "lockdown LSM not active"
+	case "none":
+		c.Status, c.Detail = CheckOK, "lockdown: none"
+	default:
+		c.Status, c.Detail = CheckWarn, "lockdown: "+mode+" (only signed kernels can be loaded)"
+	}
+	return c
+}
+
+func checkSecureBoot() Check {
+	c := Check{Name: "Secure Boot"}
+	state, err := efi.GetSecureBootState()
+	switch {
+	case err != nil:
+		c.Status, c.Detail = CheckOK, "unknown ("+err.Error()+")"
+	case state.Enabled:
+		c.Status, c.Detail = CheckWarn, "enabled, kernel lockdown is likely"
+	default:
+		c.Status, c.Detail = CheckOK, "disabled"
+	}
+	return c
+}
+
+// lockdownMode returns the active kernel lockdown mode, or "" if the
+// lockdown LSM is not available.
+func lockdownMode() string {
+	data, err := os.ReadFile(lockdownPath)
+	if err != nil {
+		return ""
+	}
+	return parseLockdown(string(data))
+}
+
+// parseLockdown extracts the bracketed mode from the lockdown securityfs file,
+// e.g. "none [integrity] confidentiality" yields "integrity".
+func parseLockdown(data string) string {
+	for _, field := range strings.Fields(data) {
+		if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+			return strings.Trim(field, "[]")
+		}
+	}
+	return ""
+}
+
+// kexecLoadDisabled reports whether kexec was disabled via sysctl.
+func kexecLoadDisabled() bool {
+	data, err := os.ReadFile(kexecLoadDisabledPath)
+	return err == nil && strings.TrimSpace(string(data)) == "1"
+}
+
+// kexecLoaded reports whether a kexec kernel image is currently staged.
+func kexecLoaded() bool {
+	data, err := os.ReadFile(kexecLoadedPath)
+	return err == nil && strings.TrimSpace(string(data)) == "1"
+}
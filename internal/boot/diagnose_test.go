@@ -0,0 +1,81 @@
+//go:build linux
+
+package boot
+
+import (
+	"testing"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+)
+
+func TestParseLockdown(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"[none] integrity confidentiality\n", "none"},
+		{"none [integrity] confidentiality\n", "integrity"},
+		{"none integrity [confidentiality]\n", "confidentiality"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseLockdown(tt.data); got != tt.want {
+			t.Errorf("parseLockdown(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []Check
+		want   bool
+	}{
+		{"empty", nil, true},
+		{"warnings only", []Check{{Status: CheckOK}, {Status: CheckWarn}}, true},
+		{"failure", []Check{{Status: CheckOK}, {Status: CheckFail}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Ready(tt.checks); got != tt.want {
+				t.Errorf("Ready() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKexecBlocker(t *testing.T) {
+	ok := func(name string) Check { return Check{Name: name, Status: CheckOK} }
+	tests := []struct {
+		name   string
+		checks []Check
+		want   int
+	}{
+		{"ready", []Check{ok("privileges"), ok("lockdown"), ok("Secure Boot")}, KexecReady},
+		{"not root", []Check{{Name: "privileges", Status: CheckFail}, {Name: "lockdown", Status: CheckWarn}}, KexecNotRoot},
+		{"sysctl", []Check{ok("privileges"), {Name: "kexec_load_disabled", Status: CheckFail}}, KexecDisabled},
+		{"lockdown before Secure Boot", []Check{
+			{Name: "CONFIG_KEXEC_FILE", Status: CheckWarn},
+			{Name: "lockdown", Status: CheckWarn},
+			{Name: "Secure Boot", Status: CheckWarn},
+		}, KexecLockdown},
+		{"Secure Boot", []Check{ok("lockdown"), {Name: "Secure Boot", Status: CheckWarn}}, KexecSecureBoot},
+		{"denied", []Check{ok("lockdown"), {Name: "CONFIG_KEXEC_FILE", Status: CheckWarn}}, KexecDenied},
+		{"unsupported", []Check{{Name: "CONFIG_KEXEC_FILE", Status: CheckFail}}, KexecUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := KexecBlocker(tt.checks); got != tt.want {
+				t.Errorf("KexecBlocker() = %d (%s), want %d", got, reason, tt.want)
+			}
+		})
+		// Scripts must tell the blockers from the codes of usage and
+		// other errors.
+		if tt.want != KexecReady && tt.want <= cli.ExitReboot {
+			t.Errorf("%s: exit code %d overlaps the cli.Exit codes", tt.name, tt.want)
+		}
+	}
+}
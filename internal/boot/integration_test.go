@@ -0,0 +1,120 @@
+//go:build linux
+
+package boot
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+
+	"github.com/cozystack/boot-to-talos/internal/source"
+	"github.com/cozystack/boot-to-talos/internal/testutil"
+)
+
+const (
+	testKernel  = "integration-kernel"
+	testInitrd  = "integration-initrd"
+	testCmdline = "talos.platform=metal console=tty0"
+)
+
+// createTestUKI returns the contents of a UKI with the test kernel, initrd
+// and command line.
+func createTestUKI(t *testing.T) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vmlinuz.efi")
+	if err := testutil.CreateTestUKIFile(path, testCmdline, testKernel, testInitrd); err != nil {
+		t.Fatalf("create UKI: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read UKI: %v", err)
+	}
+	return data
+}
+
+// rawImageRef writes a RAW disk image with the UKI on its ESP.
+func rawImageRef(t *testing.T, uki []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metal-amd64.raw")
+	if err := testutil.CreateTestRAWImage(path, 64, map[string][]byte{"/EFI/BOOT/BOOTX64.EFI": uki}); err != nil {
+		t.Fatalf("create RAW image: %v", err)
+	}
+	return path
+}
+
+// containerImageRef pushes an installer-like image with the UKI to a local
+// registry.
+func containerImageRef(t *testing.T, uki []byte) string {
+	t.Helper()
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+
+	img, err := crane.Image(map[string][]byte{
+		"usr/install/" + runtime.GOARCH + "/vmlinuz.efi": uki,
+	})
+	if err != nil {
+		t.Fatalf("build image: %v", err)
+	}
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/siderolabs/installer:v1.11.6"
+	if err := crane.Push(img, ref); err != nil {
+		t.Fatalf("push image: %v", err)
+	}
+	return ref
+}
+
+// TestBootFromSource drives boot mode from an image reference through source
+// detection, boot asset extraction and the memfds to kexec_file_load, with
+// the system calls faked.
+func TestBootFromSource(t *testing.T) {
+	uki := createTestUKI(t)
+	tests := []struct {
+		name string
+		ref  func(*testing.T, []byte) string
+	}{
+		{"RAW image", rawImageRef},
+		{"container image", containerImageRef},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withKernelState(t, "", "0")
+			orig := kexecLoadedPath
+			kexecLoadedPath = filepath.Join(t.TempDir(), "kexec_loaded")
+			t.Cleanup(func() { kexecLoadedPath = orig })
+			if err := os.WriteFile(kexecLoadedPath, []byte("0\n"), 0o644); err != nil {
+				t.Fatalf("write kexec_loaded: %v", err)
+			}
+			fake := &fakeSyscaller{}
+			withSyscaller(t, fake)
+
+			src, err := source.DetectImageSource(tt.ref(t, uki))
+			if err != nil {
+				t.Fatalf("DetectImageSource error: %v", err)
+			}
+			defer src.Close()
+
+			if err := bootFromSource(src, Options{ExtraArgs: []string{"ip=dhcp", "console=ttyS0"}, KexecUnsafe: KexecUnsafeAuto, X86Level: 1}); err != nil {
+				t.Fatalf("bootFromSource error: %v", err)
+			}
+			if len(fake.kernels) != 1 || fake.kernels[0] != testKernel {
+				t.Errorf("kexec kernels = %q, want %q", fake.kernels, testKernel)
+			}
+			if len(fake.initrds) != 1 || fake.initrds[0] != testInitrd {
+				t.Errorf("kexec initrds = %q, want %q", fake.initrds, testInitrd)
+			}
+			if want := testCmdline + " ip=dhcp console=ttyS0"; len(fake.cmdlines) != 1 || fake.cmdlines[0] != want {
+				t.Errorf("kexec cmdlines = %q, want %q", fake.cmdlines, want)
+			}
+			if len(fake.rebootCmds) != 1 {
+				t.Errorf("reboot commands = %#x, want one LINUX_REBOOT_CMD_KEXEC", fake.rebootCmds)
+			}
+		})
+	}
+}
@@ -38,7 +38,7 @@ func HandleNo5LVLWorkaround() {
 	fmt.Println()
 
 	if !cli.AskYesNo("Reboot to disable 5-level paging?", true) {
-		log.Fatal("aborted by user")
+		cli.Fatalf(cli.ExitAborted, "aborted by user")
 	}
 
 	if err := patchGrubNo5LVL(); err != nil {
@@ -145,7 +145,7 @@ func reboot() {
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
-		log.Fatalf("reboot failed: %v", err)
+		cli.Fatalf(cli.ExitReboot, "reboot failed: %v", err)
 	}
 }
 
@@ -154,8 +154,8 @@ func reboot() {
 //nolint:forbidigo
 func fallbackToManualInstructions() {
 	fmt.Println()
-	log.Fatal("Automatic workaround failed. Manual steps:\n" +
-		"  1. Edit /etc/default/grub: add 'no5lvl' to GRUB_CMDLINE_LINUX\n" +
-		"  2. Run: update-grub && reboot\n" +
+	cli.Fatalf(cli.ExitHost, "Automatic workaround failed. Manual steps:\n"+
+		"  1. Edit /etc/default/grub: add 'no5lvl' to GRUB_CMDLINE_LINUX\n"+
+		"  2. Run: update-grub && reboot\n"+
 		"  3. Re-run boot-to-talos")
 }
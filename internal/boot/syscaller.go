@@ -0,0 +1,71 @@
+//go:build linux
+
+package boot
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// syscaller wraps the raw system calls used by boot mode so that tests can
+// replace them. Errors are returned as errno values, 0 meaning success.
+type syscaller interface {
+	// MemfdCreate creates an anonymous memory file and returns its fd.
+	MemfdCreate(name string, flags uintptr) (uintptr, syscall.Errno)
+	// KexecFileLoad loads the kernel and initrd for a later kexec reboot.
+	KexecFileLoad(kernelFD, initrdFD uintptr, cmdline string, flags uintptr) syscall.Errno
+	// Reboot calls reboot(2) with the given command.
+	Reboot(cmd uintptr) syscall.Errno
+}
+
+//nolint:gochecknoglobals
+var sys syscaller = linuxSyscaller{}
+
+// linuxSyscaller issues the system calls using the arch-specific numbers.
+type linuxSyscaller struct{}
+
+func (linuxSyscaller) MemfdCreate(name string, flags uintptr) (uintptr, syscall.Errno) {
+	nameBytes := []byte(name + "\x00")
+	fd, _, errno := unix.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(&nameBytes[0])), flags, 0)
+	return fd, errno
+}
+
+// KexecFileLoad calls
+// long kexec_file_load(int kernel_fd, int initrd_fd, unsigned long cmdline_len, const char *cmdline, unsigned long flags).
+// The command line length includes the terminating NUL.
+func (linuxSyscaller) KexecFileLoad(kernelFD, initrdFD uintptr, cmdline string, flags uintptr) syscall.Errno {
+	var cmdlinePtr unsafe.Pointer
+	cmdlineLen := 0
+	if cmdline != "" {
+		cmdlineBytes := append([]byte(cmdline), 0)
+		cmdlinePtr = unsafe.Pointer(&cmdlineBytes[0])
+		cmdlineLen = len(cmdlineBytes)
+	}
+	_, _, errno := unix.Syscall6(
+		sysKexecFileLoad,
+		kernelFD,            // kernel_fd
+		initrdFD,            // initrd_fd (-1 if none)
+		uintptr(cmdlineLen), // cmdline_len
+		uintptr(cmdlinePtr), // cmdline
+		flags,               // flags
+		0,                   // unused
+	)
+	return errno
+}
+
+func (linuxSyscaller) Reboot(cmd uintptr) syscall.Errno {
+	const LINUX_REBOOT_MAGIC1 = 0xfee1dead
+	const LINUX_REBOOT_MAGIC2 = 672274793
+	_, _, errno := unix.Syscall6(
+		sysReboot,           // arch-specific syscall number
+		LINUX_REBOOT_MAGIC1, // magic1
+		LINUX_REBOOT_MAGIC2, // magic2
+		cmd,                 // cmd
+		0,                   // arg (unused)
+		0,                   // unused
+		0,                   // unused
+	)
+	return errno
+}
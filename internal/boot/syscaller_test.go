@@ -0,0 +1,295 @@
+//go:build linux
+
+package boot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/cozystack/boot-to-talos/internal/uki"
+)
+
+// fakeSyscaller records kexec and reboot calls. Memory files are real.
+type fakeSyscaller struct {
+	linuxSyscaller
+
+	kexecErrs  []syscall.Errno // results of successive KexecFileLoad calls
+	kexecFlags []uintptr
+	cmdlines   []string
+	kernels    []string // contents of the kernel files passed to successful loads
+	initrds    []string
+	rebootCmds []uintptr
+}
+
+func (f *fakeSyscaller) KexecFileLoad(kernelFD, initrdFD uintptr, cmdline string, flags uintptr) syscall.Errno {
+	f.kexecFlags = append(f.kexecFlags, flags)
+	f.cmdlines = append(f.cmdlines, cmdline)
+	// Unloads pass -1 for the fds.
+	if kernelFD != ^uintptr(0) && (len(f.kexecErrs) == 0 || f.kexecErrs[0] == 0) {
+		f.kernels = append(f.kernels, readFD(kernelFD))
+		f.initrds = append(f.initrds, readFD(initrdFD))
+	}
+	if len(f.kexecErrs) == 0 {
+		return 0
+	}
+	errno := f.kexecErrs[0]
+	f.kexecErrs = f.kexecErrs[1:]
+	return errno
+}
+
+func (f *fakeSyscaller) Reboot(cmd uintptr) syscall.Errno {
+	f.rebootCmds = append(f.rebootCmds, cmd)
+	return 0
+}
+
+// readFD returns the contents of the file open as fd, like the kernel reads
+// the kernel and initrd memfds.
+func readFD(fd uintptr) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/self/fd/%d", fd))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func withSyscaller(t *testing.T, s syscaller) {
+	t.Helper()
+	orig := sys
+	sys = s
+	t.Cleanup(func() { sys = orig })
+}
+
+// withKernelState points the kernel state files at fixtures.
+func withKernelState(t *testing.T, lockdown, kexecDisabled string) {
+	t.Helper()
+	dir := t.TempDir()
+	origLockdown, origDisabled := lockdownPath, kexecLoadDisabledPath
+	lockdownPath = filepath.Join(dir, "lockdown")
+	kexecLoadDisabledPath = filepath.Join(dir, "kexec_load_disabled")
+	t.Cleanup(func() { lockdownPath, kexecLoadDisabledPath = origLockdown, origDisabled })
+
+	if lockdown != "" {
+		if err := os.WriteFile(lockdownPath, []byte(lockdown+"\n"), 0o644); err != nil {
+			t.Fatalf("write lockdown: %v", err)
+		}
+	}
+	if err := os.WriteFile(kexecLoadDisabledPath, []byte(kexecDisabled+"\n"), 0o644); err != nil {
+		t.Fatalf("write kexec_load_disabled: %v", err)
+	}
+}
+
+func testBootAssets() *types.BootAssets {
+	return &types.BootAssets{
+		Kernel:  io.NopCloser(strings.NewReader("kernel")),
+		Initrd:  io.NopCloser(strings.NewReader("initrd")),
+		Cmdline: "talos.platform=metal",
+	}
+}
+
+func TestKexecLoadFromAssets(t *testing.T) {
+	fake := &fakeSyscaller{}
+	withSyscaller(t, fake)
+
+	if err := KexecLoadFromAssets(testBootAssets(), "console=ttyS0", KexecUnsafeAuto); err != nil {
+		t.Fatalf("KexecLoadFromAssets error: %v", err)
+	}
+	if len(fake.kexecFlags) != 1 || fake.kexecFlags[0] != 0 {
+		t.Errorf("kexec_file_load flags = %v, want [0]", fake.kexecFlags)
+	}
+	if want := "talos.platform=metal console=ttyS0"; fake.cmdlines[0] != want {
+		t.Errorf("cmdline = %q, want %q", fake.cmdlines[0], want)
+	}
+	if len(fake.rebootCmds) != 1 || fake.rebootCmds[0] != 0x45584543 {
+		t.Errorf("reboot commands = %#x, want [LINUX_REBOOT_CMD_KEXEC]", fake.rebootCmds)
+	}
+}
+
+func TestKexecLoadFromAssets_RetriesOnEPERM(t *testing.T) {
+	fake := &fakeSyscaller{kexecErrs: []syscall.Errno{unix.EPERM, 0}}
+	withSyscaller(t, fake)
+
+	if err := KexecLoadFromAssets(testBootAssets(), "", KexecUnsafeAuto); err != nil {
+		t.Fatalf("KexecLoadFromAssets error: %v", err)
+	}
+	if len(fake.kexecFlags) != 2 || fake.kexecFlags[0] != 0 || fake.kexecFlags[1] == 0 {
+		t.Errorf("kexec_file_load flags = %v, want a retry with flags set", fake.kexecFlags)
+	}
+	if len(fake.rebootCmds) != 1 {
+		t.Errorf("reboot called %d times, want 1", len(fake.rebootCmds))
+	}
+}
+
+func TestKexecLoadFromAssets_UnsafeModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		errs      []syscall.Errno
+		wantFlags []uintptr
+		wantErr   bool
+	}{
+		{"never fails on EPERM", KexecUnsafeNever, []syscall.Errno{unix.EPERM}, []uintptr{0}, true},
+		{"force sets flag first", KexecUnsafeForce, nil, []uintptr{1}, false},
+		{"force does not retry", KexecUnsafeForce, []syscall.Errno{unix.EPERM}, []uintptr{1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withKernelState(t, "", "0")
+			fake := &fakeSyscaller{kexecErrs: tt.errs}
+			withSyscaller(t, fake)
+
+			err := KexecLoadFromAssets(testBootAssets(), "", tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KexecLoadFromAssets error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(fake.kexecFlags, tt.wantFlags) {
+				t.Errorf("kexec_file_load flags = %v, want %v", fake.kexecFlags, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestKexecLoadFromAssets_NoRebootOnError(t *testing.T) {
+	fake := &fakeSyscaller{kexecErrs: []syscall.Errno{unix.ENOSYS}}
+	withSyscaller(t, fake)
+
+	err := KexecLoadFromAssets(testBootAssets(), "", KexecUnsafeAuto)
+	if err == nil || !strings.Contains(err.Error(), "CONFIG_KEXEC") {
+		t.Errorf("KexecLoadFromAssets error = %v, want CONFIG_KEXEC hint", err)
+	}
+	if len(fake.rebootCmds) != 0 {
+		t.Errorf("reboot called after failed kexec_file_load")
+	}
+}
+
+func TestHandleKexecError(t *testing.T) {
+	tests := []struct {
+		name          string
+		errno         syscall.Errno
+		lockdown      string
+		kexecDisabled string
+		want          string
+	}{
+		{"no kexec", unix.ENOSYS, "", "0", "CONFIG_KEXEC"},
+		{"lockdown", unix.EPERM, "none [integrity] confidentiality", "0", "lockdown mode (integrity)"},
+		{"sysctl", unix.EPERM, "[none] integrity confidentiality", "1", "kernel.kexec_load_disabled=0"},
+		{"permission", unix.EPERM, "", "0", "permission denied"},
+		{"busy", unix.EBUSY, "", "0", "busy"},
+		{"key rejected", unix.EKEYREJECTED, "", "0", "signature verification failed"},
+		{"not supported", unix.EOPNOTSUPP, "", "0", "CONFIG_KEXEC_FILE"},
+		{"other", unix.EINVAL, "", "0", "Check dmesg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withKernelState(t, tt.lockdown, tt.kexecDisabled)
+			err := handleKexecError(tt.errno)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("handleKexecError(%v) = %v, want error containing %q", tt.errno, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnloadStagedKernel(t *testing.T) {
+	origYes := cli.YesFlag
+	cli.YesFlag = true
+	t.Cleanup(func() { cli.YesFlag = origYes })
+
+	tests := []struct {
+		name      string
+		loaded    string
+		errs      []syscall.Errno
+		wantFlags []uintptr
+		wantErr   bool
+	}{
+		{"nothing staged", "0", nil, nil, false},
+		{"unloads staged kernel", "1", nil, []uintptr{unix.KEXEC_FILE_UNLOAD}, false},
+		{"unload fails", "1", []syscall.Errno{unix.EBUSY}, []uintptr{unix.KEXEC_FILE_UNLOAD}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := kexecLoadedPath
+			kexecLoadedPath = filepath.Join(t.TempDir(), "kexec_loaded")
+			t.Cleanup(func() { kexecLoadedPath = orig })
+			if err := os.WriteFile(kexecLoadedPath, []byte(tt.loaded+"\n"), 0o644); err != nil {
+				t.Fatalf("write kexec_loaded: %v", err)
+			}
+			fake := &fakeSyscaller{kexecErrs: tt.errs}
+			withSyscaller(t, fake)
+
+			err := unloadStagedKernel(false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unloadStagedKernel error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(fake.kexecFlags, tt.wantFlags) {
+				t.Errorf("kexec_file_load flags = %v, want %v", fake.kexecFlags, tt.wantFlags)
+			}
+		})
+	}
+}
+
+// assetsSource is an ImageSource serving fixed boot assets.
+type assetsSource struct {
+	types.ImageSource
+
+	assets *types.BootAssets
+}
+
+func (s assetsSource) GetBootAssets(_ *uki.TrustStore) (*types.BootAssets, error) {
+	return s.assets, nil
+}
+
+func TestKernelCmdline(t *testing.T) {
+	fake := &fakeSyscaller{}
+	withSyscaller(t, fake)
+
+	got, err := KernelCmdline(assetsSource{assets: testBootAssets()}, []string{"console=ttyS0", "ip=dhcp"}, false)
+	if err != nil {
+		t.Fatalf("KernelCmdline error: %v", err)
+	}
+	if want := "talos.platform=metal console=ttyS0 ip=dhcp"; got != want {
+		t.Errorf("KernelCmdline() = %q, want %q", got, want)
+	}
+	if len(fake.kexecFlags) != 0 || len(fake.rebootCmds) != 0 {
+		t.Errorf("KernelCmdline loaded or rebooted a kernel")
+	}
+}
+
+func TestWithPlatformArg(t *testing.T) {
+	tests := []struct {
+		name      string
+		cmdline   string
+		extraArgs []string
+		want      []string
+	}{
+		{"UKI with platform", "talos.platform=aws console=ttyS0", []string{"ip=dhcp"}, []string{"ip=dhcp"}},
+		{"platform in extra args", "", []string{"talos.platform=nocloud"}, []string{"talos.platform=nocloud"}},
+		{"kernel and initrd", "", []string{"ip=dhcp"}, []string{"ip=dhcp", "talos.platform=metal"}},
+		{"other talos args only", "talos.dashboard.disabled=1", nil, []string{"talos.platform=metal"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withPlatformArg(tt.cmdline, tt.extraArgs); !slices.Equal(got, tt.want) {
+				t.Errorf("withPlatformArg() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	got, err := KernelCmdline(assetsSource{assets: &types.BootAssets{Cmdline: ""}}, []string{"ip=dhcp"}, true)
+	if err != nil {
+		t.Fatalf("KernelCmdline error: %v", err)
+	}
+	if got != "ip=dhcp" {
+		t.Errorf("KernelCmdline() with noPlatformInject = %q, want %q", got, "ip=dhcp")
+	}
+}
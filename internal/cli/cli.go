@@ -3,9 +3,11 @@ package cli
 import (
 	"bufio"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strings"
+
+	"github.com/cockroachdb/errors"
 )
 
 // MultiFlag allows a flag to be specified multiple times.
@@ -22,23 +24,61 @@ var (
 	reader = bufio.NewReader(os.Stdin)
 )
 
-// Must logs a fatal error if err is not nil.
+// Must logs a fatal error if err is not nil and exits with the code of err,
+// see ExitCode.
 func Must(msg string, err error) {
 	if err != nil {
-		log.Fatalf("%s: %v", msg, err)
+		Fatalf(ExitCode(err), "%s: %v", msg, err)
 	}
 }
 
+// Prompter asks questions: it prints them to Out and reads the answers from
+// In, stdin if nil, or with Yes takes the defaults, as -yes does.
+type Prompter struct {
+	Out io.Writer
+	In  *bufio.Reader
+	Yes bool
+}
+
+// Default returns the Prompter of the command line: stdout, and the defaults
+// with -yes.
+func Default() Prompter {
+	return Prompter{Out: os.Stdout, Yes: YesFlag}
+}
+
 // Ask prompts for input with a default value.
-//
-//nolint:forbidigo
 func Ask(msg, def string) string {
-	if YesFlag {
-		fmt.Printf("%s [%s]: %s\n", msg, def, def)
+	return Default().Ask(msg, def)
+}
+
+// AskRequired prompts for required input (cannot be empty).
+func AskRequired(msg string) string {
+	return Default().AskRequired(msg)
+}
+
+// AskYesNo prompts for a yes/no answer with a default.
+func AskYesNo(msg string, def bool) bool {
+	return Default().AskYesNo(msg, def)
+}
+
+// readLine reads a line of input from In, or stdin if In is nil. The error
+// is only set if the input ended.
+func (p Prompter) readLine() (string, error) {
+	in := p.In
+	if in == nil {
+		in = reader
+	}
+	return in.ReadString('\n')
+}
+
+// Ask prompts for input with a default value.
+func (p Prompter) Ask(msg, def string) string {
+	if p.Yes {
+		fmt.Fprintf(p.Out, "%s [%s]: %s\n", msg, def, def)
 		return def
 	}
-	fmt.Printf("%s [%s]: ", msg, def)
-	t, _ := reader.ReadString('\n')
+	fmt.Fprintf(p.Out, "%s [%s]: ", msg, def)
+	t, _ := p.readLine()
 	t = strings.TrimSpace(t)
 	if t == "" {
 		return def
@@ -46,29 +86,38 @@ func Ask(msg, def string) string {
 	return t
 }
 
-// AskRequired prompts for required input (cannot be empty).
-//
-//nolint:forbidigo
-func AskRequired(msg string) string {
-	if YesFlag {
-		log.Fatalf("missing required input for: %s (cannot auto-fill)", msg)
+// AskRequired prompts for required input (cannot be empty). With Yes it
+// fails, there is no default to take.
+func (p Prompter) AskRequired(msg string) string {
+	t, err := p.Require(msg)
+	if err != nil {
+		Fatalf(ExitUsage, "%v", err)
+	}
+	return t
+}
+
+// Require is AskRequired returning the error for Yes, with ExitUsage, to
+// callers that handle it.
+func (p Prompter) Require(msg string) (string, error) {
+	if p.Yes {
+		return "", WithExitCode(ExitUsage, errors.Newf("missing required input for: %s (cannot auto-fill)", msg))
 	}
 	for {
-		fmt.Printf("%s: ", msg)
-		t, _ := reader.ReadString('\n')
-		t = strings.TrimSpace(t)
-		if t != "" {
-			return t
+		fmt.Fprintf(p.Out, "%s: ", msg)
+		t, err := p.readLine()
+		if t = strings.TrimSpace(t); t != "" {
+			return t, nil
+		}
+		if err != nil {
+			return "", WithExitCode(ExitUsage, errors.Wrapf(err, "missing required input for: %s", msg))
 		}
 	}
 }
 
 // AskYesNo prompts for a yes/no answer with a default.
-//
-//nolint:forbidigo
-func AskYesNo(msg string, def bool) bool {
-	if YesFlag {
-		fmt.Printf("%s [%s]: %v\n", msg, map[bool]string{true: "yes", false: "no"}[def], def)
+func (p Prompter) AskYesNo(msg string, def bool) bool {
+	if p.Yes {
+		fmt.Fprintf(p.Out, "%s [%s]: %v\n", msg, map[bool]string{true: "yes", false: "no"}[def], def)
 		return def
 	}
 	defStr := "yes"
@@ -76,8 +125,8 @@ func AskYesNo(msg string, def bool) bool {
 		defStr = "no"
 	}
 	for {
-		fmt.Printf("%s [%s]: ", msg, defStr)
-		in, _ := reader.ReadString('\n')
+		fmt.Fprintf(p.Out, "%s [%s]: ", msg, defStr)
+		in, _ := p.readLine()
 		in = strings.TrimSpace(strings.ToLower(in))
 		if in == "" {
 			return def
@@ -88,17 +137,27 @@ func AskYesNo(msg string, def bool) bool {
 		if in == "n" || in == "no" {
 			return false
 		}
-		fmt.Println("Please answer 'yes' or 'no'.")
+		fmt.Fprintln(p.Out, "Please answer 'yes' or 'no'.")
 	}
 }
 
-// AskMode prompts for boot/install mode selection.
+// BIOSModeWarning explains what install mode means on a host booted in legacy
+// BIOS mode.
+const BIOSModeWarning = "Note: this host booted in legacy BIOS mode. Install mode relies on the Talos installer's\n" +
+	"BIOS boot loader; images that only boot via UEFI (UKI) will not start after the reboot.\n" +
+	"Boot mode works with any firmware and is recommended here."
+
+// AskMode prompts for boot/install mode selection. On hosts booted in legacy
+// BIOS mode (uefi false) it explains why boot mode is the safer choice.
 //
 //nolint:forbidigo
-func AskMode() string {
+func AskMode(uefi bool) string {
 	modeOptions := "Mode:\n" +
 		"  1. boot – extract the kernel and initrd from the Talos installer and boot them directly using the kexec mechanism.\n" +
 		"  2. install – prepare the environment, run the Talos installer, and then overwrite the system disk with the installed image."
+	if !uefi {
+		modeOptions += "\n" + BIOSModeWarning
+	}
 
 	if YesFlag {
 		fmt.Println(modeOptions)
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/status"
+)
+
+// Exit codes of boot-to-talos, for scripts that wrap it. They are documented
+// in the README: never renumber them, only add new ones.
+const (
+	ExitOK        = 0
+	ExitFailure   = 1 // any failure without a more specific code
+	ExitUsage     = 2 // invalid flags or arguments, as for the flag package
+	ExitAborted   = 3 // the user declined a confirmation prompt
+	ExitImage     = 4 // the image could not be opened, downloaded, pulled or unpacked
+	ExitKexec     = 5 // the kernel could not be loaded or executed with kexec
+	ExitTarget    = 6 // the target disk is unsuitable: in use, too small or not writable
+	ExitInstaller = 7 // the Talos installer failed or produced an unbootable image
+	ExitHost      = 8 // the host cannot run the image or the install, e.g. its CPU is too old
+	ExitReboot    = 9 // the image is written but the host was not rebooted
+)
+
+// exitError attaches an exit code to an error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// WithExitCode returns err with the exit code Must and Exit use for it, nil
+// if err is nil. Wrapping the result keeps the code.
+func WithExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// ExitCode returns the exit code attached to err with WithExitCode,
+// ExitFailure if there is none.
+func ExitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return ExitFailure
+}
+
+// Fatalf logs like log.Fatalf and exits with code, after the status
+// server reported the failure.
+func Fatalf(code int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	_ = log.Output(2, msg)
+	status.Fail(msg)
+	os.Exit(code)
+}
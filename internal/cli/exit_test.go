@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no code", errors.New("failed"), ExitFailure},
+		{"code", WithExitCode(ExitImage, errors.New("pull failed")), ExitImage},
+		{"wrapped", errors.Wrap(WithExitCode(ExitKexec, errors.New("EPERM")), "kexec"), ExitKexec},
+		{"outermost code wins", WithExitCode(ExitHost, errors.Wrap(WithExitCode(ExitKexec, errors.New("EPERM")), "kexec")), ExitHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if WithExitCode(ExitImage, nil) != nil {
+		t.Error("WithExitCode(nil) != nil")
+	}
+}
@@ -0,0 +1,124 @@
+package cmdline
+
+import (
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+)
+
+// hostOnlyArgs are kernel args that only make sense for the currently running
+// system (bootloader, root filesystem, initramfs) and must not be carried over
+// to Talos. Network and console args are collected separately.
+//
+//nolint:gochecknoglobals
+var hostOnlyArgs = map[string]bool{
+	"BOOT_IMAGE": true,
+	"initrd":     true,
+	"root":       true,
+	"rootflags":  true,
+	"rootfstype": true,
+	"rootwait":   true,
+	"ro":         true,
+	"rw":         true,
+	"resume":     true,
+	"init":       true,
+	"quiet":      true,
+	"splash":     true,
+	"ip":         true,
+	"console":    true,
+	"bond":       true,
+	"vlan":       true,
+	"no5lvl":     true,
+}
+
+// hostOnlyPrefixes are prefixes of host-specific kernel args (initramfs and
+// distribution tooling).
+//
+//nolint:gochecknoglobals
+var hostOnlyPrefixes = []string{"rd.", "systemd.", "cloud-init", "ds=", "talos."}
+
+// Split splits a kernel command line into arguments, honoring double quotes.
+func Split(cmdline string) []string {
+	var (
+		args    []string
+		current strings.Builder
+		quoted  bool
+	)
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted:
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// Key returns the key part of a kernel argument ("foo" for "foo=bar").
+func Key(arg string) string {
+	key, _, _ := strings.Cut(arg, "=")
+	return key
+}
+
+// FilterHostArgs returns the arguments from cmdline that are worth carrying
+// over to Talos, dropping boot-specific ones like BOOT_IMAGE, root= and initrd=.
+func FilterHostArgs(cmdline string) []string {
+	var out []string
+	for _, arg := range Split(cmdline) {
+		if hostOnlyArgs[Key(arg)] {
+			continue
+		}
+		skip := false
+		for _, prefix := range hostOnlyPrefixes {
+			if strings.HasPrefix(arg, prefix) {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+// ReadHost returns the command line of the running kernel.
+func ReadHost() (string, error) {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", errors.Wrap(err, "read /proc/cmdline")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// CollectHostArgs offers the tunables of the running kernel (IOMMU, hugepages,
+// isolcpus, ...) for inclusion in the Talos command line and returns the
+// arguments confirmed by the user.
+func CollectHostArgs() []string {
+	host, err := ReadHost()
+	if err != nil {
+		return nil
+	}
+	args := FilterHostArgs(host)
+	if len(args) == 0 {
+		return nil
+	}
+	answer := cli.Ask("Kernel args to import from running system (or 'none')", strings.Join(args, " "))
+	if strings.EqualFold(answer, "none") || strings.EqualFold(answer, "no") {
+		return nil
+	}
+	return Split(answer)
+}
@@ -0,0 +1,230 @@
+package cmdline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdline string
+		want    []string
+	}{
+		{"simple", "quiet splash", []string{"quiet", "splash"}},
+		{"extra whitespace", "  a=1\tb=2\n", []string{"a=1", "b=2"}},
+		{"quoted value", `foo="a b" bar`, []string{`foo="a b"`, "bar"}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Split(tt.cmdline); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split(%q) = %q, want %q", tt.cmdline, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterHostArgs(t *testing.T) {
+	host := "BOOT_IMAGE=/vmlinuz-6.8.0 root=UUID=1234 ro quiet splash initrd=/initrd.img " +
+		"intel_iommu=on iommu=pt hugepagesz=1G hugepages=16 isolcpus=2-7 " +
+		"console=ttyS0,115200 ip=dhcp rd.lvm.lv=vg/root systemd.unified_cgroup_hierarchy=1"
+
+	want := []string{"intel_iommu=on", "iommu=pt", "hugepagesz=1G", "hugepages=16", "isolcpus=2-7"}
+	if got := FilterHostArgs(host); !reflect.DeepEqual(got, want) {
+		t.Errorf("FilterHostArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestKey(t *testing.T) {
+	if got := Key("hugepages=16"); got != "hugepages" {
+		t.Errorf("Key() = %q, want %q", got, "hugepages")
+	}
+	if got := Key("nosmt"); got != "nosmt" {
+		t.Errorf("Key() = %q, want %q", got, "nosmt")
+	}
+}
+
+func TestConfigURLArg(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"https://example.com/config.yaml", "talos.config=https://example.com/config.yaml", false},
+		{"http://10.0.0.1:8080/configs/${uuid}", "talos.config=http://10.0.0.1:8080/configs/${uuid}", false},
+		{"metal-iso", "talos.config=metal-iso", false},
+		{"ftp://example.com/config.yaml", "", true},
+		{"example.com/config.yaml", "", true},
+		{"https:///config.yaml", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ConfigURLArg(tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ConfigURLArg(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ConfigURLArg(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestKernelMaxLength(t *testing.T) {
+	bzImage := func(version uint16, cmdlineSize uint32) []byte {
+		b := make([]byte, 0x300)
+		copy(b[0x202:], "HdrS")
+		binary.LittleEndian.PutUint16(b[0x206:], version)
+		binary.LittleEndian.PutUint32(b[0x238:], cmdlineSize)
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		kernel []byte
+		want   int
+	}{
+		{"bzImage", bzImage(0x020f, 2047), 2048},
+		{"bzImage large limit", bzImage(0x020f, 4095), 4096},
+		{"old boot protocol", bzImage(0x0205, 4095), DefaultMaxLength},
+		{"arm64 Image", make([]byte, 0x300), DefaultMaxLength},
+		{"short", []byte("MZ"), DefaultMaxLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KernelMaxLength(bytes.NewReader(tt.kernel)); got != tt.want {
+				t.Errorf("KernelMaxLength() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	if err := CheckLength(strings.Repeat("a", 2047), 2048); err != nil {
+		t.Errorf("unexpected error at the limit: %v", err)
+	}
+	if err := CheckLength(strings.Repeat("a", 2048), 2048); err == nil {
+		t.Error("expected error above the limit")
+	}
+	if NearLimit(strings.Repeat("a", 1000), 2048) {
+		t.Error("1000 bytes should not be near a 2048 limit")
+	}
+	if !NearLimit(strings.Repeat("a", 1900), 2048) {
+		t.Error("1900 bytes should be near a 2048 limit")
+	}
+}
+
+func TestTalosArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    TalosOptions
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{"none", TalosOptions{}, "ghcr.io/siderolabs/installer:v1.11.6", "", false},
+		{"halt", TalosOptions{HaltIfInstalled: true}, "ghcr.io/siderolabs/installer:v1.11.6", "talos.halt_if_installed=1", false},
+		{"both", TalosOptions{HaltIfInstalled: true, Shutdown: ShutdownPoweroff}, "https://factory.talos.dev/image/abc/v1.9.0/metal-amd64.raw.xz", "talos.halt_if_installed=1 talos.shutdown=poweroff", false},
+		{"too old", TalosOptions{HaltIfInstalled: true}, "ghcr.io/siderolabs/installer:v1.5.5", "", true},
+		{"unknown version", TalosOptions{HaltIfInstalled: true}, "talos.raw.xz", "talos.halt_if_installed=1", false},
+		{"invalid shutdown", TalosOptions{Shutdown: "reboot"}, "talos.raw.xz", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := TalosArgs(tt.opts, tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TalosArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := strings.Join(args, " "); got != tt.want {
+				t.Errorf("TalosArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostnameArg(t *testing.T) {
+	tests := []struct {
+		mode    string
+		image   string
+		want    bool
+		wantErr bool
+	}{
+		{HostnameArgAuto, "ghcr.io/siderolabs/installer:v1.11.6", true, false},
+		{"", "ghcr.io/siderolabs/installer:v1.11.6", true, false},
+		{HostnameArgAuto, "talos.raw.xz", false, false},
+		{HostnameArgAuto, "ghcr.io/siderolabs/installer:v0.14.3", false, false},
+		{HostnameArgIP, "ghcr.io/siderolabs/installer:v1.11.6", false, false},
+		{HostnameArgTalos, "talos.raw.xz", true, false},
+		{HostnameArgTalos, "ghcr.io/siderolabs/installer:v0.14.3", false, true},
+		{"dhcp", "talos.raw.xz", false, true},
+	}
+	for _, tt := range tests {
+		got, err := HostnameArg(tt.mode, tt.image)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("HostnameArg(%q, %q) = %v, %v, want %v, error %v", tt.mode, tt.image, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPlatformArgs(t *testing.T) {
+	if args, ok := PlatformArgs(PlatformMetal); !ok || len(args) != 0 {
+		t.Errorf("PlatformArgs(metal) = %q, %v; want no args", args, ok)
+	}
+	args, ok := PlatformArgs("aws")
+	if !ok || !slices.Contains(args, "console=ttyS0") {
+		t.Errorf("PlatformArgs(aws) = %q, %v; want a serial console", args, ok)
+	}
+	if _, ok := PlatformArgs("nonexistent"); ok {
+		t.Error("PlatformArgs accepted an unknown platform")
+	}
+	if got := Platforms(); !slices.IsSorted(got) || !slices.Contains(got, "nocloud") {
+		t.Errorf("Platforms() = %q", got)
+	}
+}
+
+func TestReadDropIns(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"10-console.conf": "# serial console\nconsole=tty0 console=ttyS0,115200\nnomodeset\n",
+		"20-net.conf":     "net.ifnames=0 nomodeset\n\nip=dhcp\n",
+		"30-role.conf":    "console=ttyS1,115200 talos.hostname=\"worker 1\"\n",
+		"40-ignored.txt":  "quiet\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ReadDropIns(dir)
+	if err != nil {
+		t.Fatalf("ReadDropIns() error = %v", err)
+	}
+	want := []string{"nomodeset", "net.ifnames=0", "ip=dhcp", "console=ttyS1,115200", `talos.hostname="worker 1"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDropIns() = %q, want %q", got, want)
+	}
+
+	for content, wantErr := range map[string]string{
+		"=foo\n":               "has no key",
+		"talos.hostname=\"a\n": "unbalanced double quote",
+	} {
+		bad := t.TempDir()
+		if err := os.WriteFile(filepath.Join(bad, "a.conf"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadDropIns(bad); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("ReadDropIns(%q) error = %v, want %q", content, err, wantErr)
+		}
+	}
+}
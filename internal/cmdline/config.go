@@ -0,0 +1,29 @@
+package cmdline
+
+import (
+	"net/url"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ConfigURLArg validates a Talos machine config URL and returns the
+// talos.config= kernel argument for it. Besides http(s) URLs, Talos accepts
+// "metal-iso" to read the config from an attached ISO. Talos variables such
+// as ${uuid} or ${mac} are allowed in the URL.
+func ConfigURLArg(raw string) (string, error) {
+	if raw == "metal-iso" {
+		return "talos.config=" + raw, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid config URL %q", raw)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.Newf("invalid config URL %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return "", errors.Newf("invalid config URL %q: missing host", raw)
+	}
+	return "talos.config=" + raw, nil
+}
@@ -0,0 +1,79 @@
+package cmdline
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ReadDropIns returns the kernel args of the *.conf files in dir, read in
+// lexical order. Each line holds args separated by spaces; blank lines and
+// lines starting with # are skipped. An arg of a later file replaces the
+// args with the same key ("console" for "console=ttyS0") of earlier files,
+// while one file may give a key several times. Repeated args are dropped.
+func ReadDropIns(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read drop-in directory")
+	}
+	var args []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".conf") {
+			continue
+		}
+		fileArgs, err := readDropIn(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys := map[string]bool{}
+		for _, arg := range fileArgs {
+			if strings.Contains(arg, "=") {
+				keys[Key(arg)] = true
+			}
+		}
+		args = slices.DeleteFunc(args, func(arg string) bool {
+			return strings.Contains(arg, "=") && keys[Key(arg)]
+		})
+		for _, arg := range fileArgs {
+			if !slices.Contains(args, arg) {
+				args = append(args, arg)
+			}
+		}
+	}
+	return args, nil
+}
+
+// readDropIn returns the args of one drop-in file.
+func readDropIn(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open drop-in")
+	}
+	defer f.Close()
+
+	var args []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Count(line, `"`)%2 != 0 {
+			return nil, errors.Newf("%s:%d: unbalanced double quote", path, n)
+		}
+		for _, arg := range Split(line) {
+			if Key(arg) == "" {
+				return nil, errors.Newf("%s:%d: %q has no key", path, n, arg)
+			}
+			args = append(args, arg)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read %s", path)
+	}
+	return args, nil
+}
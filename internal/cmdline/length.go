@@ -0,0 +1,62 @@
+package cmdline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/cockroachdb/errors"
+)
+
+// DefaultMaxLength is the conservative kernel command line limit
+// (COMMAND_LINE_SIZE on x86 and arm64), including the terminating NUL.
+const DefaultMaxLength = 2048
+
+// InstallReserve is the room left for the arguments the Talos installer adds
+// on its own (talos.platform, console, hardening options) when checking the
+// length of extra kernel arguments in install mode.
+const InstallReserve = 512
+
+// x86 boot protocol setup header fields (Documentation/arch/x86/boot.rst).
+const (
+	bzHeaderMagicOffset  = 0x202
+	bzVersionOffset      = 0x206
+	bzCmdlineSizeOffset  = 0x238
+	bzCmdlineSizeVersion = 0x0206 // cmdline_size exists since protocol 2.06
+)
+
+// KernelMaxLength returns the command line limit of a kernel image. x86
+// bzImage kernels report it in the setup header (cmdline_size, which excludes
+// the terminating NUL); for other images DefaultMaxLength is returned.
+func KernelMaxLength(kernel io.ReaderAt) int {
+	header := make([]byte, bzCmdlineSizeOffset+4)
+	if _, err := kernel.ReadAt(header, 0); err != nil {
+		return DefaultMaxLength
+	}
+	if !bytes.Equal(header[bzHeaderMagicOffset:bzHeaderMagicOffset+4], []byte("HdrS")) {
+		return DefaultMaxLength
+	}
+	if binary.LittleEndian.Uint16(header[bzVersionOffset:]) < bzCmdlineSizeVersion {
+		return DefaultMaxLength
+	}
+	size := binary.LittleEndian.Uint32(header[bzCmdlineSizeOffset:])
+	if size == 0 || size > 1<<20 {
+		return DefaultMaxLength
+	}
+	return int(size) + 1
+}
+
+// CheckLength returns an error if cmdline (plus the terminating NUL) does
+// not fit into limit bytes; the kernel would silently truncate it.
+func CheckLength(cmdline string, limit int) error {
+	if len(cmdline)+1 > limit {
+		return errors.Newf("kernel command line is %d bytes, exceeding the kernel limit of %d bytes (it would be truncated)",
+			len(cmdline)+1, limit)
+	}
+	return nil
+}
+
+// NearLimit reports whether cmdline uses more than 90% of limit.
+func NearLimit(cmdline string, limit int) bool {
+	return (len(cmdline)+1)*10 > limit*9
+}
@@ -0,0 +1,42 @@
+package cmdline
+
+import (
+	"slices"
+	"strings"
+)
+
+// PlatformMetal is the Talos platform for bare metal and the default.
+const PlatformMetal = "metal"
+
+// platformArgs holds the kernel arguments conventionally used with each Talos
+// platform, as in the official cloud images: mostly where the serial console
+// is, plus the NVMe timeout AWS recommends for EBS volumes.
+//
+//nolint:gochecknoglobals
+var platformArgs = map[string][]string{
+	PlatformMetal:   nil,
+	"aws":           {"console=tty1", "console=ttyS0", "net.ifnames=0", "nvme_core.io_timeout=4294967295"},
+	"azure":         {"console=ttyS0,115200n8", "earlyprintk=ttyS0,115200", "rootdelay=300"},
+	"digital-ocean": {"console=ttyS0"},
+	"gcp":           {"console=ttyS0"},
+	"hcloud":        {"console=tty1", "console=ttyS0"},
+	"nocloud":       {"console=tty1", "console=ttyS0"},
+	"openstack":     {"console=tty1", "console=ttyS0"},
+}
+
+// Platforms returns the supported Talos platforms in sorted order.
+func Platforms() []string {
+	names := make([]string, 0, len(platformArgs))
+	for name := range platformArgs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// PlatformArgs returns the conventional kernel arguments for platform, and
+// whether the platform is supported.
+func PlatformArgs(platform string) ([]string, bool) {
+	args, ok := platformArgs[strings.ToLower(platform)]
+	return slices.Clone(args), ok
+}
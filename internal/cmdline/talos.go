@@ -0,0 +1,120 @@
+package cmdline
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Actions for the talos.shutdown kernel argument.
+const (
+	ShutdownHalt     = "halt"
+	ShutdownPoweroff = "poweroff"
+)
+
+// TalosOptions selects Talos kernel arguments that control what the node
+// does after it boots.
+type TalosOptions struct {
+	HaltIfInstalled bool   // talos.halt_if_installed=1: halt instead of booting if Talos is already installed
+	Shutdown        string // talos.shutdown=: ShutdownHalt or ShutdownPoweroff, empty for the Talos default
+}
+
+// talosArgSince is the first Talos version (major, minor) supporting each
+// argument.
+//
+//nolint:gochecknoglobals
+var talosArgSince = map[string][2]int{
+	"talos.halt_if_installed": {1, 6},
+	"talos.hostname":          {1, 0},
+	"talos.shutdown":          {1, 0},
+}
+
+// Ways to pass the node's hostname on the kernel command line.
+const (
+	HostnameArgAuto  = "auto"  // talos.hostname= if the image's Talos version is known to support it, else ip=
+	HostnameArgIP    = "ip"    // the hostname field of ip=
+	HostnameArgTalos = "talos" // talos.hostname=
+)
+
+// HostnameArg reports whether the hostname is passed as talos.hostname=
+// rather than in ip= for the HostnameArg* mode and the Talos version of
+// image. Auto picks talos.hostname= only for images with a recognizable
+// version that supports it.
+func HostnameArg(mode, image string) (bool, error) {
+	since := talosArgSince["talos.hostname"]
+	major, minor, ok := TalosVersion(image)
+	supported := ok && (major > since[0] || major == since[0] && minor >= since[1])
+	switch mode {
+	case HostnameArgIP:
+		return false, nil
+	case HostnameArgTalos:
+		if ok && !supported {
+			return false, errors.Newf("talos.hostname requires Talos v%d.%d or newer, image is v%d.%d",
+				since[0], since[1], major, minor)
+		}
+		return true, nil
+	case "", HostnameArgAuto:
+		return supported, nil
+	}
+	return false, errors.Newf("invalid hostname argument %q: use %s, %s or %s", mode, HostnameArgAuto, HostnameArgIP, HostnameArgTalos)
+}
+
+//nolint:gochecknoglobals
+var talosVersionRe = regexp.MustCompile(`v(\d+)\.(\d+)\.\d+`)
+
+// TalosVersion extracts the Talos version from an image reference such as
+// ghcr.io/siderolabs/installer:v1.11.6 or an Image Factory URL.
+func TalosVersion(image string) (major, minor int, ok bool) {
+	m := talosVersionRe.FindStringSubmatch(image)
+	if m == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(m[1])
+	minor, _ = strconv.Atoi(m[2])
+	return major, minor, true
+}
+
+// TalosArgs returns the kernel arguments for opts, checking that the Talos
+// version of image supports them. Images without a recognizable version are
+// not checked.
+func TalosArgs(opts TalosOptions, image string) ([]string, error) {
+	var args []string
+	if opts.HaltIfInstalled {
+		args = append(args, "talos.halt_if_installed=1")
+	}
+	switch opts.Shutdown {
+	case "":
+	case ShutdownHalt, ShutdownPoweroff:
+		args = append(args, "talos.shutdown="+opts.Shutdown)
+	default:
+		return nil, errors.Newf("invalid shutdown action %q: use %s or %s", opts.Shutdown, ShutdownHalt, ShutdownPoweroff)
+	}
+
+	major, minor, ok := TalosVersion(image)
+	if !ok {
+		return args, nil
+	}
+	for _, arg := range args {
+		since := talosArgSince[Key(arg)]
+		if major < since[0] || major == since[0] && minor < since[1] {
+			return nil, errors.Newf("%s requires Talos v%d.%d or newer, image is v%d.%d",
+				Key(arg), since[0], since[1], major, minor)
+		}
+	}
+	return args, nil
+}
+
+// String describes the options for the install and boot summaries.
+func (o TalosOptions) String() string {
+	switch {
+	case o.HaltIfInstalled && o.Shutdown != "":
+		return fmt.Sprintf("halt if already installed, %s on shutdown", o.Shutdown)
+	case o.HaltIfInstalled:
+		return "halt if already installed"
+	case o.Shutdown != "":
+		return o.Shutdown + " on shutdown"
+	}
+	return "(default)"
+}
@@ -0,0 +1,160 @@
+//go:build linux
+
+package efi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// bootBackup is the JSON backup of the EFI boot configuration written by
+// BackupBootEntries.
+type bootBackup struct {
+	BootOrder BootOrderType `json:"bootOrder"`
+	Entries   []bootVar     `json:"entries"`
+}
+
+// bootVar is a Boot#### variable, kept verbatim so that restoring it does
+// not depend on how well its load option is understood.
+type bootVar struct {
+	Index       uint16       `json:"index"`
+	Attributes  efiAttribute `json:"attributes"`
+	Data        []byte       `json:"data"`
+	Description string       `json:"description,omitempty"` // for readers of the file only
+}
+
+// BackupBootEntries writes BootOrder and all Boot#### variables to path, to
+// be restored with RestoreBootEntries if the modified boot configuration
+// fails.
+func BackupBootEntries(path string) error {
+	efiRW, err := newEFIReaderWriter(false)
+	if err != nil {
+		return errors.Wrap(err, "failed to create efivarfs reader")
+	}
+	defer efiRW.Close()
+
+	backup, err := readBootBackup(efiRW)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal EFI boot backup")
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return errors.Wrap(err, "write EFI boot backup")
+	}
+	log.Printf("backed up BootOrder %v and %d boot entries to %s", backup.BootOrder, len(backup.Entries), path)
+	return nil
+}
+
+// RestoreBootEntries writes back the boot configuration saved by
+// BackupBootEntries: the saved Boot#### variables and BootOrder. Talos boot
+// entries that are not in the backup are deleted.
+func RestoreBootEntries(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "read EFI boot backup")
+	}
+	var backup bootBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return errors.Wrapf(err, "parse EFI boot backup %s", path)
+	}
+
+	efiRW, err := newEFIReaderWriter(true)
+	if err != nil {
+		return errors.Wrap(err, "failed to create efivarfs reader/writer")
+	}
+	defer efiRW.Close()
+
+	return restoreBootBackup(efiRW, &backup)
+}
+
+// readBootBackup reads BootOrder and the Boot#### variables.
+func readBootBackup(rw efiReadWriter) (*bootBackup, error) {
+	backup := &bootBackup{}
+	order, err := getBootOrder(rw)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.Wrap(err, "failed to get BootOrder")
+	}
+	backup.BootOrder = order
+
+	varNames, err := rw.List(scopeGlobal)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list EFI variables")
+	}
+	for _, varName := range varNames {
+		s := bootVarRegexp.FindStringSubmatch(varName)
+		if s == nil {
+			continue
+		}
+		idx, err := strconv.ParseUint(s[1], 16, 16)
+		if err != nil {
+			continue
+		}
+		raw, attrs, err := rw.Read(scopeGlobal, varName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", varName)
+		}
+		entry := bootVar{Index: uint16(idx), Attributes: attrs, Data: raw}
+		if opt, err := unmarshalLoadOption(raw); err == nil {
+			entry.Description = opt.Description
+		}
+		backup.Entries = append(backup.Entries, entry)
+	}
+	slices.SortFunc(backup.Entries, func(a, b bootVar) int { return int(a.Index) - int(b.Index) })
+	return backup, nil
+}
+
+// restoreBootBackup writes the backup to rw.
+func restoreBootBackup(rw efiReadWriter, backup *bootBackup) error {
+	saved := map[uint16]bool{}
+	for _, entry := range backup.Entries {
+		saved[entry.Index] = true
+	}
+	current, err := listBootEntries(rw)
+	if err != nil {
+		return errors.Wrap(err, "failed to list boot entries")
+	}
+	var protected []string
+	for idx, entry := range current {
+		if entry.Description != talosBootEntryDescription || saved[uint16(idx)] {
+			continue
+		}
+		name := fmt.Sprintf("Boot%04X", idx)
+		if err := rw.Delete(scopeGlobal, name); isProtectedVar(err) {
+			protected = append(protected, name)
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to delete boot entry %04X", idx)
+		}
+		log.Printf("deleted Talos boot entry %04X", idx)
+	}
+
+	for _, entry := range backup.Entries {
+		name := fmt.Sprintf("Boot%04X", entry.Index)
+		if err := rw.Write(scopeGlobal, name, entry.Attributes, entry.Data); isProtectedVar(err) {
+			protected = append(protected, name)
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to restore boot entry %04X", entry.Index)
+		}
+	}
+	if len(protected) > 0 {
+		log.Printf("warning: EFI variables protected by the firmware were not modified: %s", strings.Join(protected, ", "))
+	}
+	if backup.BootOrder != nil {
+		if err := setBootOrder(rw, backup.BootOrder); err != nil {
+			return errors.Wrap(err, "failed to restore BootOrder")
+		}
+	}
+	log.Printf("restored %d boot entries, BootOrder: %v", len(backup.Entries), backup.BootOrder)
+	return nil
+}
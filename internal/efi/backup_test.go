@@ -0,0 +1,58 @@
+//go:build linux
+
+package efi
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestBootBackupRoundTrip(t *testing.T) {
+	mock := newMockEFIReadWriter()
+	other, err := (&loadOption{Description: "debian", FilePath: devicePath{&endOfDevicePath{}}}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = mock.Write(scopeGlobal, "Boot0000", attrNonVolatile|attrRuntimeAccess, other)
+	_ = mock.Write(scopeGlobal, "Boot0001", attrNonVolatile, []byte("opaque"))
+	_ = setBootOrder(mock, BootOrderType{0, 1})
+
+	backup, err := readBootBackup(mock)
+	if err != nil {
+		t.Fatalf("readBootBackup error: %v", err)
+	}
+	if len(backup.Entries) != 2 || backup.Entries[0].Description != "debian" {
+		t.Fatalf("backup entries = %+v, want Boot0000 (debian) and Boot0001", backup.Entries)
+	}
+	data, err := json.Marshal(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bootBackup
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// What UpdateEFIVariables does: a Talos entry first in BootOrder.
+	talos := &loadOption{Description: talosBootEntryDescription, FilePath: devicePath{&endOfDevicePath{}}}
+	if err := setBootEntry(mock, 2, talos); err != nil {
+		t.Fatal(err)
+	}
+	_ = setBootOrder(mock, BootOrderType{2, 0, 1})
+	_ = mock.Write(scopeGlobal, "Boot0001", attrNonVolatile, []byte("changed"))
+
+	if err := restoreBootBackup(mock, &saved); err != nil {
+		t.Fatalf("restoreBootBackup error: %v", err)
+	}
+	order, err := getBootOrder(mock)
+	if err != nil || !slices.Equal(order, BootOrderType{0, 1}) {
+		t.Errorf("restored BootOrder = %v, %v; want [0 1]", order, err)
+	}
+	if _, _, err := mock.Read(scopeGlobal, "Boot0002"); err == nil {
+		t.Error("Talos boot entry was not deleted")
+	}
+	if data, attrs, _ := mock.Read(scopeGlobal, "Boot0001"); string(data) != "opaque" || attrs != attrNonVolatile {
+		t.Errorf("restored Boot0001 = %q (attrs %d), want the saved variable", data, attrs)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -18,10 +17,13 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/google/uuid"
 	"golang.org/x/sys/unix"
 	"golang.org/x/text/encoding/unicode"
+
+	"github.com/cozystack/boot-to-talos/internal/fat"
 )
 
 const (
@@ -55,6 +57,17 @@ type SecureBootState struct {
 	SetupMode bool // SetupMode variable is 1 (keys can be enrolled without authentication)
 }
 
+func (s SecureBootState) String() string {
+	switch {
+	case s.Enabled && s.SetupMode:
+		return "enabled (setup mode)"
+	case s.Enabled:
+		return "enabled"
+	default:
+		return "disabled"
+	}
+}
+
 // GetSecureBootState reads the current Secure Boot state from UEFI variables.
 // Returns error if not running on UEFI system or variables cannot be read.
 func GetSecureBootState() (SecureBootState, error) {
@@ -89,114 +102,11 @@ func GetSecureBootState() (SecureBootState, error) {
 	return state, nil
 }
 
-// GetUKIAndPartitionInfo reads UKI file name and partition info from installed image.
-// Returns UKI file name and blkid info from raw image file.
-//
-//nolint:gocognit
-func GetUKIAndPartitionInfo(loopDevice, rawImage string) (string, any, error) {
-	// Try to find EFI partition on loop device
-	// Usually it's the first partition (p1 for loop devices)
-	var loopEfiPartition string
-	var loopEfiMountPoint string
-	var needUnmount bool
-
-	// First, check if EFI partition is already mounted (Talos installer might have mounted it)
-	// Check common mount points
-	possibleMountPoints := []string{"/tmp/loop-efi-mount", "/boot/EFI", "/mnt"}
-	for _, mp := range possibleMountPoints {
-		if _, err := os.Stat(filepath.Join(mp, "EFI", "Linux")); err == nil {
-			// Check if this mount point contains our loop device partition
-			// Read /proc/mounts to verify
-			mounts, err := os.ReadFile("/proc/mounts")
-			if err == nil {
-				if strings.Contains(string(mounts), loopDevice) && strings.Contains(string(mounts), mp) {
-					loopEfiMountPoint = mp
-					needUnmount = false //nolint:ineffassign // used after break
-					log.Printf("using existing mount point %s for EFI partition", mp)
-					break
-				}
-			}
-		}
-	}
-
-	// If not found, try to mount it ourselves
-	if loopEfiMountPoint == "" {
-		loopEfiMountPoint = "/tmp/loop-efi-mount-boot-to-talos"
-		_ = os.MkdirAll(loopEfiMountPoint, 0o755)
-		needUnmount = true
-
-		// Find EFI partition
-		for i := 1; i <= 4; i++ {
-			candidate := fmt.Sprintf("%sp%d", loopDevice, i)
-			if _, err := os.Stat(candidate); err == nil {
-				// Try to mount it to see if it's EFI partition
-				// MS_RDONLY = 0x1
-				if err := unix.Mount(candidate, loopEfiMountPoint, "vfat", 0x1, ""); err == nil {
-					// Check if it has EFI directory
-					if _, err := os.Stat(filepath.Join(loopEfiMountPoint, "EFI")); err == nil {
-						loopEfiPartition = candidate
-						break
-					}
-					_ = unix.Unmount(loopEfiMountPoint, 0)
-				} else if errors.Is(err, unix.EBUSY) {
-					// Partition is already mounted, try to find where
-					mounts, err := os.ReadFile("/proc/mounts")
-					if err == nil {
-						for line := range strings.SplitSeq(string(mounts), "\n") {
-							if strings.Contains(line, candidate) {
-								fields := strings.Fields(line)
-								if len(fields) >= 2 {
-									loopEfiMountPoint = fields[1]
-									needUnmount = false
-									log.Printf("found already mounted EFI partition at %s", loopEfiMountPoint)
-									break
-								}
-							}
-						}
-						if !needUnmount {
-							break
-						}
-					}
-				}
-			}
-		}
-
-		if loopEfiPartition == "" && needUnmount {
-			os.RemoveAll(loopEfiMountPoint)
-			return "", nil, errors.Newf("failed to find EFI partition on loop device %s", loopDevice)
-		}
-
-		if needUnmount {
-			defer func() {
-				_ = unix.Unmount(loopEfiMountPoint, 0)
-				_ = os.RemoveAll(loopEfiMountPoint)
-			}()
-		}
-	}
-
-	// Find UKI files in the installed image - same logic as sdboot.go
-	ukiFiles, err := filepath.Glob(filepath.Join(loopEfiMountPoint, "EFI", "Linux", "Talos-*.efi"))
-	if err != nil {
-		return "", nil, errors.Wrap(err, "failed to find UKI files")
-	}
-
-	if len(ukiFiles) == 0 {
-		return "", nil, errors.Newf("no UKI files found in %s", filepath.Join(loopEfiMountPoint, "EFI", "Linux"))
-	}
-
-	// Use the latest UKI file (assuming it's the one just installed)
-	// In sdboot.go, this would be ukiPath from generateNextUKIName
-	ukiPath := filepath.Base(ukiFiles[len(ukiFiles)-1])
-	log.Printf("found UKI file in installed image: %s", ukiPath)
-
-	// For now, return nil for rawBlkidInfo as it's not needed for basic functionality
-	// In full implementation, we would use blkid.ProbePath(rawImage, ...) here
-	return ukiPath, nil, nil
-}
-
 // UpdateEFIVariables creates a Talos boot entry pointing to the target disk's ESP
-// and updates BootOrder to put it first.
-func UpdateEFIVariables(disk string) error {
+// and updates BootOrder to put it first. The boot entries of the removable
+// disk, if not "", are moved to the end of BootOrder, so that the firmware
+// does not boot the installer medium again.
+func UpdateEFIVariables(disk, removable string) error {
 	efiRW, err := newEFIReaderWriter(true)
 	if err != nil {
 		return errors.Wrap(err, "failed to create efivarfs reader/writer")
@@ -218,8 +128,25 @@ func UpdateEFIVariables(disk string) error {
 		return err
 	}
 
+	var demote map[string]bool
+	if removable != "" {
+		if demote, err = diskSignatures(removable); err != nil {
+			log.Printf("warning: not moving the boot entries of %s: %v", removable, err)
+		}
+	}
+
+	return updateBootEntry(efiRW, esp, efiFilePath, demote)
+}
+
+// updateBootEntry writes the Talos boot entry for esp and puts it first in
+// BootOrder. Variables the firmware protects are skipped with a warning
+// where there is a way around them: a protected Talos entry is replaced by
+// a new one, and a protected BootOrder by BootNext to boot Talos once.
+// Entries on a partition with a signature in demote go to the end of
+// BootOrder.
+func updateBootEntry(rw efiReadWriter, esp *espInfo, efiFilePath string, demote map[string]bool) error {
 	// List existing boot entries to find existing Talos entry
-	bootEntries, err := listBootEntries(efiRW)
+	bootEntries, err := listBootEntries(rw)
 	if err != nil {
 		return errors.Wrap(err, "failed to list boot entries")
 	}
@@ -234,9 +161,10 @@ func UpdateEFIVariables(disk string) error {
 			break
 		}
 	}
+	existing := targetIdx >= 0
 
-	if targetIdx < 0 {
-		targetIdx, err = findFreeBootIndex(efiRW)
+	if !existing {
+		targetIdx, err = findFreeBootIndex(rw)
 		if err != nil {
 			return errors.Wrap(err, "failed to find free boot index")
 		}
@@ -259,12 +187,22 @@ func UpdateEFIVariables(disk string) error {
 		},
 	}
 
-	if err := setBootEntry(efiRW, targetIdx, opt); err != nil {
+	var protected []string
+	err = setBootEntry(rw, targetIdx, opt)
+	if existing && isProtectedVar(err) {
+		protected = append(protected, fmt.Sprintf("Boot%04X", targetIdx))
+		log.Printf("warning: boot entry %04X is protected by the firmware (%v), creating a new one", targetIdx, err)
+		if targetIdx, err = findFreeBootIndex(rw); err != nil {
+			return errors.Wrap(err, "failed to find free boot index")
+		}
+		err = setBootEntry(rw, targetIdx, opt)
+	}
+	if err != nil {
 		return errors.Wrapf(err, "failed to write boot entry at index %d", targetIdx)
 	}
 
 	// Update BootOrder: put new entry first, keep others without duplicates
-	bootOrder, err := getBootOrder(efiRW)
+	bootOrder, err := getBootOrder(rw)
 	if err != nil {
 		if !errors.Is(err, fs.ErrNotExist) {
 			return errors.Wrap(err, "failed to get BootOrder")
@@ -280,16 +218,43 @@ func UpdateEFIVariables(disk string) error {
 			newBootOrder = append(newBootOrder, idx)
 		}
 	}
-
-	if err := setBootOrder(efiRW, newBootOrder); err != nil {
+	newBootOrder = demoteBootEntries(newBootOrder, bootEntries, demote)
+
+	err = setBootOrder(rw, newBootOrder)
+	switch {
+	case isProtectedVar(err):
+		protected = append(protected, "BootOrder")
+		log.Printf("warning: BootOrder is protected by the firmware (%v), setting BootNext to boot Talos once", err)
+		err = rw.Write(scopeGlobal, "BootNext", attrNonVolatile|attrRuntimeAccess, BootOrderType{uint16(targetIdx)}.marshal())
+		if isProtectedVar(err) {
+			return errors.Wrapf(err, "boot entry %04X created, but both BootOrder and BootNext are protected by the firmware, "+
+				"select it in the firmware setup", targetIdx)
+		} else if err != nil {
+			return errors.Wrap(err, "failed to set BootNext")
+		}
+		log.Printf("EFI boot entry %04X created, BootOrder unchanged: %v", targetIdx, bootOrder)
+	case err != nil:
 		return errors.Wrap(err, "failed to set BootOrder")
+	default:
+		log.Printf("EFI boot entry %04X created, BootOrder: %v", targetIdx, newBootOrder)
 	}
 
-	log.Printf("EFI boot entry %04X created, BootOrder: %v", targetIdx, newBootOrder)
+	if len(protected) > 0 {
+		log.Printf("warning: EFI variables protected by the firmware were not modified: %s; "+
+			"check the boot order in the firmware setup", strings.Join(protected, ", "))
+	}
 
 	return nil
 }
 
+// isProtectedVar reports whether err is the firmware or the kernel refusing
+// to modify a variable: authenticated or write-protected variables (EACCES)
+// and efivarfs files that stay immutable (EPERM). EROFS is not one of them,
+// it means efivarfs is still mounted read-only.
+func isProtectedVar(err error) bool {
+	return errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES)
+}
+
 // EFI variables reader/writer interface.
 type efiReadWriter interface {
 	Write(scope uuid.UUID, varName string, attrs efiAttribute, value []byte) error
@@ -300,27 +265,90 @@ type efiReadWriter interface {
 
 type efiFilesystemReaderWriter struct {
 	write bool
+
+	// restore describes how to return efivarfs to its prior mount state on Close.
+	restore efivarfsRestore
 }
 
+// efivarfsRestore is the action needed to undo changes to the efivarfs mount.
+type efivarfsRestore int
+
+const (
+	restoreNone      efivarfsRestore = iota // mount state was not changed
+	restoreRemountRO                        // efivarfs was remounted read-write
+	restoreUnmount                          // efivarfs was mounted by us
+)
+
 func newEFIReaderWriter(write bool) (*efiFilesystemReaderWriter, error) {
-	if write {
-		// Remount efivarfs in read-write mode
-		// MS_REMOUNT = 0x20
-		if err := unix.Mount("efivarfs", efiVarsMountPoint, "efivarfs", 0x20, "rw"); err != nil {
-			return nil, errors.Wrap(err, "failed to remount efivarfs in read-write mode")
+	rw := &efiFilesystemReaderWriter{write: write}
+
+	mounts, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read mount table")
+	}
+	mounted, readOnly := efivarfsMountState(string(mounts), efiVarsMountPoint)
+
+	if !mounted {
+		var flags uintptr
+		if !write {
+			flags = unix.MS_RDONLY
+		}
+		if err := unix.Mount("efivarfs", efiVarsMountPoint, "efivarfs", flags, ""); err != nil {
+			return nil, errors.Wrap(err, "failed to mount efivarfs")
+		}
+		log.Printf("mounted efivarfs at %s", efiVarsMountPoint)
+		rw.restore = restoreUnmount
+		return rw, nil
+	}
+
+	if !write || !readOnly {
+		return rw, nil
+	}
+
+	// Remount efivarfs in read-write mode
+	if err := unix.Mount("efivarfs", efiVarsMountPoint, "efivarfs", unix.MS_REMOUNT, "rw"); err != nil {
+		if (errors.Is(err, unix.EBUSY) || errors.Is(err, unix.EINVAL)) && unix.Access(efiVarsMountPoint, unix.W_OK) == nil {
+			log.Printf("warning: failed to remount efivarfs (%v), but it is already writable", err)
+			return rw, nil
 		}
+		return nil, errors.Wrap(err, "failed to remount efivarfs in read-write mode")
 	}
-	return &efiFilesystemReaderWriter{write: write}, nil
+	rw.restore = restoreRemountRO
+	return rw, nil
 }
 
 func (rw *efiFilesystemReaderWriter) Close() error {
-	if rw.write {
-		// MS_REMOUNT = 0x20, MS_RDONLY = 0x1
-		return unix.Mount("efivarfs", efiVarsMountPoint, "efivarfs", 0x20|0x1, "")
+	switch rw.restore {
+	case restoreRemountRO:
+		return unix.Mount("efivarfs", efiVarsMountPoint, "efivarfs", unix.MS_REMOUNT|unix.MS_RDONLY, "")
+	case restoreUnmount:
+		return unix.Unmount(efiVarsMountPoint, 0)
+	case restoreNone:
 	}
 	return nil
 }
 
+// efivarfsMountState reports whether an efivarfs is mounted at mountPoint
+// according to the given /proc/self/mounts content, and whether it is read-only.
+// The last matching entry wins, as it is the one visible at the mount point.
+func efivarfsMountState(mounts, mountPoint string) (bool, bool) {
+	var mounted, readOnly bool
+	for line := range strings.SplitSeq(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[1] != mountPoint || fields[2] != "efivarfs" {
+			continue
+		}
+		mounted = true
+		readOnly = false
+		for opt := range strings.SplitSeq(fields[3], ",") {
+			if opt == "ro" {
+				readOnly = true
+			}
+		}
+	}
+	return mounted, readOnly
+}
+
 func varPath(scope uuid.UUID, varName string) string {
 	return fmt.Sprintf("%s/%s-%s", efiVarsPath, varName, scope.String())
 }
@@ -467,6 +495,10 @@ var bootVarRegexp = regexp.MustCompile(`^Boot([0-9A-Fa-f]{4})$`)
 type loadOption struct {
 	Description string
 	FilePath    devicePath
+
+	// Signatures of the partitions the hard drive nodes of the device path
+	// refer to, set when a load option is read, see hdSignatures.
+	Signatures []string
 }
 
 type devicePath []devicePathElem
@@ -530,6 +562,16 @@ func unmarshalLoadOption(data []byte) (*loadOption, error) {
 	opt := &loadOption{
 		Description: string(bytes.TrimSuffix(description, []byte{0})),
 	}
+	// The device path follows the UTF-16 NUL ending the description.
+	pathLen := int(binary.LittleEndian.Uint16(data[4:6]))
+	for i := 6; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			if end := i + 2 + pathLen; end <= len(data) {
+				opt.Signatures = hdSignatures(data[i+2 : end])
+			}
+			break
+		}
+	}
 	return opt, nil
 }
 
@@ -578,12 +620,12 @@ type hardDrivePath struct {
 }
 
 const (
-	dpTypeMedia       = 0x04
+	dpTypeMedia        = 0x04
 	dpSubTypeHardDrive = 0x01
-	dpSubTypeFilePath = 0x04
-	dpTypeEnd         = 0x7F
-	dpSubTypeEnd      = 0xFF
-	hardDrivePathLen  = 42 // 4-byte header + 38-byte data
+	dpSubTypeFilePath  = 0x04
+	dpTypeEnd          = 0x7F
+	dpSubTypeEnd       = 0xFF
+	hardDrivePathLen   = 42 // 4-byte header + 38-byte data
 
 	gptMBRType       = 0x02
 	gptSignatureType = 0x02
@@ -707,9 +749,13 @@ type espInfo struct {
 	PartitionNumber uint32
 	StartLBA        uint64
 	SizeLBA         uint64
+	SectorSize      uint64
 	PartitionGUID   uuid.UUID
 }
 
+// getESPInfo returns the ESP of the disk at diskPath. Of several partitions
+// typed as ESP, as some image builders leave, the one holding a Talos UKI in
+// EFI/Linux is the one Talos boots from; otherwise the first is used.
 func getESPInfo(diskPath string) (*espInfo, error) {
 	d, err := diskfs.Open(diskPath, diskfs.WithOpenMode(diskfs.ReadOnly))
 	if err != nil {
@@ -727,6 +773,12 @@ func getESPInfo(diskPath string) (*espInfo, error) {
 		return nil, errors.New("disk does not have a GPT partition table")
 	}
 
+	sectorSize := uint64(gptTable.LogicalSectorSize)
+	if sectorSize == 0 {
+		sectorSize = 512
+	}
+
+	var esps []*espInfo
 	for i, part := range gptTable.Partitions {
 		if part == nil {
 			continue
@@ -741,20 +793,38 @@ func getESPInfo(diskPath string) (*espInfo, error) {
 			return nil, errors.Wrapf(err, "parsing partition GUID %q", part.GUID)
 		}
 
-		sectorSize := uint64(gptTable.LogicalSectorSize)
-		if sectorSize == 0 {
-			sectorSize = 512
-		}
-
-		return &espInfo{
+		esps = append(esps, &espInfo{
 			PartitionNumber: uint32(i + 1),
 			StartLBA:        part.Start,
 			SizeLBA:         part.Size / sectorSize,
+			SectorSize:      sectorSize,
 			PartitionGUID:   partGUID,
-		}, nil
+		})
+	}
+
+	switch len(esps) {
+	case 0:
+		return nil, errors.Newf("EFI System Partition not found on %s", diskPath)
+	case 1:
+		return esps[0], nil
 	}
+	for _, esp := range esps {
+		if hasTalosUKI(d.Backend, esp) {
+			return esp, nil
+		}
+	}
+	return esps[0], nil
+}
 
-	return nil, errors.Newf("EFI System Partition not found on %s", diskPath)
+// hasTalosUKI reports whether the FAT filesystem of esp on b holds a Talos
+// UKI in EFI/Linux.
+func hasTalosUKI(b backend.Storage, esp *espInfo) bool {
+	espFS, err := fat.Open(b, int64(esp.SizeLBA*esp.SectorSize), int64(esp.StartLBA*esp.SectorSize))
+	if err != nil {
+		return false
+	}
+	defer espFS.Close()
+	return latestUKI(dirNames(espFS, "/EFI/Linux")) != ""
 }
 
 // sdbootFilePath returns the EFI file path for sd-boot based on architecture.
@@ -6,10 +6,16 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io/fs"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
+
+	"github.com/cozystack/boot-to-talos/internal/testutil"
 )
 
 func TestUnmarshalBootOrder(t *testing.T) {
@@ -421,6 +427,9 @@ func TestLoadOptionMarshal(t *testing.T) {
 // mockEFIReadWriter is an in-memory efiReadWriter for testing.
 type mockEFIReadWriter struct {
 	vars map[string]mockVar
+
+	// protected variables fail to be written or deleted with EPERM.
+	protected map[string]bool
 }
 
 type mockVar struct {
@@ -433,6 +442,9 @@ func newMockEFIReadWriter() *mockEFIReadWriter {
 }
 
 func (m *mockEFIReadWriter) Write(scope uuid.UUID, varName string, attrs efiAttribute, value []byte) error {
+	if m.protected[varName] {
+		return errors.Wrapf(unix.EPERM, "writing %q in scope %s", varName, scope)
+	}
 	key := varName + "-" + scope.String()
 	m.vars[key] = mockVar{data: append([]byte(nil), value...), attrs: attrs}
 
@@ -450,6 +462,9 @@ func (m *mockEFIReadWriter) Read(scope uuid.UUID, varName string) ([]byte, efiAt
 }
 
 func (m *mockEFIReadWriter) Delete(scope uuid.UUID, varName string) error {
+	if m.protected[varName] {
+		return &fs.PathError{Op: "remove", Path: varName, Err: unix.EPERM}
+	}
 	key := varName + "-" + scope.String()
 	delete(m.vars, key)
 
@@ -540,6 +555,80 @@ func TestSetBootEntry(t *testing.T) {
 	}
 }
 
+func TestUpdateBootEntryProtected(t *testing.T) {
+	esp := &espInfo{PartitionNumber: 1, StartLBA: 2048, SizeLBA: 204800, PartitionGUID: uuid.New()}
+	talos := &loadOption{Description: talosBootEntryDescription, FilePath: devicePath{&endOfDevicePath{}}}
+	other := &loadOption{Description: "debian", FilePath: devicePath{&endOfDevicePath{}}}
+
+	tests := []struct {
+		name          string
+		protected     []string
+		wantEntry     int
+		wantBootOrder BootOrderType
+		wantBootNext  bool
+	}{
+		{"unprotected", nil, 2, BootOrderType{2, 0}, false},
+		{"protected Talos entry", []string{"Boot0002"}, 1, BootOrderType{1, 0, 2}, false},
+		{"protected BootOrder", []string{"BootOrder"}, 2, BootOrderType{0, 2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockEFIReadWriter()
+			_ = setBootEntry(mock, 0, other)
+			_ = setBootEntry(mock, 2, talos)
+			_ = setBootOrder(mock, BootOrderType{0, 2})
+			mock.protected = map[string]bool{}
+			for _, name := range tt.protected {
+				mock.protected[name] = true
+			}
+
+			if err := updateBootEntry(mock, esp, `\EFI\BOOT\BOOTX64.EFI`, nil); err != nil {
+				t.Fatalf("updateBootEntry() error: %v", err)
+			}
+			entry, err := getBootEntry(mock, tt.wantEntry)
+			if err != nil || entry.Description != talosBootEntryDescription {
+				t.Errorf("boot entry %04X = %+v, %v, want the new Talos entry", tt.wantEntry, entry, err)
+			}
+			order, _ := getBootOrder(mock)
+			if !slices.Equal(order, tt.wantBootOrder) {
+				t.Errorf("BootOrder = %v, want %v", order, tt.wantBootOrder)
+			}
+			next, _, err := mock.Read(scopeGlobal, "BootNext")
+			if tt.wantBootNext != (err == nil) || tt.wantBootNext && !bytes.Equal(next, BootOrderType{uint16(tt.wantEntry)}.marshal()) {
+				t.Errorf("BootNext = %v, %v, want set: %v", next, err, tt.wantBootNext)
+			}
+		})
+	}
+
+	mock := newMockEFIReadWriter()
+	mock.protected = map[string]bool{"Boot0000": true}
+	if err := updateBootEntry(mock, esp, `\EFI\BOOT\BOOTX64.EFI`, nil); err == nil {
+		t.Error("updateBootEntry() succeeded without any writable boot entry")
+	}
+
+	mock = newMockEFIReadWriter()
+	mock.protected = map[string]bool{"BootOrder": true, "BootNext": true}
+	if err := updateBootEntry(mock, esp, `\EFI\BOOT\BOOTX64.EFI`, nil); err == nil {
+		t.Error("updateBootEntry() succeeded with BootOrder and BootNext protected")
+	}
+}
+
+func TestIsProtectedVar(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{unix.EPERM, true},
+		{errors.Wrap(unix.EACCES, "write BootOrder"), true},
+		{unix.EROFS, false},
+		{nil, false},
+	} {
+		if got := isProtectedVar(tt.err); got != tt.want {
+			t.Errorf("isProtectedVar(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
 // TestLoadOptionMarshalMatchesTalosFormat verifies our marshaling against the
 // known hex dump from Talos boot_test.go.
 func TestLoadOptionMarshalMatchesTalosFormat(t *testing.T) {
@@ -593,3 +682,103 @@ func TestLoadOptionMarshalMatchesTalosFormat(t *testing.T) {
 		t.Errorf("marshal() output does not match Talos format\ngot:\n  %X\nwant:\n  %X", data, expected)
 	}
 }
+
+func TestEfivarfsMountState(t *testing.T) {
+	tests := []struct {
+		name         string
+		mounts       string
+		wantMounted  bool
+		wantReadOnly bool
+	}{
+		{
+			name:   "not mounted",
+			mounts: "sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0\n",
+		},
+		{
+			name:         "read-only",
+			mounts:       "efivarfs /sys/firmware/efi/efivars efivarfs ro,nosuid,nodev,noexec,relatime 0 0\n",
+			wantMounted:  true,
+			wantReadOnly: true,
+		},
+		{
+			name:        "read-write",
+			mounts:      "efivarfs /sys/firmware/efi/efivars efivarfs rw,nosuid,nodev,noexec,relatime 0 0\n",
+			wantMounted: true,
+		},
+		{
+			name: "overmounted read-write",
+			mounts: "efivarfs /sys/firmware/efi/efivars efivarfs ro,relatime 0 0\n" +
+				"efivarfs /sys/firmware/efi/efivars efivarfs rw,relatime 0 0\n",
+			wantMounted: true,
+		},
+		{
+			name:   "other filesystem at mount point",
+			mounts: "tmpfs /sys/firmware/efi/efivars tmpfs rw 0 0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounted, readOnly := efivarfsMountState(tt.mounts, efiVarsMountPoint)
+			if mounted != tt.wantMounted || readOnly != tt.wantReadOnly {
+				t.Errorf("efivarfsMountState() = (%v, %v), want (%v, %v)",
+					mounted, readOnly, tt.wantMounted, tt.wantReadOnly)
+			}
+		})
+	}
+}
+
+func TestGetESPInfoPrefersTalosUKI(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		want  uint32
+	}{
+		{"second ESP holds the UKI", map[string][]byte{"/EFI/Linux/Talos-A.efi": []byte("uki")}, 2},
+		{"no UKI", map[string][]byte{"/EFI/BOOT/BOOTX64.EFI": []byte("grub")}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := filepath.Join(t.TempDir(), "esp2.raw")
+			if err := testutil.CreateTestTwoESPImage(img, 64, tt.files); err != nil {
+				t.Fatalf("create image: %v", err)
+			}
+			esp, err := getESPInfo(img)
+			if err != nil {
+				t.Fatalf("getESPInfo() error: %v", err)
+			}
+			if esp.PartitionNumber != tt.want {
+				t.Errorf("getESPInfo() = partition %d, want %d", esp.PartitionNumber, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateBootEntryDemote(t *testing.T) {
+	esp := &espInfo{PartitionNumber: 1, StartLBA: 2048, SizeLBA: 204800, PartitionGUID: uuid.New()}
+	usbPart := uuid.MustParse("15e39a00-1dd2-1000-8d7f-00a0c92408fc")
+	usb := &loadOption{Description: "USB stick", FilePath: devicePath{
+		&hardDrivePath{PartitionNumber: 1, PartitionStart: 2048, PartitionSize: 8192, PartitionSignature: usbPart},
+		&filePathElem{Path: `\EFI\BOOT\BOOTX64.EFI`},
+		&endOfDevicePath{},
+	}}
+	other := &loadOption{Description: "debian", FilePath: devicePath{&endOfDevicePath{}}}
+
+	mock := newMockEFIReadWriter()
+	_ = setBootEntry(mock, 0, usb)
+	_ = setBootEntry(mock, 1, other)
+	_ = setBootOrder(mock, BootOrderType{0, 1})
+
+	entries, _ := listBootEntries(mock)
+	if got := entries[0].Signatures; !slices.Equal(got, []string{"15E39A00-1DD2-1000-8D7F-00A0C92408FC"}) {
+		t.Errorf("signatures of the USB entry = %v", got)
+	}
+
+	demote := map[string]bool{"15E39A00-1DD2-1000-8D7F-00A0C92408FC": true}
+	if err := updateBootEntry(mock, esp, `\EFI\BOOT\BOOTX64.EFI`, demote); err != nil {
+		t.Fatalf("updateBootEntry() error: %v", err)
+	}
+	if order, _ := getBootOrder(mock); !slices.Equal(order, BootOrderType{2, 1, 0}) {
+		t.Errorf("BootOrder = %v, want the USB entry last", order)
+	}
+}
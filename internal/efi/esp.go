@@ -0,0 +1,143 @@
+//go:build linux
+
+package efi
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"golang.org/x/sys/unix"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+	"github.com/cozystack/boot-to-talos/internal/fat"
+	"github.com/cozystack/boot-to-talos/internal/uki"
+)
+
+// ErrNoUKI is returned by UKICmdline when the ESP has no Talos UKI.
+//
+//nolint:gochecknoglobals
+var ErrNoUKI = errors.New("no Talos UKI on the ESP")
+
+// openESP opens the FAT filesystem of the EFI System Partition on disk. The
+// returned function closes it.
+func openESP(disk string, opts ...diskfs.OpenOpt) (filesystem.FileSystem, func(), error) {
+	esp, err := getESPInfo(disk)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get ESP info from %s", disk)
+	}
+
+	d, err := diskfs.Open(disk, opts...)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "opening disk %s", disk)
+	}
+
+	// go-diskfs only reads FAT with 512-byte sectors, which mkfs.vfat does
+	// not use on 4Kn disks; fat.Open reads both.
+	part := d.Table.GetPartitions()[esp.PartitionNumber-1]
+	espFS, err := fat.Open(d.Backend, part.GetSize(), part.GetStart())
+	if err != nil {
+		d.Close()
+		return nil, nil, errors.Wrapf(err, "opening ESP (partition %d) on %s", esp.PartitionNumber, disk)
+	}
+
+	return espFS, func() {
+		espFS.Close()
+		d.Close()
+	}, nil
+}
+
+// VerifyESP checks that disk has a GPT with an ESP that Talos boots from: a
+// Talos UKI in EFI/Linux, or for GRUB-based releases (before Talos v1.10) a
+// boot loader at the removable-media path. It returns the ESP path found.
+func VerifyESP(disk string) (string, error) {
+	espFS, closeESP, err := openESP(disk, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return "", err
+	}
+	defer closeESP()
+
+	if uki := latestUKI(dirNames(espFS, "/EFI/Linux")); uki != "" {
+		return "/EFI/Linux/" + uki, nil
+	}
+	if suffix, ok := efiArchSuffixes[runtime.GOARCH]; ok {
+		loader := fallbackPath(suffix)
+		names := dirNames(espFS, "/EFI/BOOT")
+		if i := slices.IndexFunc(names, func(name string) bool {
+			return strings.EqualFold("/EFI/BOOT/"+name, loader)
+		}); i >= 0 {
+			return "/EFI/BOOT/" + names[i], nil
+		}
+	}
+
+	return "", errors.Newf("the ESP on %s has neither a Talos UKI (EFI/Linux/Talos-*.efi) nor a boot loader", disk)
+}
+
+// UKICmdline returns the path of the Talos UKI on the ESP of disk and its
+// kernel command line. ErrNoUKI is returned for GRUB-based images.
+func UKICmdline(disk string) (string, string, error) {
+	espFS, closeESP, err := openESP(disk, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return "", "", err
+	}
+	defer closeESP()
+
+	name := latestUKI(dirNames(espFS, "/EFI/Linux"))
+	if name == "" {
+		return "", "", ErrNoUKI
+	}
+	ukiPath := "/EFI/Linux/" + name
+	f, err := espFS.OpenFile(ukiPath, os.O_RDONLY)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "open %s on the ESP", ukiPath)
+	}
+	defer f.Close()
+	cmdline, err := uki.CmdlineFrom(uki.ReaderAt(f))
+	if err != nil {
+		return "", "", errors.Wrapf(err, "read %s", ukiPath)
+	}
+	return ukiPath, cmdline, nil
+}
+
+// MountESP mounts the ESP of the freshly written disk read-write at dir,
+// through a loop device over the ESP's byte range: the kernel cannot re-read
+// the partition table of a disk whose old partitions are still in use, as
+// those of the running host are. The returned function unmounts it and
+// flushes the writes to disk.
+func MountESP(disk, dir string) (func(), error) {
+	disk, err := filepath.EvalSymlinks(disk)
+	if err != nil {
+		return nil, err
+	}
+	esp, err := getESPInfo(disk)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get ESP info from %s", disk)
+	}
+
+	loop, lf, err := blockdev.AttachLoop(disk, blockdev.LoopConfig{
+		Offset:    int64(esp.StartLBA * esp.SectorSize),
+		Size:      int64(esp.SizeLBA * esp.SectorSize),
+		BlockSize: int(esp.SectorSize),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "attaching the ESP of %s", disk)
+	}
+	// The loop device detaches itself once it is unmounted and closed.
+	defer lf.Close()
+
+	if err := unix.Mount(loop, dir, "vfat", 0, ""); err != nil {
+		return nil, errors.Wrapf(err, "mounting ESP %s", loop)
+	}
+	return func() {
+		_ = unix.Unmount(dir, 0)
+		if f, err := os.OpenFile(disk, os.O_RDWR, 0); err == nil {
+			_ = f.Sync()
+			f.Close()
+		}
+	}, nil
+}
@@ -0,0 +1,208 @@
+//go:build linux
+
+package efi
+
+import (
+	"io"
+	"log"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs/filesystem"
+
+	"github.com/cozystack/boot-to-talos/internal/fat"
+)
+
+// efiArchSuffixes maps GOARCH to the suffix used in EFI binary names
+// (BOOTX64.EFI, systemd-bootx64.efi).
+//
+//nolint:gochecknoglobals
+var efiArchSuffixes = map[string]string{
+	"amd64": "x64",
+	"arm64": "aa64",
+}
+
+// InstallFallback makes sure the ESP on disk has a boot loader at the
+// removable-media fallback path (\EFI\BOOT\BOOTX64.EFI on amd64), so firmware
+// that lost or never had the Talos boot entry still boots the disk.
+// systemd-boot is copied there if the image has it, the latest Talos UKI
+// otherwise. It returns the ESP path that was copied, or "" if the fallback
+// path was already populated.
+func InstallFallback(disk string) (string, error) {
+	suffix, ok := efiArchSuffixes[runtime.GOARCH]
+	if !ok {
+		return "", errors.Newf("unsupported architecture: %s", runtime.GOARCH)
+	}
+
+	espFS, closeESP, err := openESP(disk)
+	if err != nil {
+		return "", err
+	}
+	defer closeESP()
+
+	dst := fallbackPath(suffix)
+	if f, err := espFS.OpenFile(dst, os.O_RDONLY); err == nil {
+		f.Close()
+		log.Printf("%s already exists on the ESP of %s", dst, disk)
+		return "", nil
+	}
+
+	src := fallbackSource(dirNames(espFS, "/EFI/systemd"), dirNames(espFS, "/EFI/Linux"), suffix)
+	if src == "" {
+		return "", errors.Newf("no systemd-boot or Talos UKI found on the ESP of %s", disk)
+	}
+
+	if err := checkESPSpace(disk, espFS, src); err != nil {
+		return "", err
+	}
+	if err := copyESPFile(espFS, src, dst); err != nil {
+		return "", err
+	}
+	log.Printf("copied %s to %s on the ESP of %s", src, dst, disk)
+
+	return src, nil
+}
+
+// fallbackPath returns the removable-media boot path for the EFI arch suffix.
+func fallbackPath(suffix string) string {
+	return "/EFI/BOOT/BOOT" + strings.ToUpper(suffix) + ".EFI"
+}
+
+// fallbackSource picks the file to install at the fallback path from the
+// entries of EFI/systemd and EFI/Linux: systemd-boot, so that boot
+// assessment and the UKI choice keep working, or else the latest Talos UKI.
+func fallbackSource(systemdNames, linuxNames []string, suffix string) string {
+	sdboot := "systemd-boot" + suffix + ".efi"
+	for _, name := range systemdNames {
+		if strings.EqualFold(name, sdboot) {
+			return "/EFI/systemd/" + name
+		}
+	}
+
+	if uki := latestUKI(linuxNames); uki != "" {
+		return "/EFI/Linux/" + uki
+	}
+
+	return ""
+}
+
+// latestUKI returns the last Talos-*.efi name in sort order, which is the one
+// the installer wrote last.
+func latestUKI(names []string) string {
+	var ukis []string
+	for _, name := range names {
+		if ok, _ := path.Match("Talos-*.efi", name); ok {
+			ukis = append(ukis, name)
+		}
+	}
+	if len(ukis) == 0 {
+		return ""
+	}
+	slices.Sort(ukis)
+
+	return ukis[len(ukis)-1]
+}
+
+// dirNames lists the file names in dir, or nil if it cannot be read.
+func dirNames(fs filesystem.FileSystem, dir string) []string {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+
+	return names
+}
+
+// checkESPSpace checks that the ESP on disk has room for a copy of src, so
+// that a full ESP is reported before a truncated boot loader is left at the
+// fallback path.
+func checkESPSpace(disk string, fs filesystem.FileSystem, src string) error {
+	size := fileSize(fs, src)
+	if size < 0 {
+		return errors.Newf("cannot find %s on the ESP of %s", src, disk)
+	}
+	free, cluster, err := espFreeSpace(disk)
+	if err != nil {
+		return err
+	}
+	// One more cluster for \EFI\BOOT if it does not exist yet.
+	needed := (size+cluster-1)/cluster*cluster + cluster
+	if needed > free {
+		return errors.Newf("the ESP of %s is too small for the fallback boot loader: copying %s needs %.1f MiB, %.1f MiB available",
+			disk, src, float64(needed)/(1<<20), float64(free)/(1<<20))
+	}
+	return nil
+}
+
+// fileSize returns the size of the file at name on fs, or -1 if it does not
+// exist.
+func fileSize(fs filesystem.FileSystem, name string) int64 {
+	entries, err := fs.ReadDir(path.Dir(name))
+	if err != nil {
+		return -1
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(e.Name(), path.Base(name)) {
+			return e.Size()
+		}
+	}
+	return -1
+}
+
+// espFreeSpace returns the free bytes and the cluster size of the FAT32
+// filesystem of the ESP on disk.
+func espFreeSpace(disk string) (free, cluster int64, err error) {
+	esp, err := getESPInfo(disk)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get ESP info from %s", disk)
+	}
+	f, err := os.Open(disk)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "opening disk %s", disk)
+	}
+	defer f.Close()
+
+	free, cluster, err = fat.FreeSpace(f, int64(esp.StartLBA*esp.SectorSize))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "reading ESP free space")
+	}
+	return free, cluster, nil
+}
+
+func copyESPFile(fs filesystem.FileSystem, src, dst string) error {
+	in, err := fs.OpenFile(src, os.O_RDONLY)
+	if err != nil {
+		return errors.Wrapf(err, "open %s on the ESP", src)
+	}
+	data, err := io.ReadAll(in)
+	in.Close()
+	if err != nil {
+		return errors.Wrapf(err, "read %s on the ESP", src)
+	}
+
+	if err := fs.Mkdir(path.Dir(dst)); err != nil {
+		return errors.Wrapf(err, "create %s on the ESP", path.Dir(dst))
+	}
+	out, err := fs.OpenFile(dst, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+	if err != nil {
+		return errors.Wrapf(err, "create %s on the ESP", dst)
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		// Do not leave a truncated boot loader behind.
+		_ = fs.Remove(dst)
+		return errors.Wrapf(err, "write %s on the ESP", dst)
+	}
+
+	return errors.Wrapf(out.Close(), "close %s on the ESP", dst)
+}
@@ -0,0 +1,322 @@
+//go:build linux
+
+package efi
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+	"github.com/cozystack/boot-to-talos/internal/testutil"
+)
+
+func TestFallbackSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		systemd []string
+		linux   []string
+		want    string
+	}{
+		{"systemd-boot", []string{"systemd-bootx64.efi"}, []string{"Talos-A.efi"}, "/EFI/systemd/systemd-bootx64.efi"},
+		{"latest UKI", nil, []string{"Talos-B.efi", "Talos-A.efi", "other.efi"}, "/EFI/Linux/Talos-B.efi"},
+		{"other arch sd-boot", []string{"systemd-bootaa64.efi"}, []string{"Talos-A.efi"}, "/EFI/Linux/Talos-A.efi"},
+		{"nothing", nil, []string{"other.efi"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fallbackSource(tt.systemd, tt.linux, "x64"); got != tt.want {
+				t.Errorf("fallbackSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackPath(t *testing.T) {
+	if got := fallbackPath("aa64"); got != "/EFI/BOOT/BOOTAA64.EFI" {
+		t.Errorf("fallbackPath(aa64) = %q", got)
+	}
+}
+
+// writeESPImage creates a GPT disk image whose ESP holds files.
+func writeESPImage(t *testing.T, files map[string]string) string {
+	t.Helper()
+	img := filepath.Join(t.TempDir(), "disk.raw")
+	const size = 64 << 20
+	d, err := diskfs.Create(img, size, diskfs.SectorSizeDefault)
+	if err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	defer d.Close()
+	table := &gpt.Table{
+		LogicalSectorSize: 512,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: size/512 - 2048, Type: gpt.EFISystemPartition, Name: "EFI"},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("partition image: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("create ESP: %v", err)
+	}
+	for name, content := range files {
+		if err := fs.Mkdir(filepath.Dir(name)); err != nil {
+			t.Fatalf("mkdir %s: %v", name, err)
+		}
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		f.Close()
+	}
+
+	return img
+}
+
+func readESPFile(t *testing.T, img, name string) string {
+	t.Helper()
+	d, err := diskfs.Open(img, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatalf("open image: %v", err)
+	}
+	defer d.Close()
+	fs, err := d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("open ESP: %v", err)
+	}
+	f, err := fs.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+
+	return string(data)
+}
+
+func TestInstallFallback(t *testing.T) {
+	suffix, ok := efiArchSuffixes[runtime.GOARCH]
+	if !ok {
+		t.Skipf("no EFI fallback path for %s", runtime.GOARCH)
+	}
+
+	img := writeESPImage(t, map[string]string{
+		"/EFI/Linux/Talos-A.efi": "old",
+		"/EFI/Linux/Talos-B.efi": "uki",
+	})
+	src, err := InstallFallback(img)
+	if err != nil {
+		t.Fatalf("InstallFallback error: %v", err)
+	}
+	if src != "/EFI/Linux/Talos-B.efi" {
+		t.Errorf("InstallFallback copied %q, want the latest UKI", src)
+	}
+	if got := readESPFile(t, img, fallbackPath(suffix)); got != "uki" {
+		t.Errorf("fallback loader content = %q, want %q", got, "uki")
+	}
+
+	// A populated fallback path is left alone.
+	src, err = InstallFallback(img)
+	if err != nil || src != "" {
+		t.Errorf("second InstallFallback = %q, %v; want no copy", src, err)
+	}
+}
+
+func TestESPFreeSpace(t *testing.T) {
+	img := writeESPImage(t, map[string]string{"/EFI/Linux/Talos-A.efi": "uki"})
+	free, cluster, err := espFreeSpace(img)
+	if err != nil {
+		t.Fatalf("espFreeSpace error: %v", err)
+	}
+	if cluster <= 0 || free <= 0 || free > 64<<20 {
+		t.Errorf("espFreeSpace = %d, %d; want free space of the 64 MiB ESP", free, cluster)
+	}
+
+	img = writeESPImage(t, map[string]string{"/EFI/Linux/Talos-A.efi": strings.Repeat("x", 8<<20)})
+	free8, _, err := espFreeSpace(img)
+	if err != nil {
+		t.Fatalf("espFreeSpace error: %v", err)
+	}
+	if free-free8 < 8<<20-cluster {
+		t.Errorf("free space dropped by %d bytes after writing 8 MiB", free-free8)
+	}
+}
+
+func TestInstallFallback_ESPTooSmall(t *testing.T) {
+	if _, ok := efiArchSuffixes[runtime.GOARCH]; !ok {
+		t.Skipf("no EFI fallback path for %s", runtime.GOARCH)
+	}
+
+	img := writeESPImage(t, map[string]string{"/EFI/Linux/Talos-A.efi": strings.Repeat("x", 40<<20)})
+	_, err := InstallFallback(img)
+	if err == nil || !strings.Contains(err.Error(), "too small for the fallback boot loader") {
+		t.Errorf("InstallFallback error = %v, want the ESP to be too small", err)
+	}
+}
+
+func TestVerifyESP(t *testing.T) {
+	suffix, ok := efiArchSuffixes[runtime.GOARCH]
+	if !ok {
+		t.Skipf("no EFI fallback path for %s", runtime.GOARCH)
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"UKI", map[string]string{"/EFI/Linux/Talos-A.efi": "uki", "/EFI/Linux/Talos-B.efi": "uki"}, "/EFI/Linux/Talos-B.efi", false},
+		{"GRUB", map[string]string{fallbackPath(suffix): "grub"}, fallbackPath(suffix), false},
+		{"empty ESP", map[string]string{"/EFI/Linux/other.efi": "x"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyESP(writeESPImage(t, tt.files))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyESP error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("VerifyESP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A disk without a partition table.
+	raw := filepath.Join(t.TempDir(), "blank.raw")
+	if err := os.WriteFile(raw, make([]byte, 1<<20), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyESP(raw); err == nil {
+		t.Error("VerifyESP accepted a disk without a GPT")
+	}
+}
+
+// TestVerifyESP_4Kn checks the ESP of a disk with 4 KiB logical sectors,
+// whose FAT has 4 KiB sectors too, through a loop device with that sector
+// size, as the installer sees it.
+func TestVerifyESP_4Kn(t *testing.T) {
+	suffix, ok := efiArchSuffixes[runtime.GOARCH]
+	if !ok {
+		t.Skipf("no EFI fallback path for %s", runtime.GOARCH)
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("attaching a loop device needs root")
+	}
+
+	img := filepath.Join(t.TempDir(), "disk.raw")
+	if err := testutil.CreateTestRAWImageWithSectorSize(img, 64, 4096, map[string][]byte{
+		"/EFI/Linux/Talos-A.efi": []byte("uki"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("losetup", "--find", "--show", "--sector-size", "4096", img).Output()
+	if err != nil {
+		t.Skipf("no loop device: %v", err)
+	}
+	loop := strings.TrimSpace(string(out))
+	t.Cleanup(func() { _ = exec.Command("losetup", "--detach", loop).Run() })
+
+	got, err := VerifyESP(loop)
+	if err != nil {
+		t.Fatalf("VerifyESP error: %v", err)
+	}
+	if got != "/EFI/Linux/Talos-A.efi" {
+		t.Errorf("VerifyESP() = %q, want the UKI", got)
+	}
+
+	if _, err := InstallFallback(loop); err != nil {
+		t.Fatalf("InstallFallback error: %v", err)
+	}
+	if got, err = VerifyESP(loop); err != nil || got != "/EFI/Linux/Talos-A.efi" {
+		t.Errorf("VerifyESP() after InstallFallback = %q, %v", got, err)
+	}
+	espFS, closeESP, err := openESP(loop, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeESP()
+	if names := dirNames(espFS, "/EFI/BOOT"); len(names) != 1 || !strings.EqualFold("/EFI/BOOT/"+names[0], fallbackPath(suffix)) {
+		t.Errorf("/EFI/BOOT = %v, want the fallback loader", names)
+	}
+}
+
+func TestAttachESPRange(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("attaching a loop device needs root")
+	}
+	img := writeESPImage(t, map[string]string{"/EFI/Linux/Talos-A.efi": "uki"})
+	esp, err := getESPInfo(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, size := int64(esp.StartLBA*esp.SectorSize), int64(esp.SizeLBA*esp.SectorSize)
+
+	loop, lf, err := blockdev.AttachLoop(img, blockdev.LoopConfig{Offset: start, Size: size, BlockSize: int(esp.SectorSize)})
+	if err != nil {
+		t.Skipf("no loop device: %v", err)
+	}
+	defer lf.Close()
+
+	if got, err := lf.Seek(0, io.SeekEnd); err != nil || got != size {
+		t.Errorf("%s is %d bytes (%v), want the %d-byte ESP", loop, got, err, size)
+	}
+	want := make([]byte, 512)
+	f, err := os.Open(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.ReadAt(want, start); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 512)
+	if _, err := lf.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s does not start with the ESP boot sector", loop)
+	}
+}
+
+func TestUKICmdline(t *testing.T) {
+	ukiFile := filepath.Join(t.TempDir(), "uki.efi")
+	if err := testutil.CreateTestUKIFile(ukiFile, "talos.platform=metal console=ttyS0", "kernel", "initrd"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(ukiFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, cmdline, err := UKICmdline(writeESPImage(t, map[string]string{"/EFI/Linux/Talos-A.efi": string(data)}))
+	if err != nil {
+		t.Fatalf("UKICmdline error: %v", err)
+	}
+	if path != "/EFI/Linux/Talos-A.efi" || cmdline != "talos.platform=metal console=ttyS0" {
+		t.Errorf("UKICmdline() = %q, %q", path, cmdline)
+	}
+
+	if _, _, err := UKICmdline(writeESPImage(t, map[string]string{"/EFI/BOOT/grub.efi": "grub"})); !errors.Is(err, ErrNoUKI) {
+		t.Errorf("UKICmdline without UKI error = %v, want ErrNoUKI", err)
+	}
+}
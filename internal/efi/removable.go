@@ -0,0 +1,109 @@
+//go:build linux
+
+package efi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/google/uuid"
+)
+
+// mbrSignatureType marks the disk signature of an MBR disk in a hard drive
+// device path, see gptSignatureType.
+const mbrSignatureType = 0x01
+
+// hdSignatures returns the partition signatures of the hard drive nodes in
+// the device path: the partition GUID, or for MBR disks the disk signature
+// as 8 hex digits.
+func hdSignatures(path []byte) []string {
+	var sigs []string
+	for len(path) >= 4 {
+		n := int(binary.LittleEndian.Uint16(path[2:4]))
+		if n < 4 || n > len(path) {
+			break
+		}
+		node := path[:n]
+		path = path[n:]
+		if node[0] == dpTypeEnd {
+			break
+		}
+		if node[0] != dpTypeMedia || node[1] != dpSubTypeHardDrive || n < hardDrivePathLen {
+			continue
+		}
+		switch node[41] {
+		case gptSignatureType:
+			id, err := uuid.FromBytes(guidToMixedEndian(uuid.UUID(node[24:40])))
+			if err == nil {
+				sigs = append(sigs, strings.ToUpper(id.String()))
+			}
+		case mbrSignatureType:
+			sigs = append(sigs, fmt.Sprintf("%08x", binary.LittleEndian.Uint32(node[24:28])))
+		}
+	}
+	return sigs
+}
+
+// diskSignatures returns the signatures boot entries use for the partitions
+// of disk, see hdSignatures: its MBR disk signature and its GPT partition
+// GUIDs.
+func diskSignatures(disk string) (map[string]bool, error) {
+	f, err := os.Open(disk)
+	if err != nil {
+		return nil, errors.Wrap(err, "open disk")
+	}
+	mbr := make([]byte, 512)
+	_, err = io.ReadFull(f, mbr)
+	f.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "read MBR")
+	}
+
+	sigs := map[string]bool{}
+	if sig := binary.LittleEndian.Uint32(mbr[440:444]); sig != 0 {
+		sigs[fmt.Sprintf("%08x", sig)] = true
+	}
+	if d, err := diskfs.Open(disk, diskfs.WithOpenMode(diskfs.ReadOnly)); err == nil {
+		if table, ok := d.Table.(*gpt.Table); ok {
+			for _, p := range table.Partitions {
+				if p != nil && p.GUID != "" {
+					sigs[strings.ToUpper(p.GUID)] = true
+				}
+			}
+		}
+		d.Close()
+	}
+	return sigs, nil
+}
+
+// demoteBootEntries moves the entries of order that refer to a partition
+// with a signature in demote to its end, keeping their relative order.
+func demoteBootEntries(order BootOrderType, entries map[int]*loadOption, demote map[string]bool) BootOrderType {
+	if len(demote) == 0 {
+		return order
+	}
+	isDemoted := func(idx uint16) bool {
+		entry := entries[int(idx)]
+		return entry != nil && slices.ContainsFunc(entry.Signatures, func(sig string) bool { return demote[sig] })
+	}
+	var kept, demoted BootOrderType
+	for _, idx := range order {
+		if isDemoted(idx) {
+			demoted = append(demoted, idx)
+		} else {
+			kept = append(kept, idx)
+		}
+	}
+	if len(demoted) > 0 {
+		log.Printf("moving boot entries %v of the removable boot medium to the end of BootOrder", demoted)
+	}
+	return append(kept, demoted...)
+}
@@ -0,0 +1,114 @@
+//go:build linux
+
+package efi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"io/fs"
+	"log"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+
+	"github.com/cozystack/boot-to-talos/internal/uki"
+)
+
+//nolint:gochecknoglobals
+var (
+	// scopeImageSecurity is EFI_IMAGE_SECURITY_DATABASE_GUID, the scope of
+	// the db and dbx variables.
+	scopeImageSecurity = uuid.MustParse("d719b2cb-3d3a-4596-a3bc-dad00e67656f")
+
+	// Signature types of EFI_SIGNATURE_LIST entries that are understood.
+	certX509GUID   = guidToMixedEndian(uuid.MustParse("a5c059a1-94e4-4aa7-87b5-ab155c2bf072"))
+	certSHA256GUID = guidToMixedEndian(uuid.MustParse("c1c41626-504c-4092-aca9-41f936934328"))
+)
+
+// ReadTrustStore reads the Secure Boot signature databases db and dbx, for
+// verifying UKI signatures like the firmware. It fails in setup mode, where
+// db is not protected, and when no db is enrolled.
+func ReadTrustStore() (*uki.TrustStore, error) {
+	state, err := GetSecureBootState()
+	if err != nil {
+		return nil, err
+	}
+	if state.SetupMode {
+		return nil, errors.New("Secure Boot is in setup mode: db can be changed without authentication")
+	}
+
+	efiRW, err := newEFIReaderWriter(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create efivarfs reader")
+	}
+	defer efiRW.Close()
+	return readTrustStore(efiRW)
+}
+
+// readTrustStore reads db and dbx from rw. A missing dbx is empty.
+func readTrustStore(rw efiReadWriter) (*uki.TrustStore, error) {
+	data, _, err := rw.Read(scopeImageSecurity, "db")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("no Secure Boot db is enrolled")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read db")
+	}
+	trust := &uki.TrustStore{}
+	if trust.DB, err = parseSignatureLists(data); err != nil {
+		return nil, errors.Wrap(err, "parse db")
+	}
+	if len(trust.DB.Certs) == 0 && len(trust.DB.Hashes) == 0 {
+		return nil, errors.New("the Secure Boot db has no certificates or hashes")
+	}
+
+	data, _, err = rw.Read(scopeImageSecurity, "dbx")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.Wrap(err, "read dbx")
+	}
+	if trust.DBX, err = parseSignatureLists(data); err != nil {
+		return nil, errors.Wrap(err, "parse dbx")
+	}
+	log.Printf("Secure Boot db: %d certificates, %d hashes; dbx: %d certificates, %d hashes",
+		len(trust.DB.Certs), len(trust.DB.Hashes), len(trust.DBX.Certs), len(trust.DBX.Hashes))
+	return trust, nil
+}
+
+// parseSignatureLists parses a sequence of EFI_SIGNATURE_LIST structures:
+// a 16-byte type GUID, the list, header and signature sizes, a header, and
+// signatures of a 16-byte owner GUID followed by the data. Types other than
+// X.509 certificates and SHA-256 hashes are skipped.
+func parseSignatureLists(data []byte) (uki.SignatureDB, error) {
+	var db uki.SignatureDB
+	for len(data) > 0 {
+		if len(data) < 28 {
+			return db, errors.New("truncated signature list")
+		}
+		sigType := data[:16]
+		listSize := int(binary.LittleEndian.Uint32(data[16:20]))
+		headerSize := int(binary.LittleEndian.Uint32(data[20:24]))
+		sigSize := int(binary.LittleEndian.Uint32(data[24:28]))
+		if listSize < 28+headerSize || listSize > len(data) || sigSize <= 16 {
+			return db, errors.New("malformed signature list")
+		}
+
+		for sigs := data[28+headerSize : listSize]; len(sigs) >= sigSize; sigs = sigs[sigSize:] {
+			sig := sigs[16:sigSize]
+			switch {
+			case bytes.Equal(sigType, certX509GUID):
+				cert, err := x509.ParseCertificate(sig)
+				if err != nil {
+					log.Printf("warning: skipping unparsable certificate in signature list: %v", err)
+					continue
+				}
+				db.Certs = append(db.Certs, cert)
+			case bytes.Equal(sigType, certSHA256GUID) && len(sig) == sha256.Size:
+				db.Hashes = append(db.Hashes, [sha256.Size]byte(sig))
+			}
+		}
+		data = data[listSize:]
+	}
+	return db, nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package efi
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// signatureList builds an EFI_SIGNATURE_LIST of sigType with one entry per
+// item.
+func signatureList(sigType []byte, items ...[]byte) []byte {
+	sigSize := 16 + len(items[0])
+	var buf bytes.Buffer
+	buf.Write(sigType)
+	_ = binary.Write(&buf, binary.LittleEndian, []uint32{uint32(28 + sigSize*len(items)), 0, uint32(sigSize)})
+	for _, item := range items {
+		buf.Write(make([]byte, 16)) // owner GUID
+		buf.Write(item)
+	}
+	return buf.Bytes()
+}
+
+func TestReadTrustStore(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test db key"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := bytes.Repeat([]byte{0xab}, 32)
+	unknownGUID := guidToMixedEndian(scopeImageSecurity) // a signature type that is skipped
+
+	t.Run("db and dbx", func(t *testing.T) {
+		rw := newMockEFIReadWriter()
+		db := append(signatureList(certX509GUID, cert), signatureList(unknownGUID, make([]byte, 256))...)
+		_ = rw.Write(scopeImageSecurity, "db", 0, db)
+		_ = rw.Write(scopeImageSecurity, "dbx", 0, signatureList(certSHA256GUID, hash, make([]byte, 32)))
+
+		trust, err := readTrustStore(rw)
+		if err != nil {
+			t.Fatalf("readTrustStore() error = %v", err)
+		}
+		if len(trust.DB.Certs) != 1 || !bytes.Equal(trust.DB.Certs[0].Raw, cert) || len(trust.DB.Hashes) != 0 {
+			t.Errorf("db = %d certs, %d hashes, want the test certificate", len(trust.DB.Certs), len(trust.DB.Hashes))
+		}
+		if len(trust.DBX.Hashes) != 2 || !bytes.Equal(trust.DBX.Hashes[0][:], hash) || len(trust.DBX.Certs) != 0 {
+			t.Errorf("dbx = %d certs, %d hashes, want 2 hashes", len(trust.DBX.Certs), len(trust.DBX.Hashes))
+		}
+	})
+
+	t.Run("no dbx", func(t *testing.T) {
+		rw := newMockEFIReadWriter()
+		_ = rw.Write(scopeImageSecurity, "db", 0, signatureList(certSHA256GUID, hash))
+		if _, err := readTrustStore(rw); err != nil {
+			t.Fatalf("readTrustStore() error = %v", err)
+		}
+	})
+
+	for name, db := range map[string][]byte{
+		"empty db":     signatureList(unknownGUID, make([]byte, 256)),
+		"truncated db": signatureList(certX509GUID, cert)[:40],
+	} {
+		t.Run(name, func(t *testing.T) {
+			rw := newMockEFIReadWriter()
+			_ = rw.Write(scopeImageSecurity, "db", 0, db)
+			if _, err := readTrustStore(rw); err == nil {
+				t.Fatal("readTrustStore() succeeded")
+			}
+		})
+	}
+
+	t.Run("no db", func(t *testing.T) {
+		if _, err := readTrustStore(newMockEFIReadWriter()); err == nil {
+			t.Fatal("readTrustStore() succeeded")
+		}
+	})
+}
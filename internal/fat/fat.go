@@ -0,0 +1,268 @@
+// Package fat opens FAT32 filesystems with go-diskfs, which only reads
+// filesystems with 512-byte logical sectors, on disks with 4 KiB sectors.
+package fat
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+)
+
+const (
+	sectorSize512 = 512
+	maxSectorSize = 4096
+
+	// Boot sector offsets of the fields read here.
+	offBytesPerSector    = 0x0b
+	offSectorsPerCluster = 0x0d
+	offReservedSectors   = 0x0e
+	offNumFATs           = 0x10
+	offSectorsPerFAT16   = 0x16
+	offTotalSectors      = 0x20
+	offSectorsPerFAT     = 0x24
+	offBackupBootSector  = 0x32
+)
+
+// sectorFields lists the boot sector fields that count sectors, by offset and
+// width in bytes.
+//
+//nolint:gochecknoglobals
+var sectorFields = []struct{ off, size int }{
+	{offSectorsPerCluster, 1},
+	{offReservedSectors, 2},
+	{0x13, 2}, // total sectors, FAT12/16
+	{offTotalSectors, 4},
+	{offSectorsPerFAT, 4},
+	{0x30, 2}, // FS information sector
+	{offBackupBootSector, 2},
+}
+
+// Open opens the FAT32 filesystem of size bytes at byte offset start of b.
+// A filesystem formatted with larger logical sectors, as mkfs.vfat does on
+// 4Kn disks, is handed to go-diskfs as one with 512-byte sectors: its boot
+// sectors are rescaled as they are read and written back, and every other
+// structure already sits at the byte offset go-diskfs computes.
+func Open(b backend.Storage, size, start int64) (filesystem.FileSystem, error) {
+	boot := make([]byte, sectorSize512)
+	if _, err := b.ReadAt(boot, start); err != nil {
+		return nil, errors.Wrap(err, "reading FAT boot sector")
+	}
+
+	sectorSize := int64(binary.LittleEndian.Uint16(boot[offBytesPerSector:]))
+	if sectorSize > sectorSize512 && sectorSize <= maxSectorSize && sectorSize&(sectorSize-1) == 0 {
+		view, err := newSectorView(b, start, boot, sectorSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading FAT filesystem with %d-byte sectors", sectorSize)
+		}
+		b = view
+	}
+
+	fs, err := fat32.Read(b, size, start, sectorSize512)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// FreeSpace returns the free bytes and the cluster size of the FAT32
+// filesystem at byte offset start of r, counted from the free entries of its
+// first FAT; go-diskfs does not report them. Any logical sector size is read
+// as it is.
+func FreeSpace(r io.ReaderAt, start int64) (free, cluster int64, err error) {
+	boot := make([]byte, sectorSize512)
+	if _, err := r.ReadAt(boot, start); err != nil {
+		return 0, 0, errors.Wrap(err, "reading FAT boot sector")
+	}
+	sectorSize := int64(binary.LittleEndian.Uint16(boot[offBytesPerSector:]))
+	sectorsPerCluster := int64(boot[offSectorsPerCluster])
+	reserved := int64(binary.LittleEndian.Uint16(boot[offReservedSectors:]))
+	fats := int64(boot[offNumFATs])
+	totalSectors := int64(binary.LittleEndian.Uint32(boot[offTotalSectors:]))
+	fatSectors := int64(binary.LittleEndian.Uint32(boot[offSectorsPerFAT:]))
+	if sectorSize == 0 || sectorsPerCluster == 0 || fatSectors == 0 || binary.LittleEndian.Uint16(boot[offSectorsPerFAT16:]) != 0 {
+		return 0, 0, errors.New("not a FAT32 filesystem")
+	}
+
+	clusters := (totalSectors - reserved - fats*fatSectors) / sectorsPerCluster
+	table := make([]byte, min(fatSectors*sectorSize, (clusters+2)*4))
+	if _, err := r.ReadAt(table, start+reserved*sectorSize); err != nil {
+		return 0, 0, errors.Wrap(err, "reading FAT")
+	}
+	var freeClusters int64
+	for i := int64(2); i < clusters+2 && (i+1)*4 <= int64(len(table)); i++ {
+		if binary.LittleEndian.Uint32(table[i*4:])&0x0fffffff == 0 {
+			freeClusters++
+		}
+	}
+
+	cluster = sectorsPerCluster * sectorSize
+	return freeClusters * cluster, cluster, nil
+}
+
+// rescale returns a copy of boot sector b with its sector counts converted
+// from sectors of from bytes to sectors of to bytes.
+func rescale(b []byte, from, to int64) ([]byte, error) {
+	out := slices.Clone(b)
+	binary.LittleEndian.PutUint16(out[offBytesPerSector:], uint16(to))
+	for _, f := range sectorFields {
+		field := out[f.off : f.off+f.size]
+		var n uint64
+		switch f.size {
+		case 1:
+			n = uint64(field[0])
+		case 2:
+			n = uint64(binary.LittleEndian.Uint16(field))
+		default:
+			n = uint64(binary.LittleEndian.Uint32(field))
+		}
+
+		n *= uint64(from)
+		if n%uint64(to) != 0 {
+			return nil, errors.Newf("boot sector field at %#x is not a whole number of %d-byte sectors", f.off, to)
+		}
+		n /= uint64(to)
+		if n >= 1<<(8*f.size) {
+			return nil, errors.Newf("boot sector field at %#x overflows with %d-byte sectors", f.off, to)
+		}
+
+		switch f.size {
+		case 1:
+			field[0] = byte(n)
+		case 2:
+			binary.LittleEndian.PutUint16(field, uint16(n))
+		default:
+			binary.LittleEndian.PutUint32(field, uint32(n))
+		}
+	}
+	return out, nil
+}
+
+// bootSector is a boot sector as go-diskfs sees it: 512 bytes at off, with
+// sector counts in 512-byte sectors.
+type bootSector struct {
+	off  int64
+	data []byte
+}
+
+// sectorView presents a FAT32 filesystem with sectorSize-byte sectors to
+// go-diskfs as one with 512-byte sectors.
+type sectorView struct {
+	backend.Storage
+
+	sectorSize int64
+	boot       []*bootSector
+
+	// backupFSInfo maps the offset go-diskfs writes the backup FS
+	// information sector to, the 512-byte sector after the backup boot
+	// sector, to where it is: the sector after it.
+	backupFSInfo, backupFSInfoAt int64
+}
+
+func newSectorView(b backend.Storage, start int64, boot []byte, sectorSize int64) (*sectorView, error) {
+	data, err := rescale(boot, sectorSize, sectorSize512)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &sectorView{
+		Storage:    b,
+		sectorSize: sectorSize,
+		boot:       []*bootSector{{off: start, data: data}},
+	}
+	if backup := int64(binary.LittleEndian.Uint16(boot[offBackupBootSector:])); backup > 0 {
+		at := start + backup*sectorSize
+		v.boot = append(v.boot, &bootSector{off: at, data: slices.Clone(data)})
+		v.backupFSInfo = at + sectorSize512
+		v.backupFSInfoAt = at + sectorSize
+	}
+	return v, nil
+}
+
+// ReadAt reads from the backend, with the boot sectors rescaled.
+func (v *sectorView) ReadAt(p []byte, off int64) (int, error) {
+	n, err := v.Storage.ReadAt(p, off)
+	v.overlay(p[:n], off)
+	return n, err
+}
+
+// Writable returns the backend for writing, with the boot sectors rescaled.
+func (v *sectorView) Writable() (backend.WritableFile, error) {
+	w, err := v.Storage.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &writableView{WritableFile: w, view: v}, nil
+}
+
+// overlay copies the rescaled boot sectors over the bytes p read at off.
+func (v *sectorView) overlay(p []byte, off int64) {
+	for _, bs := range v.boot {
+		if lo, hi, ok := overlap(off, int64(len(p)), bs.off); ok {
+			copy(p[lo-off:hi-off], bs.data[lo-bs.off:hi-bs.off])
+		}
+	}
+}
+
+// overlap returns the part of the len bytes at off that falls in the
+// 512-byte sector at sector.
+func overlap(off, n, sector int64) (int64, int64, bool) {
+	lo, hi := max(off, sector), min(off+n, sector+sectorSize512)
+	return lo, hi, lo < hi
+}
+
+type writableView struct {
+	backend.WritableFile
+
+	view *sectorView
+}
+
+func (w *writableView) ReadAt(p []byte, off int64) (int, error) {
+	n, err := w.WritableFile.ReadAt(p, off)
+	w.view.overlay(p[:n], off)
+	return n, err
+}
+
+// WriteAt writes p at off, converting the sector counts of boot sectors
+// back to the filesystem's sector size.
+func (w *writableView) WriteAt(p []byte, off int64) (int, error) {
+	v := w.view
+	if v.backupFSInfo > 0 && off == v.backupFSInfo {
+		off = v.backupFSInfoAt
+	}
+
+	out, cloned := p, false
+	updated := make([][]byte, len(v.boot))
+	for i, bs := range v.boot {
+		lo, hi, ok := overlap(off, int64(len(p)), bs.off)
+		if !ok {
+			continue
+		}
+		data := slices.Clone(bs.data)
+		copy(data[lo-bs.off:hi-bs.off], p[lo-off:hi-off])
+		native, err := rescale(data, sectorSize512, v.sectorSize)
+		if err != nil {
+			return 0, err
+		}
+		if !cloned {
+			out, cloned = slices.Clone(p), true
+		}
+		copy(out[lo-off:hi-off], native[lo-bs.off:hi-bs.off])
+		updated[i] = data
+	}
+
+	n, err := w.WritableFile.WriteAt(out, off)
+	if err != nil {
+		return n, err
+	}
+	for i, data := range updated {
+		if data != nil {
+			v.boot[i].data = data
+		}
+	}
+	return n, nil
+}
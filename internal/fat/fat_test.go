@@ -0,0 +1,42 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestRescale(t *testing.T) {
+	boot := make([]byte, 512)
+	binary.LittleEndian.PutUint16(boot[0x0b:], 4096)
+	boot[0x0d] = 1
+	binary.LittleEndian.PutUint16(boot[0x0e:], 32)
+	binary.LittleEndian.PutUint32(boot[0x20:], 16128)
+	binary.LittleEndian.PutUint32(boot[0x24:], 16)
+	binary.LittleEndian.PutUint16(boot[0x30:], 1)
+	binary.LittleEndian.PutUint16(boot[0x32:], 6)
+
+	got, err := rescale(boot, 4096, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bps := binary.LittleEndian.Uint16(got[0x0b:]); bps != 512 {
+		t.Errorf("bytes per sector = %d, want 512", bps)
+	}
+	if got[0x0d] != 8 || binary.LittleEndian.Uint16(got[0x32:]) != 48 || binary.LittleEndian.Uint32(got[0x20:]) != 16128*8 {
+		t.Errorf("sector counts not scaled by 8: % x", got[0x0b:0x34])
+	}
+
+	back, err := rescale(got, 512, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back, boot) {
+		t.Errorf("round trip changed the boot sector: % x", back[0x0b:0x34])
+	}
+
+	got[0x0d] = 9
+	if _, err := rescale(got, 512, 4096); err == nil {
+		t.Error("rescale accepted 9 512-byte sectors per 4 KiB-sector cluster")
+	}
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package host
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// DefaultX86Level is the x86-64 microarchitecture level Talos images are
+// built for.
+const DefaultX86Level = 2
+
+// x86LevelFlags lists the /proc/cpuinfo flags each x86-64 microarchitecture
+// level (x86-64 psABI) adds to the previous one; pni is how cpuinfo calls
+// SSE3 and abm LZCNT.
+//
+//nolint:gochecknoglobals
+var x86LevelFlags = map[int][]string{
+	2: {"cx16", "lahf_lm", "pni", "popcnt", "sse4_1", "sse4_2", "ssse3"},
+	3: {"avx", "avx2", "bmi1", "bmi2", "f16c", "fma", "abm", "movbe", "xsave"},
+	4: {"avx512f", "avx512bw", "avx512cd", "avx512dq", "avx512vl"},
+}
+
+// ParseX86Level parses an x86-64 microarchitecture level: v1 to v4, also
+// written x86-64-v2 or x86_64-v2.
+func ParseX86Level(s string) (int, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "x86-64-"), "x86_64-")
+	level, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+	if err != nil || !strings.HasPrefix(v, "v") || level < 1 || level > 4 {
+		return 0, errors.Newf("invalid x86-64 microarchitecture level %q: use v1, v2, v3 or v4", s)
+	}
+	return level, nil
+}
+
+// CheckX86Level checks that the host CPU implements the x86-64
+// microarchitecture level, so that a kernel built for it does not die with
+// an invalid opcode right after kexec. Hosts that are not x86 pass.
+func CheckX86Level(level int) error {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return errors.Wrap(err, "open /proc/cpuinfo")
+	}
+	defer f.Close()
+	return checkX86Level(f, level)
+}
+
+func checkX86Level(r io.Reader, level int) error {
+	var flags map[string]bool
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "flags" {
+			continue
+		}
+		flags = map[string]bool{}
+		for _, flag := range strings.Fields(value) {
+			flags[flag] = true
+		}
+		break // all CPUs have the same flags
+	}
+	if err := sc.Err(); err != nil {
+		return errors.Wrap(err, "read /proc/cpuinfo")
+	}
+	if flags == nil {
+		return nil
+	}
+
+	var missing []string
+	hostLevel := 0
+	for l := 2; l <= level; l++ {
+		for _, flag := range x86LevelFlags[l] {
+			if !flags[flag] {
+				missing = append(missing, flag)
+			}
+		}
+		if len(missing) == 0 {
+			hostLevel = l
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if hostLevel == 0 {
+		hostLevel = 1
+	}
+	return errors.Newf("the CPU only supports x86-64-v%d, the image needs x86-64-v%d (missing: %s)",
+		hostLevel, level, strings.Join(missing, " "))
+}
@@ -0,0 +1,59 @@
+//go:build linux
+
+package host
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckX86Level(t *testing.T) {
+	const (
+		v1 = "processor\t: 0\nflags\t\t: fpu vme de pse cx8 sse sse2 lm\n"
+		// All of v2 but SSE3.
+		noSSE3 = "processor\t: 0\nflags\t\t: fpu sse sse2 cx16 lahf_lm popcnt sse4_1 sse4_2 ssse3\n"
+		v3     = "processor\t: 0\nflags\t\t: fpu sse sse2 cx16 lahf_lm pni popcnt sse4_1 sse4_2 ssse3 avx avx2 bmi1 bmi2 f16c fma abm movbe xsave\n"
+		// arm64 lists its features on a Features line.
+		arm64 = "processor\t: 0\nFeatures\t: fp asimd evtstrm aes pmull sha1 sha2 crc32\n"
+	)
+	tests := []struct {
+		name    string
+		cpuinfo string
+		level   int
+		wantErr string
+	}{
+		{"v1 CPU, v1 image", v1, 1, ""},
+		{"v1 CPU, v2 image", v1, 2, "only supports x86-64-v1, the image needs x86-64-v2 (missing: cx16 lahf_lm pni popcnt"},
+		{"v2 CPU without SSE3, v2 image", noSSE3, 2, "only supports x86-64-v1, the image needs x86-64-v2 (missing: pni)"},
+		{"v3 CPU, v2 image", v3, 2, ""},
+		{"v3 CPU, v4 image", v3, 4, "only supports x86-64-v3, the image needs x86-64-v4 (missing: avx512"},
+		{"arm64", arm64, 4, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkX86Level(strings.NewReader(tt.cpuinfo), tt.level)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkX86Level() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkX86Level() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseX86Level(t *testing.T) {
+	for s, want := range map[string]int{"v1": 1, "V3": 3, "x86-64-v2": 2, "x86_64-v4": 4} {
+		if got, err := ParseX86Level(s); err != nil || got != want {
+			t.Errorf("ParseX86Level(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "v5", "v0", "x86-64"} {
+		if _, err := ParseX86Level(s); err == nil {
+			t.Errorf("ParseX86Level(%q) succeeded", s)
+		}
+	}
+}
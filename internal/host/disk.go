@@ -0,0 +1,52 @@
+//go:build linux
+
+package host
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+// DiskFree returns the space available to unprivileged users on the
+// filesystem containing path, in bytes.
+func DiskFree(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, errors.Wrapf(err, "statfs %s", path)
+	}
+	return st.Bavail * uint64(st.Bsize), nil //nolint:gosec // block size is positive
+}
+
+// CheckFreeSpace fails if the filesystem holding dir has less than needed
+// bytes available, so a full directory is reported up front instead of as a
+// write error halfway through extraction.
+func CheckFreeSpace(dir string, needed uint64) error {
+	if needed == 0 {
+		return nil
+	}
+	free, err := DiskFree(dir)
+	if err != nil {
+		return err
+	}
+	if free < needed {
+		return errors.Newf("not enough space in %s: need %s free, have %s",
+			dir, FormatBytes(int64(needed)), FormatBytes(int64(free)))
+	}
+	return nil
+}
+
+// FormatBytes formats a byte count using binary units.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
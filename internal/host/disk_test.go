@@ -0,0 +1,18 @@
+//go:build linux
+
+package host
+
+import "testing"
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if err := CheckFreeSpace(dir, 0); err != nil {
+		t.Errorf("CheckFreeSpace(0) error: %v", err)
+	}
+	if err := CheckFreeSpace(dir, 1); err != nil {
+		t.Errorf("CheckFreeSpace(1) error: %v", err)
+	}
+	if err := CheckFreeSpace(dir, 1<<62); err == nil {
+		t.Error("CheckFreeSpace accepted 4 EiB")
+	}
+}
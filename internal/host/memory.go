@@ -0,0 +1,43 @@
+//go:build linux
+
+package host
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// MemAvailable returns the memory available for new allocations in bytes,
+// as estimated by the kernel in /proc/meminfo.
+func MemAvailable() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, errors.Wrap(err, "open /proc/meminfo")
+	}
+	defer f.Close()
+	return parseMemAvailable(f)
+}
+
+func parseMemAvailable(r io.Reader) (uint64, error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse MemAvailable %q", fields[1])
+		}
+		return kb << 10, nil
+	}
+	if err := sc.Err(); err != nil {
+		return 0, errors.Wrap(err, "read /proc/meminfo")
+	}
+	return 0, errors.New("MemAvailable not found in /proc/meminfo")
+}
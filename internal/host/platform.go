@@ -0,0 +1,42 @@
+//go:build linux
+
+package host
+
+import (
+	"runtime"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+// kernelArchs maps uname machine names of the supported 64-bit little-endian
+// kernels to Go architectures.
+//
+//nolint:gochecknoglobals
+var kernelArchs = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+}
+
+// CheckPlatform verifies that the running kernel is a supported 64-bit
+// little-endian architecture matching this build, so that an unsupported
+// rescue environment fails with a clear message instead of deep in PE
+// parsing or a system call.
+func CheckPlatform() error {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return errors.Wrap(err, "uname")
+	}
+	return checkMachine(unix.ByteSliceToString(uts.Machine[:]), runtime.GOARCH)
+}
+
+func checkMachine(machine, goarch string) error {
+	arch, ok := kernelArchs[machine]
+	switch {
+	case !ok:
+		return errors.Newf("unsupported platform: kernel architecture %s, Talos needs a 64-bit little-endian x86_64 or aarch64 kernel", machine)
+	case arch != goarch:
+		return errors.Newf("this %s build of boot-to-talos cannot run on a %s kernel, use the %s build", goarch, machine, arch)
+	}
+	return nil
+}
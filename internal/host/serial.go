@@ -0,0 +1,43 @@
+//go:build linux
+
+package host
+
+import "strings"
+
+// placeholderSerials are values firmware vendors leave in DMI serial fields
+// instead of a real serial number (compared lowercase).
+//
+//nolint:gochecknoglobals
+var placeholderSerials = []string{
+	"",
+	"0",
+	"none",
+	"default string",
+	"not specified",
+	"not applicable",
+	"system serial number",
+	"to be filled by o.e.m.",
+	"0123456789",
+	"123456789",
+}
+
+// SerialNumber returns the chassis serial number from DMI (product, then
+// board serial), or an empty string if only placeholders are reported.
+func SerialNumber() string {
+	for _, name := range []string{"product_serial", "board_serial", "chassis_serial"} {
+		if serial := readDMI(name); !isPlaceholderSerial(serial) {
+			return serial
+		}
+	}
+	return ""
+}
+
+func isPlaceholderSerial(serial string) bool {
+	lower := strings.ToLower(strings.TrimSpace(serial))
+	for _, p := range placeholderSerials {
+		if lower == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+//go:build linux
+
+package host
+
+import (
+	"os"
+	"strings"
+)
+
+// dmiPath is the sysfs directory with DMI identification strings.
+const dmiPath = "/sys/class/dmi/id"
+
+// Virtualization describes the hypervisor the host is running under.
+type Virtualization struct {
+	Hypervisor string // short hypervisor name (e.g. "qemu", "vmware"), empty on bare metal
+	Product    string // DMI product name as reported by firmware
+}
+
+// IsVM returns true if a hypervisor was detected.
+func (v Virtualization) IsVM() bool {
+	return v.Hypervisor != ""
+}
+
+// FlakyEFIVars returns true for hypervisors whose firmware is known to not
+// persist EFI variables reliably (QEMU/Proxmox with OVMF).
+func (v Virtualization) FlakyEFIVars() bool {
+	return v.Hypervisor == "qemu" || v.Hypervisor == "kvm"
+}
+
+func (v Virtualization) String() string {
+	if !v.IsVM() {
+		return "none (bare metal)"
+	}
+	if v.Product == "" {
+		return v.Hypervisor
+	}
+	return v.Hypervisor + " (" + v.Product + ")"
+}
+
+// dmiVendors maps substrings of DMI sys_vendor/product_name to hypervisor names,
+// in the same spirit as systemd-detect-virt.
+//
+//nolint:gochecknoglobals
+var dmiVendors = []struct {
+	match      string
+	hypervisor string
+}{
+	{"QEMU", "qemu"},
+	{"KVM", "kvm"},
+	{"VMware", "vmware"},
+	{"VirtualBox", "oracle"},
+	{"innotek GmbH", "oracle"},
+	{"Xen", "xen"},
+	{"Microsoft Corporation", "microsoft"},
+	{"Parallels", "parallels"},
+	{"Bochs", "bochs"},
+	{"Amazon EC2", "amazon"},
+	{"Google Compute Engine", "google"},
+}
+
+// DetectVirtualization detects whether the host runs under a hypervisor.
+// DMI strings are checked first; the CPUID hypervisor bit (exposed as the
+// "hypervisor" flag in /proc/cpuinfo) is used as a fallback.
+func DetectVirtualization() Virtualization {
+	vendor := readDMI("sys_vendor")
+	product := readDMI("product_name")
+
+	if hv := matchDMIVendor(vendor, product); hv != "" {
+		return Virtualization{Hypervisor: hv, Product: product}
+	}
+
+	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil && hasHypervisorFlag(string(data)) {
+		return Virtualization{Hypervisor: "unknown", Product: product}
+	}
+
+	return Virtualization{Product: product}
+}
+
+// matchDMIVendor returns the hypervisor name matching the DMI vendor or product.
+func matchDMIVendor(vendor, product string) string {
+	for _, v := range dmiVendors {
+		if strings.Contains(vendor, v.match) || strings.Contains(product, v.match) {
+			return v.hypervisor
+		}
+	}
+	return ""
+}
+
+// hasHypervisorFlag reports whether /proc/cpuinfo content has the hypervisor CPU flag.
+func hasHypervisorFlag(cpuinfo string) bool {
+	for line := range strings.SplitSeq(cpuinfo, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if key != "flags" && key != "Features" {
+			continue
+		}
+		for flag := range strings.FieldsSeq(value) {
+			if flag == "hypervisor" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func readDMI(name string) string {
+	data, err := os.ReadFile(dmiPath + "/" + name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
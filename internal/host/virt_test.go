@@ -0,0 +1,120 @@
+//go:build linux
+
+package host
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchDMIVendor(t *testing.T) {
+	tests := []struct {
+		name    string
+		vendor  string
+		product string
+		want    string
+	}{
+		{"proxmox q35", "QEMU", "Standard PC (Q35 + ICH9, 2009)", "qemu"},
+		{"vmware", "VMware, Inc.", "VMware Virtual Platform", "vmware"},
+		{"virtualbox", "innotek GmbH", "VirtualBox", "oracle"},
+		{"hyper-v", "Microsoft Corporation", "Virtual Machine", "microsoft"},
+		{"bare metal", "Supermicro", "SYS-1029P-WTR", ""},
+		{"empty", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchDMIVendor(tt.vendor, tt.product); got != tt.want {
+				t.Errorf("matchDMIVendor(%q, %q) = %q, want %q", tt.vendor, tt.product, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasHypervisorFlag(t *testing.T) {
+	vm := "processor\t: 0\nflags\t\t: fpu vme de pse hypervisor lahf_lm\n"
+	if !hasHypervisorFlag(vm) {
+		t.Error("expected hypervisor flag to be detected")
+	}
+
+	metal := "processor\t: 0\nflags\t\t: fpu vme de pse lahf_lm\n"
+	if hasHypervisorFlag(metal) {
+		t.Error("unexpected hypervisor flag on bare metal")
+	}
+}
+
+func TestVirtualizationFlakyEFIVars(t *testing.T) {
+	if !(Virtualization{Hypervisor: "qemu"}).FlakyEFIVars() {
+		t.Error("qemu should be reported as having flaky EFI variables")
+	}
+	if (Virtualization{Hypervisor: "vmware"}).FlakyEFIVars() {
+		t.Error("vmware should not be reported as having flaky EFI variables")
+	}
+	if (Virtualization{}).IsVM() {
+		t.Error("empty Virtualization should not be a VM")
+	}
+}
+
+func TestIsPlaceholderSerial(t *testing.T) {
+	tests := []struct {
+		serial string
+		want   bool
+	}{
+		{"To Be Filled By O.E.M.", true},
+		{"Default string", true},
+		{"  ", true},
+		{"CZ2345ABCD", false},
+		{"VMware-42 1a 2b 3c", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPlaceholderSerial(tt.serial); got != tt.want {
+			t.Errorf("isPlaceholderSerial(%q) = %v, want %v", tt.serial, got, tt.want)
+		}
+	}
+}
+
+func TestParseMemAvailable(t *testing.T) {
+	meminfo := `MemTotal:        8024080 kB
+MemFree:          512000 kB
+MemAvailable:    4194304 kB
+Buffers:          102400 kB
+`
+	got, err := parseMemAvailable(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatalf("parseMemAvailable error: %v", err)
+	}
+	if want := uint64(4 << 30); got != want {
+		t.Errorf("parseMemAvailable() = %d, want %d", got, want)
+	}
+
+	if _, err := parseMemAvailable(strings.NewReader("MemTotal: 1 kB\n")); err == nil {
+		t.Error("expected error when MemAvailable is missing")
+	}
+}
+
+func TestCheckMachine(t *testing.T) {
+	tests := []struct {
+		machine, goarch string
+		wantErr         string
+	}{
+		{"x86_64", "amd64", ""},
+		{"aarch64", "arm64", ""},
+		{"aarch64", "amd64", "use the arm64 build"},
+		{"i686", "amd64", "unsupported platform"},
+		{"armv7l", "arm64", "unsupported platform"},
+		{"s390x", "amd64", "unsupported platform"},
+	}
+	for _, tt := range tests {
+		err := checkMachine(tt.machine, tt.goarch)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkMachine(%s, %s) error: %v", tt.machine, tt.goarch, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("checkMachine(%s, %s) error = %v, want %q", tt.machine, tt.goarch, err, tt.wantErr)
+		}
+	}
+}
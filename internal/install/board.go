@@ -0,0 +1,31 @@
+//go:build linux
+
+package install
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ubootDir is where Talos installer images keep the u-boot builds of the
+// supported single-board computers, one directory per board.
+const ubootDir = "usr/install/arm64/u-boot"
+
+// checkBoardAssets warns when the installer rootfs at instDir does not fit
+// the board: a board was requested but the installer has no u-boot for it,
+// or the host does not boot via UEFI and the installer ships u-boot builds
+// while no board was requested.
+func checkBoardAssets(instDir, board string, uefi bool) {
+	dir := filepath.Join(instDir, ubootDir)
+	switch {
+	case board != "":
+		if _, err := os.Stat(filepath.Join(dir, board)); err != nil {
+			log.Printf("warning: installer has no u-boot build for board %s in /%s", board, ubootDir)
+		}
+	case !uefi:
+		if _, err := os.Stat(dir); err == nil {
+			log.Printf("warning: host does not boot via UEFI and the installer ships u-boot builds; use -board for single-board computers")
+		}
+	}
+}
@@ -0,0 +1,101 @@
+//go:build linux
+
+package install
+
+import (
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/network"
+)
+
+// defaultConfigTemplate is the minimal machine config piped to the
+// installer, which only uses it to pass validation.
+const defaultConfigTemplate = `version: v1alpha1
+machine:
+  type: {{ .MachineType }}
+  ca: {crt: {{ .CACert }}}
+  install: {disk: /dev/sda}
+cluster:
+  controlPlane: {endpoint: https://localhost:6443}
+`
+
+// ConfigContext holds the values a -config-template is rendered with.
+type ConfigContext struct {
+	Disk        string // target disk
+	Hostname    string // hostname from the ip= kernel argument, if any
+	IP          string // address from the ip= kernel argument, if any
+	MachineType string // MachineTypeWorker or MachineTypeControlPlane
+	CACert      string // placeholder CA certificate (base64)
+}
+
+// ParseConfigTemplate reads and parses a Go template for the config piped to
+// the installer.
+func ParseConfigTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read config template")
+	}
+	return parseConfigTemplate(path, string(data))
+}
+
+func parseConfigTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse config template")
+	}
+	return tmpl, nil
+}
+
+// installerConfig renders the config piped to the installer from tmpl, or
+// from the default template if tmpl is nil.
+func installerConfig(tmpl *template.Template, opts Options) (string, error) {
+	if tmpl == nil {
+		var err error
+		if tmpl, err = parseConfigTemplate("default", defaultConfigTemplate); err != nil {
+			return "", err
+		}
+	}
+	ip, hostname := ipArgFields(opts.ExtraArgs)
+	var b strings.Builder
+	err := tmpl.Execute(&b, ConfigContext{
+		Disk:        opts.Disk,
+		Hostname:    hostname,
+		IP:          ip,
+		MachineType: opts.MachineType,
+		CACert:      FakeCert(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "render config template")
+	}
+	return b.String(), nil
+}
+
+// ipArgFields returns the client address and hostname of the first ip=
+// kernel argument, the hostname of talos.hostname= taking precedence. IPv6
+// addresses are returned without brackets.
+func ipArgFields(args []string) (ip, hostname string) {
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "talos.hostname="); ok {
+			hostname = value
+		}
+	}
+	for _, arg := range args {
+		value, ok := strings.CutPrefix(arg, "ip=")
+		if !ok {
+			continue
+		}
+		fields := network.SplitIPArg(value)
+		if len(fields) < 5 {
+			return "", hostname
+		}
+		if hostname == "" {
+			hostname = fields[4]
+		}
+		return strings.Trim(fields[0], "[]"), hostname
+	}
+	return "", hostname
+}
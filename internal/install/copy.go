@@ -0,0 +1,172 @@
+//go:build linux
+
+package install
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/host"
+	"github.com/cozystack/boot-to-talos/internal/status"
+)
+
+// progressInterval is how often disk copy progress is reported.
+const progressInterval = 5 * time.Second
+
+// readOnlyHint explains the errors a write-protected target disk fails with.
+const readOnlyHint = "target is read-only — check the SD card write-protect switch / device permissions"
+
+// isReadOnly reports whether err means the target disk refuses writes: a
+// write-protected device or missing permissions.
+func isReadOnly(err error) bool {
+	return errors.Is(err, unix.EROFS) || errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM)
+}
+
+// mustWrite is cli.Must for opening and writing target disks, with a
+// targeted hint for read-only targets.
+func mustWrite(msg string, err error) {
+	if isReadOnly(err) {
+		cli.Fatalf(cli.ExitTarget, "%s: %v: %s", msg, err, readOnlyHint)
+	}
+	cli.Must(msg, cli.WithExitCode(cli.ExitTarget, err))
+}
+
+// copyToDisks copies src to the already opened dsts, syncing after every
+// write and periodically logging progress. size is the number of bytes
+// expected from src, or -1 if unknown. It returns the number of bytes written
+// to each dst and their sha256.
+func copyToDisks(dsts []*os.File, src io.Reader, size int64) (int64, string) {
+	var (
+		written    int64
+		start      = time.Now()
+		lastReport = start
+		hash       = sha256.New()
+	)
+	buf := make([]byte, 4<<20)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			for _, dst := range dsts {
+				_, werr := dst.Write(buf[:n])
+				mustWrite("write "+dst.Name(), werr)
+				_ = dst.Sync()
+			}
+			hash.Write(buf[:n])
+			written += int64(n)
+			status.Progress(written, size)
+			if time.Since(lastReport) >= progressInterval {
+				log.Printf("writing %s", progressString(written, size, time.Since(start)))
+				lastReport = time.Now()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		cli.Must("read", err)
+	}
+	elapsed := time.Since(start)
+	log.Printf("wrote %s in %s (%s)", host.FormatBytes(written), elapsed.Round(time.Second), formatRate(written, elapsed))
+	return written, hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeImage writes the image read from src to every target disk at once.
+// size is the image size, or -1 if unknown. With more than one target each
+// disk is read back and checked against the written data, then the mirrors
+// get GPT GUIDs of their own.
+func writeImage(src io.Reader, size int64, targets []string) {
+	outs := make([]*os.File, 0, len(targets))
+	defer func() {
+		for _, out := range outs {
+			out.Close()
+		}
+	}()
+	for _, target := range targets {
+		out, err := os.OpenFile(target, os.O_WRONLY, 0)
+		mustWrite("open disk "+target, err)
+		outs = append(outs, out)
+		checkImageFits(out, target, size)
+	}
+
+	status.SetPhase(status.PhaseCopying, strings.Join(targets, ", "))
+	written, digest := copyToDisks(outs, src, size)
+	if len(targets) < 2 {
+		return
+	}
+	for _, target := range targets {
+		log.Printf("verifying %s", target)
+		cli.Must("verify "+target, cli.WithExitCode(cli.ExitTarget, verifyDisk(target, written, digest)))
+	}
+	log.Printf("image verified on %d disks (sha256 %s)", len(targets), digest)
+	for _, mirror := range targets[1:] {
+		cli.Must("regenerate GUIDs on "+mirror, cli.WithExitCode(cli.ExitTarget, regenerateGUIDs(mirror)))
+	}
+}
+
+// verifyDisk reads the first n bytes of disk, bypassing cached pages, and
+// compares their sha256 with digest.
+func verifyDisk(disk string, n int64, digest string) error {
+	f, err := os.Open(disk)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", disk)
+	}
+	defer f.Close()
+	// Drop the pages cached while writing so the data comes from the disk.
+	_ = unix.Fadvise(int(f.Fd()), 0, n, unix.FADV_DONTNEED)
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, n)); err != nil {
+		return errors.Wrapf(err, "read %s", disk)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != digest {
+		return errors.Newf("%s does not contain the written image: sha256 %s, want %s", disk, got, digest)
+	}
+	return nil
+}
+
+// checkImageFits fails if an image of the given size does not fit on the
+// opened block device. Unknown sizes (-1) are not checked.
+func checkImageFits(dst *os.File, disk string, size int64) {
+	if size < 0 {
+		return
+	}
+	diskSize, err := dst.Seek(0, io.SeekEnd)
+	cli.Must("get disk size", err)
+	_, err = dst.Seek(0, io.SeekStart)
+	cli.Must("seek disk", err)
+	if diskSize > 0 && size > diskSize {
+		cli.Fatalf(cli.ExitTarget, "image (%s) does not fit on %s (%s)", host.FormatBytes(size), disk, host.FormatBytes(diskSize))
+	}
+}
+
+// progressString describes copy progress after elapsed: bytes written and
+// throughput, plus percentage and ETA when size is known.
+func progressString(written, size int64, elapsed time.Duration) string {
+	rate := formatRate(written, elapsed)
+	if size <= 0 {
+		return fmt.Sprintf("%s, %s", host.FormatBytes(written), rate)
+	}
+	progress := fmt.Sprintf("%s of %s (%d%%), %s", host.FormatBytes(written), host.FormatBytes(size), written*100/size, rate)
+	if written > 0 && written < size {
+		eta := time.Duration(float64(elapsed) * float64(size-written) / float64(written))
+		progress += ", ETA " + eta.Round(time.Second).String()
+	}
+	return progress
+}
+
+// formatRate formats the throughput of n bytes copied in elapsed.
+func formatRate(n int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "- B/s"
+	}
+	return host.FormatBytes(int64(float64(n)/elapsed.Seconds())) + "/s"
+}
@@ -0,0 +1,143 @@
+//go:build linux
+
+package install
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"golang.org/x/sys/unix"
+
+	"github.com/cozystack/boot-to-talos/internal/testutil"
+)
+
+func TestWriteImage_Mirrors(t *testing.T) {
+	dir := t.TempDir()
+	image := bytes.Repeat([]byte("talos"), 10000)
+	targets := []string{filepath.Join(dir, "disk0"), filepath.Join(dir, "disk1")}
+	for _, target := range targets {
+		if err := os.WriteFile(target, make([]byte, 1<<20), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeImage(bytes.NewReader(image), int64(len(image)), targets)
+
+	for _, target := range targets {
+		data, err := os.ReadFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[:len(image)], image) {
+			t.Errorf("%s does not start with the image", target)
+		}
+	}
+}
+
+func TestRegenerateGUIDs(t *testing.T) {
+	dir := t.TempDir()
+	primary, mirror := filepath.Join(dir, "disk0"), filepath.Join(dir, "disk1")
+	if err := testutil.CreateTestRAWImage(primary, 16, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mirror, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := regenerateGUIDs(mirror); err != nil {
+		t.Fatalf("regenerateGUIDs error: %v", err)
+	}
+
+	readGPT := func(path string) *gpt.Table {
+		t.Helper()
+		d, err := diskfs.Open(path, diskfs.WithOpenMode(diskfs.ReadOnly))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		table, err := d.GetPartitionTable()
+		if err != nil {
+			t.Fatalf("read GPT of %s: %v", path, err)
+		}
+		return table.(*gpt.Table)
+	}
+	want, got := readGPT(primary), readGPT(mirror)
+	if got.GUID == want.GUID {
+		t.Errorf("mirror kept disk GUID %s", got.GUID)
+	}
+	if len(got.Partitions) != len(want.Partitions) {
+		t.Fatalf("mirror has %d partitions, want %d", len(got.Partitions), len(want.Partitions))
+	}
+	for i, p := range got.Partitions {
+		if p.GUID == want.Partitions[i].GUID {
+			t.Errorf("partition %d kept GUID %s", i+1, p.GUID)
+		}
+		if p.Name != want.Partitions[i].Name || p.Start != want.Partitions[i].Start || p.End != want.Partitions[i].End {
+			t.Errorf("partition %d = %q %d-%d, want %q %d-%d", i+1, p.Name, p.Start, p.End,
+				want.Partitions[i].Name, want.Partitions[i].Start, want.Partitions[i].End)
+		}
+	}
+}
+
+func TestVerifyDisk(t *testing.T) {
+	disk := filepath.Join(t.TempDir(), "disk")
+	data := []byte("0123456789abcdef")
+	if err := os.WriteFile(disk, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data[:8])
+	digest := hex.EncodeToString(sum[:])
+
+	if err := verifyDisk(disk, 8, digest); err != nil {
+		t.Errorf("verifyDisk error: %v", err)
+	}
+	if err := verifyDisk(disk, 12, digest); err == nil {
+		t.Error("verifyDisk should fail for data that differs from the image")
+	}
+}
+
+func TestProgressString(t *testing.T) {
+	tests := []struct {
+		name    string
+		written int64
+		size    int64
+		elapsed time.Duration
+		want    string
+	}{
+		{"known size", 1 << 30, 4 << 30, 10 * time.Second, "1.0 GiB of 4.0 GiB (25%), 102.4 MiB/s, ETA 30s"},
+		{"done", 4 << 30, 4 << 30, 40 * time.Second, "4.0 GiB of 4.0 GiB (100%), 102.4 MiB/s"},
+		{"unknown size", 512 << 20, -1, 5 * time.Second, "512.0 MiB, 102.4 MiB/s"},
+		{"no time elapsed", 0, -1, 0, "0 B, - B/s"},
+	}
+	for _, tt := range tests {
+		if got := progressString(tt.written, tt.size, tt.elapsed); got != tt.want {
+			t.Errorf("%s: progressString() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	for _, errno := range []unix.Errno{unix.EROFS, unix.EACCES, unix.EPERM} {
+		err := &os.PathError{Op: "open", Path: "/dev/mmcblk0", Err: errno}
+		if !isReadOnly(err) {
+			t.Errorf("isReadOnly(%v) = false", err)
+		}
+	}
+	if isReadOnly(&os.PathError{Op: "write", Path: "/dev/sda", Err: unix.EIO}) {
+		t.Error("isReadOnly(EIO) = true")
+	}
+	if isReadOnly(nil) {
+		t.Error("isReadOnly(nil) = true")
+	}
+}
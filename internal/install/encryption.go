@@ -0,0 +1,107 @@
+//go:build linux
+
+package install
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/boot-to-talos/internal/cmdline"
+)
+
+// encryptionSince is the first Talos version (major, minor) supporting each
+// key provider of machine.systemDiskEncryption.
+//
+//nolint:gochecknoglobals
+var encryptionSince = map[string][2]int{
+	"nodeID": {1, 0},
+	"static": {1, 0},
+	"kms":    {1, 5},
+	"tpm":    {1, 5},
+}
+
+// DiskEncryption maps the volumes in machine.systemDiskEncryption of the
+// installer config (state, ephemeral) to their key providers.
+type DiskEncryption map[string][]string
+
+// parseDiskEncryption reads machine.systemDiskEncryption from the YAML config,
+// which may consist of several documents.
+func parseDiskEncryption(config string) (DiskEncryption, error) {
+	enc := DiskEncryption{}
+	dec := yaml.NewDecoder(strings.NewReader(config))
+	for {
+		var doc struct {
+			Machine struct {
+				SystemDiskEncryption map[string]struct {
+					Keys []map[string]any `yaml:"keys"`
+				} `yaml:"systemDiskEncryption"`
+			} `yaml:"machine"`
+		}
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return enc, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "parse config")
+		}
+		for volume, spec := range doc.Machine.SystemDiskEncryption {
+			providers := enc[volume]
+			for _, key := range spec.Keys {
+				for _, p := range slices.Sorted(maps.Keys(encryptionSince)) {
+					if _, ok := key[p]; ok && !slices.Contains(providers, p) {
+						providers = append(providers, p)
+					}
+				}
+			}
+			enc[volume] = providers
+		}
+	}
+}
+
+// check reports key providers the Talos version of image does not support.
+// Images without a recognizable version are not checked.
+func (e DiskEncryption) check(image string) error {
+	major, minor, ok := cmdline.TalosVersion(image)
+	for _, volume := range e.volumes() {
+		providers := e[volume]
+		if len(providers) == 0 {
+			return errors.Newf("systemDiskEncryption.%s has no key provider (nodeID, static, kms or tpm)", volume)
+		}
+		if !ok {
+			continue
+		}
+		for _, p := range providers {
+			since := encryptionSince[p]
+			if major < since[0] || major == since[0] && minor < since[1] {
+				return errors.Newf("systemDiskEncryption.%s: the %s key provider requires Talos v%d.%d or newer, image is v%d.%d",
+					volume, p, since[0], since[1], major, minor)
+			}
+		}
+	}
+	return nil
+}
+
+// volumes returns the encrypted volumes in sorted order.
+func (e DiskEncryption) volumes() []string {
+	volumes := make([]string, 0, len(e))
+	for v := range e {
+		volumes = append(volumes, v)
+	}
+	slices.Sort(volumes)
+	return volumes
+}
+
+// String describes the encryption for the summary, e.g.
+// "ephemeral (static), state (tpm)".
+func (e DiskEncryption) String() string {
+	parts := make([]string, 0, len(e))
+	for _, v := range e.volumes() {
+		parts = append(parts, v+" ("+strings.Join(e[v], ", ")+")")
+	}
+	return strings.Join(parts, ", ")
+}
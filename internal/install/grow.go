@@ -0,0 +1,103 @@
+//go:build linux
+
+package install
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+)
+
+// growAlignment is the alignment of the end of the grown partition.
+const growAlignment = 1 << 20
+
+// growImage grows the image written to disk if requested. The image is
+// already bootable at this point, so failures are only reported.
+func growImage(disk string, opts Options) {
+	if !opts.GrowImage {
+		return
+	}
+	if err := GrowDisk(disk, opts.GrowSize); err != nil {
+		log.Printf("warning: failed to grow partition table on %s: %v", disk, err)
+	}
+}
+
+// GrowDisk moves the backup GPT of the image written to disk to the end of
+// the first size bytes of the disk (the whole disk if size is 0) and grows
+// the last partition into the freed space. Only the partition table is
+// changed; the filesystem inside the partition keeps its size.
+func GrowDisk(disk string, size int64) error {
+	f, err := os.OpenFile(disk, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", disk)
+	}
+	defer f.Close()
+
+	diskSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "get size of %s", disk)
+	}
+	if size == 0 {
+		size = diskSize
+	}
+	if size > diskSize {
+		return errors.Newf("requested size %d exceeds size of %s (%d bytes)", size, disk, diskSize)
+	}
+
+	sectorSize, err := blockdev.LogicalBlockSize(disk)
+	if err != nil {
+		log.Printf("warning: cannot get logical block size of %s, assuming 512: %v", disk, err)
+		sectorSize = 512
+	}
+
+	if err := growGPT(f, sectorSize, size); err != nil {
+		return err
+	}
+	return errors.Wrapf(f.Sync(), "sync %s", disk)
+}
+
+// growGPT rewrites the GPT on f for a device of size bytes, extending the
+// partition that ends last up to the new last usable sector.
+func growGPT(f *os.File, sectorSize int, size int64) error {
+	table, err := gpt.Read(f, sectorSize, sectorSize)
+	if err != nil {
+		return errors.Wrap(err, "read GPT")
+	}
+
+	var last *gpt.Partition
+	for _, p := range table.Partitions {
+		if last == nil || p.End > last.End {
+			last = p
+		}
+	}
+	if last == nil {
+		return errors.New("GPT has no partitions")
+	}
+
+	size -= size % int64(sectorSize)
+	if uint64(size) <= table.TotalSize() {
+		log.Printf("partition table already spans %d bytes, not growing", table.TotalSize())
+		return nil
+	}
+	oldEnd := last.End
+	table.Resize(uint64(size))
+
+	alignSectors := uint64(growAlignment / sectorSize)
+	end := (table.LastDataSector()+1)/alignSectors*alignSectors - 1
+	if end < oldEnd {
+		end = oldEnd
+	}
+	last.End = end
+	last.Size = (last.End - last.Start + 1) * uint64(sectorSize)
+
+	if err := table.Write(f, size); err != nil {
+		return errors.Wrap(err, "write GPT")
+	}
+	log.Printf("grew partition %q from %d to %d sectors", last.Name, oldEnd-last.Start+1, last.End-last.Start+1)
+	return nil
+}
@@ -0,0 +1,101 @@
+//go:build linux
+
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/efi"
+)
+
+// Post-install hook failure handling.
+const (
+	HookOnErrorAbort  = "abort"  // leave the host running without rebooting
+	HookOnErrorReboot = "reboot" // log the failure and reboot anyway
+)
+
+// postInstallHook is the user script run after the image is written and the
+// EFI variables are updated, right before the reboot.
+type postInstallHook struct {
+	path    string
+	onError string
+	targets []string
+	espDir  string // mount point for the ESP of the first target, "" if it has none we can mount
+}
+
+// newPostInstallHook prepares the hook of opts, nil if there is none. The ESP
+// mount point is created in tmpDir now: by the time the hook runs, all
+// filesystems are read-only.
+func newPostInstallHook(opts Options, tmpDir string) *postInstallHook {
+	if opts.PostInstallHook == "" {
+		return nil
+	}
+	hook := &postInstallHook{path: opts.PostInstallHook, onError: opts.PostInstallHookOnError, targets: opts.targets()}
+	hook.espDir = filepath.Join(tmpDir, "esp")
+	if err := os.Mkdir(hook.espDir, 0o700); err != nil {
+		log.Printf("warning: cannot create ESP mount point for the post-install hook: %v", err)
+		hook.espDir = ""
+	}
+	return hook
+}
+
+// env returns the environment of the hook: the host environment plus the
+// install targets and the ESP mount point.
+func (h *postInstallHook) env(esp string) []string {
+	return append(os.Environ(),
+		"BOOT_TO_TALOS_DISK="+h.targets[0],
+		"BOOT_TO_TALOS_DISKS="+strings.Join(h.targets, " "),
+		"BOOT_TO_TALOS_ESP="+esp,
+	)
+}
+
+// exec runs the hook with the ESP of the first target mounted and logs its
+// output.
+func (h *postInstallHook) exec() error {
+	var esp string
+	if h.espDir != "" {
+		unmount, err := efi.MountESP(h.targets[0], h.espDir)
+		if err != nil {
+			log.Printf("warning: cannot mount the ESP for the post-install hook: %v", err)
+		} else {
+			defer unmount()
+			esp = h.espDir
+		}
+	}
+
+	log.Printf("running post-install hook %s", h.path)
+	cmd := exec.Command(h.path)
+	cmd.Env = h.env(esp)
+	out, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		log.Printf("post-install hook: %s", scanner.Text())
+	}
+	return errors.Wrap(err, "post-install hook")
+}
+
+// run runs the hook, if any, and stops before the reboot if it fails and
+// the failure policy says so.
+func (h *postInstallHook) run() {
+	if h == nil {
+		return
+	}
+	err := h.exec()
+	if err == nil {
+		return
+	}
+	if h.onError == HookOnErrorReboot {
+		log.Printf("warning: %v, rebooting anyway", err)
+		return
+	}
+	cli.Fatalf(cli.ExitReboot, "%v; not rebooting, the image is already written", err)
+}
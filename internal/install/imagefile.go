@@ -0,0 +1,190 @@
+//go:build linux
+
+package install
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/host"
+	"github.com/cozystack/boot-to-talos/internal/status"
+)
+
+// Compression formats of image file targets (-output-compress).
+const (
+	CompressXZ   = "xz"
+	CompressZstd = "zst"
+	CompressGzip = "gz"
+)
+
+// OutputCompressions lists the valid -output-compress values.
+//
+//nolint:gochecknoglobals
+var OutputCompressions = []string{CompressXZ, CompressZstd, CompressGzip}
+
+// imageFileExts are the extensions a new image file target needs, so that a
+// mistyped disk name such as "sda" is not taken for a file to create.
+//
+//nolint:gochecknoglobals
+var imageFileExts = []string{".raw", ".img"}
+
+// IsImageFile reports whether the install target names a regular file, or a
+// new .raw or .img file (optionally with a compression extension) in an
+// existing directory outside /dev, rather than a disk: install mode then
+// builds a disk image file and leaves the host alone.
+func IsImageFile(target string) bool {
+	fi, err := os.Stat(target)
+	if err == nil {
+		return fi.Mode().IsRegular()
+	}
+	abs, aerr := filepath.Abs(target)
+	if !errors.Is(err, os.ErrNotExist) || aerr != nil || strings.HasPrefix(abs, "/dev/") || !hasImageFileExt(abs) {
+		return false
+	}
+	dir, err := os.Stat(filepath.Dir(abs))
+	return err == nil && dir.IsDir()
+}
+
+// hasImageFileExt reports whether path ends in an image file extension,
+// followed by at most one -output-compress extension.
+func hasImageFileExt(path string) bool {
+	for _, c := range OutputCompressions {
+		path = strings.TrimSuffix(path, "."+c)
+	}
+	ext := filepath.Ext(path)
+	for _, e := range imageFileExts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// createImageFile creates the image file target, or empties it, so that the
+// image can be written to it like to a disk.
+func createImageFile(path string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	mustWrite("create image file "+path, err)
+	f.Close()
+}
+
+// uncompressedPath returns the temporary file the image for the compressed
+// image file at path is built in: next to it, so that it is on the same
+// filesystem, and hidden.
+func uncompressedPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".uncompressed")
+}
+
+// newCompressor returns a writer that compresses to w in format.
+func newCompressor(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case CompressXZ:
+		return xz.NewWriter(w)
+	case CompressZstd:
+		return zstd.NewWriter(w)
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, errors.Newf("unknown compression %q (supported: %s)", format, strings.Join(OutputCompressions, ", "))
+	}
+}
+
+// compressImage streams the finished image at src through the encoder of
+// format into dst, then removes src.
+func compressImage(src, dst, format string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", src)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "create %s", dst)
+	}
+	defer out.Close()
+
+	log.Printf("compressing the image into %s (%s)", dst, format)
+	enc, err := newCompressor(out, format)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(enc, in)
+	if err != nil {
+		return errors.Wrapf(err, "compress %s", src)
+	}
+	if err := enc.Close(); err != nil {
+		return errors.Wrapf(err, "compress %s", src)
+	}
+	if err := out.Sync(); err != nil {
+		return errors.Wrapf(err, "sync %s", dst)
+	}
+	if fi, err := out.Stat(); err == nil {
+		log.Printf("compressed %s to %s", host.FormatBytes(n), host.FormatBytes(fi.Size()))
+	}
+	return errors.Wrapf(os.Remove(src), "remove %s", src)
+}
+
+// checkImageFile fails for options that need a disk of this host when the
+// image goes to an image file, and for -output-compress without one.
+func checkImageFile(opts Options) error {
+	if !opts.imageFile() {
+		if opts.OutputCompress != "" {
+			return errors.Newf("-output-compress needs an image file as -disk, %s is not a regular file", opts.Disk)
+		}
+		return nil
+	}
+	switch {
+	case len(opts.Mirrors) > 0:
+		return errors.New("an image file cannot be mirrored to further disks")
+	case opts.GrowImage && opts.GrowSize == 0:
+		return errors.New("-grow-image on an image file needs -grow-size-gib")
+	}
+	return nil
+}
+
+// extendImageFile makes room in the image file for the partition table
+// changes after writing: up to GrowSize for -grow-image and the seed
+// partition for a nocloud seed. Disks have their size already.
+func extendImageFile(opts Options) {
+	if !opts.imageFile() {
+		return
+	}
+	path := opts.targets()[0]
+	fi, err := os.Stat(path)
+	mustWrite("stat "+path, err)
+	size := fi.Size()
+	if opts.GrowImage {
+		size = max(size, opts.GrowSize)
+	}
+	if opts.NocloudSeed != nil {
+		// Alignment of the seed partition and the backup GPT.
+		size += nocloudPartitionSize + 2*growAlignment
+	}
+	if size > fi.Size() {
+		mustWrite("extend "+path, os.Truncate(path, size))
+	}
+}
+
+// finish ends the install: it reboots into Talos, or, for an image file,
+// compresses it if requested and leaves the host as it is.
+func finish(opts Options) {
+	if !opts.imageFile() {
+		reboot(opts.RebootMethod)
+		return
+	}
+	if opts.OutputCompress != "" {
+		cli.Must("compress image", cli.WithExitCode(cli.ExitTarget, compressImage(opts.targets()[0], opts.Disk, opts.OutputCompress)))
+	}
+	status.SetPhase(status.PhaseDone, opts.Disk)
+	status.Stop()
+	log.Printf("disk image written to %s", opts.Disk)
+}
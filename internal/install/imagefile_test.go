@@ -0,0 +1,118 @@
+//go:build linux
+
+package install
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestIsImageFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "talos.raw")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{existing, true},
+		{filepath.Join(dir, "new.raw.xz"), true},
+		{dir, false},
+		{"/dev/null", false},
+		{"/dev/sdzz", false},
+		{filepath.Join(dir, "missing", "talos.raw"), false},
+		{filepath.Join(dir, "new.img"), true},
+		{filepath.Join(dir, "new.raw.zst"), true},
+		{filepath.Join(dir, "sda"), false},
+		{filepath.Join(dir, "new.xz"), false},
+		{"sda", false},
+	}
+	for _, tt := range tests {
+		if got := IsImageFile(tt.target); got != tt.want {
+			t.Errorf("IsImageFile(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestCheckImageFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "talos.raw")
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"disk", Options{Disk: "/dev/null"}, false},
+		{"compressed disk", Options{Disk: "/dev/null", OutputCompress: CompressXZ}, true},
+		{"file", Options{Disk: file, OutputCompress: CompressZstd}, false},
+		{"mirrored file", Options{Disk: file, Mirrors: []string{"/dev/null"}}, true},
+		{"grown file", Options{Disk: file, GrowImage: true, GrowSize: 8 << 30}, false},
+		{"grown to the end of a file", Options{Disk: file, GrowImage: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkImageFile(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("checkImageFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompressImage(t *testing.T) {
+	image := bytes.Repeat([]byte("talos disk image "), 100000)
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		CompressXZ: func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) },
+		CompressZstd: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			return d, err
+		},
+		CompressGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}
+	for _, format := range OutputCompressions {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			dst := filepath.Join(dir, "talos.raw."+format)
+			src := uncompressedPath(dst)
+			if err := os.WriteFile(src, image, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := compressImage(src, dst, format); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("uncompressed image %s was not removed: %v", src, err)
+			}
+			f, err := os.Open(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			r, err := decoders[format](f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, image) {
+				t.Errorf("decompressed %d bytes, want the %d-byte image", len(got), len(image))
+			}
+		})
+	}
+
+	if _, err := newCompressor(io.Discard, "bz2"); err == nil {
+		t.Error("newCompressor accepted bz2")
+	}
+}
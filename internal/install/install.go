@@ -3,26 +3,143 @@
 package install
 
 import (
-	"archive/tar"
+	"bufio"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
-	"unsafe"
+	"text/template"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"golang.org/x/sys/unix"
 
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
 	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/cmdline"
 	"github.com/cozystack/boot-to-talos/internal/efi"
+	"github.com/cozystack/boot-to-talos/internal/host"
+	"github.com/cozystack/boot-to-talos/internal/network"
+	"github.com/cozystack/boot-to-talos/internal/status"
 	"github.com/cozystack/boot-to-talos/internal/types"
 )
 
+// Machine types accepted by the installer config.
+const (
+	MachineTypeWorker       = "worker"
+	MachineTypeControlPlane = "controlplane"
+)
+
+// EFI boot entry handling modes.
+const (
+	EFIVarsAuto   = "auto"   // update unless the hypervisor is known to lose EFI variables
+	EFIVarsUpdate = "update" // always create the Talos boot entry
+	EFIVarsSkip   = "skip"   // never touch EFI variables, rely on the removable-media path
+)
+
+// Options configures install mode.
+type Options struct {
+	Disk      string   // target disk (will be wiped)
+	Mirrors   []string // further disks that receive the same image
+	ExtraArgs []string // extra kernel arguments
+	SizeGiB   uint64   // size of the intermediate image.raw in GiB
+	EFIVars   string   // EFI boot entry handling (EFIVarsAuto, EFIVarsUpdate or EFIVarsSkip)
+
+	// EFIBackup is the file BootOrder and the Boot#### variables are saved
+	// to before the Talos boot entry is created, "" for no backup. It must
+	// not be on the target disk.
+	EFIBackup string
+
+	// EFIFallback copies the installed boot loader to the removable-media
+	// path (\EFI\BOOT\BOOTX64.EFI) on the ESP of every target, for firmware
+	// that does not keep EFI variables.
+	EFIFallback bool
+
+	// EFIDemote moves the boot entries of the removable disk the running
+	// system booted from to the end of BootOrder, so that the machine does
+	// not boot the installer again if the stick stays plugged in.
+	EFIDemote bool
+
+	// GrowImage extends the partition table written to Disk and its last
+	// partition to GrowSize bytes, or to the whole disk if GrowSize is 0.
+	GrowImage bool
+	GrowSize  int64
+
+	// LoopMaxPart is the max_part the loop module is loaded with if
+	// /dev/loop-control is missing, 0 for the module default.
+	LoopMaxPart int
+
+	// TmpfsSize controls the tmpfs the install assets are unpacked into:
+	// TmpfsAuto, TmpfsOff or a tmpfs size= option.
+	TmpfsSize string
+
+	// MachineType is the machine.type of the config piped to the installer
+	// (MachineTypeWorker or MachineTypeControlPlane).
+	MachineType string
+
+	// ConfigTemplate renders the machine config piped to the installer, see
+	// ConfigContext. nil uses a minimal config that only passes validation.
+	ConfigTemplate *template.Template
+
+	// CacheDir is the directory HTTP downloads are cached in, "" for none.
+	// It must not be on a target.
+	CacheDir string
+
+	// Overlay is a directory copied over the installer rootfs before the
+	// installer runs, for extra binaries or modules it needs. "" for none.
+	Overlay string
+
+	// X86Level is the x86-64 microarchitecture level of the image, checked
+	// against the host CPU for the summary. 0 skips the check.
+	X86Level int
+
+	// Board is the single-board computer to install for (installer
+	// --board). Board installs boot via u-boot, EFI handling is skipped.
+	Board string
+
+	// Platform is the Talos platform to install for (installer --platform
+	// and talos.platform=), cmdline.PlatformMetal if empty.
+	Platform string
+
+	// Talos lists the Talos kernel arguments included in ExtraArgs, for the
+	// summary.
+	Talos cmdline.TalosOptions
+
+	// NocloudSeed is written to a partition labelled NocloudLabel after the
+	// last partition of the image, for the Talos nocloud platform. nil
+	// writes none.
+	NocloudSeed NocloudSeed
+
+	// PostInstallHook is a script run after the image is written and the
+	// EFI variables are updated, right before the reboot. It gets the
+	// targets and the mounted ESP in BOOT_TO_TALOS_* environment variables.
+	PostInstallHook string
+
+	// PostInstallHookOnError is HookOnErrorAbort or HookOnErrorReboot.
+	PostInstallHookOnError string
+
+	// RebootMethod is one of RebootMethods; "" means RebootAuto.
+	RebootMethod string
+
+	// Plan extends the summary with the source type, Secure Boot state and
+	// network topology and folds the warnings that otherwise prompt on their
+	// own into the single final confirmation.
+	Plan bool
+
+	// OutputCompress is the format the finished image is compressed into
+	// Disk with when Disk is an image file (see IsImageFile): CompressXZ,
+	// CompressZstd or CompressGzip. "" leaves the image uncompressed.
+	OutputCompress string
+
+	// building is the file a compressed image file is built in, see
+	// uncompressedPath.
+	building string
+}
+
 // MountBind performs a bind mount.
 func MountBind(src, dst string) {
 	_ = os.MkdirAll(dst, 0o755)
@@ -43,28 +160,35 @@ func OverrideCmdline(root, content string) {
 	cli.Must("bind cmdline", unix.Mount(tmp, filepath.Join(root, "proc/cmdline"), "", unix.MS_BIND, ""))
 }
 
-// CopyWithFsync copies a file from src to dst with fsync after each write.
-func CopyWithFsync(src, dst string) {
-	log.Printf("copy %s → %s", src, dst)
-	in, err := os.Open(src)
-	cli.Must("open src", err)
-	defer in.Close()
-	out, err := os.OpenFile(dst, os.O_WRONLY, 0)
-	cli.Must("open dst", err)
-	defer out.Close()
-	buf := make([]byte, 4<<20)
-	for {
-		n, err := in.Read(buf)
-		if n > 0 {
-			_, werr := out.Write(buf[:n])
-			cli.Must("write", werr)
-			_ = out.Sync()
-		}
-		if err == io.EOF {
-			break
-		}
-		cli.Must("read", err)
+// diskName describes disk for the summary by its stable /dev/disk/by-id name
+// if it has one.
+func diskName(disk string) string {
+	if stable := blockdev.StableName(disk); stable != "" {
+		return stable + " (" + disk + ")"
 	}
+	return disk
+}
+
+// targets returns the disks the image is written to, Disk first.
+func (o Options) targets() []string {
+	if o.building != "" {
+		return []string{o.building}
+	}
+	return append([]string{o.Disk}, o.Mirrors...)
+}
+
+// imageFile reports whether the image is written to a file instead of a
+// disk of this host.
+func (o Options) imageFile() bool {
+	return IsImageFile(o.Disk)
+}
+
+// platform returns the Talos platform to install for.
+func (o Options) platform() string {
+	if o.Platform == "" {
+		return cmdline.PlatformMetal
+	}
+	return o.Platform
 }
 
 // FakeCert generates a fake certificate for installer.
@@ -74,59 +198,163 @@ func FakeCert() string {
 	return base64.StdEncoding.EncodeToString(r)
 }
 
-// SetupLoop sets up a loop device for the given file path.
-// Returns the loop device path and the file handle.
-func SetupLoop(path string) (string, *os.File) {
-	ctrl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
-	cli.Must("open loop-control", err)
-	num, _, errno := unix.Syscall(unix.SYS_IOCTL, ctrl.Fd(), unix.LOOP_CTL_GET_FREE, 0)
-	if errno != 0 {
-		log.Fatalf("LOOP_CTL_GET_FREE: %v", errno)
-	}
-	loop := fmt.Sprintf("/dev/loop%d", num)
-	lf, err := os.OpenFile(loop, os.O_RDWR, 0)
-	cli.Must("open loop", err)
-	bf, err := os.OpenFile(path, os.O_RDWR, 0)
-	cli.Must("open backing", err)
-	_, _, errno = unix.Syscall(unix.SYS_IOCTL, lf.Fd(), unix.LOOP_SET_FD, bf.Fd())
-	if errno != 0 {
-		log.Fatalf("LOOP_SET_FD: %v", errno)
-	}
-	var info unix.LoopInfo64
-	info.Flags = unix.LO_FLAGS_AUTOCLEAR
-	_, _, errno = unix.Syscall(unix.SYS_IOCTL, lf.Fd(), unix.LOOP_SET_STATUS64, uintptr(unsafe.Pointer(&info)))
-	if errno != 0 {
-		log.Fatalf("LOOP_SET_STATUS64: %v", errno)
-	}
-	return loop, lf
+// shouldUpdateEFIVars decides whether the Talos EFI boot entry should be written.
+func shouldUpdateEFIVars(mode string, virt host.Virtualization) bool {
+	switch mode {
+	case EFIVarsUpdate:
+		return true
+	case EFIVarsSkip:
+		return false
+	default:
+		return !virt.FlakyEFIVars()
+	}
+}
+
+// checkEFIBackup refuses an EFI boot entry backup file on one of the targets:
+// it would be overwritten with the image right after it is written.
+func checkEFIBackup(targets []string, backup string) error {
+	if backup == "" {
+		return nil
+	}
+	for _, target := range targets {
+		if reason := blockdev.Holds(target, backup); reason != "" {
+			return errors.Newf("%s is on the target disk %s (%s); keep the backup on another disk, e.g. a USB stick", backup, target, reason)
+		}
+	}
+	return nil
 }
 
 // RunInstallMode executes install mode: extracts image, runs installer, copies to disk.
 //
 //nolint:forbidigo
-func RunInstallMode(source types.ImageSource, disk string, extraArgs []string, sizeGiB uint64) {
+func RunInstallMode(source types.ImageSource, opts Options) {
+	disk := opts.Disk
+	extraArgs := opts.ExtraArgs
+	sizeGiB := opts.SizeGiB
+
+	// Boards boot via u-boot, EFI state of the host is irrelevant.
+	uefi := opts.Board == "" && efi.IsUEFIBoot()
+	virt := host.DetectVirtualization()
+	imageFile := opts.imageFile()
+	if err := checkImageFile(opts); err != nil {
+		cli.Fatalf(cli.ExitUsage, "%v", err)
+	}
+	// The image brings its own GPT, which the firmware only finds at the
+	// start of a whole disk.
+	if blockdev.IsPartition(disk) {
+		cli.Fatalf(cli.ExitUsage, "%s is a partition: install mode writes the image to a whole disk, installing next to another OS is not supported (use boot mode or a separate disk)", disk)
+	}
+	// The firmware cannot read md RAID arrays or LVM volumes, an EFI boot
+	// entry has to point to an ESP on a disk.
+	volume := blockdev.VolumeKind(disk)
+	updateEFIVars := uefi && volume == "" && !imageFile && shouldUpdateEFIVars(opts.EFIVars, virt)
+	if opts.EFIFallback && opts.Board != "" {
+		cli.Fatalf(cli.ExitUsage, "-efi-fallback is only possible for EFI installs")
+	}
+	if opts.NocloudSeed != nil && opts.GrowImage {
+		cli.Fatalf(cli.ExitUsage, "a nocloud seed partition cannot be combined with growing the image")
+	}
+	var imageSize int64
+	if source.Type() != types.ImageSourceRAW {
+		imageSize = int64(sizeGiB) << 30
+	}
+	if imageFile {
+		if err := host.CheckFreeSpace(filepath.Dir(disk), uint64(imageSize)); err != nil {
+			cli.Fatalf(cli.ExitTarget, "refusing to write the image file: %v", err)
+		}
+	}
+	if volume != "" {
+		if err := checkVolume(disk, imageSize); err != nil {
+			cli.Fatalf(cli.ExitTarget, "refusing to install: %v", err)
+		}
+	}
+	if len(opts.Mirrors) > 0 {
+		if err := checkMirrors(opts, imageSize); err != nil {
+			cli.Fatalf(cli.ExitTarget, "refusing to install: %v", err)
+		}
+	}
+	if opts.Overlay != "" && source.Type() == types.ImageSourceRAW {
+		cli.Fatalf(cli.ExitUsage, "-overlay needs an installer image: RAW disk images are written as they are")
+	}
+	work := chooseWorkDir(opts.TmpfsSize, source, sizeGiB)
+	if err := checkImageDirs(opts.targets(), source, work, opts.CacheDir); err != nil {
+		cli.Fatalf(cli.ExitTarget, "refusing to install: %v", err)
+	}
+	if err := checkEFIBackup(opts.targets(), opts.EFIBackup); err != nil {
+		cli.Fatalf(cli.ExitUsage, "invalid -efi-backup: %v", err)
+	}
+
 	// Check Secure Boot state on UEFI systems
-	if efi.IsUEFIBoot() {
-		sbState, err := efi.GetSecureBootState()
-		if err == nil && sbState.Enabled && !sbState.SetupMode {
-			fmt.Println("\nWARNING: Secure Boot is enabled!")
-			fmt.Println("Talos UKI is signed with Sidero Labs keys, which are not in your UEFI db.")
-			fmt.Println("The installed system will likely fail to boot.")
-			fmt.Println("")
-			fmt.Println("Options:")
-			fmt.Println("  1. Disable Secure Boot in BIOS/UEFI settings")
-			fmt.Println("  2. Put UEFI in Setup Mode to allow automatic key enrollment")
-			fmt.Println("")
-			if !cli.AskYesNo("Proceed anyway (not recommended)?", false) {
-				log.Fatal("aborted: Secure Boot is enabled")
-			}
-			fmt.Println("")
+	var sbState efi.SecureBootState
+	var sbErr error
+	secureBoot := false
+	if uefi {
+		sbState, sbErr = efi.GetSecureBootState()
+		secureBoot = sbErr == nil && sbState.Enabled && !sbState.SetupMode
+	}
+	if secureBoot && !opts.Plan {
+		printSecureBootWarning()
+		if !cli.AskYesNo("Proceed anyway (not recommended)?", false) {
+			cli.Fatalf(cli.ExitAborted, "aborted: Secure Boot is enabled")
+		}
+		fmt.Println("")
+	}
+
+	// The installer formats the encrypted volumes from the config it is
+	// given; RAW images are written as they are.
+	var encryption DiskEncryption
+	if opts.ConfigTemplate != nil && source.Type() != types.ImageSourceRAW {
+		config, err := installerConfig(opts.ConfigTemplate, opts)
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -config-template: %v", err)
+		}
+		encryption, err = parseDiskEncryption(config)
+		if err == nil {
+			err = encryption.check(source.Reference())
+		}
+		if err != nil {
+			cli.Fatalf(cli.ExitUsage, "invalid -config-template: %v", err)
 		}
 	}
 
 	fmt.Println("\nSummary:")
 	fmt.Printf("  Image: %s\n", source.Reference())
-	fmt.Printf("  Disk:  %s\n", disk)
+	if opts.Plan {
+		fmt.Printf("  Source type: %s\n", source.Type())
+	}
+	switch {
+	case imageFile && opts.OutputCompress != "":
+		fmt.Printf("  Image file: %s (%s compressed, built in %s)\n", disk, opts.OutputCompress, uncompressedPath(disk))
+	case imageFile:
+		fmt.Printf("  Image file: %s\n", disk)
+	case volume != "":
+		fmt.Printf("  Disk:  %s (%s)\n", diskName(disk), volumeName(volume))
+	default:
+		fmt.Printf("  Disk:  %s\n", diskName(disk))
+	}
+	if len(opts.Mirrors) > 0 {
+		names := make([]string, len(opts.Mirrors))
+		for i, mirror := range opts.Mirrors {
+			names[i] = diskName(mirror)
+		}
+		fmt.Printf("  Mirrors: %s (same image, verified after writing)\n", strings.Join(names, ", "))
+	}
+	if opts.Board != "" {
+		fmt.Printf("  Board: %s (u-boot, no EFI boot entry)\n", opts.Board)
+	}
+	if opts.Overlay != "" {
+		fmt.Printf("  Installer overlay: %s\n", opts.Overlay)
+	}
+	if opts.platform() != cmdline.PlatformMetal {
+		fmt.Printf("  Platform: %s\n", opts.platform())
+	}
+	if opts.GrowImage {
+		if opts.GrowSize != 0 {
+			fmt.Printf("  Grow image: last partition up to %s\n", host.FormatBytes(opts.GrowSize))
+		} else {
+			fmt.Println("  Grow image: last partition up to the end of the disk")
+		}
+	}
 	fmt.Printf("  Extra kernel args: %s\n",
 		func() string {
 			if len(extraArgs) == 0 {
@@ -134,18 +362,134 @@ func RunInstallMode(source types.ImageSource, disk string, extraArgs []string, s
 			}
 			return strings.Join(extraArgs, " ")
 		}())
-	fmt.Printf("\nWARNING: ALL DATA ON %s WILL BE ERASED!\n\n", disk)
-	if !cli.AskYesNo("Continue?", true) {
-		log.Fatal("aborted by user")
+	fmt.Printf("  Talos behavior: %s\n", opts.Talos)
+	if len(encryption) > 0 {
+		fmt.Printf("  Disk encryption: %s\n", encryption)
+	}
+	if opts.Plan {
+		network.PrintSummary(extraArgs)
+	}
+	fmt.Printf("  Virtualization: %s\n", virt)
+	fmt.Printf("  Work directory: %s\n", work)
+	if opts.Board == "" && !uefi {
+		fmt.Println("  Firmware: legacy BIOS (the installer sets up its BIOS boot loader, no EFI boot entry)")
+	}
+	if uefi {
+		switch {
+		case imageFile:
+			fmt.Println("  EFI boot entry: skip (image file, the firmware will use the removable-media fallback path)")
+		case updateEFIVars:
+			fmt.Printf("  EFI boot entry: create Talos entry for %s and put it first in BootOrder\n", disk)
+		case volume != "":
+			fmt.Printf("  EFI boot entry: skip (the firmware cannot read an %s)\n", volumeName(volume))
+		default:
+			fmt.Println("  EFI boot entry: skip (firmware will use the removable-media fallback path)")
+		}
+	}
+	if updateEFIVars && opts.EFIBackup != "" {
+		fmt.Printf("  EFI boot backup: %s (restore with -efi-restore)\n", opts.EFIBackup)
+	}
+	if removable := blockdev.RemovableRoot(); updateEFIVars && removable != "" {
+		if opts.EFIDemote {
+			fmt.Printf("  Removable boot medium: %s (its EFI boot entries go to the end of BootOrder)\n", removable)
+		} else {
+			fmt.Printf("  Removable boot medium: %s (add -efi-demote-removable if the firmware would boot it again)\n", removable)
+		}
+	}
+	if opts.EFIFallback {
+		fmt.Println("  EFI fallback loader: copy to the removable-media path on the ESP")
+	}
+	if opts.NocloudSeed != nil {
+		fmt.Printf("  Nocloud seed: %s on a %d MiB %s partition after the image\n",
+			strings.Join(opts.NocloudSeed.names(), ", "), nocloudPartitionSize>>20, NocloudLabel)
+	}
+	switch {
+	case imageFile:
+		fmt.Println("  Reboot: none, this host is left as it is")
+	case opts.RebootMethod == RebootNone:
+		fmt.Println("  Reboot: none, reboot manually after the install")
+	}
+	if opts.PostInstallHook != "" {
+		fmt.Printf("  Post-install hook: %s (on failure: %s)\n", opts.PostInstallHook, opts.PostInstallHookOnError)
+	}
+	if opts.Plan {
+		switch {
+		case !uefi:
+			fmt.Println("  Secure Boot: n/a (not booted via UEFI)")
+		case sbErr != nil:
+			fmt.Printf("  Secure Boot: unknown (%v)\n", sbErr)
+		default:
+			fmt.Printf("  Secure Boot: %s\n", sbState)
+		}
+	}
+	if opts.Board == "" && !uefi {
+		fmt.Println()
+		fmt.Println(cli.BIOSModeWarning)
+	}
+	if uefi && volume == "" && !imageFile && !updateEFIVars && opts.EFIVars != EFIVarsSkip {
+		fmt.Printf("\nWARNING: %s firmware (OVMF) often fails to persist EFI variables.\n", virt.Hypervisor)
+		fmt.Println("The Talos boot entry will not be created; the VM will boot from the")
+		fmt.Println("removable-media fallback path on the ESP. Use -efi-vars=update to force it.")
+		if !opts.EFIFallback {
+			fmt.Println("Use -efi-fallback to make sure that path holds the Talos boot loader.")
+		}
+	}
+	// The installer adds its own arguments; leave room for them.
+	extraCmdline := strings.Join(append([]string{"talos.platform=" + opts.platform()}, extraArgs...), " ")
+	if err := cmdline.CheckLength(extraCmdline, cmdline.DefaultMaxLength-cmdline.InstallReserve); err != nil {
+		cli.Fatalf(cli.ExitUsage, "extra kernel args are too long: %v", err)
+	}
+	if cmdline.NearLimit(extraCmdline, cmdline.DefaultMaxLength-cmdline.InstallReserve) {
+		fmt.Printf("\nWARNING: extra kernel args are %d bytes long, close to the kernel command line limit.\n", len(extraCmdline))
+	}
+	if opts.X86Level != 0 {
+		if err := host.CheckX86Level(opts.X86Level); err != nil {
+			fmt.Printf("\nWARNING: %v.\n", err)
+			fmt.Println("Talos will not boot on this machine once installed.")
+		}
+	}
+	if volume != "" {
+		fmt.Printf("\nNOTE: the firmware cannot boot from an %s on its own; Talos has to be\n", volumeName(volume))
+		fmt.Println("started from an ESP on a disk (see README).")
+	}
+	fmt.Printf("\nWARNING: ALL DATA ON %s WILL BE ERASED!\n\n", strings.Join(opts.targets(), ", "))
+	if secureBoot && opts.Plan {
+		printSecureBootWarning()
+	}
+	// A plan with a Secure Boot warning defaults to no, like the prompt it
+	// replaces.
+	if !cli.AskYesNo("Continue?", !(secureBoot && opts.Plan)) {
+		cli.Fatalf(cli.ExitAborted, "aborted by user")
 	}
 	fmt.Println()
 
+	if imageFile {
+		if opts.OutputCompress != "" {
+			opts.building = uncompressedPath(disk)
+		}
+		createImageFile(opts.targets()[0])
+	}
+
+	// Back up while the host filesystems are still writable.
+	if opts.EFIBackup != "" {
+		if updateEFIVars {
+			if err := efi.BackupBootEntries(opts.EFIBackup); err != nil {
+				cli.Fatalf(cli.ExitHost, "failed to back up EFI boot entries: %v", err)
+			}
+		} else {
+			log.Printf("EFI boot entries are left alone, not writing %s", opts.EFIBackup)
+		}
+	}
+
 	// Get install assets from source
-	tmpDir, err := os.MkdirTemp("", "installer-*")
+	tmpDir, err := mkdirWorkDir(work)
 	if err != nil {
-		log.Fatalf("create temporary directory: %v", err)
+		cli.Fatalf(cli.ExitHost, "create temporary directory: %v", err)
 	}
 	log.Printf("created temporary directory %s", tmpDir)
+	if !work.tmpfs {
+		log.Printf("warning: not using tmpfs for %s: %s", tmpDir, work.reason)
+	}
 
 	mounted := false
 	defer func() {
@@ -155,224 +499,250 @@ func RunInstallMode(source types.ImageSource, disk string, extraArgs []string, s
 		os.RemoveAll(tmpDir)
 	}()
 
-	cli.Must("mount tmpfs", unix.Mount("tmpfs", tmpDir, "tmpfs", 0, ""))
-	mounted = true
+	if work.tmpfs {
+		data := ""
+		if work.size != "" {
+			data = "size=" + work.size
+		}
+		cli.Must("mount tmpfs", unix.Mount("tmpfs", tmpDir, "tmpfs", 0, data))
+		mounted = true
+	}
+
+	if err := host.CheckFreeSpace(tmpDir, tmpfsNeeds(source, sizeGiB)); err != nil {
+		cli.Fatalf(cli.ExitHost, "%v; use -tmpfs-size or -temp-dir to provide more", err)
+	}
 
 	assets, err := source.GetInstallAssets(tmpDir, sizeGiB)
 	if err != nil {
-		log.Fatalf("failed to get install assets from %s source: %v", source.Type(), err)
+		cli.Fatalf(cli.ExitImage, "failed to get install assets from %s source: %v", source.Type(), err)
 	}
 	defer assets.Close()
 
+	hook := newPostInstallHook(opts, tmpDir)
+
 	// Use disk image from assets
 	if assets.DiskImage != nil {
-		runDiskImageInstall(assets, disk, extraArgs)
+		runDiskImageInstall(assets, disk, extraArgs, opts, hook)
 	} else if assets.RootfsPath != "" {
-		runChrootInstall(assets, disk, extraArgs, sizeGiB, tmpDir)
+		runChrootInstall(assets, disk, extraArgs, sizeGiB, tmpDir, opts, updateEFIVars, hook)
 	} else {
-		log.Fatal("install assets contain neither disk image nor rootfs path")
+		cli.Fatalf(cli.ExitImage, "install assets contain neither disk image nor rootfs path")
+	}
+}
+
+// printSecureBootWarning explains why an install with Secure Boot enabled
+// will likely not boot.
+//
+//nolint:forbidigo
+func printSecureBootWarning() {
+	fmt.Println("\nWARNING: Secure Boot is enabled!")
+	fmt.Println("Talos UKI is signed with Sidero Labs keys, which are not in your UEFI db.")
+	fmt.Println("The installed system will likely fail to boot.")
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Println("  1. Disable Secure Boot in BIOS/UEFI settings")
+	fmt.Println("  2. Put UEFI in Setup Mode to allow automatic key enrollment")
+	fmt.Println("")
+}
+
+// installEFIFallback copies the boot loader to the removable-media path on
+// the ESP written to disk, if requested.
+func installEFIFallback(disk string, opts Options) {
+	if !opts.EFIFallback {
+		return
+	}
+	if _, err := efi.InstallFallback(disk); err != nil {
+		log.Printf("warning: failed to install EFI fallback loader on %s: %v", disk, err)
 	}
 }
 
 // runDiskImageInstall installs using a pre-built disk image (RAW).
-func runDiskImageInstall(assets *types.InstallAssets, disk string, extraArgs []string) {
-	log.Printf("installing from disk image to %s", disk)
-
-	// Copy disk image to target disk
-	out, err := os.OpenFile(disk, os.O_WRONLY, 0)
-	cli.Must("open disk", err)
-	defer out.Close()
-
-	buf := make([]byte, 4<<20) // 4MB buffer
-	for {
-		n, err := assets.DiskImage.Read(buf)
-		if n > 0 {
-			_, werr := out.Write(buf[:n])
-			cli.Must("write", werr)
-			_ = out.Sync()
-		}
-		if err == io.EOF {
-			break
-		}
-		cli.Must("read", err)
+func runDiskImageInstall(assets *types.InstallAssets, disk string, extraArgs []string, opts Options, hook *postInstallHook) {
+	targets := opts.targets()
+	log.Printf("installing from disk image to %s", strings.Join(targets, ", "))
+
+	if assets.DiskImageSize < 0 {
+		log.Print("image size is unknown (compressed stream without size metadata)")
 	}
 
-	log.Printf("disk image copied to %s", disk)
+	// A GPT is only valid for the sector size it was created with.
+	image := bufio.NewReaderSize(assets.DiskImage, 8<<10)
+	header, _ := image.Peek(8 << 10)
+	if imageSectors := blockdev.GPTSectorSize(header); imageSectors != 0 && !opts.imageFile() {
+		for _, target := range targets {
+			diskSectors, err := blockdev.LogicalBlockSize(target)
+			if err != nil {
+				log.Printf("warning: cannot get logical block size of %s: %v", target, err)
+			} else if diskSectors != imageSectors {
+				cli.Fatalf(cli.ExitTarget, "image is partitioned for %d-byte sectors but %s uses %d-byte sectors; use an installer image (container or ISO) instead",
+					imageSectors, target, diskSectors)
+			}
+		}
+	}
+	writeImage(image, assets.DiskImageSize, targets)
+
+	log.Printf("disk image copied to %s", strings.Join(targets, ", "))
+	extendImageFile(opts)
+	for _, target := range targets {
+		growImage(target, opts)
+		installEFIFallback(target, opts)
+		writeNocloudSeed(target, opts)
+	}
 
-	// If extra args provided, we need to patch the UKI cmdline
-	if len(extraArgs) > 0 {
-		log.Printf("extra kernel args provided but UKI patching for installed image is not implemented yet")
+	// RAW images are written as they are, so the requested args must
+	// already be in the image.
+	if opts.Board == "" {
+		verifyCmdline(targets[0], extraArgs)
 	}
 
-	log.Print("rebooting system")
-	_ = os.WriteFile("/proc/sysrq-trigger", []byte("b"), 0)
+	hook.run()
+	finish(opts)
 }
 
 // runChrootInstall installs using chroot installer.
-func runChrootInstall(assets *types.InstallAssets, disk string, extraArgs []string, sizeGiB uint64, tmpDir string) {
+func runChrootInstall(assets *types.InstallAssets, disk string, extraArgs []string, sizeGiB uint64, tmpDir string, opts Options, updateEFIVars bool, hook *postInstallHook) {
 	instDir := assets.RootfsPath
 
 	raw := filepath.Join(tmpDir, "image.raw")
+	if err := host.CheckFreeSpace(tmpDir, sizeGiB<<30); err != nil {
+		cli.Fatalf(cli.ExitHost, "%v for the %d GiB raw disk image; use -tmpfs-size or -temp-dir to provide more", err, sizeGiB)
+	}
 	log.Printf("creating raw disk %s (%d GiB)", raw, sizeGiB)
 	f, err := os.Create(raw)
 	cli.Must("create raw disk image", err)
 	cli.Must("truncate raw disk image", f.Truncate(int64(sizeGiB)<<30))
 	f.Close()
 
-	loop, lf := SetupLoop(raw)
+	blockSize, err := blockdev.LogicalBlockSize(disk)
+	if opts.imageFile() {
+		blockSize, err = 512, nil
+	}
+	if err != nil {
+		log.Printf("warning: cannot get logical block size of %s, assuming 512: %v", disk, err)
+		blockSize = 512
+	}
+	if blockSize != 512 {
+		log.Printf("%s uses %d-byte logical sectors", disk, blockSize)
+	}
+
+	loop, lf := SetupLoop(raw, blockSize, opts.LoopMaxPart)
 	log.Printf("attached %s to %s", raw, loop)
-	defer func() {
-		_, _, _ = unix.Syscall(unix.SYS_IOCTL, lf.Fd(), unix.LOOP_CLR_FD, 0)
-		lf.Close()
-	}()
+	defer blockdev.DetachLoop(lf)
+
+	// Before the host's /proc, /sys and /dev are bound into the rootfs.
+	if opts.Overlay != "" {
+		if err := applyOverlay(opts.Overlay, instDir); err != nil {
+			cli.Fatalf(cli.ExitUsage, "failed to apply -overlay: %v", err)
+		}
+	}
 
 	MountBind("/proc", filepath.Join(instDir, "proc"))
 	MountBindRecursive("/sys", filepath.Join(instDir, "sys"))
 	MountBind("/dev", filepath.Join(instDir, "dev"))
-	OverrideCmdline(instDir, "talos.platform=metal "+strings.Join(extraArgs, " "))
+	OverrideCmdline(instDir, "talos.platform="+opts.platform()+" "+strings.Join(extraArgs, " "))
+
+	checkBoardAssets(instDir, opts.Board, efi.IsUEFIBoot())
 
 	execPath := "/usr/bin/installer"
-	args := []string{execPath, "install", "--platform", "metal", "--disk", loop, "--force"}
+	args := []string{execPath, "install", "--platform", opts.platform(), "--disk", loop, "--force"}
+	if opts.Board != "" {
+		args = append(args, "--board", opts.Board)
+	}
 	for _, a := range extraArgs {
 		args = append(args, "--extra-kernel-arg", a)
 	}
 
 	stdinR, stdinW, err := os.Pipe()
 	cli.Must("create stdin pipe", err)
+	config, err := installerConfig(opts.ConfigTemplate, opts)
+	cli.Must("installer config", err)
 	go func() {
-		fmt.Fprintf(stdinW, `version: v1alpha1
-machine:
-  ca: {crt: %s}
-  install: {disk: /dev/sda}
-cluster:
-  controlPlane: {endpoint: https://localhost:6443}
-`, FakeCert())
+		fmt.Fprint(stdinW, config)
 		stdinW.Close()
 	}()
 
+	stderrR, stderrW, err := os.Pipe()
+	cli.Must("create stderr pipe", err)
+	var output installerOutput
+	outputDone := make(chan struct{})
+	go func() {
+		output.copy(os.Stderr, stderrR)
+		close(outputDone)
+	}()
+
 	attr := &syscall.ProcAttr{
 		Dir:   "/",
 		Env:   []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
-		Files: []uintptr{stdinR.Fd(), os.Stdout.Fd(), os.Stderr.Fd()},
+		Files: []uintptr{stdinR.Fd(), os.Stdout.Fd(), stderrW.Fd()},
 		Sys:   &syscall.SysProcAttr{Chroot: instDir},
 	}
 
 	log.Print("starting Talos installer")
+	status.SetPhase(status.PhaseInstalling, "")
 	pid, err := syscall.ForkExec(execPath, args, attr)
 	cli.Must("forkexec", err)
+	stderrW.Close()
 	var ws syscall.WaitStatus
 	_, err = syscall.Wait4(pid, &ws, 0, nil)
 	cli.Must("wait", err)
-	if !ws.Exited() || ws.ExitStatus() != 0 {
-		log.Fatalf("installer exited %d", ws.ExitStatus())
+	// Processes the installer left behind may still hold the pipe open.
+	select {
+	case <-outputDone:
+	case <-time.After(5 * time.Second):
+	}
+	switch {
+	case ws.Signaled():
+		cli.Fatalf(cli.ExitInstaller, "%s", output.failure(fmt.Sprintf("killed by signal %v", ws.Signal()), sizeGiB))
+	case !ws.Exited() || ws.ExitStatus() != 0:
+		cli.Fatalf(cli.ExitInstaller, "%s", output.failure(fmt.Sprintf("exited %d", ws.ExitStatus()), sizeGiB))
 	}
 	log.Print("Talos installer finished successfully")
 
-	log.Print("remounting all filesystems read-only")
-	_ = os.WriteFile("/proc/sysrq-trigger", []byte("u"), 0)
+	// Catch installers that exit 0 without writing a bootable layout before
+	// the image overwrites the disk we are running from.
+	// A BIOS install boots through the installer's legacy boot loader and may
+	// leave the ESP empty.
+	if opts.Board == "" && efi.IsUEFIBoot() {
+		_ = lf.Sync()
+		bootFile, err := efi.VerifyESP(loop)
+		if err != nil {
+			cli.Fatalf(cli.ExitInstaller, "installer produced an unbootable image, not copying it to the disk: %v", err)
+		}
+		log.Printf("verified installed image: ESP has %s", bootFile)
+		verifyCmdline(loop, extraArgs)
+	}
+
+	// An image file is written to the host filesystems.
+	if !opts.imageFile() {
+		log.Print("remounting all filesystems read-only")
+		_ = os.WriteFile("/proc/sysrq-trigger", []byte("u"), 0)
+	}
 
-	CopyWithFsync(raw, disk)
-	log.Printf("installation image copied to %s", disk)
+	targets := opts.targets()
+	in, err := os.Open(raw)
+	cli.Must("open raw disk image", err)
+	writeImage(in, int64(sizeGiB)<<30, targets)
+	in.Close()
+	log.Printf("installation image copied to %s", strings.Join(targets, ", "))
+	extendImageFile(opts)
+	for _, target := range targets {
+		growImage(target, opts)
+		installEFIFallback(target, opts)
+		writeNocloudSeed(target, opts)
+	}
 
 	// Create EFI boot entry pointing to the target disk's ESP
-	if efi.IsUEFIBoot() {
+	if updateEFIVars {
 		log.Print("creating EFI boot entry")
-		if err := efi.UpdateEFIVariables(disk); err != nil {
+		var removable string
+		if opts.EFIDemote {
+			removable = blockdev.RemovableRoot()
+		}
+		if err := efi.UpdateEFIVariables(disk, removable); err != nil {
 			log.Printf("warning: failed to update EFI variables: %v", err)
 		}
 	}
 
-	log.Print("rebooting system")
-	_ = os.WriteFile("/proc/sysrq-trigger", []byte("b"), 0)
-}
-
-// ExtractContainerLayers extracts container image layers to a directory.
-// This is exported for use by sources that need to extract containers.
-//
-//nolint:gocognit
-func ExtractContainerLayers(layers []io.ReadCloser, destDir string) error {
-	for _, layer := range layers {
-		tr := tar.NewReader(layer)
-		for {
-			h, err := tr.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				layer.Close()
-				return errors.Wrap(err, "read tar")
-			}
-
-			if suffix, found := strings.CutPrefix(filepath.Base(h.Name), ".wh."); found {
-				os.RemoveAll(filepath.Join(destDir, filepath.Dir(h.Name), suffix))
-				continue
-			}
-
-			target := filepath.Join(destDir, h.Name)
-
-			// Security: prevent path traversal attacks
-			cleanTarget := filepath.Clean(target)
-			cleanDest := filepath.Clean(destDir)
-			if !strings.HasPrefix(cleanTarget, cleanDest+string(os.PathSeparator)) && cleanTarget != cleanDest {
-				continue // skip files that would escape destDir
-			}
-
-			switch h.Typeflag {
-			case tar.TypeDir:
-				_ = os.MkdirAll(target, os.FileMode(h.Mode))
-			case tar.TypeReg:
-				if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
-					return errors.Wrap(err, "create directory")
-				}
-				f, err := os.Create(target)
-				if err != nil {
-					return errors.Wrap(err, "create file")
-				}
-				if _, err := io.Copy(f, tr); err != nil {
-					f.Close()
-					os.Remove(target)
-					return errors.Wrap(err, "extract file")
-				}
-				if err := f.Close(); err != nil {
-					os.Remove(target)
-					return errors.Wrap(err, "close file")
-				}
-				if err := os.Chmod(target, os.FileMode(h.Mode)); err != nil {
-					return errors.Wrap(err, "chmod")
-				}
-			case tar.TypeSymlink:
-				// Validate symlink target doesn't escape destDir
-				linkTarget := h.Linkname
-				if !filepath.IsAbs(linkTarget) {
-					linkTarget = filepath.Join(filepath.Dir(target), linkTarget)
-				}
-				cleanLink := filepath.Clean(linkTarget)
-				if !strings.HasPrefix(cleanLink, cleanDest+string(os.PathSeparator)) && cleanLink != cleanDest {
-					continue // skip symlink escape attempt
-				}
-				_ = os.MkdirAll(filepath.Dir(target), 0o755)
-				_ = os.Symlink(h.Linkname, target)
-			case tar.TypeLink:
-				// Validate hardlink source doesn't escape destDir
-				linkSource := filepath.Join(destDir, h.Linkname)
-				cleanSource := filepath.Clean(linkSource)
-				if !strings.HasPrefix(cleanSource, cleanDest+string(os.PathSeparator)) && cleanSource != cleanDest {
-					continue // skip hardlink escape attempt
-				}
-				_ = os.Link(linkSource, target)
-			case tar.TypeChar, tar.TypeBlock:
-				_ = os.MkdirAll(filepath.Dir(target), 0o755)
-				dev := int(unix.Mkdev(uint32(h.Devmajor), uint32(h.Devminor)))
-				mode := uint32(h.Mode)
-				if h.Typeflag == tar.TypeChar {
-					mode |= unix.S_IFCHR
-				} else {
-					mode |= unix.S_IFBLK
-				}
-				_ = unix.Mknod(target, mode, dev)
-			}
-		}
-		layer.Close()
-	}
-	return nil
+	hook.run()
+	finish(opts)
 }
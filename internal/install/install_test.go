@@ -0,0 +1,285 @@
+//go:build linux
+
+package install
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/boot-to-talos/internal/types"
+)
+
+func TestInstallerConfig(t *testing.T) {
+	for _, machineType := range []string{MachineTypeWorker, MachineTypeControlPlane} {
+		cfg, err := installerConfig(nil, Options{MachineType: machineType})
+		if err != nil {
+			t.Fatalf("installerConfig error: %v", err)
+		}
+		if !strings.Contains(cfg, "\n  type: "+machineType+"\n") {
+			t.Errorf("installerConfig(%q) has no machine type:\n%s", machineType, cfg)
+		}
+		if !strings.HasPrefix(cfg, "version: v1alpha1\nmachine:\n") {
+			t.Errorf("installerConfig(%q) is not a v1alpha1 config:\n%s", machineType, cfg)
+		}
+	}
+}
+
+func TestInstallerConfigTemplate(t *testing.T) {
+	tmpl, err := parseConfigTemplate("test", "{{ .Disk }} {{ .IP }} {{ .Hostname }} {{ .MachineType }}")
+	if err != nil {
+		t.Fatalf("parseConfigTemplate error: %v", err)
+	}
+	opts := Options{
+		Disk:        "/dev/sda",
+		MachineType: MachineTypeControlPlane,
+		ExtraArgs:   []string{"bond=bond0:eth0,eth1", "ip=[2001:db8::10]::[fe80::1]:64:node1:bond0:none"},
+	}
+	cfg, err := installerConfig(tmpl, opts)
+	if err != nil {
+		t.Fatalf("installerConfig error: %v", err)
+	}
+	if want := "/dev/sda 2001:db8::10 node1 controlplane"; cfg != want {
+		t.Errorf("installerConfig() = %q, want %q", cfg, want)
+	}
+
+	if _, err := parseConfigTemplate("bad", "{{ .Disk "); err == nil {
+		t.Error("parseConfigTemplate should reject invalid templates")
+	}
+	tmpl, _ = parseConfigTemplate("unknown", "{{ .Unknown }}")
+	if _, err := installerConfig(tmpl, opts); err == nil {
+		t.Error("installerConfig should fail for unknown fields")
+	}
+}
+
+func TestIPArgFields(t *testing.T) {
+	tests := []struct {
+		args         []string
+		wantIP, host string
+	}{
+		{[]string{"ip=10.0.0.5::10.0.0.1:255.255.255.0:node1:eth0:none"}, "10.0.0.5", "node1"},
+		{[]string{"console=ttyS0"}, "", ""},
+		{[]string{"ip=dhcp"}, "", ""},
+		{[]string{"ip=10.0.0.5::10.0.0.1:255.255.255.0::eth0:none", "talos.hostname=node1.example.com"}, "10.0.0.5", "node1.example.com"},
+		{[]string{"talos.hostname=node1"}, "", "node1"},
+	}
+	for _, tt := range tests {
+		ip, host := ipArgFields(tt.args)
+		if ip != tt.wantIP || host != tt.host {
+			t.Errorf("ipArgFields(%q) = %q, %q, want %q, %q", tt.args, ip, host, tt.wantIP, tt.host)
+		}
+	}
+}
+
+// fakeSource is an image source with only a type and a reference.
+type fakeSource struct {
+	types.ImageSource
+
+	typ types.ImageSourceType
+	ref string
+}
+
+func (s fakeSource) Type() types.ImageSourceType { return s.typ }
+func (s fakeSource) Reference() string           { return s.ref }
+
+func TestLocalImage(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "metal-amd64.raw.xz")
+	if err := os.WriteFile(image, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source fakeSource
+		want   string
+	}{
+		{"local RAW", fakeSource{typ: types.ImageSourceRAW, ref: image}, image},
+		{"local ISO", fakeSource{typ: types.ImageSourceISO, ref: image}, image},
+		{"download", fakeSource{typ: types.ImageSourceRAW, ref: "https://factory.talos.dev/metal-amd64.raw.xz"}, ""},
+		{"container", fakeSource{typ: types.ImageSourceContainer, ref: image}, ""},
+		{"directory", fakeSource{typ: types.ImageSourceRAW, ref: filepath.Dir(image)}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localImage(tt.source); got != tt.want {
+				t.Errorf("localImage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstallerOutput(t *testing.T) {
+	var out installerOutput
+	var w strings.Builder
+	out.copy(&w, strings.NewReader("creating partitions\nerror: write /boot/EFI/Linux/Talos-A.efi: No space left on device\n"))
+	if !strings.Contains(w.String(), "creating partitions\n") {
+		t.Errorf("installer output not passed through: %q", w.String())
+	}
+	if hint := out.failureHint(3); !strings.Contains(hint, "-image-size-gib 6") {
+		t.Errorf("failureHint() = %q, want a larger -image-size-gib", hint)
+	}
+
+	out = installerOutput{}
+	out.copy(io.Discard, strings.NewReader("failed to install bootloader: invalid argument\n"))
+	if hint := out.failureHint(3); hint != "" {
+		t.Errorf("failureHint() = %q, want none", hint)
+	}
+}
+
+func TestInstallerOutputFailure(t *testing.T) {
+	var input strings.Builder
+	for i := range 15 {
+		fmt.Fprintf(&input, "step %d\n", i)
+	}
+	input.WriteString("2025/01/02 10:00:00 failed to install bootloader: invalid argument\n\nunmounting\n")
+
+	var out installerOutput
+	out.copy(io.Discard, strings.NewReader(input.String()))
+	got := out.failure("exited 1", 3)
+	lines := strings.Split(got, "\n")
+	if want := "installer exited 1: 2025/01/02 10:00:00 failed to install bootloader: invalid argument"; lines[0] != want {
+		t.Errorf("first line = %q, want %q", lines[0], want)
+	}
+	if len(lines) != 2+installerTailLines || lines[1] != "last 10 lines of installer output:" ||
+		lines[2] != "  step 7" || lines[len(lines)-1] != "  unmounting" {
+		t.Errorf("failure() = %q, want the last %d non-empty lines", got, installerTailLines)
+	}
+
+	out = installerOutput{}
+	if got := out.failure("killed by signal killed", 3); got != "installer killed by signal killed" {
+		t.Errorf("failure() without output = %q", got)
+	}
+}
+
+// TestInstallerOutputLateWrites reads the output while it is still being
+// written, as after install mode stops waiting for leftover processes; run
+// with -race.
+func TestInstallerOutputLateWrites(t *testing.T) {
+	r, w := io.Pipe()
+	var out installerOutput
+	done := make(chan struct{})
+	go func() {
+		out.copy(io.Discard, r)
+		close(done)
+	}()
+	go func() {
+		for i := range 1000 {
+			fmt.Fprintf(w, "error %d: No space left on device\n", i)
+		}
+		w.Close()
+	}()
+	for range 100 {
+		_ = out.failure("exited 1", 3)
+	}
+	<-done
+	if got := out.failure("exited 1", 3); !strings.Contains(got, "error 999") {
+		t.Errorf("failure() = %q, want the last line", got)
+	}
+}
+
+func TestPostInstallHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	body := "#!/bin/sh\necho \"$BOOT_TO_TALOS_DISK|$BOOT_TO_TALOS_DISKS|$BOOT_TO_TALOS_ESP\" > " + out + "\nexit $HOOK_EXIT\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := &postInstallHook{path: script, targets: []string{"/dev/sda", "/dev/sdb"}}
+	t.Setenv("HOOK_EXIT", "0")
+	if err := hook.exec(); err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/dev/sda|/dev/sda /dev/sdb|\n"; string(got) != want {
+		t.Errorf("hook environment = %q, want %q", got, want)
+	}
+
+	t.Setenv("HOOK_EXIT", "3")
+	if err := hook.exec(); err == nil {
+		t.Error("exec of a failing hook returned no error")
+	}
+}
+
+func TestParseDiskEncryption(t *testing.T) {
+	config := `version: v1alpha1
+machine:
+  type: worker
+  systemDiskEncryption:
+    # STATE sealed to the TPM
+    state:
+      provider: luks2
+      keys:
+        - slot: 0
+          tpm: {}
+    ephemeral:
+      provider: luks2
+      keys:
+        - nodeID: {}
+          slot: 0
+        - static:
+            passphrase: secret
+          slot: 1
+  install: {disk: /dev/sda}
+cluster:
+  systemDiskEncryption: {}
+`
+	enc, err := parseDiskEncryption(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := enc.String(), "ephemeral (nodeID, static), state (tpm)"; got != want {
+		t.Errorf("parseDiskEncryption() = %q, want %q", got, want)
+	}
+	if err := enc.check("ghcr.io/siderolabs/installer:v1.10.5"); err != nil {
+		t.Errorf("check(v1.10) error: %v", err)
+	}
+	if err := enc.check("ghcr.io/siderolabs/installer:v1.4.8"); err == nil || !strings.Contains(err.Error(), "tpm key provider requires Talos v1.5") {
+		t.Errorf("check(v1.4) error = %v, want tpm to be unsupported", err)
+	}
+	if err := enc.check("installer.tar"); err != nil {
+		t.Errorf("check(unversioned) error: %v", err)
+	}
+
+	// Flow style, in the second document.
+	enc, err = parseDiskEncryption("apiVersion: v1alpha1\nkind: HostnameConfig\n---\nmachine: {systemDiskEncryption: {state: {provider: luks2, keys: [{slot: 0, tpm: {}}]}}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := enc.String(), "state (tpm)"; got != want {
+		t.Errorf("parseDiskEncryption(flow style) = %q, want %q", got, want)
+	}
+
+	if enc, err := parseDiskEncryption(defaultConfigTemplate); err != nil || len(enc) != 0 {
+		t.Errorf("parseDiskEncryption(default) = %v, %v, want none", enc, err)
+	}
+	enc, err = parseDiskEncryption("machine:\n  systemDiskEncryption:\n    state:\n      provider: luks2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.check(""); err == nil {
+		t.Error("check accepted a volume without key provider")
+	}
+	if _, err := parseDiskEncryption("machine: [\n"); err == nil {
+		t.Error("parseDiskEncryption accepted invalid YAML")
+	}
+}
+
+func TestMissingArgs(t *testing.T) {
+	cmdline := "talos.platform=metal console=tty0 console=ttyS0,115200 ip=10.0.0.2::10.0.0.1:255.255.255.0::eth0:::::"
+	want := []string{"ip=10.0.0.2::10.0.0.1:255.255.255.0::eth0:::::", "console=ttyS0,115200", "bond=bond0:eth0,eth1:mode=802.3ad"}
+	got := missingArgs(cmdline, want)
+	if len(got) != 1 || got[0] != want[2] {
+		t.Errorf("missingArgs() = %v, want [%s]", got, want[2])
+	}
+	if got := missingArgs(cmdline, want[:2]); len(got) != 0 {
+		t.Errorf("missingArgs() = %v, want none", got)
+	}
+}
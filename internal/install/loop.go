@@ -0,0 +1,31 @@
+//go:build linux
+
+package install
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+	"github.com/cozystack/boot-to-talos/internal/cli"
+)
+
+// SetupLoop sets up a loop device for the given file path, with partition
+// scanning so that the partitions the installer writes get device nodes.
+// If blockSize is not 512, the loop device presents that logical sector size,
+// so a GPT written through it matches a 4Kn target disk. A maxPart above 0 is
+// the max_part the loop module is loaded with if it is not loaded yet.
+// Returns the loop device path and the file handle.
+func SetupLoop(path string, blockSize, maxPart int) (string, *os.File) {
+	loop, lf, err := blockdev.AttachLoop(path, blockdev.LoopConfig{BlockSize: blockSize, PartScan: true, MaxPart: maxPart})
+	cli.Must("set up loop device", err)
+	if maxPart > 0 {
+		data, _ := os.ReadFile("/sys/module/loop/parameters/max_part")
+		if loaded, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && loaded < maxPart {
+			log.Printf("note: the loop module was loaded with max_part=%d, partitions beyond it get extended device numbers", loaded)
+		}
+	}
+	return loop, lf
+}
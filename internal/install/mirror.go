@@ -0,0 +1,107 @@
+//go:build linux
+
+package install
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/google/uuid"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+	"github.com/cozystack/boot-to-talos/internal/host"
+)
+
+// checkMirrors validates the mirror disks of opts before anything is written:
+// each must be a distinct whole disk that is not in use, with the sector
+// size of Disk and room for an image of imageSize bytes (0 if not known yet).
+func checkMirrors(opts Options, imageSize int64) error {
+	primarySectors, primaryErr := blockdev.LogicalBlockSize(opts.Disk)
+	seen := map[string]string{}
+	for _, target := range opts.targets() {
+		resolved, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			return errors.Wrapf(err, "resolve %s", target)
+		}
+		if prev, ok := seen[resolved]; ok {
+			return errors.Newf("%s and %s are the same disk", prev, target)
+		}
+		seen[resolved] = target
+	}
+
+	for _, mirror := range opts.Mirrors {
+		if blockdev.IsPartition(mirror) {
+			return errors.Newf("mirror %s is a partition, mirrors must be whole disks", mirror)
+		}
+		if reason := blockdev.InUse(mirror); reason != "" {
+			return errors.Newf("mirror %s is in use: %s", mirror, reason)
+		}
+		sectors, err := blockdev.LogicalBlockSize(mirror)
+		if err == nil && primaryErr == nil && sectors != primarySectors {
+			return errors.Newf("mirror %s uses %d-byte sectors but %s uses %d-byte sectors", mirror, sectors, opts.Disk, primarySectors)
+		}
+		size, err := deviceSize(mirror)
+		if err != nil {
+			return err
+		}
+		if imageSize > 0 && size < imageSize {
+			return errors.Newf("mirror %s (%s) is too small for the %s image", mirror, host.FormatBytes(size), host.FormatBytes(imageSize))
+		}
+	}
+	return nil
+}
+
+// regenerateGUIDs gives the GPT of mirror, written with the same image as
+// the primary disk, its own disk and partition GUIDs, so that the boot entry,
+// which names the ESP by its partition GUID, and /dev/disk/by-partuuid only
+// match one disk. Partition labels are kept, Talos finds its partitions by
+// them. Images without a GPT are left as they are.
+func regenerateGUIDs(mirror string) error {
+	f, err := os.OpenFile(mirror, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", mirror)
+	}
+	defer f.Close()
+
+	header := make([]byte, 4096+512)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return errors.Wrapf(err, "read %s", mirror)
+	}
+	sectorSize := blockdev.GPTSectorSize(header)
+	if sectorSize == 0 {
+		log.Printf("note: %s has no GPT, its partition table stays identical to the primary disk's", mirror)
+		return nil
+	}
+
+	table, err := gpt.Read(f, sectorSize, sectorSize)
+	if err != nil {
+		return errors.Wrapf(err, "read GPT of %s", mirror)
+	}
+	table.GUID = strings.ToUpper(uuid.NewString())
+	for _, p := range table.Partitions {
+		p.GUID = strings.ToUpper(uuid.NewString())
+	}
+	if err := table.Write(f, int64(table.TotalSize())); err != nil {
+		return errors.Wrapf(err, "write GPT of %s", mirror)
+	}
+	return errors.Wrapf(f.Sync(), "sync %s", mirror)
+}
+
+// deviceSize returns the size of a block device in bytes.
+func deviceSize(device string) (int64, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return 0, errors.Wrapf(err, "open %s", device)
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, errors.Wrapf(err, "get size of %s", device)
+	}
+	return size, nil
+}
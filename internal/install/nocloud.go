@@ -0,0 +1,240 @@
+//go:build linux
+
+package install
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+)
+
+const (
+	// NocloudLabel is the filesystem label of the nocloud seed; the Talos
+	// nocloud platform looks for cidata or CIDATA.
+	NocloudLabel = "CIDATA"
+
+	// nocloudPartitionSize is the size of the seed partition appended after
+	// the last partition of the image.
+	nocloudPartitionSize = 16 << 20
+
+	// nocloudMaxData limits the seed files, leaving room for the FAT.
+	nocloudMaxData = 8 << 20
+)
+
+// nocloudFiles are the files a nocloud seed may contain. Talos reads its
+// machine config from user-data.
+//
+//nolint:gochecknoglobals
+var nocloudFiles = []string{"user-data", "meta-data", "network-config", "vendor-data"}
+
+// NocloudSeed maps the nocloud seed file names to their contents.
+type NocloudSeed map[string][]byte
+
+// LoadNocloudSeed reads a nocloud seed from path: a directory with user-data
+// and optionally meta-data, network-config and vendor-data, or a single file
+// used as user-data (the Talos machine config). An empty meta-data is added
+// if there is none, as nocloud requires it.
+func LoadNocloudSeed(path string) (NocloudSeed, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read nocloud seed")
+	}
+
+	seed := NocloudSeed{}
+	if !fi.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "read nocloud seed")
+		}
+		seed["user-data"] = data
+	} else {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "read nocloud seed")
+		}
+		for _, e := range entries {
+			if !slices.Contains(nocloudFiles, e.Name()) || !e.Type().IsRegular() {
+				return nil, errors.Newf("unexpected %s in nocloud seed directory (expected files %s)",
+					e.Name(), strings.Join(nocloudFiles, ", "))
+			}
+			data, err := os.ReadFile(filepath.Join(path, e.Name()))
+			if err != nil {
+				return nil, errors.Wrap(err, "read nocloud seed")
+			}
+			seed[e.Name()] = data
+		}
+	}
+	if _, ok := seed["meta-data"]; !ok {
+		seed["meta-data"] = nil
+	}
+
+	return seed, seed.validate()
+}
+
+// validate checks that the seed has a Talos machine config as user-data and
+// fits into the seed partition.
+func (s NocloudSeed) validate() error {
+	userData := bytes.TrimSpace(s["user-data"])
+	if len(userData) == 0 {
+		return errors.New("nocloud seed has no user-data")
+	}
+	if bytes.HasPrefix(userData, []byte("#cloud-config")) {
+		return errors.New("user-data is a cloud-config; Talos expects its machine config there")
+	}
+
+	total := 0
+	for _, name := range s.names() {
+		if !utf8.Valid(s[name]) {
+			return errors.Newf("nocloud seed file %s is not UTF-8 text", name)
+		}
+		total += len(s[name])
+	}
+	if total > nocloudMaxData {
+		return errors.Newf("nocloud seed files are %d bytes, at most %d fit into the seed partition", total, nocloudMaxData)
+	}
+	return nil
+}
+
+// names returns the file names of the seed in sorted order.
+func (s NocloudSeed) names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// writeNocloudSeed writes the nocloud seed of opts to disk, if requested.
+// The image is already bootable at this point, so failures are only
+// reported.
+func writeNocloudSeed(disk string, opts Options) {
+	if opts.NocloudSeed == nil {
+		return
+	}
+	if err := WriteNocloudSeed(disk, opts.NocloudSeed); err != nil {
+		log.Printf("warning: failed to write nocloud seed to %s, Talos will boot into maintenance mode: %v", disk, err)
+	}
+}
+
+// WriteNocloudSeed appends a partition after the last partition of the image
+// written to disk and puts a FAT filesystem labelled NocloudLabel with the
+// seed files on it. If the partition table of the image has no room for the
+// partition, it is extended to the end of the disk.
+func WriteNocloudSeed(path string, seed NocloudSeed) error {
+	part, err := addNocloudPartition(path)
+	if err != nil {
+		return err
+	}
+
+	d, err := diskfs.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer d.Close()
+	if _, err := d.GetPartitionTable(); err != nil {
+		return errors.Wrap(err, "read GPT")
+	}
+	fs, err := d.CreateFilesystem(disk.FilesystemSpec{
+		Partition:   part,
+		FSType:      filesystem.TypeFat32,
+		VolumeLabel: NocloudLabel,
+	})
+	if err != nil {
+		return errors.Wrap(err, "create nocloud seed filesystem")
+	}
+	defer fs.Close()
+
+	for _, name := range seed.names() {
+		f, err := fs.OpenFile("/"+name, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+		if err != nil {
+			return errors.Wrapf(err, "create %s on the seed partition", name)
+		}
+		_, err = io.Copy(f, bytes.NewReader(seed[name]))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return errors.Wrapf(err, "write %s on the seed partition", name)
+		}
+	}
+	log.Printf("wrote nocloud seed (%s) to partition %d of %s", strings.Join(seed.names(), ", "), part, path)
+	return nil
+}
+
+// addNocloudPartition adds the seed partition to the GPT on path and returns
+// its number.
+func addNocloudPartition(path string) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+
+	diskSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, errors.Wrapf(err, "get size of %s", path)
+	}
+	sectorSize, err := blockdev.LogicalBlockSize(path)
+	if err != nil {
+		log.Printf("warning: cannot get logical block size of %s, assuming 512: %v", path, err)
+		sectorSize = 512
+	}
+	// go-diskfs only creates FAT filesystems with 512-byte sectors.
+	if sectorSize != 512 {
+		return 0, errors.Newf("%s uses %d-byte sectors, the seed filesystem needs 512-byte sectors", path, sectorSize)
+	}
+
+	table, err := gpt.Read(f, sectorSize, sectorSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "read GPT")
+	}
+	var lastEnd uint64
+	for _, p := range table.Partitions {
+		if p.Name == "cidata" {
+			return 0, errors.New("the image already has a cidata partition")
+		}
+		lastEnd = max(lastEnd, p.End)
+	}
+
+	alignSectors := uint64(growAlignment / sectorSize)
+	start := (lastEnd + alignSectors) / alignSectors * alignSectors
+	end := start + nocloudPartitionSize/uint64(sectorSize) - 1
+	diskSize -= diskSize % int64(sectorSize)
+	if end > table.LastDataSector() && uint64(diskSize) > table.TotalSize() {
+		log.Printf("extending the partition table to the end of %s for the nocloud seed", path)
+		table.Resize(uint64(diskSize))
+	}
+	if end > table.LastDataSector() {
+		return 0, errors.Newf("no room for the %d MiB seed partition after the last partition of %s",
+			nocloudPartitionSize>>20, path)
+	}
+
+	table.Partitions = append(table.Partitions, &gpt.Partition{
+		Start: start,
+		End:   end,
+		Size:  nocloudPartitionSize,
+		Type:  gpt.LinuxFilesystem,
+		Name:  "cidata",
+	})
+	if err := table.Write(f, diskSize); err != nil {
+		return 0, errors.Wrap(err, "write GPT")
+	}
+	if err := f.Sync(); err != nil {
+		return 0, errors.Wrapf(err, "sync %s", path)
+	}
+	return len(table.Partitions), nil
+}
@@ -0,0 +1,135 @@
+//go:build linux
+
+package install
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+
+	"github.com/cozystack/boot-to-talos/internal/testutil"
+)
+
+func TestLoadNocloudSeed(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "talos.yaml")
+	if err := os.WriteFile(config, []byte("version: v1alpha1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	seed, err := LoadNocloudSeed(config)
+	if err != nil {
+		t.Fatalf("LoadNocloudSeed(file) error: %v", err)
+	}
+	if string(seed["user-data"]) != "version: v1alpha1\n" {
+		t.Errorf("user-data = %q", seed["user-data"])
+	}
+	if _, ok := seed["meta-data"]; !ok {
+		t.Error("LoadNocloudSeed did not add an empty meta-data")
+	}
+
+	seedDir := filepath.Join(dir, "seed")
+	if err := os.Mkdir(seedDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"user-data": "version: v1alpha1\n", "meta-data": "local-hostname: node1\n"} {
+		if err := os.WriteFile(filepath.Join(seedDir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seed, err = LoadNocloudSeed(seedDir)
+	if err != nil {
+		t.Fatalf("LoadNocloudSeed(dir) error: %v", err)
+	}
+	if string(seed["meta-data"]) != "local-hostname: node1\n" {
+		t.Errorf("meta-data = %q", seed["meta-data"])
+	}
+
+	if err := os.WriteFile(filepath.Join(seedDir, "user-data"), []byte("#cloud-config\nusers: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNocloudSeed(seedDir); err == nil {
+		t.Error("LoadNocloudSeed accepted a cloud-config user-data")
+	}
+	if err := os.WriteFile(filepath.Join(seedDir, "user-data"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNocloudSeed(seedDir); err == nil {
+		t.Error("LoadNocloudSeed accepted an empty user-data")
+	}
+	if err := os.WriteFile(filepath.Join(seedDir, "userdata"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNocloudSeed(seedDir); err == nil {
+		t.Error("LoadNocloudSeed accepted an unknown file")
+	}
+}
+
+func TestWriteNocloudSeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(64 << 20); err != nil {
+		t.Fatal(err)
+	}
+	// A GPT that spans only the first 32 MiB, like an image written to a
+	// larger disk.
+	table := &gpt.Table{
+		ProtectiveMBR:      true,
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 4095, Type: gpt.EFISystemPartition, Name: "EFI"},
+		},
+	}
+	if err := table.Write(f, 32<<20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	seed := NocloudSeed{"user-data": []byte("version: v1alpha1\n"), "meta-data": nil}
+	if err := WriteNocloudSeed(path, seed); err != nil {
+		t.Fatalf("WriteNocloudSeed error: %v", err)
+	}
+
+	d, err := diskfs.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	fs, err := d.GetFilesystem(2)
+	if err != nil {
+		t.Fatalf("open seed filesystem: %v", err)
+	}
+	if label := fs.Label(); label != NocloudLabel {
+		t.Errorf("seed label = %q, want %q", label, NocloudLabel)
+	}
+	userData, err := fs.OpenFile("/user-data", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("open user-data: %v", err)
+	}
+	data, _ := io.ReadAll(userData)
+	if string(data) != "version: v1alpha1\n" {
+		t.Errorf("user-data on the seed partition = %q", data)
+	}
+
+	if err := WriteNocloudSeed(path, seed); err == nil {
+		t.Error("WriteNocloudSeed added a second seed partition")
+	}
+}
+
+func TestWriteNocloudSeed_NoRoom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	if err := testutil.CreateTestRAWImage(path, 64, nil); err != nil {
+		t.Fatal(err)
+	}
+	seed := NocloudSeed{"user-data": []byte("version: v1alpha1\n")}
+	if err := WriteNocloudSeed(path, seed); err == nil {
+		t.Error("WriteNocloudSeed succeeded on a disk without free space")
+	}
+}
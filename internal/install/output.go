@@ -0,0 +1,110 @@
+//go:build linux
+
+package install
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// installerTailLines is the number of last installer output lines repeated
+// in the error when the installer fails.
+const installerTailLines = 10
+
+// installerOutput passes the installer's stderr through, keeps its last
+// lines and notes failures that have a known remedy.
+type installerOutput struct {
+	// mu guards the fields below: processes the installer left behind may
+	// still write to its stderr after install mode stopped waiting for it.
+	mu sync.Mutex
+
+	noSpace bool     // the installer ran out of space in the raw image
+	tail    []string // last installerTailLines non-empty lines
+}
+
+// copy copies the installer's stderr from r to w line by line until r is
+// closed.
+func (o *installerOutput) copy(w io.Writer, r io.Reader) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		fmt.Fprintln(w, line)
+		if strings.TrimSpace(line) != "" {
+			o.mu.Lock()
+			if len(o.tail) == installerTailLines {
+				o.tail = o.tail[1:]
+			}
+			o.tail = append(o.tail, line)
+			o.mu.Unlock()
+		}
+		if strings.Contains(strings.ToLower(line), "no space left on device") {
+			o.mu.Lock()
+			o.noSpace = true
+			o.mu.Unlock()
+		}
+	}
+	// Keep draining after an overlong line, the installer must not block.
+	_, _ = io.Copy(w, r)
+}
+
+// failureHint returns advice for the failure seen in the output, or "".
+func (o *installerOutput) failureHint(sizeGiB uint64) string {
+	o.mu.Lock()
+	noSpace := o.noSpace
+	o.mu.Unlock()
+	if noSpace {
+		return fmt.Sprintf("the installer ran out of space in the %d GiB raw image, "+
+			"images with many system extensions need more: retry with a larger -image-size-gib, e.g. -image-size-gib %d",
+			sizeGiB, sizeGiB*2)
+	}
+	return ""
+}
+
+// lines returns a copy of the last lines of output.
+func (o *installerOutput) lines() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.tail)
+}
+
+// lastError returns the last line of tail that reports an error, or the last
+// line if none does: installers print the proximate cause last.
+func lastError(tail []string) string {
+	for i := len(tail) - 1; i >= 0; i-- {
+		lower := strings.ToLower(tail[i])
+		if strings.Contains(lower, "error") || strings.Contains(lower, "failed") || strings.Contains(lower, "fatal") {
+			return strings.TrimSpace(tail[i])
+		}
+	}
+	if len(tail) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(tail[len(tail)-1])
+}
+
+// failure describes the failed installer run for the final error: the exit
+// status with the last error line, the last lines of output and the remedy
+// if one is known.
+func (o *installerOutput) failure(status string, sizeGiB uint64) string {
+	tail := o.lines()
+	var b strings.Builder
+	b.WriteString("installer " + status)
+	if line := lastError(tail); line != "" {
+		b.WriteString(": " + line)
+	}
+	if len(tail) > 0 {
+		fmt.Fprintf(&b, "\nlast %d lines of installer output:", len(tail))
+		for _, line := range tail {
+			b.WriteString("\n  " + line)
+		}
+	}
+	if hint := o.failureHint(sizeGiB); hint != "" {
+		b.WriteString("\n" + hint)
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+//go:build linux
+
+package install
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/rootfs"
+)
+
+// CheckOverlay checks that dir can be copied over the installer rootfs: it
+// must be a directory of directories, regular files and symlinks.
+func CheckOverlay(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.Newf("%s is not a directory", dir)
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch d.Type() {
+		case 0, fs.ModeDir, fs.ModeSymlink:
+			return nil
+		}
+		return errors.Newf("%s is not a directory, regular file or symlink", path)
+	})
+}
+
+// applyOverlay copies the tree at dir over the installer rootfs at root,
+// replacing files that exist. Symlinks of the rootfs and of the overlay are
+// resolved within root, as the chrooted installer resolves them; relative
+// symlinks of the overlay that climb above root are refused.
+func applyOverlay(dir, root string) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return errors.Wrap(err, "resolve installer rootfs")
+	}
+	var files int
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.Type() == fs.ModeDir {
+			// A directory may be a symlink in the rootfs, e.g. lib ->
+			// usr/lib: merge into where it points.
+			target, err := rootfs.Resolve(root, rel)
+			if err != nil {
+				return err
+			}
+			return errors.Wrap(os.MkdirAll(target, info.Mode().Perm()), "create directory")
+		}
+
+		// Files and symlinks replace the last component, but not what the
+		// directories above them point to.
+		parent, err := rootfs.Resolve(root, filepath.Dir(rel))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(parent, filepath.Base(rel))
+
+		if d.Type() == fs.ModeSymlink {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(link) && !rootfs.WithinDir(root, filepath.Join(parent, link)) {
+				return errors.Newf("symlink %s -> %s escapes the installer rootfs", rel, link)
+			}
+			_ = os.Remove(target)
+			files++
+			return errors.Wrap(os.Symlink(link, target), "create symlink")
+		}
+		files++
+		return copyOverlayFile(path, target, info.Mode().Perm())
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("copied %d files from overlay %s into the installer rootfs", files, dir)
+	return nil
+}
+
+// copyOverlayFile copies the regular file src to dst with mode, replacing
+// dst.
+func copyOverlayFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "open overlay file")
+	}
+	defer in.Close()
+	_ = os.Remove(dst) // do not write through a symlink or into a running binary
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return errors.Wrap(err, "create file")
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return errors.Wrapf(err, "copy %s", src)
+	}
+	if err := out.Close(); err != nil {
+		return errors.Wrapf(err, "copy %s", src)
+	}
+	return errors.Wrap(os.Chmod(dst, mode), "chmod")
+}
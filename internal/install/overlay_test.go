@@ -0,0 +1,96 @@
+//go:build linux
+
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyOverlay(t *testing.T) {
+	// newRootfs returns an installer rootfs with lib -> usr/lib and an
+	// absolute bin -> /usr/bin that points out of it on the host.
+	newRootfs := func(t *testing.T) string {
+		t.Helper()
+		root := t.TempDir()
+		for _, dir := range []string{"usr/lib", "usr/bin"} {
+			if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(root, "usr/bin/installer"), []byte("old"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("usr/lib", filepath.Join(root, "lib")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("/usr/bin", filepath.Join(root, "bin")); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	write := func(t *testing.T, dir, name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("files and symlinks", func(t *testing.T) {
+		root, overlay := newRootfs(t), t.TempDir()
+		write(t, overlay, "lib/modules/extra/driver.ko", "module")
+		write(t, overlay, "usr/bin/installer", "new")
+		write(t, overlay, "bin/tool", "tool")
+		if err := os.Symlink("../lib/modules", filepath.Join(overlay, "usr/modules")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("/usr/lib/modules", filepath.Join(overlay, "modules")); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckOverlay(overlay); err != nil {
+			t.Fatalf("CheckOverlay() error = %v", err)
+		}
+		if err := applyOverlay(overlay, root); err != nil {
+			t.Fatalf("applyOverlay() error = %v", err)
+		}
+		for path, want := range map[string]string{
+			"usr/lib/modules/extra/driver.ko": "module",
+			"usr/bin/installer":               "new",
+			"usr/modules/extra/driver.ko":     "module",
+			"usr/bin/tool":                    "tool",
+		} {
+			got, err := os.ReadFile(filepath.Join(root, path))
+			if err != nil || string(got) != want {
+				t.Errorf("%s = %q, %v, want %q", path, got, err, want)
+			}
+		}
+		if link, err := os.Readlink(filepath.Join(root, "modules")); err != nil || link != "/usr/lib/modules" {
+			t.Errorf("modules -> %q, %v, want the absolute symlink kept", link, err)
+		}
+	})
+
+	t.Run("escaping symlink", func(t *testing.T) {
+		root, overlay := newRootfs(t), t.TempDir()
+		if err := os.Symlink("../../../etc/passwd", filepath.Join(overlay, "passwd")); err != nil {
+			t.Fatal(err)
+		}
+		err := applyOverlay(overlay, root)
+		if err == nil || !strings.Contains(err.Error(), "installer rootfs") {
+			t.Fatalf("applyOverlay() error = %v, want an escape error", err)
+		}
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "overlay")
+		write(t, filepath.Dir(file), "overlay", "")
+		if err := CheckOverlay(file); err == nil {
+			t.Fatal("CheckOverlay() of a file succeeded")
+		}
+	})
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package install
+
+import (
+	"log"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/status"
+)
+
+// Reboot methods used once the image is written.
+const (
+	RebootAuto    = "auto"    // reboot(2), falling back to sysrq
+	RebootSyscall = "syscall" // reboot(2) with LINUX_REBOOT_CMD_RESTART
+	RebootSysrq   = "sysrq"   // echo b > /proc/sysrq-trigger
+	RebootNone    = "none"    // leave the host running for a manual reboot
+)
+
+// RebootMethods lists the valid -reboot-method values.
+//
+//nolint:gochecknoglobals
+var RebootMethods = []string{RebootAuto, RebootSyscall, RebootSysrq, RebootNone}
+
+// reboot restarts the machine into the installed image. It returns only for
+// RebootNone; if every method fails, it exits asking for a manual reboot.
+func reboot(method string) {
+	if method == "" {
+		method = RebootAuto
+	}
+	status.SetPhase(status.PhaseRebooting, method)
+	status.Stop()
+	if method == RebootNone {
+		log.Print("not rebooting (-reboot-method none); reboot the machine to start Talos")
+		return
+	}
+	log.Print("rebooting system")
+	unix.Sync()
+
+	var errs error
+	if method != RebootSysrq {
+		err := unix.Reboot(unix.LINUX_REBOOT_CMD_RESTART)
+		errs = errors.CombineErrors(errs, errors.Wrap(err, "reboot(2)"))
+		if method == RebootAuto {
+			log.Printf("warning: %v, trying sysrq", err)
+		}
+	}
+	if method != RebootSyscall {
+		err := os.WriteFile("/proc/sysrq-trigger", []byte("b"), 0)
+		if err == nil {
+			err = errors.New("still running after the trigger")
+		}
+		errs = errors.CombineErrors(errs, errors.Wrap(err, "sysrq"))
+	}
+	cli.Fatalf(cli.ExitReboot, "failed to reboot: %v; the image is written, reboot the machine manually", errs)
+}
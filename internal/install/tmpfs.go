@@ -0,0 +1,176 @@
+//go:build linux
+
+package install
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+	"github.com/cozystack/boot-to-talos/internal/host"
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
+	"github.com/cozystack/boot-to-talos/internal/types"
+)
+
+// Tmpfs handling for the installer work directory.
+const (
+	TmpfsAuto = ""    // size the tmpfs from the image and available memory
+	TmpfsOff  = "off" // never use tmpfs, work in a disk-backed directory
+)
+
+const (
+	// installerRootfsSize is a generous estimate of the unpacked installer
+	// container image.
+	installerRootfsSize = 1 << 30
+	// tmpfsHeadroom is kept free in the tmpfs and in memory.
+	tmpfsHeadroom = 512 << 20
+	// diskTempDir is where the work directory goes when tmpfs is not used
+	// and no temp directory was configured.
+	diskTempDir = "/var/tmp"
+)
+
+//nolint:gochecknoglobals
+var tmpfsSizeRe = regexp.MustCompile(`^[0-9]+[kKmMgG%]?$`)
+
+// ValidTmpfsSize reports whether s is a valid -tmpfs-size value: TmpfsAuto,
+// TmpfsOff or a tmpfs size= option such as 4G or 50%.
+func ValidTmpfsSize(s string) bool {
+	return s == TmpfsAuto || s == TmpfsOff || tmpfsSizeRe.MatchString(s)
+}
+
+// workDir describes where install assets are unpacked.
+type workDir struct {
+	tmpfs  bool
+	size   string // tmpfs size= option, empty for the kernel default
+	reason string // why a disk-backed directory is used
+}
+
+func (w workDir) String() string {
+	switch {
+	case !w.tmpfs:
+		return fmt.Sprintf("disk-backed directory in %s (%s)", diskWorkDirBase(), w.reason)
+	case w.size != "":
+		return "tmpfs, size " + w.size
+	default:
+		return "tmpfs"
+	}
+}
+
+// tmpfsNeeds estimates the space install assets of the source need in the
+// work directory. Disk images are streamed and need none.
+func tmpfsNeeds(source types.ImageSource, sizeGiB uint64) uint64 {
+	if source.Type() != types.ImageSourceContainer {
+		return 0
+	}
+	return sizeGiB<<30 + installerRootfsSize
+}
+
+// planWorkDir decides how to back the work directory for the -tmpfs-size
+// option, the space needed and the available memory (0 if unknown).
+func planWorkDir(opt string, needed, available uint64) workDir {
+	switch {
+	case opt == TmpfsOff:
+		return workDir{reason: "-tmpfs-size=off"}
+	case opt != TmpfsAuto:
+		return workDir{tmpfs: true, size: opt}
+	case needed == 0 || available == 0:
+		return workDir{tmpfs: true}
+	case needed+2*tmpfsHeadroom > available:
+		return workDir{reason: fmt.Sprintf("needs %s, only %s of memory available",
+			host.FormatBytes(int64(needed)), host.FormatBytes(int64(available)))}
+	}
+	return workDir{tmpfs: true, size: fmt.Sprintf("%dk", (needed+tmpfsHeadroom)>>10)}
+}
+
+// chooseWorkDir plans the work directory for the source and warns if a
+// disk-backed directory lacks the space needed.
+func chooseWorkDir(opt string, source types.ImageSource, sizeGiB uint64) workDir {
+	available, err := host.MemAvailable()
+	if err != nil {
+		log.Printf("warning: cannot determine available memory: %v", err)
+	}
+	needed := tmpfsNeeds(source, sizeGiB)
+	w := planWorkDir(opt, needed, available)
+	if !w.tmpfs && needed > 0 {
+		base := diskWorkDirBase()
+		free, err := host.DiskFree(base)
+		switch {
+		case err != nil:
+			log.Printf("warning: cannot determine free space in %s: %v", base, err)
+		case free < needed:
+			log.Printf("warning: %s has %s free, the installer needs about %s; use -temp-dir to pick another directory",
+				base, host.FormatBytes(int64(free)), host.FormatBytes(int64(needed)))
+		}
+	}
+	return w
+}
+
+// diskWorkDirBase returns the directory disk-backed work directories are
+// created in: the configured temp directory, or diskTempDir as /tmp is often
+// a small tmpfs.
+func diskWorkDirBase() string {
+	if tempdir.Configured() {
+		return tempdir.Dir()
+	}
+	return diskTempDir
+}
+
+// checkImageDirs refuses targets holding a path the image is read from or
+// passes through before it is written: a local image file, the temp
+// directory downloads and extracted archives go to, the download cache and a
+// disk-backed work directory. Writing the target would destroy the image
+// halfway through the copy.
+func checkImageDirs(targets []string, source types.ImageSource, w workDir, cacheDir string) error {
+	type imagePath struct{ path, what, remedy string }
+	const tempRemedy = "use -temp-dir to move them to another disk or a tmpfs"
+	var paths []imagePath
+	if image := localImage(source); image != "" {
+		paths = append(paths, imagePath{image, "the image", "copy it to another disk or a tmpfs first"})
+	}
+	if source.Type() != types.ImageSourceContainer {
+		paths = append(paths, imagePath{tempdir.Dir(), "the temporary files of the image", tempRemedy})
+	}
+	if cacheDir != "" {
+		paths = append(paths, imagePath{cacheDir, "the download cache", "move -cache-dir to another disk or use -no-cache"})
+	}
+	if !w.tmpfs {
+		paths = append(paths, imagePath{diskWorkDirBase(), "the temporary files of the image", tempRemedy})
+	}
+	for _, target := range targets {
+		for _, p := range paths {
+			if reason := blockdev.Holds(target, p.path); reason != "" {
+				return errors.Newf("%s holds %s (%s); %s", target, p.what, reason, p.remedy)
+			}
+		}
+	}
+	return nil
+}
+
+// localImage returns the local file source reads a RAW or ISO image from, or
+// "" for container images, downloads and stdin.
+func localImage(source types.ImageSource) string {
+	if source.Type() == types.ImageSourceContainer {
+		return ""
+	}
+	ref := source.Reference()
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return ""
+	}
+	if fi, err := os.Stat(ref); err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	return ref
+}
+
+// mkdirWorkDir creates the work directory.
+func mkdirWorkDir(w workDir) (string, error) {
+	if w.tmpfs {
+		return tempdir.MkdirTemp("installer-*")
+	}
+	return os.MkdirTemp(diskWorkDirBase(), "installer-*")
+}
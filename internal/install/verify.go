@@ -0,0 +1,63 @@
+//go:build linux
+
+package install
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/efi"
+)
+
+// verifiedArgPrefixes are the kernel args the installed UKI is checked for:
+// without them the node does not come up on the configured network or
+// console.
+//
+//nolint:gochecknoglobals
+var verifiedArgPrefixes = []string{"ip=", "bond=", "vlan=", "bridge=", "talos.hostname=", "console="}
+
+// verifyCmdline warns if the kernel command line of the Talos UKI installed
+// on disk lacks any of the network and console args in extraArgs. GRUB-based
+// images are not checked.
+func verifyCmdline(disk string, extraArgs []string) {
+	var want []string
+	for _, arg := range extraArgs {
+		if slices.ContainsFunc(verifiedArgPrefixes, func(p string) bool { return strings.HasPrefix(arg, p) }) {
+			want = append(want, arg)
+		}
+	}
+	if len(want) == 0 {
+		return
+	}
+
+	ukiPath, cmdline, err := efi.UKICmdline(disk)
+	if errors.Is(err, efi.ErrNoUKI) {
+		return
+	}
+	if err != nil {
+		log.Printf("warning: cannot verify the kernel args of the installed image: %v", err)
+		return
+	}
+	if missing := missingArgs(cmdline, want); len(missing) > 0 {
+		log.Printf("warning: the kernel command line of %s lacks %s; the node may not come up with the configured network or console. "+
+			"UKI images take their kernel args from the image, e.g. from an Image Factory schematic",
+			ukiPath, strings.Join(missing, " "))
+		return
+	}
+	log.Printf("verified kernel args in %s: %s", ukiPath, strings.Join(want, " "))
+}
+
+// missingArgs returns the args in want that are not on cmdline.
+func missingArgs(cmdline string, want []string) []string {
+	have := strings.Fields(cmdline)
+	var missing []string
+	for _, arg := range want {
+		if !slices.Contains(have, arg) {
+			missing = append(missing, arg)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package install
+
+import (
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/blockdev"
+	"github.com/cozystack/boot-to-talos/internal/host"
+)
+
+// volumeName describes a blockdev.VolumeKind for messages.
+func volumeName(kind string) string {
+	if kind == blockdev.VolumeMD {
+		return "md RAID array"
+	}
+	return "LVM logical volume"
+}
+
+// checkVolume checks that the md RAID array or LVM logical volume disk has
+// room for an image of imageSize bytes (0 if not known yet). Whether it is
+// in use was checked with the target.
+func checkVolume(disk string, imageSize int64) error {
+	size, err := deviceSize(disk)
+	if err != nil {
+		return err
+	}
+	if imageSize > 0 && size < imageSize {
+		return errors.Newf("%s (%s) is too small for the %s image", disk, host.FormatBytes(size), host.FormatBytes(imageSize))
+	}
+	return nil
+}
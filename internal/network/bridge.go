@@ -0,0 +1,163 @@
+//go:build linux
+
+package network
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Bridge VLAN netlink constants (linux/if_bridge.h, linux/rtnetlink.h), not
+// in x/sys/unix.
+const (
+	iflaBridgeVLANInfo     = 2      // IFLA_BRIDGE_VLAN_INFO
+	bridgeVLANInfoPVID     = 1 << 1 // BRIDGE_VLAN_INFO_PVID
+	bridgeVLANInfoUntagged = 1 << 2 // BRIDGE_VLAN_INFO_UNTAGGED
+	rtextFilterBRVLAN      = 1 << 1 // RTEXT_FILTER_BRVLAN
+)
+
+// BridgeVLAN is a VLAN entry of a bridge port, or of the bridge itself for
+// the host's own traffic.
+type BridgeVLAN struct {
+	VID      uint16 `json:"vid"`
+	PVID     bool   `json:"pvid,omitempty"`     // untagged ingress frames are put into this VLAN
+	Untagged bool   `json:"untagged,omitempty"` // egress frames leave untagged
+}
+
+// pvid returns the PVID among vlans, or 0 if there is none.
+func pvid(vlans []BridgeVLAN) uint16 {
+	for _, v := range vlans {
+		if v.PVID {
+			return v.VID
+		}
+	}
+	return 0
+}
+
+// BridgeUplinkVLAN returns the VLAN that the host's untagged traffic on a
+// VLAN-aware bridge carries on the uplink port, or 0 if it leaves the
+// uplink untagged. This is the case for the management address of Proxmox
+// VLAN-aware bridges with "bridge-pvid" set, where Talos has to put the
+// address on a VLAN of the uplink instead.
+func BridgeUplinkVLAN(bridge, uplink *LinkInfo) uint16 {
+	if bridge == nil || uplink == nil || !bridge.IsBridge() || !bridge.VLANFiltering {
+		return 0
+	}
+	// The uplink must be a port of the bridge (a bond, not its slaves).
+	if uplink.MasterIndex != bridge.Index {
+		return 0
+	}
+
+	vid := pvid(bridge.BridgeVLANs)
+	if vid == 0 {
+		return 0
+	}
+	for _, v := range uplink.BridgeVLANs {
+		if v.VID == vid && !v.Untagged {
+			return vid
+		}
+	}
+	return 0
+}
+
+// decodeBridgeVLANFiltering reports whether the IFLA_INFO_DATA of a bridge
+// has VLAN filtering enabled.
+func decodeBridgeVLANFiltering(data []byte) (bool, error) {
+	decoder, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return false, err
+	}
+
+	filtering := false
+	for decoder.Next() {
+		if decoder.Type() == unix.IFLA_BR_VLAN_FILTERING {
+			filtering = decoder.Uint8() == 1
+		}
+	}
+
+	return filtering, decoder.Err()
+}
+
+// collectBridgeVLANs returns the VLAN entries of bridges and bridge ports by
+// link index (like "bridge vlan show").
+func collectBridgeVLANs() (map[uint32][]BridgeVLAN, error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error dialing netlink socket")
+	}
+	defer conn.Close()
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.IFLA_EXT_MASK, rtextFilterBRVLAN)
+	attrs, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+	ifinfo := make([]byte, unix.SizeofIfInfomsg)
+	ifinfo[0] = unix.AF_BRIDGE
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETLINK,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append(ifinfo, attrs...),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error dumping bridge VLANs")
+	}
+
+	vlans := make(map[uint32][]BridgeVLAN)
+	for _, m := range msgs {
+		if len(m.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		index := binary.NativeEndian.Uint32(m.Data[4:8])
+		entries, err := decodeBridgeVLANs(m.Data[unix.SizeofIfInfomsg:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding bridge VLANs of link %d", index)
+		}
+		if len(entries) > 0 {
+			vlans[index] = entries
+		}
+	}
+
+	return vlans, nil
+}
+
+// decodeBridgeVLANs decodes the IFLA_BRIDGE_VLAN_INFO entries nested in the
+// IFLA_AF_SPEC attribute of an AF_BRIDGE link message.
+func decodeBridgeVLANs(data []byte) ([]BridgeVLAN, error) {
+	decoder, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var vlans []BridgeVLAN
+	for decoder.Next() {
+		if decoder.Type() != unix.IFLA_AF_SPEC {
+			continue
+		}
+		decoder.Nested(func(nad *netlink.AttributeDecoder) error {
+			for nad.Next() {
+				b := nad.Bytes()
+				if nad.Type() != iflaBridgeVLANInfo || len(b) < 4 {
+					continue
+				}
+				// struct bridge_vlan_info { __u16 flags; __u16 vid; }
+				flags := binary.NativeEndian.Uint16(b[0:2])
+				vlans = append(vlans, BridgeVLAN{
+					VID:      binary.NativeEndian.Uint16(b[2:4]),
+					PVID:     flags&bridgeVLANInfoPVID != 0,
+					Untagged: flags&bridgeVLANInfoUntagged != 0,
+				})
+			}
+			return nil
+		})
+	}
+
+	return vlans, decoder.Err()
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package network
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestBridgeUplinkVLAN(t *testing.T) {
+	self := []BridgeVLAN{{VID: 100, PVID: true, Untagged: true}}
+	tests := []struct {
+		name      string
+		filtering bool
+		bridge    []BridgeVLAN
+		port      []BridgeVLAN
+		master    uint32
+		want      uint16
+	}{
+		{"tagged on uplink", true, self, []BridgeVLAN{{VID: 100}, {VID: 200}}, 10, 100},
+		{"untagged on uplink", true, self, []BridgeVLAN{{VID: 100, PVID: true, Untagged: true}}, 10, 0},
+		{"not VLAN-aware", false, self, []BridgeVLAN{{VID: 100}}, 10, 0},
+		{"no bridge PVID", true, []BridgeVLAN{{VID: 100}}, []BridgeVLAN{{VID: 100}}, 10, 0},
+		{"VLAN not on uplink", true, self, []BridgeVLAN{{VID: 200}}, 10, 0},
+		{"uplink not a port", true, self, []BridgeVLAN{{VID: 100}}, 11, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bridge := &LinkInfo{Name: "vmbr0", Index: 10, Kind: "bridge", VLANFiltering: tt.filtering, BridgeVLANs: tt.bridge}
+			uplink := &LinkInfo{Name: "eno1", Index: 2, Type: 1, MasterIndex: tt.master, SlaveKind: "bridge", BridgeVLANs: tt.port}
+			if got := BridgeUplinkVLAN(bridge, uplink); got != tt.want {
+				t.Errorf("BridgeUplinkVLAN() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBridgeVLANs(t *testing.T) {
+	entry := func(flags, vid uint16) []byte {
+		b := make([]byte, 4)
+		binary.NativeEndian.PutUint16(b[0:2], flags)
+		binary.NativeEndian.PutUint16(b[2:4], vid)
+		return b
+	}
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, "eno1")
+	ae.Nested(unix.IFLA_AF_SPEC, func(nae *netlink.AttributeEncoder) error {
+		nae.Uint16(0, 0) // IFLA_BRIDGE_FLAGS
+		nae.Bytes(iflaBridgeVLANInfo, entry(bridgeVLANInfoPVID|bridgeVLANInfoUntagged, 1))
+		nae.Bytes(iflaBridgeVLANInfo, entry(0, 100))
+		return nil
+	})
+	data, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	got, err := decodeBridgeVLANs(data)
+	if err != nil {
+		t.Fatalf("decodeBridgeVLANs error: %v", err)
+	}
+	want := []BridgeVLAN{{VID: 1, PVID: true, Untagged: true}, {VID: 100}}
+	if !slices.Equal(got, want) {
+		t.Errorf("decodeBridgeVLANs() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package network
+
+import (
+	"fmt"
+	"strings"
+)
+
+// localConsole is the console on the attached screen.
+const localConsole = "tty0"
+
+// askConsole asks for the serial console and whether to keep the local
+// console alongside it, and returns the console= arguments. With
+// opts.NoConsole no console= argument is added and nothing is asked.
+func askConsole(opts Options) []string {
+	p := opts.prompter()
+	if opts.NoConsole {
+		fmt.Fprintln(p.Out, "Serial console: none (-no-console)")
+		return nil
+	}
+	serial := strings.TrimSpace(p.Ask("Configure serial console? (or 'no')", "ttyS0"))
+	if serial == "" {
+		serial = "ttyS0"
+	}
+	if noConsole(serial) {
+		return nil
+	}
+	if consoleDevice(serial) == localConsole {
+		return consoleArgs(serial, false, false)
+	}
+
+	keepLocal := p.AskYesNo("Also keep the local console ("+localConsole+")?", false)
+	localPrimary := false
+	if keepLocal {
+		primary := p.Ask("Primary console, which gets /dev/console (serial or "+localConsole+")", "serial")
+		localPrimary = strings.EqualFold(primary, localConsole)
+	}
+	return consoleArgs(serial, keepLocal, localPrimary)
+}
+
+// noConsole reports whether a console answer declines the serial console.
+func noConsole(answer string) bool {
+	switch strings.ToLower(answer) {
+	case "no", "n", "none", "off":
+		return true
+	}
+	return false
+}
+
+// consoleArgs returns the console= arguments for the serial console and,
+// with keepLocal, tty0. The kernel uses the last console= as /dev/console,
+// so the primary console goes last.
+func consoleArgs(serial string, keepLocal, localPrimary bool) []string {
+	if !keepLocal || consoleDevice(serial) == localConsole {
+		return []string{"console=" + serial}
+	}
+	if localPrimary {
+		return []string{"console=" + serial, "console=" + localConsole}
+	}
+	return []string{"console=" + localConsole, "console=" + serial}
+}
+
+// consoleDevice returns the device of a console= value, without options
+// such as the baud rate (ttyS0,115200n8).
+func consoleDevice(value string) string {
+	device, _, _ := strings.Cut(value, ",")
+	return device
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package network
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConsoleArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		serial       string
+		keepLocal    bool
+		localPrimary bool
+		want         []string
+	}{
+		{"serial only", "ttyS0", false, false, []string{"console=ttyS0"}},
+		{"serial primary", "ttyS0,115200", true, false, []string{"console=tty0", "console=ttyS0,115200"}},
+		{"local primary", "ttyS1", true, true, []string{"console=ttyS1", "console=tty0"}},
+		{"no duplicate tty0", "tty0", true, true, []string{"console=tty0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := consoleArgs(tt.serial, tt.keepLocal, tt.localPrimary); !slices.Equal(got, tt.want) {
+				t.Errorf("consoleArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoConsole(t *testing.T) {
+	for _, answer := range []string{"no", "None", "n", "OFF"} {
+		if !noConsole(answer) {
+			t.Errorf("noConsole(%q) = false, want true", answer)
+		}
+	}
+	for _, answer := range []string{"ttyS0", "ttyS1,115200n8", "tty0", "nope"} {
+		if noConsole(answer) {
+			t.Errorf("noConsole(%q) = true, want false", answer)
+		}
+	}
+	if args := askConsole(Options{NoConsole: true}); args != nil {
+		t.Errorf("askConsole with NoConsole = %q, want none", args)
+	}
+}
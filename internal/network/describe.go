@@ -0,0 +1,221 @@
+//go:build linux
+
+package network
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jsimonetti/rtnetlink/v2"
+)
+
+// operStates names the RFC 2863 operational states (IF_OPER_*).
+//
+//nolint:gochecknoglobals
+var operStates = []string{"unknown", "notpresent", "down", "lowerlayerdown", "testing", "dormant", "up"}
+
+// OperStateString returns the name of an operational state as in
+// /sys/class/net/<iface>/operstate.
+func OperStateString(state rtnetlink.OperationalState) string {
+	if int(state) < len(operStates) {
+		return operStates[state]
+	}
+	return fmt.Sprintf("state %d", state)
+}
+
+// LinkDetails describes the bond, VLAN and bridge settings of link for the
+// link table of net-info.
+func LinkDetails(info *NetworkInfo, link *LinkInfo) string {
+	var parts []string
+	if link.BondMaster != nil {
+		b := link.BondMaster
+		parts = append(parts, "mode="+BondModeToString(b.Mode),
+			"xmit_hash_policy="+HashPolicyToString(b.HashPolicy),
+			"lacp_rate="+LACPRateToString(b.LACPRate),
+			fmt.Sprintf("miimon=%d", b.MIIMon))
+	}
+	if link.VLAN != nil {
+		parent := "?"
+		if p := info.GetLinkByIndex(link.LinkIndex); p != nil {
+			parent = p.Name
+		}
+		parts = append(parts, fmt.Sprintf("vid=%d on %s", link.VLAN.VID, parent))
+	}
+	if link.IsBridge() && link.VLANFiltering {
+		parts = append(parts, "vlan_filtering")
+	}
+	if len(link.BridgeVLANs) > 0 {
+		var vlans []string
+		for _, v := range link.BridgeVLANs {
+			vlan := fmt.Sprintf("%d", v.VID)
+			if v.PVID {
+				vlan += " pvid"
+			}
+			if v.Untagged {
+				vlan += " untagged"
+			}
+			vlans = append(vlans, vlan)
+		}
+		parts = append(parts, "vlans="+strings.Join(vlans, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// DescribeArgs renders the network kernel arguments among args (ip=, bond=,
+// vlan= and talos.hostname=) as one human-readable line per interface, for
+// summaries.
+func DescribeArgs(args []string) []string {
+	var lines []string
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "bond":
+			lines = append(lines, describeBond(value))
+		case "vlan":
+			name, parent, _ := strings.Cut(value, ":")
+			lines = append(lines, fmt.Sprintf("%s: VLAN on %s", name, parent))
+		case "ip":
+			lines = append(lines, describeIP(value))
+		case "talos.hostname":
+			lines = append(lines, "hostname: "+value)
+		}
+	}
+	return lines
+}
+
+// PrintSummary prints the network topology configured by args as summary
+// lines.
+//
+//nolint:forbidigo
+func PrintSummary(args []string) {
+	if selectedRoute != nil {
+		fmt.Printf("  Default route: %s\n", selectedRoute)
+	}
+	lines := DescribeArgs(args)
+	if len(lines) == 0 {
+		fmt.Println("  Network: (no kernel args, Talos uses DHCP)")
+		return
+	}
+	fmt.Println("  Network:")
+	for _, line := range lines {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// describeBond describes a bond=<name>:<slaves>:<options>[:<mtu>] value.
+func describeBond(value string) string {
+	fields := strings.Split(value, ":")
+	line := fields[0] + ": bond"
+	if len(fields) > 1 && fields[1] != "" {
+		line += " of " + strings.ReplaceAll(fields[1], ",", ", ")
+	}
+	if len(fields) > 2 && fields[2] != "" {
+		line += " (" + fields[2] + ")"
+	}
+	if len(fields) > 3 && fields[3] != "" {
+		line += ", MTU " + fields[3]
+	}
+	return line
+}
+
+// describeIP describes an ip= value, either a bare autoconf method (ip=dhcp)
+// or the <client>:<server>:<gw>:<netmask>:<hostname>:<device>:<autoconf>:<dns0>:<dns1>
+// form written by GenerateIPCmdline.
+func describeIP(value string) string {
+	fields := SplitIPArg(value)
+	if len(fields) == 1 {
+		return "all interfaces: " + value
+	}
+	field := func(i int) string {
+		if i < len(fields) {
+			return strings.Trim(fields[i], "[]")
+		}
+		return ""
+	}
+
+	device := field(5)
+	if device == "" {
+		device = "default interface"
+	}
+	var parts []string
+	if addr := field(0); addr != "" {
+		if mask := field(3); mask != "" {
+			addr += "/" + mask
+		}
+		parts = append(parts, addr)
+	}
+	if gw := field(2); gw != "" {
+		parts = append(parts, "via "+gw)
+	}
+	if autoconf := field(6); autoconf != "" && autoconf != "none" && autoconf != "off" {
+		parts = append(parts, autoconf)
+	}
+	if hostname := field(4); hostname != "" {
+		parts = append(parts, "hostname "+hostname)
+	}
+	var dns []string
+	for i := 7; i < len(fields); i++ {
+		if server := field(i); server != "" {
+			dns = append(dns, server)
+		}
+	}
+	if len(dns) > 0 {
+		parts = append(parts, "DNS "+strings.Join(dns, ", "))
+	}
+	return device + ": " + strings.Join(parts, ", ")
+}
+
+// SplitIPArg splits an ip= value on colons outside of brackets.
+func SplitIPArg(value string) []string {
+	var (
+		fields []string
+		depth  int
+		start  int
+	)
+	for i, c := range value {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				fields = append(fields, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, value[start:])
+}
+
+// nameMapping records how a host interface is named in Talos.
+type nameMapping struct {
+	Host  string // interface name on this host
+	Talos string // name in the generated kernel args, "" if Talos has no such link
+	Note  string // role of the interface, e.g. "bond slave"
+}
+
+// formatNameMappings renders names as "host → Talos" lines, followed by the
+// device ip= is put on.
+func formatNameMappings(names []nameMapping, ipDevice string) []string {
+	width := 0
+	for _, n := range names {
+		width = max(width, len(n.Host))
+	}
+	lines := make([]string, 0, len(names)+1)
+	for _, n := range names {
+		talos := n.Talos
+		if talos == "" {
+			talos = "(none)"
+		}
+		line := fmt.Sprintf("%-*s → %s", width, n.Host, talos)
+		if n.Note != "" {
+			line += " (" + n.Note + ")"
+		}
+		lines = append(lines, line)
+	}
+	return append(lines, "ip= goes on "+ipDevice)
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package network
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDescribeArgs(t *testing.T) {
+	args := []string{
+		"console=ttyS0",
+		"bond=bond0:enp1s0,enp2s0:mode=802.3ad,lacp_rate=fast:9000",
+		"vlan=bond0.100:bond0",
+		"ip=10.0.0.5::10.0.0.1:255.255.255.0:node1:bond0.100:none:1.1.1.1",
+		"ip=[2001:db8::5]::[2001:db8::1]:64::eth1:none",
+		"ip=dhcp",
+	}
+	want := []string{
+		"bond0: bond of enp1s0, enp2s0 (mode=802.3ad,lacp_rate=fast), MTU 9000",
+		"bond0.100: VLAN on bond0",
+		"bond0.100: 10.0.0.5/255.255.255.0, via 10.0.0.1, hostname node1, DNS 1.1.1.1",
+		"eth1: 2001:db8::5/64, via 2001:db8::1",
+		"all interfaces: dhcp",
+	}
+	if got := DescribeArgs(args); !slices.Equal(got, want) {
+		t.Errorf("DescribeArgs() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSplitIPArg(t *testing.T) {
+	got := SplitIPArg("[2001:db8::5]::[2001:db8::1]:64")
+	want := []string{"[2001:db8::5]", "", "[2001:db8::1]", "64"}
+	if !slices.Equal(got, want) {
+		t.Errorf("SplitIPArg() = %q, want %q", got, want)
+	}
+}
+
+func TestLinkDetails(t *testing.T) {
+	info := NewNetworkInfo([]LinkInfo{
+		{Name: "eno1", Index: 2, Type: 1},
+		{Name: "eno1.100", Index: 3, Kind: "vlan", LinkIndex: 2, VLAN: &VLANSpec{VID: 100}},
+		{Name: "bond0", Index: 4, Kind: "bond", BondMaster: &BondMasterSpec{Mode: BondMode8023AD, LACPRate: LACPRateFast, MIIMon: 100}},
+		{Name: "vmbr0", Index: 5, Kind: "bridge", VLANFiltering: true, BridgeVLANs: []BridgeVLAN{{VID: 1, PVID: true, Untagged: true}, {VID: 100}}},
+	})
+	tests := map[string]string{
+		"eno1":     "",
+		"eno1.100": "vid=100 on eno1",
+		"bond0":    "mode=802.3ad xmit_hash_policy=layer2 lacp_rate=fast miimon=100",
+		"vmbr0":    "vlan_filtering vlans=1 pvid untagged,100",
+	}
+	for name, want := range tests {
+		if got := LinkDetails(info, info.GetLinkByName(name)); got != want {
+			t.Errorf("LinkDetails(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFormatNameMappings(t *testing.T) {
+	names := []nameMapping{
+		{Host: "bond0", Talos: "bond0", Note: "bond"},
+		{Host: "eno1", Talos: "enx001122334455", Note: "slave of bond0"},
+		{Host: "vmbr0", Note: "bridge, flattened: its address moves to bond0"},
+	}
+	want := []string{
+		"bond0 → bond0 (bond)",
+		"eno1  → enx001122334455 (slave of bond0)",
+		"vmbr0 → (none) (bridge, flattened: its address moves to bond0)",
+		"ip= goes on bond0",
+	}
+	if got := formatNameMappings(names, "bond0"); !slices.Equal(got, want) {
+		t.Errorf("formatNameMappings() =\n%q\nwant\n%q", got, want)
+	}
+}
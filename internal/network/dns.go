@@ -0,0 +1,133 @@
+//go:build linux
+
+package network
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+)
+
+// resolvConfPaths lists resolver configs in order of preference. When
+// systemd-resolved is used, /etc/resolv.conf only points to the local stub
+// and the upstream servers are in /run/systemd/resolve/resolv.conf.
+//
+//nolint:gochecknoglobals
+var resolvConfPaths = []string{"/etc/resolv.conf", "/run/systemd/resolve/resolv.conf"}
+
+// maxCmdlineNameservers is the number of DNS servers the ip= argument can carry.
+const maxCmdlineNameservers = 2
+
+// ResolvConf holds the resolver settings relevant for Talos.
+type ResolvConf struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Search      []string `json:"search,omitempty"`
+}
+
+// ParseResolvConf parses resolv.conf content. As in glibc, the last
+// "search" or "domain" line wins.
+func ParseResolvConf(content string) ResolvConf {
+	var rc ResolvConf
+	for line := range strings.SplitSeq(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			rc.Nameservers = append(rc.Nameservers, fields[1])
+		case "search":
+			rc.Search = fields[1:]
+		case "domain":
+			rc.Search = fields[1:2]
+		}
+	}
+	return rc
+}
+
+// hasUpstreamNameserver reports whether any nameserver is not a loopback stub.
+func (rc ResolvConf) hasUpstreamNameserver() bool {
+	for _, ns := range rc.Nameservers {
+		if addr, err := netip.ParseAddr(ns); err == nil && !addr.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadResolvConf reads the resolver configuration of the running system,
+// skipping configs that only list a local stub resolver.
+func ReadResolvConf() ResolvConf {
+	var first *ResolvConf
+	for _, path := range resolvConfPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		rc := ParseResolvConf(string(data))
+		if rc.hasUpstreamNameserver() {
+			if len(rc.Search) == 0 && first != nil {
+				rc.Search = first.Search
+			}
+			return rc
+		}
+		if first == nil {
+			first = &rc
+		}
+	}
+	if first == nil {
+		return ResolvConf{}
+	}
+	return *first
+}
+
+// HostnameWithDomain appends the search domain to a short hostname. Talos
+// derives its resolver search domain from the domain part of the hostname.
+func HostnameWithDomain(hostname, domain string) string {
+	if hostname == "" || domain == "" || strings.Contains(hostname, ".") {
+		return hostname
+	}
+	return hostname + "." + strings.TrimSuffix(domain, ".")
+}
+
+// askDNS asks for DNS servers and search domains, using those of rc as
+// defaults, and returns the hostname (with the search domain appended) and
+// the DNS servers to put into ip=. Prompts are skipped when nothing was
+// detected.
+func askDNS(p cli.Prompter, hostname string, rc ResolvConf) (string, []string) {
+	var dns []string
+	if len(rc.Nameservers) > 0 {
+		servers := rc.Nameservers
+		if len(servers) > maxCmdlineNameservers {
+			servers = servers[:maxCmdlineNameservers]
+		}
+		answer := p.Ask("DNS servers (or 'none')", strings.Join(servers, " "))
+		if !strings.EqualFold(answer, "none") {
+			dns = strings.Fields(answer)
+		}
+		if len(dns) > maxCmdlineNameservers {
+			fmt.Fprintf(p.Out, "Only %d DNS servers can be passed on the kernel command line, ignoring: %s\n",
+				maxCmdlineNameservers, strings.Join(dns[maxCmdlineNameservers:], " "))
+			dns = dns[:maxCmdlineNameservers]
+		}
+	}
+
+	if len(rc.Search) > 0 {
+		answer := p.Ask("DNS search domains (or 'none')", strings.Join(rc.Search, " "))
+		if !strings.EqualFold(answer, "none") {
+			search := strings.Fields(answer)
+			if len(search) > 0 {
+				hostname = HostnameWithDomain(hostname, search[0])
+			}
+			if len(search) > 1 {
+				fmt.Fprintf(p.Out, "Only one search domain can be passed on the kernel command line, ignoring: %s\n",
+					strings.Join(search[1:], " "))
+			}
+		}
+	}
+
+	return hostname, dns
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    ResolvConf
+	}{
+		{
+			name:    "nameservers and search",
+			content: "# generated\nnameserver 10.0.0.1\nnameserver 10.0.0.2\nsearch cluster.local example.com\n",
+			want:    ResolvConf{Nameservers: []string{"10.0.0.1", "10.0.0.2"}, Search: []string{"cluster.local", "example.com"}},
+		},
+		{
+			name:    "domain",
+			content: "domain corp.example.com\nnameserver 192.168.1.1\n",
+			want:    ResolvConf{Nameservers: []string{"192.168.1.1"}, Search: []string{"corp.example.com"}},
+		},
+		{
+			name:    "last search line wins",
+			content: "search a.example\ndomain b.example\nsearch c.example d.example\n",
+			want:    ResolvConf{Search: []string{"c.example", "d.example"}},
+		},
+		{
+			name:    "comments and options",
+			content: "; comment\n#nameserver 1.1.1.1\noptions edns0 trust-ad\nnameserver 127.0.0.53\n",
+			want:    ResolvConf{Nameservers: []string{"127.0.0.53"}},
+		},
+		{
+			name:    "empty",
+			content: "",
+			want:    ResolvConf{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseResolvConf(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseResolvConf() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasUpstreamNameserver(t *testing.T) {
+	if (ResolvConf{Nameservers: []string{"127.0.0.53"}}).hasUpstreamNameserver() {
+		t.Error("systemd-resolved stub should not count as upstream nameserver")
+	}
+	if !(ResolvConf{Nameservers: []string{"127.0.0.53", "10.0.0.1"}}).hasUpstreamNameserver() {
+		t.Error("expected upstream nameserver to be detected")
+	}
+}
+
+func TestHostnameWithDomain(t *testing.T) {
+	tests := []struct {
+		hostname, domain, want string
+	}{
+		{"node1", "cluster.local", "node1.cluster.local"},
+		{"node1", "example.com.", "node1.example.com"},
+		{"node1.example.com", "cluster.local", "node1.example.com"},
+		{"node1", "", "node1"},
+		{"", "cluster.local", ""},
+	}
+
+	for _, tt := range tests {
+		if got := HostnameWithDomain(tt.hostname, tt.domain); got != tt.want {
+			t.Errorf("HostnameWithDomain(%q, %q) = %q, want %q", tt.hostname, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateIPCmdline(t *testing.T) {
+	tests := []struct {
+		name string
+		dns  []string
+		want string
+	}{
+		{"no dns", nil, "ip=10.0.0.5::10.0.0.1:255.255.255.0:node1:eth0:none"},
+		{"one dns", []string{"10.0.0.53"}, "ip=10.0.0.5::10.0.0.1:255.255.255.0:node1:eth0:none:10.0.0.53"},
+		{"extra dns dropped", []string{"1.1.1.1", "8.8.8.8", "9.9.9.9"}, "ip=10.0.0.5::10.0.0.1:255.255.255.0:node1:eth0:none:1.1.1.1:8.8.8.8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GenerateIPCmdline("10.0.0.5", "10.0.0.1", "255.255.255.0", "node1", "eth0", tt.dns...); got != tt.want {
+				t.Errorf("GenerateIPCmdline() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+//go:build linux
+
+package network
+
+import (
+	"net"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/host"
+)
+
+// Hostname sources for Options.HostnameFrom.
+const (
+	HostnameFromSystem = ""       // hostname of the running system
+	HostnameFromSerial = "serial" // talos-<DMI serial>, falling back to the MAC
+	HostnameFromMAC    = "mac"    // talos-<MAC of the primary interface>
+)
+
+// hostnamePrefix is prepended to generated hostnames.
+const hostnamePrefix = "talos-"
+
+// maxLabelLength is the maximum length of a DNS label.
+const maxLabelLength = 63
+
+// SanitizeHostname turns s into a valid DNS label: lowercase letters, digits
+// and dashes, no leading or trailing dash, at most 63 characters.
+func SanitizeHostname(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	label := strings.TrimRight(b.String(), "-")
+	if len(label) > maxLabelLength {
+		label = strings.TrimRight(label[:maxLabelLength], "-")
+	}
+	return label
+}
+
+// GenerateHostname returns a unique hostname derived from the chassis serial
+// or from the MAC address of iface, according to from.
+func GenerateHostname(from, iface string) (string, error) {
+	switch from {
+	case HostnameFromSerial:
+		if serial := host.SerialNumber(); serial != "" {
+			return SanitizeHostname(hostnamePrefix + serial), nil
+		}
+		return GenerateHostname(HostnameFromMAC, iface)
+	case HostnameFromMAC:
+		mac, err := getPermanentMAC(iface)
+		if err != nil || len(mac) == 0 {
+			ifc, ierr := net.InterfaceByName(iface)
+			if ierr != nil {
+				return "", errors.Wrapf(ierr, "get MAC address of %s", iface)
+			}
+			mac = ifc.HardwareAddr
+		}
+		if len(mac) == 0 {
+			return "", errors.Newf("%s has no MAC address", iface)
+		}
+		return SanitizeHostname(hostnamePrefix + strings.ReplaceAll(mac.String(), ":", "")), nil
+	default:
+		return "", errors.Newf("unknown hostname source %q (must be 'serial' or 'mac')", from)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHostname(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"talos-CZ2345ABCD", "talos-cz2345abcd"},
+		{"talos-VMware-42 1a 2b 3c", "talos-vmware-42-1a-2b-3c"},
+		{"talos-ABC_123/456..", "talos-abc-123-456"},
+		{"--node--", "node"},
+		{"talos-" + strings.Repeat("x", 80), "talos-" + strings.Repeat("x", 57)},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeHostname(tt.in); got != tt.want {
+			t.Errorf("SanitizeHostname(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateHostnameUnknownSource(t *testing.T) {
+	if _, err := GenerateHostname("uuid", "eth0"); err == nil {
+		t.Error("expected error for unknown hostname source")
+	}
+}
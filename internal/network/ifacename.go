@@ -0,0 +1,87 @@
+//go:build linux
+
+package network
+
+import (
+	"net"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// maxIfaceNameLen is the longest interface name the kernel accepts
+// (IFNAMSIZ without the terminating NUL).
+const maxIfaceNameLen = 15
+
+// ParseIfaceNames parses <mac>=<name> mappings that force the Talos name of
+// the interface with that MAC in the generated network arguments. Each MAC
+// and each name may only be given once.
+func ParseIfaceNames(specs []string) (map[string]string, error) {
+	names := map[string]string{}
+	macOf := map[string]string{}
+	for _, spec := range specs {
+		macStr, name, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, errors.Newf("%q: use <mac>=<name>", spec)
+		}
+		mac, err := net.ParseMAC(strings.TrimSpace(macStr))
+		if err != nil || len(mac) != 6 {
+			return nil, errors.Newf("%q: invalid MAC address %q", spec, macStr)
+		}
+		name = strings.TrimSpace(name)
+		if err := checkIfaceName(name); err != nil {
+			return nil, errors.Wrapf(err, "%q", spec)
+		}
+		key := mac.String()
+		if _, dup := names[key]; dup {
+			return nil, errors.Newf("MAC %s is mapped twice", key)
+		}
+		if other, dup := macOf[name]; dup {
+			return nil, errors.Newf("name %s is given to both %s and %s", name, other, key)
+		}
+		names[key] = name
+		macOf[name] = key
+	}
+	return names, nil
+}
+
+// checkIfaceName checks that name is usable as an interface name, in
+// particular as the device of ip= and the parent of vlan=.
+func checkIfaceName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("empty interface name")
+	case len(name) > maxIfaceNameLen:
+		return errors.Newf("interface name %s is longer than %d characters", name, maxIfaceNameLen)
+	case name == "." || name == "..":
+		return errors.Newf("invalid interface name %s", name)
+	case strings.ContainsAny(name, "/:,= \t"):
+		return errors.Newf("interface name %s contains one of / : , = or a space", name)
+	}
+	return nil
+}
+
+// linkMAC returns the MAC the Talos name of the host interface name is
+// derived from: the permanent MAC, or for interfaces other than bond slaves,
+// which carry the MAC of their bond, the current one. "" if there is none.
+func linkMAC(name string, bondSlave bool) string {
+	if mac, err := getPermanentMAC(name); err == nil && strings.Trim(mac.String(), "0:") != "" {
+		return mac.String()
+	}
+	if bondSlave {
+		return ""
+	}
+	if ifc, err := net.InterfaceByName(name); err == nil && len(ifc.HardwareAddr) > 0 {
+		return ifc.HardwareAddr.String()
+	}
+	return ""
+}
+
+// simpleName returns the Talos name of the host interface name for the
+// fallback without netlink: the one opts force for its MAC, else PrettyName.
+func simpleName(opts Options, name string) string {
+	if n, ok := opts.IfaceNames[linkMAC(name, false)]; ok {
+		return n
+	}
+	return PrettyName(name)
+}
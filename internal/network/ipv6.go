@@ -0,0 +1,111 @@
+//go:build linux
+
+package network
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+// ipv6RouteReject is the RTF_REJECT flag of unreachable routes.
+const ipv6RouteReject = 0x0200
+
+// DefaultRoute6 returns the IPv6 default route interface and gateway with
+// the lowest metric. It is queried via netlink, with /proc/net/ipv6_route as
+// a fallback.
+func DefaultRoute6() (iface, gw string, err error) {
+	routes, err := netlinkDefaultRoutes(unix.AF_INET6)
+	if err == nil {
+		if len(routes) == 0 {
+			return "", "", errors.New("no IPv6 default route")
+		}
+		return routes[0].Iface, routes[0].Gateway, nil
+	}
+	f, ferr := os.Open("/proc/net/ipv6_route")
+	if ferr != nil {
+		return "", "", errors.CombineErrors(err, errors.Wrap(ferr, "open /proc/net/ipv6_route"))
+	}
+	defer f.Close()
+	return parseDefaultRoute6(f)
+}
+
+// parseDefaultRoute6 finds the default route in /proc/net/ipv6_route content:
+// destination ::/0, skipping unreachable routes and the loopback device.
+func parseDefaultRoute6(r io.Reader) (iface, gw string, err error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		flds := strings.Fields(sc.Text())
+		if len(flds) < 10 || flds[0] != strings.Repeat("0", 32) || flds[1] != "00" || flds[9] == "lo" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(flds[8], 16, 32)
+		if flags&ipv6RouteReject != 0 {
+			continue
+		}
+		if nh := hexIPv6(flds[4]); nh != nil && !nh.IsUnspecified() {
+			gw = nh.String()
+		}
+		return flds[9], gw, nil
+	}
+	if err := sc.Err(); err != nil {
+		return "", "", errors.Wrap(err, "read /proc/net/ipv6_route")
+	}
+	return "", "", errors.New("no IPv6 default route")
+}
+
+// hexIPv6 decodes a 32 hex digit IPv6 address as used in /proc/net.
+func hexIPv6(h string) net.IP {
+	if len(h) != 32 {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i := range net.IPv6len {
+		b, err := strconv.ParseUint(h[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return nil
+		}
+		ip[i] = byte(b)
+	}
+	return ip
+}
+
+// IfaceAddr6 returns the global IPv6 address and prefix length of the named
+// interface. Link-local addresses are skipped.
+func IfaceAddr6(name string) (ip, prefix string, err error) {
+	ifc, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "interface %s", name)
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return "", "", errors.Wrapf(err, "addresses of %s", name)
+	}
+	return globalAddr6(addrs, name)
+}
+
+func globalAddr6(addrs []net.Addr, name string) (ip, prefix string, err error) {
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.To4() != nil || !n.IP.IsGlobalUnicast() {
+			continue
+		}
+		ones, _ := n.Mask.Size()
+		return n.IP.String(), strconv.Itoa(ones), nil
+	}
+	return "", "", errors.Newf("no global IPv6 address on %s", name)
+}
+
+// cmdlineAddr brackets IPv6 addresses for the colon-separated ip= argument.
+func cmdlineAddr(addr string) string {
+	if strings.Contains(addr, ":") {
+		return "[" + addr + "]"
+	}
+	return addr
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package network
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseDefaultRoute6(t *testing.T) {
+	routes := `20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     bond0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     bond0
+`
+	iface, gw, err := parseDefaultRoute6(strings.NewReader(routes))
+	if err != nil {
+		t.Fatalf("parseDefaultRoute6 error: %v", err)
+	}
+	if iface != "bond0" || gw != "fe80::1" {
+		t.Errorf("parseDefaultRoute6() = %q, %q, want bond0, fe80::1", iface, gw)
+	}
+
+	if _, _, err := parseDefaultRoute6(strings.NewReader(strings.SplitAfter(routes, "\n")[0])); err == nil {
+		t.Error("parseDefaultRoute6 should fail without a default route")
+	}
+}
+
+func TestGlobalAddr6(t *testing.T) {
+	mustCIDR := func(s string) net.Addr {
+		ip, n, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.IP = ip
+		return n
+	}
+	addrs := []net.Addr{mustCIDR("fe80::1/64"), mustCIDR("2001:db8::10/64")}
+	ip, prefix, err := globalAddr6(addrs, "bond0")
+	if err != nil || ip != "2001:db8::10" || prefix != "64" {
+		t.Errorf("globalAddr6() = %q, %q, %v, want 2001:db8::10, 64", ip, prefix, err)
+	}
+	if _, _, err := globalAddr6(addrs[:1], "bond0"); err == nil {
+		t.Error("globalAddr6 should ignore link-local addresses")
+	}
+}
+
+func TestGenerateIPCmdlineIPv6(t *testing.T) {
+	got := GenerateIPCmdline("2001:db8::10", "fe80::1", "64", "node1", "bond0", "2001:4860:4860::8888")
+	want := "ip=[2001:db8::10]::[fe80::1]:64:node1:bond0:none:[2001:4860:4860::8888]"
+	if got != want {
+		t.Errorf("GenerateIPCmdline() = %q, want %q", got, want)
+	}
+}
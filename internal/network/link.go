@@ -0,0 +1,91 @@
+//go:build linux
+
+package network
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// sysClassNet is the sysfs directory listing network interfaces.
+const sysClassNet = "/sys/class/net"
+
+// linkPollInterval is how often link state is polled while waiting.
+const linkPollInterval = 500 * time.Millisecond
+
+// LinkState is the state of an interface as reported by sysfs.
+type LinkState struct {
+	OperState string // operstate: up, down, dormant, lowerlayerdown, unknown, ...
+	Carrier   bool   // carrier is detected
+}
+
+// Ready reports whether the interface can pass traffic. Virtual interfaces
+// often report "unknown" while working fine.
+func (s LinkState) Ready() bool {
+	return s.Carrier && (s.OperState == "up" || s.OperState == "unknown")
+}
+
+func (s LinkState) String() string {
+	if s.OperState == "" {
+		return "unknown"
+	}
+	if !s.Carrier && s.OperState != "down" {
+		return s.OperState + ", no carrier"
+	}
+	return s.OperState
+}
+
+// GetLinkState returns the state of the named interface.
+func GetLinkState(name string) LinkState {
+	return linkState(sysClassNet, name)
+}
+
+func linkState(root, name string) LinkState {
+	base := filepath.Join(root, name)
+	state := LinkState{OperState: readSysfs(filepath.Join(base, "operstate"))}
+	// Reading carrier fails with EINVAL while the interface is down.
+	state.Carrier = readSysfs(filepath.Join(base, "carrier")) == "1"
+	return state
+}
+
+func readSysfs(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// WaitForLink waits up to timeout for a default route to appear and for its
+// interface to have carrier. It returns the last error if the network did
+// not come up in time.
+func WaitForLink(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	log.Printf("waiting up to %s for network link", timeout)
+	for {
+		err := linkReady()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(linkPollInterval)
+	}
+}
+
+func linkReady() error {
+	dev, _, err := DefaultRoute()
+	if err != nil {
+		return err
+	}
+	if state := GetLinkState(dev); !state.Ready() {
+		return errors.Newf("interface %s is not ready (%s)", dev, state)
+	}
+	return nil
+}
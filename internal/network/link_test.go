@@ -0,0 +1,53 @@
+//go:build linux
+
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkState(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, file, content string) {
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content+"\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("eth0", "operstate", "up")
+	write("eth0", "carrier", "1")
+	write("eth1", "operstate", "down")
+	write("eth2", "operstate", "lowerlayerdown")
+	write("eth2", "carrier", "0")
+	write("tun0", "operstate", "unknown")
+	write("tun0", "carrier", "1")
+
+	tests := []struct {
+		name      string
+		wantReady bool
+		wantStr   string
+	}{
+		{"eth0", true, "up"},
+		{"eth1", false, "down"},
+		{"eth2", false, "lowerlayerdown, no carrier"},
+		{"tun0", true, "unknown"},
+		{"missing", false, "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := linkState(root, tt.name)
+			if state.Ready() != tt.wantReady {
+				t.Errorf("Ready() = %v, want %v", state.Ready(), tt.wantReady)
+			}
+			if state.String() != tt.wantStr {
+				t.Errorf("String() = %q, want %q", state.String(), tt.wantStr)
+			}
+		})
+	}
+}
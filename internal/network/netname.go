@@ -0,0 +1,134 @@
+//go:build linux
+
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sysRoot is the root of sysfs.
+const sysRoot = "/sys"
+
+//nolint:gochecknoglobals
+var pciAddrRe = regexp.MustCompile(`^([0-9a-f]{4}):([0-9a-f]{2}):([0-9a-f]{2})\.([0-7])$`)
+
+// pciAddr is the address of a PCI function, domain:bus:slot.function.
+type pciAddr struct {
+	domain, bus, slot, function uint64
+}
+
+func parsePCIAddr(s string) (pciAddr, bool) {
+	m := pciAddrRe.FindStringSubmatch(s)
+	if m == nil {
+		return pciAddr{}, false
+	}
+	var a pciAddr
+	a.domain, _ = strconv.ParseUint(m[1], 16, 32)
+	a.bus, _ = strconv.ParseUint(m[2], 16, 8)
+	a.slot, _ = strconv.ParseUint(m[3], 16, 8)
+	a.function, _ = strconv.ParseUint(m[4], 16, 8)
+	return a, true
+}
+
+// predictableName derives the predictable name udev (and Talos) gives the
+// Ethernet interface iface from sysfs below sys, without udev data: eno<N>
+// for onboard devices, ens<N> for devices in a hotplug slot and
+// enp<bus>s<slot> from the PCI path otherwise, with a function suffix for
+// multi-function devices and a port suffix for devices with several ports
+// per function. Returns "" for interfaces not backed by a PCI device.
+func predictableName(sys, iface string) string {
+	device, err := filepath.EvalSymlinks(filepath.Join(sys, "class", "net", iface, "device"))
+	if err != nil {
+		return ""
+	}
+
+	// Virtio and similar devices sit below the PCI function.
+	pciDir := device
+	addr, ok := parsePCIAddr(filepath.Base(pciDir))
+	for !ok && pciDir != filepath.Dir(pciDir) {
+		pciDir = filepath.Dir(pciDir)
+		addr, ok = parsePCIAddr(filepath.Base(pciDir))
+	}
+	if !ok {
+		return ""
+	}
+
+	var port string
+	if n, err := strconv.ParseUint(readSysfs(filepath.Join(sys, "class", "net", iface, "dev_port")), 10, 32); err == nil && n > 0 {
+		port = fmt.Sprintf("d%d", n)
+	}
+
+	for _, attr := range []string{"acpi_index", "index"} {
+		if n, err := strconv.ParseUint(readSysfs(filepath.Join(pciDir, attr)), 10, 32); err == nil && n > 0 {
+			return fmt.Sprintf("eno%d%s", n, port)
+		}
+	}
+
+	var function string
+	if addr.function > 0 || multiFunction(pciDir, addr) {
+		function = fmt.Sprintf("f%d", addr.function)
+	}
+	if slot := hotplugSlot(sys, addr); slot != "" {
+		return "ens" + slot + function + port
+	}
+
+	var domain string
+	if addr.domain > 0 {
+		domain = fmt.Sprintf("P%d", addr.domain)
+	}
+	return fmt.Sprintf("en%sp%ds%d%s%s", domain, addr.bus, addr.slot, function, port)
+}
+
+// multiFunction reports whether the PCI device at pciDir has other
+// functions next to it.
+func multiFunction(pciDir string, addr pciAddr) bool {
+	entries, err := os.ReadDir(filepath.Dir(pciDir))
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if other, ok := parsePCIAddr(e.Name()); ok && other != addr &&
+			other.domain == addr.domain && other.bus == addr.bus && other.slot == addr.slot {
+			return true
+		}
+	}
+	return false
+}
+
+// hotplugSlot returns the name of the PCI hotplug slot holding the device
+// at addr, or "".
+func hotplugSlot(sys string, addr pciAddr) string {
+	slots := filepath.Join(sys, "bus", "pci", "slots")
+	entries, err := os.ReadDir(slots)
+	if err != nil {
+		return ""
+	}
+	want := fmt.Sprintf("%04x:%02x:%02x", addr.domain, addr.bus, addr.slot)
+	for _, e := range entries {
+		if readSysfs(filepath.Join(slots, e.Name(), "address")) == want {
+			if _, err := strconv.ParseUint(e.Name(), 10, 32); err == nil {
+				return e.Name()
+			}
+		}
+	}
+	return ""
+}
+
+// bondSlaveName returns the name to reference a bond slave by in the bond=
+// argument. Slaves carry the MAC of the bond, so unlike PrettyName it never
+// uses the current MAC: without a permanent MAC the name is derived from the
+// PCI path, and the kernel name is the last resort.
+func bondSlaveName(name string) string {
+	if mac, err := getPermanentMAC(name); err == nil && strings.Trim(mac.String(), "0:") != "" {
+		return macToInterfaceName(mac)
+	}
+	if predictable := predictableName(sysRoot, name); predictable != "" {
+		return predictable
+	}
+	return name
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sysNetFixture builds a fake sysfs tree with PCI network devices.
+type sysNetFixture struct {
+	t   *testing.T
+	sys string
+}
+
+// nic adds interface iface backed by the device at devPath below
+// devices/pci0000:00, with optional sysfs attributes of the PCI function.
+func (f sysNetFixture) nic(iface, devPath string, attrs map[string]string) {
+	f.t.Helper()
+	dev := filepath.Join(f.sys, "devices", "pci0000:00", devPath)
+	netDir := filepath.Join(f.sys, "class", "net", iface)
+	for _, dir := range []string{dev, netDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(dev, filepath.Join(netDir, "device")); err != nil {
+		f.t.Fatal(err)
+	}
+	for name, value := range attrs {
+		path := filepath.Join(dev, name)
+		if name == "dev_port" {
+			path = filepath.Join(netDir, name)
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+func (f sysNetFixture) slot(name, address string) {
+	f.t.Helper()
+	dir := filepath.Join(f.sys, "bus", "pci", "slots", name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "address"), []byte(address+"\n"), 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func TestPredictableName(t *testing.T) {
+	f := sysNetFixture{t: t, sys: t.TempDir()}
+	f.nic("eth0", "0000:00:1f.6", map[string]string{"acpi_index": "1"})
+	f.nic("eth1", "0000:00:1c.0/0000:03:00.0", nil)
+	f.nic("eth2", "0000:00:1c.0/0000:03:00.1", nil)
+	f.nic("eth3", "0000:00:1c.4/0000:05:00.0", map[string]string{"dev_port": "1"})
+	f.nic("eth4", "0000:00:03.0/virtio0", nil)
+	f.slot("3", "0000:00:03")
+	f.nic("eth5", "0000:00:1c.5/0000:3b:00.0", nil)
+	f.nic("eth6", "0000:00:1c.6/0000:06:00.0", map[string]string{"index": "2"})
+	if err := os.MkdirAll(filepath.Join(f.sys, "class", "net", "bond0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"eth0":  "eno1",     // onboard, ACPI index
+		"eth1":  "enp3s0f0", // first function of a dual-port card
+		"eth2":  "enp3s0f1",
+		"eth3":  "enp5s0d1", // second port sharing a function
+		"eth4":  "ens3",     // virtio in a hotplug slot
+		"eth5":  "enp59s0",
+		"eth6":  "eno2", // onboard, SMBIOS index
+		"bond0": "",     // no backing device
+		"nope":  "",
+	}
+	for iface, want := range tests {
+		if got := predictableName(f.sys, iface); got != want {
+			t.Errorf("predictableName(%s) = %q, want %q", iface, got, want)
+		}
+	}
+}
@@ -4,13 +4,18 @@ package network
 
 import (
 	"bufio"
+	"cmp"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/netip"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/jsimonetti/rtnetlink/v2"
@@ -49,39 +54,41 @@ const (
 
 // LinkInfo represents network interface information.
 type LinkInfo struct {
-	Name             string
-	Index            uint32
-	Type             uint16
-	LinkIndex        uint32 // Parent interface index (for VLAN, etc.)
-	Flags            uint32
-	HardwareAddr     net.HardwareAddr
-	MTU              uint32
-	MasterIndex      uint32
-	OperationalState rtnetlink.OperationalState
-	Kind             string
-	SlaveKind        string
-	BondMaster       *BondMasterSpec
-	VLAN             *VLANSpec
+	Name             string                     `json:"name"`
+	Index            uint32                     `json:"index"`
+	Type             uint16                     `json:"type,omitempty"`
+	LinkIndex        uint32                     `json:"linkIndex,omitempty"` // Parent interface index (for VLAN, etc.)
+	Flags            uint32                     `json:"flags,omitempty"`
+	HardwareAddr     net.HardwareAddr           `json:"-"` // Talos names derived from it are in Snapshot.Names
+	MTU              uint32                     `json:"mtu,omitempty"`
+	MasterIndex      uint32                     `json:"masterIndex,omitempty"`
+	OperationalState rtnetlink.OperationalState `json:"operState,omitempty"`
+	Kind             string                     `json:"kind,omitempty"`
+	SlaveKind        string                     `json:"slaveKind,omitempty"`
+	BondMaster       *BondMasterSpec            `json:"bond,omitempty"`
+	VLAN             *VLANSpec                  `json:"vlan,omitempty"`
+	VLANFiltering    bool                       `json:"vlanFiltering,omitempty"` // VLAN-aware bridge
+	BridgeVLANs      []BridgeVLAN               `json:"bridgeVLANs,omitempty"`   // VLANs of a VLAN-aware bridge or its ports
 }
 
 // VLANSpec represents VLAN configuration.
 type VLANSpec struct {
-	VID      uint16 // VLAN ID (1-4094)
-	Protocol uint16 // VLAN protocol (0x8100 for 802.1Q, 0x88a8 for 802.1ad)
+	VID      uint16 `json:"vid"`                // VLAN ID (1-4094)
+	Protocol uint16 `json:"protocol,omitempty"` // VLAN protocol (0x8100 for 802.1Q, 0x88a8 for 802.1ad)
 }
 
 // BondMasterSpec represents bond master configuration.
 type BondMasterSpec struct {
-	Mode         uint8
-	HashPolicy   uint8
-	LACPRate     uint8
-	MIIMon       uint32
-	UpDelay      uint32
-	DownDelay    uint32
-	ARPInterval  uint32
-	ARPIPTargets []netip.Addr
-	PrimaryIndex *uint32
-	UseCarrier   bool
+	Mode         uint8        `json:"mode"`
+	HashPolicy   uint8        `json:"hashPolicy,omitempty"`
+	LACPRate     uint8        `json:"lacpRate,omitempty"`
+	MIIMon       uint32       `json:"miimon,omitempty"`
+	UpDelay      uint32       `json:"updelay,omitempty"`
+	DownDelay    uint32       `json:"downdelay,omitempty"`
+	ARPInterval  uint32       `json:"arpInterval,omitempty"`
+	ARPIPTargets []netip.Addr `json:"arpIPTargets,omitempty"`
+	PrimaryIndex *uint32      `json:"primaryIndex,omitempty"`
+	UseCarrier   bool         `json:"useCarrier,omitempty"`
 }
 
 // NetworkInfo contains all collected network information.
@@ -192,11 +199,7 @@ func CollectNetworkInfo() (*NetworkInfo, error) {
 		return nil, errors.Wrap(err, "error listing links")
 	}
 
-	info := &NetworkInfo{
-		linkIndex: make(map[uint32]*LinkInfo),
-		linkName:  make(map[string]*LinkInfo),
-	}
-
+	var infoLinks []LinkInfo
 	for _, link := range links {
 		li := LinkInfo{
 			Name:             link.Attributes.Name,
@@ -238,22 +241,44 @@ func CollectNetworkInfo() (*NetworkInfo, error) {
 						} else {
 							li.VLAN = vlanSpec
 						}
+					case "bridge":
+						filtering, err := decodeBridgeVLANFiltering(linkData.Data)
+						if err != nil {
+							log.Printf("warning: failed to decode bridge attributes for %s: %v", link.Attributes.Name, err)
+						}
+						li.VLANFiltering = filtering
 					}
 				}
 			}
 		}
 
-		info.Links = append(info.Links, li)
+		infoLinks = append(infoLinks, li)
 	}
 
-	// Build indexes
+	bridgeVLANs, err := collectBridgeVLANs()
+	if err != nil {
+		log.Printf("warning: failed to collect bridge VLANs: %v", err)
+	}
+	for i := range infoLinks {
+		infoLinks[i].BridgeVLANs = bridgeVLANs[infoLinks[i].Index]
+	}
+
+	return NewNetworkInfo(infoLinks), nil
+}
+
+// NewNetworkInfo creates a NetworkInfo from links and indexes them.
+func NewNetworkInfo(links []LinkInfo) *NetworkInfo {
+	info := &NetworkInfo{
+		Links:     links,
+		linkIndex: make(map[uint32]*LinkInfo),
+		linkName:  make(map[string]*LinkInfo),
+	}
 	for i := range info.Links {
 		l := &info.Links[i]
 		info.linkIndex[l.Index] = l
 		info.linkName[l.Name] = l
 	}
-
-	return info, nil
+	return info
 }
 
 func decodeBondMasterSpec(data []byte) (*BondMasterSpec, error) {
@@ -442,9 +467,18 @@ func LACPRateToString(rate uint8) string {
 	return "slow"
 }
 
+// defaultMTU is the Ethernet MTU the kernel uses when none is configured.
+const defaultMTU = 1500
+
 // GenerateBondCmdline generates kernel cmdline for bond configuration.
-// Format: bond=<bondname>:<slaves>:<options>
+// Format: bond=<bondname>:<slaves>:<options>[:<mtu>]
 func GenerateBondCmdline(info *NetworkInfo, bond *LinkInfo, bondName string) string {
+	return generateBondCmdline(info, bond, bondName, bondSlaveName)
+}
+
+// generateBondCmdline is GenerateBondCmdline with the Talos names of the
+// slaves given by slaveName.
+func generateBondCmdline(info *NetworkInfo, bond *LinkInfo, bondName string, slaveName func(string) string) string {
 	if bond == nil || !bond.IsBond() || bond.BondMaster == nil {
 		return ""
 	}
@@ -458,9 +492,14 @@ func GenerateBondCmdline(info *NetworkInfo, bond *LinkInfo, bondName string) str
 	// Build slave list using predictable names
 	var slaveNames []string
 	for _, slave := range slaves {
-		slaveNames = append(slaveNames, PrettyName(slave.Name))
+		slaveNames = append(slaveNames, slaveName(slave.Name))
 	}
+	return bondCmdline(bond, bondName, slaveNames)
+}
 
+// bondCmdline returns the bond= arg for bond, with the Talos names of its
+// slaves.
+func bondCmdline(bond *LinkInfo, bondName string, slaveNames []string) string {
 	// Build options
 	var options []string
 
@@ -495,10 +534,72 @@ func GenerateBondCmdline(info *NetworkInfo, bond *LinkInfo, bondName string) str
 		options = append(options, fmt.Sprintf("downdelay=%d", bond.BondMaster.DownDelay))
 	}
 
-	return fmt.Sprintf("bond=%s:%s:%s",
+	cmdline := fmt.Sprintf("bond=%s:%s:%s",
 		bondName,
 		strings.Join(slaveNames, ","),
 		strings.Join(options, ","))
+
+	// Non-default MTU (e.g. jumbo frames); the bond applies it to its slaves
+	if bond.MTU != 0 && bond.MTU != defaultMTU {
+		cmdline += fmt.Sprintf(":%d", bond.MTU)
+	}
+
+	return cmdline
+}
+
+// askBondSlaves asks for the slaves of a bond that has none, e.g. because
+// its links were not up yet, listing the physical interfaces. There is no
+// default: enslaving the wrong links cuts the node off, so with -yes this
+// fails, naming the bond. It returns the host and Talos names of the slaves
+// given; names not found on the host are taken as Talos names.
+func (s *Snapshot) askBondSlaves(p cli.Prompter, bond *LinkInfo) ([]nameMapping, error) {
+	fmt.Fprintf(p.Out, "  WARNING: bond %s has no slaves, its links may not be up yet\n", bond.Name)
+	hosts := map[string]string{} // Talos name to host name
+	for i := range s.netInfo().Links {
+		l := &s.netInfo().Links[i]
+		if l.IsPhysical() {
+			name := s.prettyName(l.Name)
+			fmt.Fprintf(p.Out, "  Physical interface: %s (%s, link: %s)\n", l.Name, name, s.linkState(l.Name))
+			hosts[name] = l.Name
+		}
+	}
+	answer, err := p.Require(fmt.Sprintf("Slaves of bond %s (comma-separated, host or Talos names)", bond.Name))
+	if err != nil {
+		return nil, err
+	}
+	var slaves []nameMapping
+	for _, name := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+		sl := nameMapping{Host: name, Talos: name}
+		if s.netInfo().GetLinkByName(name) != nil {
+			sl.Talos = s.prettyName(name)
+		} else if host, ok := hosts[name]; ok {
+			sl.Host = host
+		}
+		if !slices.ContainsFunc(slaves, func(n nameMapping) bool { return n.Talos == sl.Talos }) {
+			slaves = append(slaves, sl)
+		}
+	}
+	if len(slaves) == 0 {
+		log.Printf("warning: no slaves for bond %s, Talos will not create it", bond.Name)
+	}
+	return slaves, nil
+}
+
+// BondMTUWarnings returns warnings about slaves whose MTU differs from the
+// bond's. Talos sets the bond MTU on all slaves, so such differences are
+// not carried over.
+func BondMTUWarnings(info *NetworkInfo, bond *LinkInfo) []string {
+	if bond == nil || !bond.IsBond() {
+		return nil
+	}
+	var warnings []string
+	for _, slave := range info.GetBondSlaves(bond.Index) {
+		if slave.MTU != 0 && bond.MTU != 0 && slave.MTU != bond.MTU {
+			warnings = append(warnings, fmt.Sprintf("slave %s has MTU %d but bond %s has MTU %d, Talos will use %d on all slaves",
+				slave.Name, slave.MTU, bond.Name, bond.MTU, bond.MTU))
+		}
+	}
+	return warnings
 }
 
 // GenerateVLANCmdline generates kernel cmdline for VLAN configuration.
@@ -520,36 +621,154 @@ func GenerateVLANCmdline(info *NetworkInfo, vlan *LinkInfo, vlanName string) str
 
 // GenerateIPCmdline generates kernel cmdline for IP configuration.
 // Format: ip=<client-ip>:<server-ip>:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>
-func GenerateIPCmdline(ip, gateway, netmask, hostname, device string) string {
-	// Format: ip=<client-ip>:<server-ip>:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>
+// IPv6 addresses are put in brackets and netmask is the prefix length.
+func GenerateIPCmdline(ip, gateway, netmask, hostname, device string, dns ...string) string {
+	// Format: ip=<client-ip>:<server-ip>:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>:<dns0-ip>:<dns1-ip>
 	// server-ip is empty, autoconf is "none" for static
-	return fmt.Sprintf("ip=%s::%s:%s:%s:%s:none", ip, gateway, netmask, hostname, device)
+	cmdline := fmt.Sprintf("ip=%s::%s:%s:%s:%s:none", cmdlineAddr(ip), cmdlineAddr(gateway), netmask, hostname, device)
+	for i, server := range dns {
+		if i == maxCmdlineNameservers {
+			break
+		}
+		cmdline += ":" + cmdlineAddr(server)
+	}
+	return cmdline
 }
 
-// DefaultRoute returns the default route interface and gateway.
-func DefaultRoute() (iface, gw string, err error) {
-	f, err := os.Open("/proc/net/route")
-	if err != nil {
-		return
+// defaultRoute is a default route from netlink or /proc/net.
+type defaultRoute struct {
+	Iface   string
+	Gateway string
+	Metric  uint32
+}
+
+func (r defaultRoute) String() string {
+	s := "dev " + r.Iface
+	if r.Gateway != "" && r.Gateway != "0.0.0.0" {
+		s = "via " + r.Gateway + " " + s
 	}
-	defer f.Close()
+	return fmt.Sprintf("%s metric %d", s, r.Metric)
+}
+
+// rtfUp is the RTF_UP route flag.
+const rtfUp = 0x1
+
+//nolint:gochecknoglobals
+var (
+	// routeIface is the interface of the default route picked by the user
+	// among several with the lowest metric, "" to use the first one.
+	routeIface string
+	// selectedRoute is the default route the network config is based on,
+	// for the summary.
+	selectedRoute *defaultRoute
+)
 
-	sc := bufio.NewScanner(f)
+// parseDefaultRoutes returns the IPv4 default routes that are up in
+// /proc/net/route content, lowest metric first.
+func parseDefaultRoutes(r io.Reader) ([]defaultRoute, error) {
+	var routes []defaultRoute
+	sc := bufio.NewScanner(r)
 	sc.Scan()
 	for sc.Scan() {
 		flds := strings.Fields(sc.Text())
-		if len(flds) >= 3 && flds[1] == "00000000" {
-			iface = flds[0]
-			gw = hexIPLittle(flds[2])
-			return
+		if len(flds) < 8 || flds[1] != "00000000" || flds[7] != "00000000" {
+			continue
 		}
+		if flags, _ := strconv.ParseUint(flds[3], 16, 32); flags&rtfUp == 0 {
+			continue
+		}
+		metric, _ := strconv.ParseUint(flds[6], 10, 32)
+		routes = append(routes, defaultRoute{Iface: flds[0], Gateway: hexIPLittle(flds[2]), Metric: uint32(metric)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "read /proc/net/route")
+	}
+	slices.SortStableFunc(routes, func(a, b defaultRoute) int { return cmp.Compare(a.Metric, b.Metric) })
+	return routes, nil
+}
+
+// defaultRoutes returns the IPv4 default routes, lowest metric first. They
+// are queried via netlink, with /proc/net/route as a fallback.
+func defaultRoutes() ([]defaultRoute, error) {
+	routes, err := netlinkDefaultRoutes(unix.AF_INET)
+	if err == nil {
+		return routes, nil
+	}
+	f, ferr := os.Open("/proc/net/route")
+	if ferr != nil {
+		return nil, errors.CombineErrors(err, ferr)
+	}
+	defer f.Close()
+	return parseDefaultRoutes(f)
+}
+
+// preferredRoute returns the route of the interface chosen by the user if
+// there is one, else the route with the lowest metric.
+func preferredRoute(routes []defaultRoute, iface string) defaultRoute {
+	for _, r := range routes {
+		if r.Iface == iface {
+			return r
+		}
+	}
+	return routes[0]
+}
+
+// tiedRoutes returns the default routes sharing the lowest metric, one per
+// interface. More than one means the kernel's choice is arbitrary.
+func tiedRoutes(routes []defaultRoute) []defaultRoute {
+	var tied []defaultRoute
+	for _, r := range routes {
+		if r.Metric != routes[0].Metric {
+			break
+		}
+		if !slices.ContainsFunc(tied, func(t defaultRoute) bool { return t.Iface == r.Iface }) {
+			tied = append(tied, r)
+		}
+	}
+	return tied
+}
+
+// DefaultRoute returns the default route interface and gateway: the route
+// with the lowest metric, or the one chosen by the user among equals.
+func DefaultRoute() (iface, gw string, err error) {
+	routes, err := defaultRoutes()
+	if err != nil {
+		return "", "", err
+	}
+	if len(routes) == 0 {
+		return "", "", errors.New("no default route")
 	}
-	if err = sc.Err(); err != nil {
-		err = errors.Wrap(err, "read /proc/net/route")
+	r := preferredRoute(routes, routeIface)
+	return r.Iface, r.Gateway, nil
+}
+
+// chooseDefaultRoute asks which interface to use when several default
+// routes share the lowest metric, and remembers the route the network
+// config is based on.
+func chooseDefaultRoute(p cli.Prompter) {
+	routes, err := defaultRoutes()
+	if err != nil || len(routes) == 0 {
 		return
 	}
-	err = errors.New("no default route")
-	return
+	if tied := tiedRoutes(routes); len(tied) > 1 && routeIface == "" {
+		fmt.Fprintln(p.Out, "\nSeveral default routes have the same metric:")
+		names := make([]string, len(tied))
+		for i, r := range tied {
+			fmt.Fprintf(p.Out, "  %s\n", r)
+			names[i] = r.Iface
+		}
+		for {
+			answer := p.Ask("Interface for the default route", tied[0].Iface)
+			if slices.Contains(names, answer) {
+				routeIface = answer
+				break
+			}
+			fmt.Fprintf(p.Out, "Choose one of: %s\n", strings.Join(names, ", "))
+		}
+	}
+	r := preferredRoute(routes, routeIface)
+	selectedRoute = &r
+	fmt.Fprintf(p.Out, "\nDefault route: %s\n", r)
 }
 
 // IfaceAddr returns the IPv4 address and netmask of the named interface.
@@ -641,33 +860,100 @@ func GetHostname() string {
 	return hostname
 }
 
+// Options configures kernel argument collection.
+type Options struct {
+	HostnameFrom string        // hostname default: HostnameFromSystem, HostnameFromSerial or HostnameFromMAC
+	LinkWait     time.Duration // how long to wait for a default route with carrier, 0 to not wait
+	NoConsole    bool          // add no console= argument and do not ask for one
+
+	// TalosHostname passes the hostname as talos.hostname= instead of in
+	// the hostname field of ip=, so that it is set without a static address.
+	TalosHostname bool
+
+	// IfaceNames maps MACs, as net.HardwareAddr.String formats them, to the
+	// Talos name used for the interface instead of the derived one, see
+	// ParseIfaceNames.
+	IfaceNames map[string]string
+
+	// Out receives the prompts and the detected settings, os.Stdout if nil.
+	Out io.Writer
+	// Yes takes the default answers, as -yes does.
+	Yes bool
+}
+
+// prompter returns the cli.Prompter for opts: Out, and the default answers
+// with opts.Yes or -yes.
+func (opts Options) prompter() cli.Prompter {
+	p := cli.Default()
+	if opts.Out != nil {
+		p.Out = opts.Out
+	}
+	p.Yes = p.Yes || opts.Yes
+	return p
+}
+
+// hostnameArgs returns the hostname for the ip= argument and the arguments
+// that carry it instead, according to opts.
+func hostnameArgs(opts Options, hostname string) (string, []string) {
+	if opts.TalosHostname && hostname != "" {
+		return "", []string{"talos.hostname=" + hostname}
+	}
+	return hostname, nil
+}
+
 // CollectKernelArgs collects kernel arguments for network configuration.
-func CollectKernelArgs() []string {
+func CollectKernelArgs(opts Options) []string {
+	if opts.LinkWait > 0 {
+		if err := WaitForLink(opts.LinkWait); err != nil {
+			log.Printf("warning: network link not ready after %s: %v", opts.LinkWait, err)
+		}
+	}
+
+	chooseDefaultRoute(opts.prompter())
+
 	// Try netlink-based detection first (supports bond/bridge)
-	if args := collectKernelArgsNetlink(); args != nil {
+	if args := collectKernelArgsNetlink(opts); args != nil {
 		return args
 	}
 
 	// Fallback to simple detection
-	return collectKernelArgsSimple()
+	return collectKernelArgsSimple(opts)
 }
 
-//nolint:gocognit,forbidigo,funlen
-func collectKernelArgsNetlink() []string {
-	// Try to collect network info via netlink
-	netInfo, err := CollectNetworkInfo()
+// defaultHostname returns the hostname to offer for the node, generated
+// from the serial or MAC of iface if requested.
+func defaultHostname(opts Options, iface string) string {
+	if opts.HostnameFrom == HostnameFromSystem {
+		return GetHostname()
+	}
+	hostname, err := GenerateHostname(opts.HostnameFrom, iface)
 	if err != nil {
-		log.Printf("warning: failed to collect network info via netlink: %v", err)
-		log.Printf("falling back to simple detection")
-		return nil // Will use fallback
+		log.Printf("warning: failed to generate hostname: %v", err)
+		return GetHostname()
 	}
+	return hostname
+}
 
-	// Find default route interface
-	dev, gw, err := DefaultRoute()
+func collectKernelArgsNetlink(opts Options) []string {
+	snap, err := ReadSnapshot()
 	if err != nil {
-		log.Printf("warning: no default route found: %v", err)
-		return nil
+		log.Printf("warning: %v", err)
+		log.Printf("falling back to simple detection")
+		return nil // Will use fallback
 	}
+	return snap.kernelArgs(opts)
+}
+
+// kernelArgs asks for the network configuration, offering what is detected
+// in s as defaults, and returns the kernel args for it.
+//
+//nolint:gocognit,funlen
+func (s *Snapshot) kernelArgs(opts Options) []string {
+	p := opts.prompter()
+	s.ifaceNames = opts.IfaceNames
+	netInfo := s.netInfo()
+	dev, gw, ip, mask := s.Device, s.Gateway, s.Address, s.Netmask
+	ipv6 := strings.Contains(ip, ":")
 
 	// Get link info for the interface
 	link := netInfo.GetLinkByName(dev)
@@ -685,15 +971,8 @@ func collectKernelArgsNetlink() []string {
 		actualDevice = link
 	}
 
-	// Get IP address and mask
-	ip, mask, err := IfaceAddr(dev)
-	if err != nil {
-		log.Printf("warning: failed to get IP address for %s: %v", dev, err)
-		return nil
-	}
-
 	// Ask user if they want networking
-	netOn := cli.AskYesNo("Add networking configuration?", true)
+	netOn := p.AskYesNo("Add networking configuration?", true)
 	if !netOn {
 		return nil
 	}
@@ -705,59 +984,107 @@ func collectKernelArgsNetlink() []string {
 	// If there's a bond, the IP goes on the bond (or VLAN on bond)
 	var ipDevice string
 	bondName := "bond0"
+	// How the interfaces involved are named in Talos, shown before the
+	// device for ip= is asked for.
+	var names []nameMapping
 
 	// Handle bond
 	if actualDevice.IsBond() {
-		fmt.Printf("\nDetected bond interface: %s\n", actualDevice.Name)
+		fmt.Fprintf(p.Out, "\nDetected bond interface: %s\n", actualDevice.Name)
 		slaves := netInfo.GetBondSlaves(actualDevice.Index)
 		if len(slaves) > 0 {
-			fmt.Printf("  Slaves: ")
-			for i, s := range slaves {
+			fmt.Fprintf(p.Out, "  Slaves: ")
+			for i, sl := range slaves {
 				if i > 0 {
-					fmt.Printf(", ")
+					fmt.Fprintf(p.Out, ", ")
 				}
-				fmt.Printf("%s (%s)", s.Name, PrettyName(s.Name))
+				fmt.Fprintf(p.Out, "%s (%s, link: %s)", sl.Name, s.slaveName(sl.Name), s.linkState(sl.Name))
 			}
-			fmt.Println()
+			fmt.Fprintln(p.Out)
 		}
 		if actualDevice.BondMaster != nil {
-			fmt.Printf("  Mode: %s\n", BondModeToString(actualDevice.BondMaster.Mode))
+			fmt.Fprintf(p.Out, "  Mode: %s\n", BondModeToString(actualDevice.BondMaster.Mode))
 			if actualDevice.BondMaster.Mode == BondMode8023AD {
-				fmt.Printf("  Hash policy: %s\n", HashPolicyToString(actualDevice.BondMaster.HashPolicy))
-				fmt.Printf("  LACP rate: %s\n", LACPRateToString(actualDevice.BondMaster.LACPRate))
+				fmt.Fprintf(p.Out, "  Hash policy: %s\n", HashPolicyToString(actualDevice.BondMaster.HashPolicy))
+				fmt.Fprintf(p.Out, "  LACP rate: %s\n", LACPRateToString(actualDevice.BondMaster.LACPRate))
 			}
 		}
 
+		if actualDevice.MTU != 0 && actualDevice.MTU != defaultMTU {
+			fmt.Fprintf(p.Out, "  MTU: %d\n", actualDevice.MTU)
+		}
+		for _, w := range BondMTUWarnings(netInfo, actualDevice) {
+			fmt.Fprintf(p.Out, "  WARNING: %s\n", w)
+		}
+
+		names = append(names, nameMapping{Host: actualDevice.Name, Talos: bondName, Note: "bond"})
+		for _, sl := range slaves {
+			names = append(names, nameMapping{Host: sl.Name, Talos: s.slaveName(sl.Name), Note: "slave of " + bondName})
+		}
+
 		// Generate bond cmdline
-		bondCmdline := GenerateBondCmdline(netInfo, actualDevice, bondName)
-		if bondCmdline != "" {
-			out = append(out, bondCmdline)
+		bondArg := generateBondCmdline(netInfo, actualDevice, bondName, s.slaveName)
+		if bondArg == "" && actualDevice.BondMaster != nil {
+			// Without slaves Talos would not create the bond the IP goes on.
+			asked, err := s.askBondSlaves(p, actualDevice)
+			if err != nil {
+				cli.Fatalf(cli.ExitUsage, "%v", err)
+			}
+			var slaveNames []string
+			for _, sl := range asked {
+				slaveNames = append(slaveNames, sl.Talos)
+				names = append(names, nameMapping{Host: sl.Host, Talos: sl.Talos, Note: "slave of " + bondName})
+			}
+			if len(slaveNames) > 0 {
+				bondArg = bondCmdline(actualDevice, bondName, slaveNames)
+			}
+		}
+		if bondArg != "" {
+			out = append(out, bondArg)
 		}
 		ipDevice = bondName
 	} else {
 		// Regular interface
-		ipDevice = PrettyName(actualDevice.Name)
-		fmt.Printf("\nDetected interface: %s (%s)\n", actualDevice.Name, ipDevice)
+		ipDevice = s.prettyName(actualDevice.Name)
+		fmt.Fprintf(p.Out, "\nDetected interface: %s (%s, link: %s)\n", actualDevice.Name, ipDevice, s.linkState(actualDevice.Name))
+		names = append(names, nameMapping{Host: actualDevice.Name, Talos: ipDevice})
+	}
+	// Talos has no bridge: VLANs of the bridge move to the uplink.
+	uplinkName := ipDevice
+	if link.IsBridge() {
+		names = append(names, nameMapping{Host: link.Name, Note: "bridge, flattened: its address moves to " + uplinkName})
+	}
+
+	// Host address on a VLAN-aware bridge whose PVID is tagged on the uplink
+	if vid := BridgeUplinkVLAN(link, actualDevice); vid != 0 {
+		vlanName := fmt.Sprintf("%s.%d", uplinkName, vid)
+		fmt.Fprintf(p.Out, "\nDetected VLAN-aware bridge %s: host traffic uses VLAN %d, tagged on %s\n", link.Name, vid, actualDevice.Name)
+		out = append(out, fmt.Sprintf("vlan=%s:%s", vlanName, uplinkName))
+		names = append(names, nameMapping{Host: link.Name + " PVID", Talos: vlanName, Note: fmt.Sprintf("VLAN %d", vid)})
+		ipDevice = vlanName
 	}
 
 	// Handle VLANs
 	if len(vlans) > 0 {
-		fmt.Printf("\nDetected VLAN configuration:\n")
+		fmt.Fprintf(p.Out, "\nDetected VLAN configuration:\n")
 		for _, vlan := range vlans {
 			if vlan.VLAN != nil {
 				parent := netInfo.GetLinkByIndex(vlan.LinkIndex)
 				parentName := "unknown"
 				if parent != nil {
-					if parent.IsBond() && actualDevice.IsBond() {
+					switch {
+					case parent.IsBridge():
+						parentName = uplinkName
+					case parent.IsBond() && actualDevice.IsBond():
 						parentName = bondName
-					} else {
-						parentName = PrettyName(parent.Name)
+					default:
+						parentName = s.prettyName(parent.Name)
 					}
 				}
-				fmt.Printf("  VLAN %d on %s (interface: %s)\n", vlan.VLAN.VID, parentName, vlan.Name)
+				fmt.Fprintf(p.Out, "  VLAN %d on %s (interface: %s)\n", vlan.VLAN.VID, parentName, vlan.Name)
 			}
 		}
-		fmt.Println()
+		fmt.Fprintln(p.Out)
 
 		// Generate VLAN cmdlines (in reverse order - from lowest to topmost)
 		// This ensures parent interfaces are created before child VLANs
@@ -772,20 +1099,23 @@ func collectKernelArgsNetlink() []string {
 			parent := netInfo.GetLinkByIndex(vlan.LinkIndex)
 			var parentName string
 			if parent != nil {
-				if parent.IsBond() && actualDevice.IsBond() {
+				if parent.IsBridge() {
+					parentName = uplinkName
+				} else if parent.IsBond() && actualDevice.IsBond() {
 					parentName = bondName
 				} else if parent.IsVLAN() {
 					// Nested VLAN - find the previous VLAN's name
 					// For now, use predictable name
-					parentName = PrettyName(parent.Name)
+					parentName = s.prettyName(parent.Name)
 				} else {
-					parentName = PrettyName(parent.Name)
+					parentName = s.prettyName(parent.Name)
 				}
 			}
 
 			vlanName := fmt.Sprintf("%s.%d", parentName, vlan.VLAN.VID)
 			vlanCmdline := fmt.Sprintf("vlan=%s:%s", vlanName, parentName)
 			out = append(out, vlanCmdline)
+			names = append(names, nameMapping{Host: vlan.Name, Talos: vlanName, Note: fmt.Sprintf("VLAN %d", vlan.VLAN.VID)})
 
 			// The topmost VLAN is where we put the IP
 			if i == 0 {
@@ -794,58 +1124,96 @@ func collectKernelArgsNetlink() []string {
 		}
 	}
 
-	// Ask for IP configuration
-	ipDevice = cli.Ask("Network device for IP", ipDevice)
-	ip = cli.Ask("IP address", ip)
-	mask = cli.Ask("Netmask", mask)
-	gw = cli.Ask("Gateway (or 'none')", gw)
-	if strings.EqualFold(gw, "none") {
-		gw = ""
+	fmt.Fprintln(p.Out, "\nInterface names in Talos:")
+	for _, line := range formatNameMappings(names, ipDevice) {
+		fmt.Fprintf(p.Out, "  %s\n", line)
 	}
-	hostname := cli.Ask("Hostname", GetHostname())
 
-	// Generate IP cmdline
-	ipCmdline := GenerateIPCmdline(ip, gw, mask, hostname, ipDevice)
-	out = append(out, ipCmdline)
-
-	// Serial console
-	console := cli.Ask("Configure serial console? (or 'no')", "ttyS0")
-	if console == "" {
-		console = "ttyS0"
+	// Ask for IP configuration
+	ipDevice = p.Ask("Talos network device for IP (or another Talos name)", ipDevice)
+	if ipv6 {
+		ip = p.Ask("IPv6 address (or 'auto' for SLAAC)", ip)
+	} else {
+		ip = p.Ask("IP address", ip)
 	}
-	if !strings.EqualFold(console, "no") && !strings.EqualFold(console, "none") {
-		out = append(out, "console="+console)
+	if strings.EqualFold(ip, "auto") {
+		// Talos configures IPv6 from router advertisements on its own; the
+		// bond/VLAN arguments above still create the link.
+		fmt.Fprintln(p.Out, "No ip= argument: the address is autoconfigured from router advertisements.")
+		if opts.TalosHostname {
+			_, args := hostnameArgs(opts, p.Ask("Hostname", s.defaultHostname(opts, actualDevice.Name)))
+			out = append(out, args...)
+		}
+	} else {
+		if ipv6 {
+			mask = p.Ask("Prefix length", mask)
+		} else {
+			mask = p.Ask("Netmask", mask)
+		}
+		gw = p.Ask("Gateway (or 'none')", gw)
+		if strings.EqualFold(gw, "none") {
+			gw = ""
+		}
+		hostname := p.Ask("Hostname", s.defaultHostname(opts, actualDevice.Name))
+		hostname, dns := askDNS(p, hostname, s.DNS)
+		hostname, args := hostnameArgs(opts, hostname)
+
+		// Generate IP cmdline
+		ipCmdline := GenerateIPCmdline(ip, gw, mask, hostname, ipDevice, dns...)
+		out = append(out, ipCmdline)
+		out = append(out, args...)
 	}
 
+	// Serial console
+	out = append(out, askConsole(opts)...)
+
 	return out
 }
 
-func collectKernelArgsSimple() []string {
+func collectKernelArgsSimple(opts Options) []string {
+	p := opts.prompter()
 	dev, gw, _ := DefaultRoute()
 	ip, mask, _ := IfaceAddr(dev)
-	dev = PrettyName(dev)
-	hostname := GetHostname()
+	hostname := defaultHostname(opts, dev)
+	if dev != "" {
+		fmt.Fprintf(p.Out, "\nDetected interface: %s (link: %s)\n", dev, GetLinkState(dev))
+	}
+	host := dev
+	dev = simpleName(opts, dev)
+	mapping := nameMapping{Host: host, Talos: dev}
+	if parent, vid, ok := simpleVLAN(host); ok {
+		// Talos creates the VLAN itself from vlan=, on the parent.
+		dev = fmt.Sprintf("%s.%d", simpleName(opts, parent), vid)
+		mapping = nameMapping{Host: host, Talos: dev, Note: fmt.Sprintf("VLAN %d on %s", vid, parent)}
+	}
+	if host != "" {
+		fmt.Fprintf(p.Out, "Interface name in Talos: %s\n", formatNameMappings([]nameMapping{mapping}, dev)[0])
+		if ifc, err := net.InterfaceByName(host); err == nil && ifc.MTU != defaultMTU {
+			fmt.Fprintf(p.Out, "MTU: %d (no kernel arg sets it for a plain interface; use machine.network.interfaces[].mtu in the machine config)\n", ifc.MTU)
+		}
+	}
 
-	netOn := cli.AskYesNo("Add networking configuration?", true)
+	netOn := p.AskYesNo("Add networking configuration?", true)
 	var out []string
 	if netOn {
-		dev = cli.Ask("Interface", dev)
-		ip = cli.Ask("IP address", ip)
-		mask = cli.Ask("Netmask", mask)
-		gw = cli.Ask("Gateway (or 'none')", gw)
+		dev = p.Ask("Talos interface name", dev)
+		if parent, _, ok := vlanFromName(dev); ok {
+			out = append(out, fmt.Sprintf("vlan=%s:%s", dev, parent))
+		}
+		ip = p.Ask("IP address", ip)
+		mask = p.Ask("Netmask", mask)
+		gw = p.Ask("Gateway (or 'none')", gw)
 		if strings.EqualFold(gw, "none") {
 			gw = ""
 		}
-		hostname = cli.Ask("Hostname", hostname)
-		out = append(out, fmt.Sprintf("ip=%s::%s:%s:%s:%s:none", ip, gw, mask, hostname, dev))
+		hostname = p.Ask("Hostname", hostname)
+		var dns []string
+		hostname, dns = askDNS(p, hostname, ReadResolvConf())
+		hostname, args := hostnameArgs(opts, hostname)
+		out = append(out, GenerateIPCmdline(ip, gw, mask, hostname, dev, dns...))
+		out = append(out, args...)
 	}
 
-	console := cli.Ask("Configure serial console? (or 'no')", "ttyS0")
-	if console == "" {
-		console = "ttyS0"
-	}
-	if !strings.EqualFold(console, "no") && !strings.EqualFold(console, "none") {
-		out = append(out, "console="+console)
-	}
+	out = append(out, askConsole(opts)...)
 	return out
 }
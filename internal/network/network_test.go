@@ -0,0 +1,117 @@
+//go:build linux
+
+package network
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func bondTestInfo(bondMTU uint32, slaveMTUs ...uint32) (*NetworkInfo, *LinkInfo) {
+	links := []LinkInfo{{
+		Name:       "bond0",
+		Index:      10,
+		Kind:       "bond",
+		MTU:        bondMTU,
+		BondMaster: &BondMasterSpec{Mode: BondMode8023AD, MIIMon: 100},
+	}}
+	for i, mtu := range slaveMTUs {
+		links = append(links, LinkInfo{
+			Name:        "testslave" + string(rune('0'+i)),
+			Index:       uint32(i + 1),
+			SlaveKind:   "bond",
+			MasterIndex: 10,
+			MTU:         mtu,
+		})
+	}
+	info := NewNetworkInfo(links)
+	return info, info.GetLinkByName("bond0")
+}
+
+func TestGenerateBondCmdlineMTU(t *testing.T) {
+	tests := []struct {
+		name    string
+		bondMTU uint32
+		want    string
+	}{
+		{"default MTU", 1500, "bond=bond0:testslave0,testslave1:mode=802.3ad,xmit_hash_policy=layer2,lacp_rate=slow,miimon=100"},
+		{"unknown MTU", 0, "bond=bond0:testslave0,testslave1:mode=802.3ad,xmit_hash_policy=layer2,lacp_rate=slow,miimon=100"},
+		{"jumbo frames", 9000, "bond=bond0:testslave0,testslave1:mode=802.3ad,xmit_hash_policy=layer2,lacp_rate=slow,miimon=100:9000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, bond := bondTestInfo(tt.bondMTU, tt.bondMTU, tt.bondMTU)
+			if got := GenerateBondCmdline(info, bond, "bond0"); got != tt.want {
+				t.Errorf("GenerateBondCmdline() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBondMTUWarnings(t *testing.T) {
+	tests := []struct {
+		name      string
+		bondMTU   uint32
+		slaveMTUs []uint32
+		want      []string
+	}{
+		{"consistent", 9000, []uint32{9000, 9000}, nil},
+		{"mixed slaves", 9000, []uint32{9000, 1500}, []string{"testslave1"}},
+		{"all slaves differ", 9000, []uint32{1500, 1500}, []string{"testslave0", "testslave1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, bond := bondTestInfo(tt.bondMTU, tt.slaveMTUs...)
+			warnings := BondMTUWarnings(info, bond)
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("BondMTUWarnings() = %q, want warnings for %v", warnings, tt.want)
+			}
+			for i, slave := range tt.want {
+				if !strings.Contains(warnings[i], "slave "+slave+" ") {
+					t.Errorf("warning %q does not mention %s", warnings[i], slave)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDefaultRoutes(t *testing.T) {
+	routes := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0100000A	0003	0	0	200	00000000	0	0	0
+eth0	0000000A	00000000	0001	0	0	200	00FFFFFF	0	0	0
+eth1	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth2	00000000	0102A8C0	0002	0	0	50	00000000	0	0	0
+eth3	00000000	0103A8C0	0003	0	0	100	00000000	0	0	0
+`
+	got, err := parseDefaultRoutes(strings.NewReader(routes))
+	if err != nil {
+		t.Fatalf("parseDefaultRoutes error: %v", err)
+	}
+	want := []defaultRoute{
+		{Iface: "eth1", Gateway: "192.168.1.1", Metric: 100},
+		{Iface: "eth3", Gateway: "192.168.3.1", Metric: 100},
+		{Iface: "eth0", Gateway: "10.0.0.1", Metric: 200},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("parseDefaultRoutes() = %v, want %v", got, want)
+	}
+
+	if tied := tiedRoutes(got); !slices.Equal(tied, want[:2]) {
+		t.Errorf("tiedRoutes() = %v, want %v", tied, want[:2])
+	}
+	if tied := tiedRoutes(got[1:]); len(tied) != 1 {
+		t.Errorf("tiedRoutes() without a tie = %v", tied)
+	}
+	if r := preferredRoute(got, ""); r.Iface != "eth1" {
+		t.Errorf("preferredRoute() = %v, want eth1", r)
+	}
+	if r := preferredRoute(got, "eth3"); r.Iface != "eth3" {
+		t.Errorf("preferredRoute(eth3) = %v, want eth3", r)
+	}
+	if s := got[0].String(); s != "via 192.168.1.1 dev eth1 metric 100" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package network
+
+import (
+	"cmp"
+	"net"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jsimonetti/rtnetlink/v2"
+	"golang.org/x/sys/unix"
+)
+
+// netlinkDefaultRoutes returns the default routes of the address family
+// (unix.AF_INET or unix.AF_INET6) in the main routing table of the current
+// network namespace, lowest metric first.
+func netlinkDefaultRoutes(family uint8) ([]defaultRoute, error) {
+	conn, err := rtnetlink.Dial(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error dialing rtnetlink socket")
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Route.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing routes")
+	}
+	return routesFromMessages(msgs, family, func(index uint32) string {
+		ifc, err := net.InterfaceByIndex(int(index))
+		if err != nil {
+			return ""
+		}
+		return ifc.Name
+	}), nil
+}
+
+// routesFromMessages picks the unicast default routes of family in the main
+// table from route messages. Multipath routes are listed once per next hop.
+// ifName resolves interface indexes; routes via unknown interfaces or the
+// loopback device are skipped.
+func routesFromMessages(msgs []rtnetlink.RouteMessage, family uint8, ifName func(uint32) string) []defaultRoute {
+	var routes []defaultRoute
+	add := func(index uint32, gw net.IP, metric uint32) {
+		name := ifName(index)
+		if name == "" || name == "lo" {
+			return
+		}
+		r := defaultRoute{Iface: name, Metric: metric}
+		if gw != nil && !gw.IsUnspecified() {
+			r.Gateway = gw.String()
+		}
+		routes = append(routes, r)
+	}
+
+	for _, m := range msgs {
+		table := m.Attributes.Table
+		if table == 0 {
+			table = uint32(m.Table)
+		}
+		if m.Family != family || m.DstLength != 0 || m.Type != unix.RTN_UNICAST || table != unix.RT_TABLE_MAIN {
+			continue
+		}
+		if len(m.Attributes.Multipath) == 0 {
+			add(m.Attributes.OutIface, m.Attributes.Gateway, m.Attributes.Priority)
+			continue
+		}
+		for _, nh := range m.Attributes.Multipath {
+			add(nh.Hop.IfIndex, nh.Gateway, m.Attributes.Priority)
+		}
+	}
+	slices.SortStableFunc(routes, func(a, b defaultRoute) int { return cmp.Compare(a.Metric, b.Metric) })
+	return routes
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package network
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/jsimonetti/rtnetlink/v2"
+	"golang.org/x/sys/unix"
+)
+
+func TestRoutesFromMessages(t *testing.T) {
+	names := map[uint32]string{1: "lo", 2: "eth0", 3: "eth1", 4: "bond0"}
+	ifName := func(index uint32) string { return names[index] }
+	route := func(family, dstLen, typ uint8, attrs rtnetlink.RouteAttributes) rtnetlink.RouteMessage {
+		if attrs.Table == 0 {
+			attrs.Table = unix.RT_TABLE_MAIN
+		}
+		return rtnetlink.RouteMessage{Family: family, DstLength: dstLen, Type: typ, Attributes: attrs}
+	}
+	msgs := []rtnetlink.RouteMessage{
+		route(unix.AF_INET, 24, unix.RTN_UNICAST, rtnetlink.RouteAttributes{OutIface: 2}),
+		route(unix.AF_INET, 0, unix.RTN_UNICAST, rtnetlink.RouteAttributes{OutIface: 3, Gateway: net.ParseIP("10.0.1.1"), Priority: 200}),
+		route(unix.AF_INET, 0, unix.RTN_UNICAST, rtnetlink.RouteAttributes{OutIface: 2, Gateway: net.ParseIP("10.0.0.1"), Priority: 100}),
+		route(unix.AF_INET, 0, unix.RTN_UNICAST, rtnetlink.RouteAttributes{OutIface: 4, Table: 100}),
+		route(unix.AF_INET6, 0, unix.RTN_UNREACHABLE, rtnetlink.RouteAttributes{OutIface: 1, Priority: 1}),
+		route(unix.AF_INET6, 0, unix.RTN_UNICAST, rtnetlink.RouteAttributes{Priority: 1024, Multipath: []rtnetlink.NextHop{
+			{Hop: rtnetlink.RTNextHop{IfIndex: 4}, Gateway: net.ParseIP("fe80::1")},
+			{Hop: rtnetlink.RTNextHop{IfIndex: 9}, Gateway: net.ParseIP("fe80::2")},
+		}}),
+	}
+
+	got := routesFromMessages(msgs, unix.AF_INET, ifName)
+	want := []defaultRoute{{Iface: "eth0", Gateway: "10.0.0.1", Metric: 100}, {Iface: "eth1", Gateway: "10.0.1.1", Metric: 200}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv4 routes = %v, want %v", got, want)
+	}
+
+	got = routesFromMessages(msgs, unix.AF_INET6, ifName)
+	want = []defaultRoute{{Iface: "bond0", Gateway: "fe80::1", Metric: 1024}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv6 routes = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,202 @@
+//go:build linux
+
+package network
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Snapshot is the network state the kernel args are generated from: the
+// links, the default route with the address of its interface and the
+// resolver configuration. It is read from the running system, or from a
+// JSON file to generate the args offline, e.g. in CI against fixtures.
+type Snapshot struct {
+	Links    []LinkInfo `json:"links"`
+	Device   string     `json:"device"` // interface of the default route
+	Gateway  string     `json:"gateway,omitempty"`
+	Address  string     `json:"address"`
+	Netmask  string     `json:"netmask"` // prefix length for an IPv6 address
+	Hostname string     `json:"hostname,omitempty"`
+	DNS      ResolvConf `json:"dns"`
+
+	// Talos names of host interfaces: enx<MAC> of the permanent or current
+	// MAC, and for bond slaves the name Talos gives them. Interfaces that
+	// are not listed keep their host name.
+	Names      map[string]string `json:"names,omitempty"`
+	SlaveNames map[string]string `json:"slaveNames,omitempty"`
+
+	// MACs of host interfaces, to match Options.IfaceNames: the permanent
+	// MAC, or the current one for interfaces that are not bond slaves.
+	MACs map[string]string `json:"macs,omitempty"`
+
+	live       bool // read from the running system
+	info       *NetworkInfo
+	ifaceNames map[string]string // Options.IfaceNames
+}
+
+// ReadSnapshot reads the network state of the running system.
+func ReadSnapshot() (*Snapshot, error) {
+	info, err := CollectNetworkInfo()
+	if err != nil {
+		return nil, errors.Wrap(err, "collect network info via netlink")
+	}
+
+	dev, gw, err := DefaultRoute()
+	if err != nil {
+		var err6 error
+		dev, gw, err6 = DefaultRoute6()
+		if err6 != nil {
+			return nil, errors.Newf("no default route found: %v, %v", err, err6)
+		}
+	}
+
+	// Falling back to IPv6 on v6-only hosts
+	ip, mask, err := IfaceAddr(dev)
+	if err != nil {
+		var err6 error
+		ip, mask, err6 = IfaceAddr6(dev)
+		if err6 != nil {
+			return nil, errors.Newf("failed to get IP address for %s: %v, %v", dev, err, err6)
+		}
+		log.Printf("no IPv4 address on %s, using IPv6 address %s/%s", dev, ip, mask)
+		if !strings.Contains(gw, ":") {
+			_, gw, _ = DefaultRoute6()
+		}
+	}
+
+	s := &Snapshot{
+		Links:      info.Links,
+		Device:     dev,
+		Gateway:    gw,
+		Address:    ip,
+		Netmask:    mask,
+		Hostname:   GetHostname(),
+		DNS:        ReadResolvConf(),
+		Names:      map[string]string{},
+		SlaveNames: map[string]string{},
+		MACs:       map[string]string{},
+		live:       true,
+		info:       info,
+	}
+	for i := range info.Links {
+		l := &info.Links[i]
+		if name := PrettyName(l.Name); name != l.Name {
+			s.Names[l.Name] = name
+		}
+		if l.IsBondSlave() {
+			if name := bondSlaveName(l.Name); name != l.Name {
+				s.SlaveNames[l.Name] = name
+			}
+		}
+		if mac := linkMAC(l.Name, l.IsBondSlave()); mac != "" {
+			s.MACs[l.Name] = mac
+		}
+	}
+	return s, nil
+}
+
+// LoadSnapshot reads a snapshot written by WriteSnapshot, or by hand.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read network snapshot")
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrapf(err, "parse network snapshot %s", path)
+	}
+	if s.Device == "" || s.Address == "" {
+		return nil, errors.Newf("network snapshot %s has no device or address", path)
+	}
+	return s, nil
+}
+
+// WriteSnapshot writes s as indented JSON to path, or to stdout if path is
+// "-".
+func WriteSnapshot(s *Snapshot, path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode network snapshot")
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return errors.Wrap(os.WriteFile(path, data, 0o644), "write network snapshot")
+}
+
+// DryRunKernelArgs generates the network kernel args from s with the
+// default answers and without console= arguments, as the install and boot
+// flows would with -yes and -no-console. The prompts and detected settings
+// are printed to opts.Out.
+func DryRunKernelArgs(s *Snapshot, opts Options) []string {
+	opts.Yes = true
+	opts.NoConsole = true
+	return s.kernelArgs(opts)
+}
+
+// netInfo returns the links of s, indexed.
+func (s *Snapshot) netInfo() *NetworkInfo {
+	if s.info == nil {
+		s.info = NewNetworkInfo(s.Links)
+	}
+	return s.info
+}
+
+// forcedName returns the Talos name Options.IfaceNames gives the MAC of a
+// host interface, "" if there is none.
+func (s *Snapshot) forcedName(name string) string {
+	if mac, ok := s.MACs[name]; ok {
+		return s.ifaceNames[mac]
+	}
+	return ""
+}
+
+// prettyName returns the Talos name of a host interface, see PrettyName.
+func (s *Snapshot) prettyName(name string) string {
+	if n := s.forcedName(name); n != "" {
+		return n
+	}
+	if n, ok := s.Names[name]; ok {
+		return n
+	}
+	return name
+}
+
+// slaveName returns the Talos name of a bond slave, see bondSlaveName.
+func (s *Snapshot) slaveName(name string) string {
+	if n := s.forcedName(name); n != "" {
+		return n
+	}
+	if n, ok := s.SlaveNames[name]; ok {
+		return n
+	}
+	return name
+}
+
+// linkState describes the state of the named link: from sysfs on the
+// running system, from the recorded operational state otherwise.
+func (s *Snapshot) linkState(name string) string {
+	if s.live {
+		return GetLinkState(name).String()
+	}
+	if l := s.netInfo().GetLinkByName(name); l != nil {
+		return OperStateString(l.OperationalState)
+	}
+	return "unknown"
+}
+
+// defaultHostname returns the hostname to offer for the node. Hostnames
+// generated from the serial or MAC of iface need the running system.
+func (s *Snapshot) defaultHostname(opts Options, iface string) string {
+	if s.live {
+		return defaultHostname(opts, iface)
+	}
+	return s.Hostname
+}
@@ -0,0 +1,266 @@
+//go:build linux
+
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+)
+
+func TestDryRunKernelArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		opts     Options
+		want     string
+	}{
+		{
+			name: "VLAN on LACP bond",
+			snapshot: `{
+				"links": [
+					{"name": "eno1", "index": 2, "type": 1, "slaveKind": "bond", "masterIndex": 4, "mtu": 9000, "operState": 6},
+					{"name": "eno2", "index": 3, "type": 1, "slaveKind": "bond", "masterIndex": 4, "mtu": 9000, "operState": 6},
+					{"name": "bond0", "index": 4, "kind": "bond", "mtu": 9000, "bond": {"mode": 4, "hashPolicy": 1, "lacpRate": 1, "miimon": 100}},
+					{"name": "bond0.100", "index": 5, "kind": "vlan", "linkIndex": 4, "mtu": 9000, "vlan": {"vid": 100}}
+				],
+				"device": "bond0.100", "gateway": "10.0.0.1", "address": "10.0.0.5", "netmask": "255.255.255.0",
+				"hostname": "node1", "dns": {"nameservers": ["10.0.0.2"], "search": ["example.org"]},
+				"slaveNames": {"eno1": "enxaabbccddee01", "eno2": "enxaabbccddee02"}
+			}`,
+			want: "bond=bond0:enxaabbccddee01,enxaabbccddee02:mode=802.3ad,xmit_hash_policy=layer3+4,lacp_rate=fast,miimon=100:9000 " +
+				"vlan=bond0.100:bond0 ip=10.0.0.5::10.0.0.1:255.255.255.0:node1.example.org:bond0.100:none:10.0.0.2",
+		},
+		{
+			name: "Proxmox bridge",
+			snapshot: `{
+				"links": [
+					{"name": "enp1s0", "index": 2, "type": 1, "slaveKind": "bridge", "masterIndex": 3},
+					{"name": "vmbr0", "index": 3, "kind": "bridge"}
+				],
+				"device": "vmbr0", "gateway": "192.168.1.1", "address": "192.168.1.10", "netmask": "255.255.255.0",
+				"hostname": "pve", "names": {"enp1s0": "enx001122334455", "vmbr0": "enx001122334455"}
+			}`,
+			opts: Options{TalosHostname: true},
+			want: "ip=192.168.1.10::192.168.1.1:255.255.255.0::enx001122334455:none talos.hostname=pve",
+		},
+		{
+			name: "VLAN-aware Proxmox bridge on bond",
+			snapshot: `{
+				"links": [
+					{"name": "eno1", "index": 2, "type": 1, "slaveKind": "bond", "masterIndex": 4},
+					{"name": "eno2", "index": 3, "type": 1, "slaveKind": "bond", "masterIndex": 4},
+					{"name": "bond0", "index": 4, "kind": "bond", "masterIndex": 5, "bond": {"mode": 1, "miimon": 100},
+					 "bridgeVLANs": [{"vid": 1, "pvid": true, "untagged": true}, {"vid": 20}]},
+					{"name": "vmbr0", "index": 5, "kind": "bridge", "vlanFiltering": true,
+					 "bridgeVLANs": [{"vid": 20, "pvid": true, "untagged": true}]}
+				],
+				"device": "vmbr0", "gateway": "10.20.0.1", "address": "10.20.0.10", "netmask": "255.255.0.0",
+				"slaveNames": {"eno1": "enp1s0f0", "eno2": "enp1s0f1"}
+			}`,
+			want: "bond=bond0:enp1s0f0,enp1s0f1:mode=active-backup,miimon=100 " +
+				"vlan=bond0.20:bond0 ip=10.20.0.10::10.20.0.1:255.255.0.0::bond0.20:none",
+		},
+		{
+			name: "forced name of VLAN parent",
+			snapshot: `{
+				"links": [
+					{"name": "enp5s0", "index": 5, "type": 1},
+					{"name": "enp5s0.30", "index": 6, "kind": "vlan", "linkIndex": 5, "vlan": {"vid": 30}}
+				],
+				"device": "enp5s0.30", "address": "10.30.0.5", "netmask": "255.255.255.0",
+				"names": {"enp5s0": "enx001122334455"},
+				"macs": {"enp5s0": "00:11:22:33:44:55"}
+			}`,
+			opts: Options{IfaceNames: map[string]string{"00:11:22:33:44:55": "eth0"}},
+			want: "vlan=eth0.30:eth0 ip=10.30.0.5:::255.255.255.0::eth0.30:none",
+		},
+		{
+			name: "forced bond slave name",
+			snapshot: `{
+				"links": [
+					{"name": "eno1", "index": 2, "type": 1, "slaveKind": "bond", "masterIndex": 4},
+					{"name": "eno2", "index": 3, "type": 1, "slaveKind": "bond", "masterIndex": 4},
+					{"name": "bond0", "index": 4, "kind": "bond", "bond": {"mode": 1, "miimon": 100}}
+				],
+				"device": "bond0", "address": "10.0.0.5", "netmask": "255.255.255.0",
+				"slaveNames": {"eno1": "enxaabbccddee01", "eno2": "enxaabbccddee02"},
+				"macs": {"eno1": "aa:bb:cc:dd:ee:01", "eno2": "aa:bb:cc:dd:ee:02"}
+			}`,
+			opts: Options{IfaceNames: map[string]string{"aa:bb:cc:dd:ee:01": "eth1"}},
+			want: "bond=bond0:eth1,enxaabbccddee02:mode=active-backup,miimon=100 ip=10.0.0.5:::255.255.255.0::bond0:none",
+		},
+		{
+			name: "IPv6 only",
+			snapshot: `{
+				"links": [{"name": "eth0", "index": 2, "type": 1}],
+				"device": "eth0", "gateway": "fe80::1", "address": "2001:db8::10", "netmask": "64", "hostname": "v6"
+			}`,
+			want: "ip=[2001:db8::10]::[fe80::1]:64:v6:eth0:none",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "snapshot.json")
+			if err := os.WriteFile(path, []byte(tt.snapshot), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			snap, err := LoadSnapshot(path)
+			if err != nil {
+				t.Fatalf("LoadSnapshot() error = %v", err)
+			}
+			if got := strings.Join(DryRunKernelArgs(snap, tt.opts), " "); got != tt.want {
+				t.Errorf("DryRunKernelArgs() = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAskBondSlaves verifies the slaves asked for a bond that has none, and
+// the bond= arg built from them: host and Talos names are both accepted, and
+// -yes fails instead of leaving the bond without slaves.
+func TestAskBondSlaves(t *testing.T) {
+	snapshot := `{
+		"links": [
+			{"name": "eno1", "index": 2, "type": 1},
+			{"name": "eno2", "index": 3, "type": 1},
+			{"name": "bond0", "index": 4, "kind": "bond", "bond": {"mode": 1, "miimon": 100}}
+		],
+		"device": "bond0", "address": "10.0.0.5", "netmask": "255.255.255.0",
+		"names": {"eno1": "enxaabbccddee01", "eno2": "enxaabbccddee02"}
+	}`
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, []byte(snapshot), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	bond := snap.netInfo().GetLinkByName("bond0")
+
+	tests := []struct {
+		name    string
+		input   string
+		yes     bool
+		want    []nameMapping
+		wantArg string
+	}{
+		{
+			name:    "host names",
+			input:   "eno1, eno2\n",
+			want:    []nameMapping{{Host: "eno1", Talos: "enxaabbccddee01"}, {Host: "eno2", Talos: "enxaabbccddee02"}},
+			wantArg: "bond=bond0:enxaabbccddee01,enxaabbccddee02:mode=active-backup,miimon=100",
+		},
+		{
+			name:    "Talos name after an empty answer",
+			input:   "\nenxaabbccddee02\n",
+			want:    []nameMapping{{Host: "eno2", Talos: "enxaabbccddee02"}},
+			wantArg: "bond=bond0:enxaabbccddee02:mode=active-backup,miimon=100",
+		},
+		{
+			name:    "duplicates",
+			input:   "eno1,enxaabbccddee01\n",
+			want:    []nameMapping{{Host: "eno1", Talos: "enxaabbccddee01"}},
+			wantArg: "bond=bond0:enxaabbccddee01:mode=active-backup,miimon=100",
+		},
+		{name: "-yes", yes: true},
+		{name: "no answer", input: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := cli.Prompter{Out: &bytes.Buffer{}, In: bufio.NewReader(strings.NewReader(tt.input)), Yes: tt.yes}
+			got, err := snap.askBondSlaves(p, bond)
+			if tt.want == nil {
+				if err == nil || cli.ExitCode(err) != cli.ExitUsage || !strings.Contains(err.Error(), "bond0") {
+					t.Errorf("askBondSlaves() = %v, %v, want a usage error naming the bond", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("askBondSlaves() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("askBondSlaves() = %v, want %v", got, tt.want)
+			}
+			var names []string
+			for _, sl := range got {
+				names = append(names, sl.Talos)
+			}
+			if arg := bondCmdline(bond, "bond0", names); arg != tt.wantArg {
+				t.Errorf("bondCmdline() = %q, want %q", arg, tt.wantArg)
+			}
+		})
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	info, _ := bondTestInfo(9000, 9000, 9000)
+	snap := &Snapshot{
+		Links:      info.Links,
+		Device:     "bond0",
+		Address:    "10.0.0.5",
+		Netmask:    "255.255.255.0",
+		SlaveNames: map[string]string{"testslave0": "enp1s0f0"},
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := WriteSnapshot(snap, path); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	loaded, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	want := strings.Join(DryRunKernelArgs(snap, Options{}), " ")
+	if got := strings.Join(DryRunKernelArgs(loaded, Options{}), " "); got != want {
+		t.Errorf("args from the written snapshot = %q, want %q", got, want)
+	}
+}
+
+func TestDryRunKernelArgs_Output(t *testing.T) {
+	info, _ := bondTestInfo(9000, 9000, 9000)
+	snap := &Snapshot{Links: info.Links, Device: "bond0", Address: "10.0.0.5", Netmask: "255.255.255.0"}
+
+	var out bytes.Buffer
+	DryRunKernelArgs(snap, Options{Out: &out})
+	if !strings.Contains(out.String(), "Add networking configuration? [yes]: true") {
+		t.Errorf("prompts not written to Options.Out:\n%s", out.String())
+	}
+	if cli.YesFlag {
+		t.Error("DryRunKernelArgs set -yes")
+	}
+}
+
+func TestParseIfaceNames(t *testing.T) {
+	names, err := ParseIfaceNames([]string{"AA:BB:CC:DD:EE:01=eth0", "aa-bb-cc-dd-ee-02=uplink"})
+	if err != nil {
+		t.Fatalf("ParseIfaceNames() error = %v", err)
+	}
+	if names["aa:bb:cc:dd:ee:01"] != "eth0" || names["aa:bb:cc:dd:ee:02"] != "uplink" {
+		t.Errorf("ParseIfaceNames() = %v", names)
+	}
+
+	for spec, wantErr := range map[string]string{
+		"aa:bb:cc:dd:ee:01":                       "use <mac>=<name>",
+		"aa:bb:cc:dd:ee=eth0":                     "invalid MAC",
+		"aa:bb:cc:dd:ee:01=":                      "empty interface name",
+		"aa:bb:cc:dd:ee:01=averyveryverylongname": "longer than 15",
+		"aa:bb:cc:dd:ee:01=eth:0":                 "contains one of",
+	} {
+		if _, err := ParseIfaceNames([]string{spec}); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("ParseIfaceNames(%q) error = %v, want %q", spec, err, wantErr)
+		}
+	}
+	if _, err := ParseIfaceNames([]string{"aa:bb:cc:dd:ee:01=eth0", "AA:BB:CC:DD:EE:01=eth1"}); err == nil {
+		t.Error("ParseIfaceNames() accepted a MAC mapped twice")
+	}
+	if _, err := ParseIfaceNames([]string{"aa:bb:cc:dd:ee:01=eth0", "aa:bb:cc:dd:ee:02=eth0"}); err == nil {
+		t.Error("ParseIfaceNames() accepted a name given twice")
+	}
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package network
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// procVLANConfig lists the VLAN interfaces of the 8021q module.
+const procVLANConfig = "/proc/net/vlan/config"
+
+// vlanNameRe matches VLAN interfaces named <parent>.<vid>.
+//
+//nolint:gochecknoglobals
+var vlanNameRe = regexp.MustCompile(`^(.+)\.([0-9]{1,4})$`)
+
+// vlanFromName returns the parent and VLAN ID of an interface named
+// <parent>.<vid>, as Talos names its VLANs.
+func vlanFromName(name string) (parent string, vid int, ok bool) {
+	m := vlanNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	vid, _ = strconv.Atoi(m[2])
+	if vid < 1 || vid > 4094 {
+		return "", 0, false
+	}
+	return m[1], vid, true
+}
+
+// simpleVLAN returns the parent and VLAN ID of the host interface name
+// without netlink: from /proc/net/vlan/config, which also covers VLANs
+// named otherwise (vlan100), or else from the <parent>.<vid> name.
+func simpleVLAN(name string) (parent string, vid int, ok bool) {
+	if f, err := os.Open(procVLANConfig); err == nil {
+		defer f.Close()
+		if parent, vid, ok := parseVLANConfig(f, name); ok {
+			return parent, vid, true
+		}
+	}
+	return vlanFromName(name)
+}
+
+// parseVLANConfig finds name in /proc/net/vlan/config content, lines of
+// "<name> | <vid> | <parent>" after two header lines.
+func parseVLANConfig(r io.Reader, name string) (parent string, vid int, ok bool) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		flds := strings.Split(sc.Text(), "|")
+		if len(flds) != 3 || strings.TrimSpace(flds[0]) != name {
+			continue
+		}
+		vid, err := strconv.Atoi(strings.TrimSpace(flds[1]))
+		if err != nil {
+			continue
+		}
+		return strings.TrimSpace(flds[2]), vid, true
+	}
+	return "", 0, false
+}
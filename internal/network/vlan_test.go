@@ -0,0 +1,45 @@
+//go:build linux
+
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVLANFromName(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent string
+		vid    int
+		ok     bool
+	}{
+		{"eth0.100", "eth0", 100, true},
+		{"bond0.4094", "bond0", 4094, true},
+		{"enp0s3.10.20", "enp0s3.10", 20, true},
+		{"eth0", "", 0, false},
+		{"eth0.0", "", 0, false},
+		{"eth0.5000", "", 0, false},
+	}
+	for _, tt := range tests {
+		parent, vid, ok := vlanFromName(tt.name)
+		if parent != tt.parent || vid != tt.vid || ok != tt.ok {
+			t.Errorf("vlanFromName(%q) = %q, %d, %v; want %q, %d, %v", tt.name, parent, vid, ok, tt.parent, tt.vid, tt.ok)
+		}
+	}
+}
+
+func TestParseVLANConfig(t *testing.T) {
+	config := `VLAN Dev name	 | VLAN ID
+Name-Type: VLAN_NAME_TYPE_RAW_PLUS_VID_NO_PAD
+eth0.100       | 100  | eth0
+vlan200        | 200  | bond0
+`
+	parent, vid, ok := parseVLANConfig(strings.NewReader(config), "vlan200")
+	if !ok || parent != "bond0" || vid != 200 {
+		t.Errorf("parseVLANConfig(vlan200) = %q, %d, %v; want bond0, 200", parent, vid, ok)
+	}
+	if _, _, ok := parseVLANConfig(strings.NewReader(config), "eth1"); ok {
+		t.Error("parseVLANConfig found eth1")
+	}
+}
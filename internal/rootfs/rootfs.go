@@ -0,0 +1,65 @@
+// Package rootfs resolves paths in an extracted image rootfs the way a
+// process chrooted into it sees them, so that writing into the rootfs never
+// leaves it.
+package rootfs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// maxSymlinks is the number of symlinks Resolve follows before it gives up,
+// as the kernel does with ELOOP.
+const maxSymlinks = 40
+
+// WithinDir reports whether path is dir or below it.
+func WithinDir(dir, path string) bool {
+	cleanPath := filepath.Clean(path)
+	cleanDir := filepath.Clean(dir)
+	return strings.HasPrefix(cleanPath, cleanDir+string(os.PathSeparator)) || cleanPath == cleanDir
+}
+
+// Resolve returns the host path of rel in the rootfs at root, with symlinks
+// resolved as a process chrooted into root sees them: absolute targets are
+// relative to root and ".." stops at it. Components that do not exist are
+// kept as they are, so the result is always below root.
+func Resolve(root, rel string) (string, error) {
+	root = filepath.Clean(root)
+	resolved := root
+	rest := strings.Split(filepath.ToSlash(rel), "/")
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if resolved != root {
+				resolved = filepath.Dir(resolved)
+			}
+			continue
+		}
+		next := filepath.Join(resolved, name)
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", errors.Newf("too many levels of symlinks in %s", rel)
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = root
+		}
+		rest = append(strings.Split(link, "/"), rest...)
+	}
+	return resolved, nil
+}
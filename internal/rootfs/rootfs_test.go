@@ -0,0 +1,50 @@
+package rootfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithinDir(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/root/fs":              true,
+		"/root/fs/usr/lib":      true,
+		"/root/fs/../fs/usr":    true,
+		"/root/fs/../etc":       false,
+		"/root/fsx":             false,
+		"/root/fs/usr/../../..": false,
+	} {
+		if got := WithinDir("/root/fs", path); got != want {
+			t.Errorf("WithinDir(/root/fs, %q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "usr/lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"lib": "usr/lib", "abs": "/usr/lib", "loop": "loop"} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for rel, want := range map[string]string{
+		"lib/modules":       "usr/lib/modules",
+		"abs/modules":       "usr/lib/modules",
+		"../../etc/passwd":  "etc/passwd",
+		"abs/../../../etc":  "etc",
+		"usr/./lib/new/dir": "usr/lib/new/dir",
+	} {
+		got, err := Resolve(root, rel)
+		if err != nil || got != filepath.Join(root, want) {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", rel, got, err, filepath.Join(root, want))
+		}
+	}
+	if _, err := Resolve(root, "loop/x"); err == nil {
+		t.Error("Resolve() followed a symlink loop")
+	}
+}
@@ -0,0 +1,391 @@
+package source
+
+import (
+	"archive/tar"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
+	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/cozystack/boot-to-talos/internal/uki"
+)
+
+// Archive kinds an image can be wrapped in, see Detection.Archive.
+const (
+	ArchiveSplit = "split" // split into <image>.part0, <image>.part1, ...
+	ArchiveTar   = "tar"   // single image in a plain tar
+)
+
+// splitPartRe matches the name of one part of a split image.
+//
+//nolint:gochecknoglobals
+var splitPartRe = regexp.MustCompile(`(?i)^(.+)\.part([0-9]+)$`)
+
+// splitImageName returns the name of the image path is a part of, or "" if
+// path is not named like a part.
+func splitImageName(path string) string {
+	m := splitPartRe.FindStringSubmatch(path)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// firstSplitPart returns the first part of the split image at path, "" if
+// there is none. Numbering starts at 0 or 1.
+func firstSplitPart(path string) string {
+	for _, suffix := range []string{".part0", ".part1"} {
+		if fileExists(path + suffix) {
+			return path + suffix
+		}
+	}
+	return ""
+}
+
+// splitParts returns the parts of the split image part belongs to, in
+// order, and their total size. The parts must be numbered without gaps and,
+// as split(1) writes them, all but the last must have the same size and the
+// last must not be larger, so that a missing or truncated part is detected
+// before the image is used.
+func splitParts(part string) ([]string, int64, error) {
+	m := splitPartRe.FindStringSubmatch(part)
+	if m == nil {
+		return nil, 0, errors.Newf("%s is not named <image>.part<N>", part)
+	}
+	dir, image := filepath.Split(m[1])
+	prefix := m[1] + ".part"
+
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "list parts")
+	}
+	byIndex := map[int]string{}
+	for _, e := range entries {
+		pm := splitPartRe.FindStringSubmatch(e.Name())
+		if pm == nil || pm[1] != image {
+			continue
+		}
+		n, err := strconv.Atoi(pm[2])
+		if err != nil {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		if other, ok := byIndex[n]; ok {
+			return nil, 0, errors.Newf("parts %s and %s have the same number", other, p)
+		}
+		byIndex[n] = p
+	}
+	indexes := make([]int, 0, len(byIndex))
+	for n := range byIndex {
+		indexes = append(indexes, n)
+	}
+	slices.Sort(indexes)
+	if len(indexes) == 0 || indexes[0] > 1 {
+		return nil, 0, errors.Newf("first part %s0 or %s1 not found", prefix, prefix)
+	}
+
+	var (
+		parts    []string
+		total    int64
+		partSize int64
+	)
+	for i, n := range indexes {
+		if n != indexes[0]+i {
+			return nil, 0, errors.Newf("part %s%d is missing", prefix, indexes[0]+i)
+		}
+		p := byIndex[n]
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "stat part")
+		}
+		size := fi.Size()
+		switch {
+		case size == 0:
+			return nil, 0, errors.Newf("part %s is empty", p)
+		case i == 0:
+			partSize = size
+		case i < len(indexes)-1 && size != partSize:
+			return nil, 0, errors.Newf("part %s is %d bytes, the first part is %d: truncated part?", p, size, partSize)
+		case size > partSize:
+			return nil, 0, errors.Newf("last part %s is larger than the others", p)
+		}
+		parts = append(parts, p)
+		total += size
+	}
+	return parts, total, nil
+}
+
+// tarImage returns the header of the single ISO or RAW image in the tar
+// archive at path. Other regular files, such as checksums, are ignored.
+func tarImage(path string) (*tar.Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+
+	var found *tar.Header
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "read tar archive %s", path)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, err := detectLocal(hdr.Name); err != nil {
+			continue
+		}
+		if found != nil {
+			return nil, errors.Newf("tar archive %s has several images: %s and %s", path, found.Name, hdr.Name)
+		}
+		found = hdr
+	}
+	if found == nil {
+		return nil, errors.Newf("tar archive %s has no .iso or .raw[.xz|.gz|.zst] file", path)
+	}
+	return found, nil
+}
+
+// detectSplit detects the type of the split image part is a part of from
+// the image name.
+func detectSplit(part string) (Detection, error) {
+	image := splitImageName(part)
+	d, err := detectLocal(image)
+	if err != nil {
+		return Detection{}, err
+	}
+	d.Archive = ArchiveSplit
+	d.Path = part
+	d.Reason = "split parts of " + filepath.Base(image) + " (" + d.Reason + ")"
+	return d, nil
+}
+
+// detectTar detects the type of the image in the tar archive at path from
+// the name of the image.
+func detectTar(path string) (Detection, error) {
+	hdr, err := tarImage(path)
+	if err != nil {
+		return Detection{}, err
+	}
+	d, err := detectLocal(hdr.Name)
+	if err != nil {
+		return Detection{}, err
+	}
+	d.Archive = ArchiveTar
+	d.Path = path
+	d.Reason = "tar archive member " + hdr.Name + " (" + d.Reason + ")"
+	return d, nil
+}
+
+// ArchiveSource wraps a local ISO or RAW image split into parts or packed in
+// a tar archive. The image is reassembled or extracted to a temporary file
+// first, then handled by the RAW or ISO source.
+type ArchiveSource struct {
+	path            string // first part or tar archive
+	archive         string
+	targetType      types.ImageSourceType
+	tmpDir          string            // holds the reassembled image
+	imagePath       string            // reassembled image in tmpDir
+	delegatedSource types.ImageSource // source created for delegation
+}
+
+// NewArchiveSource creates a new ArchiveSource for an image of targetType
+// in the archive at path, of an Archive* kind.
+func NewArchiveSource(path, archive string, targetType types.ImageSourceType) *ArchiveSource {
+	return &ArchiveSource{
+		path:       path,
+		archive:    archive,
+		targetType: targetType,
+	}
+}
+
+func (s *ArchiveSource) Type() types.ImageSourceType {
+	return s.targetType
+}
+
+func (s *ArchiveSource) Reference() string {
+	return s.path
+}
+
+// Probe checks that all parts are present with consistent sizes, or that the
+// tar archive holds exactly one image.
+func (s *ArchiveSource) Probe() error {
+	if s.archive == ArchiveTar {
+		_, err := tarImage(s.path)
+		return err
+	}
+	_, _, err := splitParts(s.path)
+	return err
+}
+
+// GetBootAssets reassembles the image and delegates to the RAW or ISO
+// source.
+func (s *ArchiveSource) GetBootAssets(trust *uki.TrustStore) (*types.BootAssets, error) {
+	path, err := s.ensureExtracted()
+	if err != nil {
+		return nil, err
+	}
+	if s.targetType == types.ImageSourceISO {
+		s.delegatedSource = NewISOSource(path)
+	} else {
+		s.delegatedSource = NewRAWSource(path)
+	}
+	return s.delegatedSource.GetBootAssets(trust)
+}
+
+// GetInstallAssets reassembles the image and delegates to the RAW source.
+func (s *ArchiveSource) GetInstallAssets(tmpDir string, sizeGiB uint64) (*types.InstallAssets, error) {
+	if s.targetType == types.ImageSourceISO {
+		return nil, errors.New("ISO source install mode not supported")
+	}
+	path, err := s.ensureExtracted()
+	if err != nil {
+		return nil, err
+	}
+	s.delegatedSource = NewRAWSource(path)
+	return s.delegatedSource.GetInstallAssets(tmpDir, sizeGiB)
+}
+
+// ensureExtracted writes the image to a temporary file, keeping its name so
+// that its compression is recognized, and returns the file's path.
+func (s *ArchiveSource) ensureExtracted() (string, error) {
+	if s.imagePath != "" {
+		return s.imagePath, nil
+	}
+	tmpDir, err := tempdir.MkdirTemp("archive-source-*")
+	if err != nil {
+		return "", errors.Wrap(err, "create temp dir")
+	}
+
+	var path string
+	if s.archive == ArchiveTar {
+		path, err = extractTarImage(s.path, tmpDir)
+	} else {
+		path, err = joinSplitParts(s.path, tmpDir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	s.tmpDir = tmpDir
+	s.imagePath = path
+	return path, nil
+}
+
+// joinSplitParts concatenates the parts of the split image part belongs to
+// into dir and returns the path of the image.
+func joinSplitParts(part, dir string) (string, error) {
+	parts, total, err := splitParts(part)
+	if err != nil {
+		return "", err
+	}
+	if err := checkFreeSpace(dir, uint64(total)); err != nil {
+		return "", errors.Wrap(err, "reassemble split image")
+	}
+	path := filepath.Join(dir, filepath.Base(splitImageName(part)))
+	out, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrap(err, "create image file")
+	}
+	defer out.Close()
+
+	var written int64
+	for _, p := range parts {
+		in, err := os.Open(p)
+		if err != nil {
+			return "", errors.Wrap(err, "open part")
+		}
+		n, err := io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return "", errors.Wrapf(err, "copy part %s", p)
+		}
+		written += n
+	}
+	if written != total {
+		return "", errors.Newf("parts changed while reading: %d bytes read, expected %d", written, total)
+	}
+	// A failed Close can be the only sign of a short write.
+	if err := out.Close(); err != nil {
+		return "", errors.Wrap(err, "write image file")
+	}
+	log.Printf("reassembled %s from %d parts (%d bytes)", filepath.Base(path), len(parts), total)
+	return path, nil
+}
+
+// extractTarImage extracts the image in the tar archive at path into dir
+// and returns the path of the image.
+func extractTarImage(path, dir string) (string, error) {
+	hdr, err := tarImage(path)
+	if err != nil {
+		return "", err
+	}
+	if err := checkFreeSpace(dir, uint64(hdr.Size)); err != nil {
+		return "", errors.Wrapf(err, "extract %s", hdr.Name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			return "", errors.Wrapf(err, "read tar archive %s", path)
+		}
+		if h.Name != hdr.Name {
+			continue
+		}
+		imagePath := filepath.Join(dir, filepath.Base(h.Name))
+		out, err := os.Create(imagePath)
+		if err != nil {
+			return "", errors.Wrap(err, "create image file")
+		}
+		defer out.Close()
+		n, err := io.Copy(out, tr)
+		if err != nil {
+			return "", errors.Wrapf(err, "extract %s", h.Name)
+		}
+		if n != h.Size {
+			return "", errors.Newf("extracted %d bytes of %s, the archive says %d", n, h.Name, h.Size)
+		}
+		// A failed Close can be the only sign of a short write.
+		if err := out.Close(); err != nil {
+			return "", errors.Wrapf(err, "extract %s", h.Name)
+		}
+		log.Printf("extracted %s from %s (%d bytes)", h.Name, filepath.Base(path), n)
+		return imagePath, nil
+	}
+}
+
+func (s *ArchiveSource) Close() error {
+	var errs []error
+	if s.delegatedSource != nil {
+		if err := s.delegatedSource.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		s.delegatedSource = nil
+	}
+	if s.tmpDir != "" {
+		if err := os.RemoveAll(s.tmpDir); err != nil {
+			errs = append(errs, err)
+		}
+		s.tmpDir = ""
+	}
+	s.imagePath = ""
+	return errors.Join(errs...)
+}
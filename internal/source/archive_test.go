@@ -0,0 +1,192 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/boot-to-talos/internal/types"
+)
+
+// writeParts writes the named parts with the given sizes into dir.
+func writeParts(t *testing.T, dir string, sizes map[string]int) {
+	t.Helper()
+	for name, size := range sizes {
+		data := bytes.Repeat([]byte{name[len(name)-1]}, size)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSplitParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		parts     map[string]int
+		wantParts []string
+		wantErr   string
+	}{
+		{
+			name:      "numbered from 0",
+			parts:     map[string]int{"a.raw.xz.part0": 4, "a.raw.xz.part1": 4, "a.raw.xz.part2": 2, "b.raw.xz.part0": 1},
+			wantParts: []string{"a.raw.xz.part0", "a.raw.xz.part1", "a.raw.xz.part2"},
+		},
+		{
+			name:      "numbered from 1 beyond 9",
+			parts:     map[string]int{"a.raw.part1": 2, "a.raw.part2": 2, "a.raw.part3": 2, "a.raw.part4": 2, "a.raw.part5": 2, "a.raw.part6": 2, "a.raw.part7": 2, "a.raw.part8": 2, "a.raw.part9": 2, "a.raw.part10": 2, "a.raw.part11": 1},
+			wantParts: []string{"a.raw.part1", "a.raw.part2", "a.raw.part3", "a.raw.part4", "a.raw.part5", "a.raw.part6", "a.raw.part7", "a.raw.part8", "a.raw.part9", "a.raw.part10", "a.raw.part11"},
+		},
+		{
+			name:    "missing part",
+			parts:   map[string]int{"a.raw.xz.part0": 4, "a.raw.xz.part2": 4},
+			wantErr: "a.raw.xz.part1 is missing",
+		},
+		{
+			name:    "truncated part",
+			parts:   map[string]int{"a.raw.xz.part0": 4, "a.raw.xz.part1": 3, "a.raw.xz.part2": 2},
+			wantErr: "truncated part",
+		},
+		{
+			name:    "last part larger",
+			parts:   map[string]int{"a.raw.xz.part0": 4, "a.raw.xz.part1": 5},
+			wantErr: "larger than the others",
+		},
+		{
+			name:    "same number twice",
+			parts:   map[string]int{"a.raw.xz.part0": 4, "a.raw.xz.part00": 4},
+			wantErr: "have the same number",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeParts(t, dir, tt.parts)
+
+			var first string
+			for name := range tt.parts {
+				if strings.HasPrefix(name, "a.") {
+					first = name
+				}
+			}
+			parts, _, err := splitParts(filepath.Join(dir, first))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("splitParts() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitParts() error = %v", err)
+			}
+			for i := range parts {
+				parts[i] = filepath.Base(parts[i])
+			}
+			if strings.Join(parts, " ") != strings.Join(tt.wantParts, " ") {
+				t.Errorf("splitParts() = %v, want %v", parts, tt.wantParts)
+			}
+		})
+	}
+}
+
+func TestArchiveSource(t *testing.T) {
+	image := bytes.Repeat([]byte("talos disk image "), 100)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(image)
+	zw.Close()
+
+	dir := t.TempDir()
+	compressed := gz.Bytes()
+	half := (len(compressed) + 1) / 2
+	for i, part := range [][]byte{compressed[:half], compressed[half:]} {
+		name := filepath.Join(dir, "metal-amd64.raw.gz.part"+string(rune('0'+i)))
+		if err := os.WriteFile(name, part, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for name, data := range map[string][]byte{"sha256sum.txt": []byte("checksum"), "out/metal-amd64.raw": image} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+	tw.Close()
+	tarPath := filepath.Join(dir, "metal-amd64.tar")
+	if err := os.WriteFile(tarPath, tarball.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		ref         string
+		wantArchive string
+		wantReason  string
+		wantHandler string
+	}{
+		{
+			name:        "first part",
+			ref:         filepath.Join(dir, "metal-amd64.raw.gz.part0"),
+			wantArchive: ArchiveSplit,
+			wantReason:  "split parts of metal-amd64.raw.gz (local file with .raw.gz extension)",
+			wantHandler: "split parts reassembled, then RAW disk image",
+		},
+		{
+			name:        "image name of the parts",
+			ref:         filepath.Join(dir, "metal-amd64.raw.gz"),
+			wantArchive: ArchiveSplit,
+			wantReason:  "split parts of metal-amd64.raw.gz (local file with .raw.gz extension)",
+			wantHandler: "split parts reassembled, then RAW disk image",
+		},
+		{
+			name:        "tar",
+			ref:         tarPath,
+			wantArchive: ArchiveTar,
+			wantReason:  "tar archive member out/metal-amd64.raw (local file with .raw extension)",
+			wantHandler: "tar extraction, then RAW disk image",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Detect(tt.ref)
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			if d.Type != types.ImageSourceRAW || d.Archive != tt.wantArchive {
+				t.Errorf("Detect() = {%v, archive=%q}, want {raw, archive=%q}", d.Type, d.Archive, tt.wantArchive)
+			}
+			if d.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", d.Reason, tt.wantReason)
+			}
+			if d.Handler() != tt.wantHandler {
+				t.Errorf("Handler() = %q, want %q", d.Handler(), tt.wantHandler)
+			}
+
+			src, err := DetectImageSource(tt.ref)
+			if err != nil {
+				t.Fatalf("DetectImageSource() error = %v", err)
+			}
+			defer src.Close()
+			if err := Probe(src); err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			assets, err := src.GetInstallAssets(t.TempDir(), 0)
+			if err != nil {
+				t.Fatalf("GetInstallAssets() error = %v", err)
+			}
+			defer assets.DiskImage.Close()
+			got, err := io.ReadAll(assets.DiskImage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, image) {
+				t.Errorf("image has %d bytes, want the %d bytes of the original", len(got), len(image))
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package source
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Boards lists the single-board computers supported by the Talos installer
+// --board option. Their images boot through u-boot instead of UEFI.
+//
+//nolint:gochecknoglobals
+var Boards = []string{
+	"bananapi_m64",
+	"jetson_nano",
+	"libretech_all_h3_cc_h5",
+	"nanopi_r4s",
+	"pine64",
+	"rock64",
+	"rockpi_4",
+	"rockpi_4c",
+	"rpi_4",
+	"rpi_generic",
+}
+
+// boardImageRe matches board image names such as metal-rpi_generic-arm64.raw.xz.
+//
+//nolint:gochecknoglobals
+var boardImageRe = regexp.MustCompile(`metal-([a-z0-9_]+)-arm64`)
+
+// BoardFromImage returns the board an image reference was built for, taken
+// from the image variant in its name, or "" for generic images.
+func BoardFromImage(ref string) string {
+	m := boardImageRe.FindStringSubmatch(strings.ToLower(path.Base(ref)))
+	if m == nil || !slices.Contains(Boards, m[1]) {
+		return ""
+	}
+	return m[1]
+}
@@ -0,0 +1,22 @@
+package source
+
+import "testing"
+
+func TestBoardFromImage(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"metal-rpi_generic-arm64.raw.xz", "rpi_generic"},
+		{"/tmp/images/metal-rock64-arm64.raw", "rock64"},
+		{"https://github.com/siderolabs/talos/releases/download/v1.6.7/metal-rpi_4-arm64.raw.xz", "rpi_4"},
+		{"metal-arm64.raw.xz", ""},
+		{"metal-unknown_board-arm64.raw.xz", ""},
+		{"ghcr.io/siderolabs/installer:v1.6.7", ""},
+	}
+	for _, tt := range tests {
+		if got := BoardFromImage(tt.ref); got != tt.want {
+			t.Errorf("BoardFromImage(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package source
+
+import (
+	"bufio"
+	"cmp"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs/filesystem"
+
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
+	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/cozystack/boot-to-talos/internal/uki"
+)
+
+// espFS is the part of an ESP or ISO filesystem boot entries are read from.
+type espFS interface {
+	ReadDir(path string) ([]os.FileInfo, error)
+	OpenFile(path string, flag int) (filesystem.File, error)
+}
+
+// Spellings of the removable-media directory, searched for a UKI before
+// loader entries are consulted. Only the first that exists is read: on FAT
+// they all name the same directory.
+//
+//nolint:gochecknoglobals
+var removableDirs = []string{
+	"/EFI/BOOT",
+	"/EFI/boot",
+	"/efi/boot",
+}
+
+// bootEntry is what boot mode loads from an ESP or ISO: a UKI, or a kernel
+// with its initrds and command line.
+type bootEntry struct {
+	ID      string // loader entry, "" for a UKI at the removable-media path
+	UKI     string
+	Kernel  string
+	Initrds []string
+	Options string
+}
+
+// findBootEntry returns the UKI at the removable-media path of fs or, if
+// that is a boot loader such as systemd-boot or shim, the entry the boot
+// loader would start by default (see loaderEntry). Of several UKIs there,
+// chooseUKI picks one.
+func findBootEntry(fs espFS) (*bootEntry, error) {
+	var ukis []ukiCandidate
+	var stubs []string
+	for _, dir := range removableDirs {
+		entries, err := fs.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".efi") {
+				continue
+			}
+			p := path.Join(dir, entry.Name())
+			if isUKIFile(fs, p) {
+				ukis = append(ukis, ukiCandidate{name: p, path: p})
+			} else {
+				stubs = append(stubs, p)
+			}
+		}
+		break
+	}
+	if len(ukis) > 0 {
+		if len(ukis) > 1 {
+			for i := range ukis {
+				ukis[i].version = fsUname(fs, ukis[i].path)
+			}
+		}
+		c, err := chooseUKI(ukis, len(ukis))
+		if err != nil {
+			return nil, err
+		}
+		return &bootEntry{UKI: c.path}, nil
+	}
+	if len(stubs) > 0 {
+		log.Printf("%s: boot loader, not a UKI; following its loader entries", strings.Join(stubs, ", "))
+	}
+
+	e, err := loaderEntry(fs)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("using loader entry %s", e.ID)
+	return e, nil
+}
+
+// fsUname returns the kernel release of the UKI at p on fs, see uki.Uname.
+func fsUname(fs espFS, p string) string {
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return uki.UnameFrom(uki.ReaderAt(f))
+}
+
+// isUKIFile reports whether the file at p on fs is a UKI, see uki.IsUKI.
+func isUKIFile(fs espFS, p string) bool {
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, uki.HeadSize)
+	n, _ := io.ReadFull(f, head)
+	return uki.IsUKI(head[:n])
+}
+
+// loaderEntry returns the Boot Loader Specification entry systemd-boot
+// starts by default: a type #1 entry in /loader/entries or a type #2 UKI in
+// /EFI/Linux. That is the entry loader.conf names with "default", else the
+// last one in version order, which is the newest version for the usual
+// <name>-<version> IDs. Entries that chainload another boot loader are
+// skipped.
+func loaderEntry(fs espFS) (*bootEntry, error) {
+	var entries []*bootEntry
+	if files, err := fs.ReadDir("/loader/entries"); err == nil {
+		for _, fi := range files {
+			if fi.IsDir() || !strings.HasSuffix(strings.ToLower(fi.Name()), ".conf") {
+				continue
+			}
+			e, err := readLoaderEntry(fs, "/loader/entries/"+fi.Name())
+			if err != nil {
+				log.Printf("warning: skipping loader entry: %v", err)
+				continue
+			}
+			if e.UKI != "" && !isUKIFile(fs, e.UKI) {
+				log.Printf("skipping loader entry %s: %s is not a UKI", e.ID, e.UKI)
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	if files, err := fs.ReadDir("/EFI/Linux"); err == nil {
+		for _, fi := range files {
+			p := "/EFI/Linux/" + fi.Name()
+			if !fi.IsDir() && strings.HasSuffix(strings.ToLower(fi.Name()), ".efi") && isUKIFile(fs, p) {
+				entries = append(entries, &bootEntry{ID: fi.Name(), UKI: p})
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no UKI at the removable-media path and no loader entry with a UKI or kernel")
+	}
+	slices.SortFunc(entries, func(a, b *bootEntry) int { return compareVersions(a.ID, b.ID) })
+
+	if pattern := loaderDefault(fs); pattern != "" {
+		for i := len(entries) - 1; i >= 0; i-- {
+			id := entries[i].ID
+			if ok, _ := path.Match(pattern, id); ok {
+				return entries[i], nil
+			}
+			if ok, _ := path.Match(pattern, strings.TrimSuffix(id, path.Ext(id))); ok {
+				return entries[i], nil
+			}
+		}
+		log.Printf("warning: no loader entry matches the default %q of loader.conf", pattern)
+	}
+	return entries[len(entries)-1], nil
+}
+
+// compareVersions compares entry IDs like systemd-boot sorts them: runs of
+// digits by their numeric value, so that 1.10 sorts after 1.9, and the rest
+// byte by byte.
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitsLen(a), digitsLen(b)
+			da, db := strings.TrimLeft(a[:na], "0"), strings.TrimLeft(b[:nb], "0")
+			if c := cmp.Compare(len(da), len(db)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(da, db); c != 0 {
+				return c
+			}
+			a, b = a[na:], b[nb:]
+			continue
+		}
+		if c := cmp.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// digitsLen returns the length of the run of digits s starts with.
+func digitsLen(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
+
+// loaderDefault returns the "default" pattern of /loader/loader.conf, ""
+// if there is none or it names a special entry such as @saved.
+func loaderDefault(fs espFS) string {
+	f, err := fs.OpenFile("/loader/loader.conf", os.O_RDONLY)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+		value = strings.TrimSpace(value)
+		if key == "default" && !strings.HasPrefix(value, "@") {
+			return value
+		}
+	}
+	return ""
+}
+
+// readLoaderEntry parses the type #1 entry at p. Its ID is the file name.
+func readLoaderEntry(fs espFS, p string) (*bootEntry, error) {
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", p)
+	}
+	defer f.Close()
+
+	e := &bootEntry{ID: path.Base(p)}
+	var options []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "efi":
+			e.UKI = espPath(value)
+		case "linux":
+			e.Kernel = espPath(value)
+		case "initrd":
+			e.Initrds = append(e.Initrds, espPath(value))
+		case "options":
+			options = append(options, value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read %s", p)
+	}
+	e.Options = strings.Join(options, " ")
+	if e.UKI == "" && e.Kernel == "" {
+		return nil, errors.Newf("%s has neither efi nor linux", p)
+	}
+	return e, nil
+}
+
+// espPath returns the path of an entry's file on the ESP: entries may omit
+// the leading slash.
+func espPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// entryBootAssets copies the UKI or the kernel and initrds of e to a
+// temporary directory and returns the boot assets read from there. Several
+// initrds are concatenated, as the boot loader passes them.
+func entryBootAssets(fs espFS, e *bootEntry, trust *uki.TrustStore) (*types.BootAssets, error) {
+	if e.UKI != "" {
+		ukiTempPath, ukiTempDir, err := copyUKIToTemp(fs, e.UKI)
+		if err != nil {
+			return nil, err
+		}
+		assets, err := buildBootAssetsFromUKI(ukiTempPath, ukiTempDir, trust)
+		if err != nil {
+			os.RemoveAll(ukiTempDir)
+			return nil, err
+		}
+		return assets, nil
+	}
+
+	if trust != nil {
+		return nil, errUnsignedKernel
+	}
+	if len(e.Initrds) == 0 {
+		return nil, errors.Newf("loader entry %s has no initrd", e.ID)
+	}
+	tmpDir, err := tempdir.MkdirTemp("loader-entry-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	kernelTempPath := filepath.Join(tmpDir, "kernel")
+	initrdTempPath := filepath.Join(tmpDir, "initrd")
+	if err := copyFromFS(fs, []string{e.Kernel}, kernelTempPath); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, errors.Wrap(err, "copy kernel")
+	}
+	if err := copyFromFS(fs, e.Initrds, initrdTempPath); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, errors.Wrap(err, "copy initrd")
+	}
+
+	kernelFile, err := os.Open(kernelTempPath)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, errors.Wrap(err, "open kernel")
+	}
+	initrdFile, err := os.Open(initrdTempPath)
+	if err != nil {
+		kernelFile.Close()
+		os.RemoveAll(tmpDir)
+		return nil, errors.Wrap(err, "open initrd")
+	}
+	shared := newFilesCloser([]*os.File{kernelFile, initrdFile}, tmpDir)
+
+	return &types.BootAssets{
+		Kernel:  &readerCloser{reader: kernelFile, closer: shared},
+		Initrd:  &readerCloser{reader: initrdFile, closer: shared},
+		Cmdline: e.Options,
+	}, nil
+}
+
+// copyFromFS writes the files at srcs on fs, one after another, to dst.
+func copyFromFS(fs espFS, srcs []string, dst string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, src := range srcs {
+		in, err := fs.OpenFile(src, os.O_RDONLY)
+		if err != nil {
+			return errors.Wrapf(err, "open %s", src)
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return errors.Wrapf(err, "copy %s", src)
+		}
+	}
+	return nil
+}
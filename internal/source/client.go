@@ -0,0 +1,213 @@
+package source
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// DefaultClientConfigPath is the client config read when -client-config is
+// not given, if it exists.
+const DefaultClientConfigPath = "/etc/boot-to-talos.conf"
+
+// ClientConfig holds site-wide network settings for fetching images: proxy,
+// extra CA certificates, registry mirrors and registry credentials. It is
+// read from a file of "key = value" lines, '#' starts a comment:
+//
+//	https_proxy = http://proxy.example.com:3128
+//	no_proxy = 10.0.0.0/8,.example.com
+//	ca_cert = /etc/pki/example-ca.pem
+//	registry_mirror = ghcr.io=registry.example.com/ghcr
+//	registry_auth = registry.example.com=user:token
+//
+// ca_cert, registry_mirror and registry_auth may be repeated.
+type ClientConfig struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	CACerts    []string                // PEM files trusted in addition to the system roots
+	Mirrors    map[string]string       // registry host -> mirror registry and optional path prefix
+	Auth       map[string]RegistryAuth // registry host -> credentials
+}
+
+// RegistryAuth holds basic auth credentials for a registry.
+type RegistryAuth struct {
+	Username string
+	Password string
+}
+
+//nolint:gochecknoglobals
+var (
+	// clientConfig is the applied client config, empty if none.
+	clientConfig = &ClientConfig{}
+	// rootCAs are the trusted roots for image downloads, nil for the
+	// system roots.
+	rootCAs *x509.CertPool
+)
+
+// LoadClientConfig reads the client config at path.
+func LoadClientConfig(path string) (*ClientConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open client config")
+	}
+	defer f.Close()
+
+	cfg, err := ParseClientConfig(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+	return cfg, nil
+}
+
+// ParseClientConfig parses a client config, see ClientConfig for the format.
+func ParseClientConfig(r io.Reader) (*ClientConfig, error) {
+	cfg := &ClientConfig{
+		Mirrors: map[string]string{},
+		Auth:    map[string]RegistryAuth{},
+	}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, errors.Newf("line %d: expected key = value", lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "http_proxy":
+			cfg.HTTPProxy = value
+		case "https_proxy":
+			cfg.HTTPSProxy = value
+		case "no_proxy":
+			cfg.NoProxy = value
+		case "ca_cert":
+			cfg.CACerts = append(cfg.CACerts, value)
+		case "registry_mirror":
+			registry, mirror, ok := strings.Cut(value, "=")
+			if !ok || registry == "" || mirror == "" {
+				return nil, errors.Newf("line %d: expected registry_mirror = <registry>=<mirror>", lineNo)
+			}
+			cfg.Mirrors[registry] = strings.TrimSuffix(mirror, "/")
+		case "registry_auth":
+			registry, creds, ok := strings.Cut(value, "=")
+			username, password, ok2 := strings.Cut(creds, ":")
+			if !ok || !ok2 || registry == "" || username == "" {
+				return nil, errors.Newf("line %d: expected registry_auth = <registry>=<user>:<password>", lineNo)
+			}
+			cfg.Auth[registry] = RegistryAuth{Username: username, Password: password}
+		default:
+			return nil, errors.Newf("line %d: unknown key %q", lineNo, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyClientConfig makes cfg the client config for image downloads. Proxy
+// variables already set in the environment take precedence over the file.
+func ApplyClientConfig(cfg *ClientConfig) error {
+	for env, value := range map[string]string{
+		"HTTP_PROXY":  cfg.HTTPProxy,
+		"HTTPS_PROXY": cfg.HTTPSProxy,
+		"NO_PROXY":    cfg.NoProxy,
+	} {
+		if value == "" || os.Getenv(env) != "" || os.Getenv(strings.ToLower(env)) != "" {
+			continue
+		}
+		if err := os.Setenv(env, value); err != nil {
+			return errors.Wrapf(err, "set %s", env)
+		}
+	}
+
+	if len(cfg.CACerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range cfg.CACerts {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return errors.Wrap(err, "read CA certificate")
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return errors.Newf("no PEM certificates in %s", file)
+			}
+		}
+		rootCAs = pool
+	}
+	httpClient.Transport = newTransport()
+
+	clientConfig = cfg
+	return nil
+}
+
+// newTransport returns an HTTP transport for image downloads. It clones
+// DefaultTransport to preserve proxy support from the environment,
+// connection pooling, timeouts and keep-alive settings.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs}
+	return transport
+}
+
+// registryRefs returns the references to try for ref: the configured mirror
+// of its registry first, then ref itself.
+func registryRefs(ref string) []string {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return []string{ref}
+	}
+	mirror, ok := clientConfig.Mirrors[r.Context().RegistryStr()]
+	if !ok {
+		return []string{ref}
+	}
+	mirrored := mirror + "/" + r.Context().RepositoryStr()
+	if d, ok := r.(name.Digest); ok {
+		mirrored += "@" + d.DigestStr()
+	} else {
+		mirrored += ":" + r.Identifier()
+	}
+	return []string{mirrored, ref}
+}
+
+// tryRegistryRefs calls fn with the references of registryRefs in turn until
+// one succeeds, logging mirror failures.
+func tryRegistryRefs(ref string, fn func(ref string) error) error {
+	refs := registryRefs(ref)
+	for _, mirrored := range refs[:len(refs)-1] {
+		err := fn(mirrored)
+		if err == nil {
+			return nil
+		}
+		log.Printf("warning: mirror %s failed, falling back to %s: %v", mirrored, ref, err)
+	}
+	return fn(ref)
+}
+
+// clientKeychain resolves registry credentials from the client config and
+// falls back to the Docker config keychain.
+type clientKeychain struct{}
+
+func (clientKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if auth, ok := clientConfig.Auth[target.RegistryStr()]; ok {
+		return authn.FromConfig(authn.AuthConfig{Username: auth.Username, Password: auth.Password}), nil
+	}
+	return authn.DefaultKeychain.Resolve(target)
+}
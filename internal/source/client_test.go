@@ -0,0 +1,94 @@
+package source
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestParseClientConfig(t *testing.T) {
+	cfg, err := ParseClientConfig(strings.NewReader(`
+# corporate network
+https_proxy = http://proxy.example.com:3128
+no_proxy = 10.0.0.0/8,.example.com  # internal
+ca_cert = /etc/pki/a.pem
+ca_cert = /etc/pki/b.pem
+registry_mirror = ghcr.io=registry.example.com/ghcr/
+registry_auth = registry.example.com=robot:p:ss
+`))
+	if err != nil {
+		t.Fatalf("ParseClientConfig error: %v", err)
+	}
+	want := &ClientConfig{
+		HTTPSProxy: "http://proxy.example.com:3128",
+		NoProxy:    "10.0.0.0/8,.example.com",
+		CACerts:    []string{"/etc/pki/a.pem", "/etc/pki/b.pem"},
+		Mirrors:    map[string]string{"ghcr.io": "registry.example.com/ghcr"},
+		Auth:       map[string]RegistryAuth{"registry.example.com": {Username: "robot", Password: "p:ss"}},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("ParseClientConfig() = %+v, want %+v", cfg, want)
+	}
+
+	for _, bad := range []string{
+		"https_proxy",
+		"proxy = http://proxy:3128",
+		"registry_mirror = ghcr.io",
+		"registry_auth = ghcr.io=token",
+	} {
+		if _, err := ParseClientConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseClientConfig(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestRegistryRefs(t *testing.T) {
+	saved := clientConfig
+	defer func() { clientConfig = saved }()
+	clientConfig = &ClientConfig{Mirrors: map[string]string{"ghcr.io": "registry.example.com/ghcr"}}
+
+	tests := []struct {
+		ref  string
+		want []string
+	}{
+		{"ghcr.io/siderolabs/installer:v1.11.6", []string{
+			"registry.example.com/ghcr/siderolabs/installer:v1.11.6",
+			"ghcr.io/siderolabs/installer:v1.11.6",
+		}},
+		{"ghcr.io/siderolabs/installer@sha256:" + strings.Repeat("a", 64), []string{
+			"registry.example.com/ghcr/siderolabs/installer@sha256:" + strings.Repeat("a", 64),
+			"ghcr.io/siderolabs/installer@sha256:" + strings.Repeat("a", 64),
+		}},
+		{"quay.io/example/installer:v1", []string{"quay.io/example/installer:v1"}},
+	}
+	for _, tt := range tests {
+		if got := registryRefs(tt.ref); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("registryRefs(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestClientKeychain(t *testing.T) {
+	saved := clientConfig
+	defer func() { clientConfig = saved }()
+	clientConfig = &ClientConfig{Auth: map[string]RegistryAuth{"registry.example.com": {Username: "robot", Password: "secret"}}}
+
+	repo, err := name.NewRepository("registry.example.com/talos/installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := clientKeychain{}.Resolve(repo)
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	cfg, err := auth.Authorization()
+	if err != nil {
+		t.Fatalf("Authorization error: %v", err)
+	}
+	if want := (&authn.AuthConfig{Username: "robot", Password: "secret"}); !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Authorization() = %+v, want %+v", cfg, want)
+	}
+}
@@ -25,11 +25,6 @@ func newSharedCloser(closer io.Closer, cleanupDir string) *sharedCloser {
 	return &sharedCloser{closer: closer, cleanupDirs: dirs}
 }
 
-// newSharedCloserMulti creates a sharedCloser with multiple cleanup directories.
-func newSharedCloserMulti(closer io.Closer, cleanupDirs []string) *sharedCloser {
-	return &sharedCloser{closer: closer, cleanupDirs: cleanupDirs}
-}
-
 // Close closes the underlying closer and removes all cleanup directories.
 // Safe to call multiple times - only the first call has effect.
 func (s *sharedCloser) Close() error {
@@ -5,28 +5,81 @@ package source
 import (
 	"archive/tar"
 	"context"
-	"crypto/tls"
+	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"golang.org/x/sys/unix"
 
+	"github.com/cozystack/boot-to-talos/internal/rootfs"
+	"github.com/cozystack/boot-to-talos/internal/status"
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
 	"github.com/cozystack/boot-to-talos/internal/types"
 	"github.com/cozystack/boot-to-talos/internal/uki"
 )
 
+// UKIGlob is a path.Match pattern for the UKI in container image layers. A
+// pattern without a slash is matched against the file name only. Empty uses
+// the default: a vmlinuz.efi below an install directory.
+//
+//nolint:gochecknoglobals
+var UKIGlob string
+
+// ImagePlatform is the platform pulled from container images, forcing it
+// instead of the host platform (linux/GOARCH) when set.
+//
+//nolint:gochecknoglobals
+var ImagePlatform *v1.Platform
+
+// ParseImagePlatform parses an os/arch[/variant] platform for ImagePlatform.
+func ParseImagePlatform(s string) (*v1.Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") || strings.ContainsAny(s, ": ") {
+		return nil, errors.Newf("invalid platform %q (want os/arch[/variant], e.g. linux/arm64)", s)
+	}
+	p := &v1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// imagePlatform returns the platform to pull: ImagePlatform or the host's.
+func imagePlatform() v1.Platform {
+	if ImagePlatform != nil {
+		return *ImagePlatform
+	}
+	return v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// maxUKICandidates limits the entries logged when no UKI is found.
+const maxUKICandidates = 20
+
 // ContainerSource implements ImageSource for container registry images.
 type ContainerSource struct {
-	ref     string
-	tmpDir  string // temporary directory for extracted files
-	ukiPath string // path to extracted UKI
+	ref        string
+	tmpDir     string // temporary directory for extracted files
+	ukiPath    string // path to extracted UKI
+	kernelPath string // path to extracted kernel, for images without a UKI
+	initrdPath string // path to extracted initrd, for images without a UKI
+
+	// ukis are the UKIs found in the image layers, the one to boot is
+	// chosen once all layers are read, see chooseUKI.
+	ukis []ukiCandidate
+
+	// candidates are the EFI binaries and kernels seen while looking for
+	// the UKI, logged if none matches.
+	candidates []string
 }
 
 // NewContainerSource creates a new ContainerSource.
@@ -42,27 +95,31 @@ func (s *ContainerSource) Reference() string {
 	return s.ref
 }
 
-// setupTransportWithProxy configures HTTP transport with proxy support.
-// Clones DefaultTransport to preserve connection pooling, timeouts, and keep-alive settings.
-func setupTransportWithProxy() http.RoundTripper {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
-	return transport
+// craneOptions returns the crane options for registry access: the download
+// transport, credentials from the client config and ctx.
+func craneOptions(ctx context.Context) []crane.Option {
+	return []crane.Option{
+		crane.WithTransport(newTransport()),
+		crane.WithAuthFromKeychain(clientKeychain{}),
+		crane.WithContext(ctx),
+	}
 }
 
 // containerPullTimeout is the maximum time allowed for pulling a container image.
 const containerPullTimeout = 30 * time.Minute
 
-// extractUKIFromImage pulls container image and extracts UKI to tmpDir.
+// extractUKIFromImage pulls the container image, extracts its UKIs to tmpDir
+// and chooses the one to boot. Images without a UKI fall back to a separate
+// kernel and initrd.
 //
 //nolint:gocognit
 func (s *ContainerSource) extractUKIFromImage() (err error) {
-	if s.ukiPath != "" {
+	if s.ukiPath != "" || s.kernelPath != "" {
 		return nil // already extracted
 	}
 
 	// Create temp directory
-	tmpDir, err := os.MkdirTemp("", "container-source-*")
+	tmpDir, err := tempdir.MkdirTemp("container-source-*")
 	if err != nil {
 		return errors.Wrap(err, "create temp dir")
 	}
@@ -74,6 +131,9 @@ func (s *ContainerSource) extractUKIFromImage() (err error) {
 			os.RemoveAll(s.tmpDir)
 			s.tmpDir = ""
 			s.ukiPath = ""
+			s.ukis = nil
+			s.kernelPath = ""
+			s.initrdPath = ""
 		}
 	}()
 
@@ -81,33 +141,170 @@ func (s *ContainerSource) extractUKIFromImage() (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), containerPullTimeout)
 	defer cancel()
 
-	transport := setupTransportWithProxy()
-	img, err := crane.Pull(s.ref, crane.WithTransport(transport), crane.WithContext(ctx))
+	layers, err := pullLayers(ctx, s.ref)
 	if err != nil {
-		return errors.Wrapf(err, "pull image %s", s.ref)
+		return err
+	}
+
+	// Extract the UKIs, or a kernel and initrd, from the layers
+	status.SetPhase(status.PhasePulling, s.ref)
+	for i, layer := range layers {
+		if err := s.processLayerForUKI(layer); err != nil {
+			return err
+		}
+		status.Progress(int64(i+1), int64(len(layers)))
+	}
+
+	if err := s.chooseUKI(); err != nil {
+		return err
+	}
+	if s.ukiPath != "" {
+		s.kernelPath, s.initrdPath = "", ""
+		return nil
+	}
+	if UKIGlob != "" {
+		s.logUKICandidates()
+		return errors.Newf("no UKI matching -uki-glob %q found in image", UKIGlob)
+	}
+	if s.kernelPath == "" || s.initrdPath == "" {
+		s.logUKICandidates()
+		return errors.New("neither a UKI kernel (vmlinuz.efi) nor a separate kernel and initrd found in image")
+	}
+	log.Printf("no UKI in image, using kernel %s and initrd %s", filepath.Base(s.kernelPath), filepath.Base(s.initrdPath))
+
+	return nil
+}
+
+// pullLayers pulls the layers of the image for the host platform, or
+// ImagePlatform. Multi-arch indexes are resolved explicitly, so that an image
+// without a manifest for the platform is reported as such instead of as
+// missing boot files. Layers a local containerd already has are read from
+// its content store.
+func pullLayers(ctx context.Context, ref string) ([]v1.Layer, error) {
+	var desc *remote.Descriptor
+	err := tryRegistryRefs(ref, func(ref string) error {
+		var err error
+		desc, err = crane.Get(ref, craneOptions(ctx)...)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "pull image %s", ref)
+	}
+
+	var img v1.Image
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read image index %s", ref)
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read image index %s", ref)
+		}
+		m, err := platformManifest(manifest.Manifests, imagePlatform())
+		if err != nil {
+			return nil, errors.Wrapf(err, "image %s", ref)
+		}
+		img, err = idx.Image(m.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pull %s manifest of %s", m.Platform, ref)
+		}
+	} else {
+		img, err = desc.Image()
+		if err != nil {
+			return nil, errors.Wrapf(err, "pull image %s", ref)
+		}
+		if err := checkImagePlatform(img); err != nil {
+			return nil, errors.Wrapf(err, "image %s", ref)
+		}
 	}
 
-	// Extract layers looking for UKI
 	layers, err := img.Layers()
 	if err != nil {
-		return errors.Wrap(err, "get layers")
+		return nil, errors.Wrap(err, "get layers")
 	}
+	if len(layers) == 0 {
+		return nil, errors.Newf("image %s has no layers", ref)
+	}
+	return withLocalContent(layers), nil
+}
 
-	for _, layer := range layers {
-		if err := s.processLayerForUKI(layer); err != nil {
-			return err
+// platformManifest returns the manifest of an image index for want. The
+// variant only has to match if want has one.
+func platformManifest(manifests []v1.Descriptor, want v1.Platform) (v1.Descriptor, error) {
+	var available []string
+	for _, m := range manifests {
+		if m.Platform == nil {
+			continue
 		}
+		if m.Platform.OS == want.OS && m.Platform.Architecture == want.Architecture &&
+			(want.Variant == "" || m.Platform.Variant == want.Variant) {
+			return m, nil
+		}
+		// Attestation manifests of BuildKit use unknown/unknown.
+		if m.Platform.OS != "unknown" {
+			available = append(available, platformString(*m.Platform))
+		}
+	}
+	if len(available) == 0 {
+		return v1.Descriptor{}, errors.Newf("no %s manifest in this multi-arch image", platformString(want))
 	}
+	return v1.Descriptor{}, errors.Newf("no %s manifest in this multi-arch image (available: %s)",
+		platformString(want), strings.Join(available, ", "))
+}
 
-	if s.ukiPath == "" {
-		return errors.New("UKI kernel (vmlinuz.efi) not found in image")
+// checkImagePlatform checks that a single-platform image is built for
+// ImagePlatform, if it is set. Images for the host platform are not checked,
+// as before.
+func checkImagePlatform(img v1.Image) error {
+	if ImagePlatform == nil {
+		return nil
 	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return errors.Wrap(err, "read image config")
+	}
+	got := v1.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}
+	if got.OS != ImagePlatform.OS || got.Architecture != ImagePlatform.Architecture ||
+		ImagePlatform.Variant != "" && got.Variant != ImagePlatform.Variant {
+		return errors.Newf("image is built for %s, not %s", platformString(got), platformString(*ImagePlatform))
+	}
+	return nil
+}
 
+// platformString formats p as os/arch[/variant].
+func platformString(p v1.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// containerProbeTimeout is the maximum time allowed for fetching image metadata.
+const containerProbeTimeout = 30 * time.Second
+
+// Probe fetches the image manifest to check that the reference exists and the
+// registry is reachable, without pulling any layers.
+func (s *ContainerSource) Probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), containerProbeTimeout)
+	defer cancel()
+
+	err := tryRegistryRefs(s.ref, func(ref string) error {
+		_, err := crane.Head(ref, craneOptions(ctx)...)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fetch manifest of %s", s.ref)
+	}
 	return nil
 }
 
-// processLayerForUKI processes a single layer looking for UKI file.
+// processLayerForUKI processes a single layer looking for UKI file, and for
+// a separate kernel and initrd in case the image has no UKI.
 // Using a separate function ensures defer r.Close() executes after each layer.
+//
+//nolint:gocognit
 func (s *ContainerSource) processLayerForUKI(layer interface{ Uncompressed() (io.ReadCloser, error) }) error {
 	r, err := layer.Uncompressed()
 	if err != nil {
@@ -130,65 +327,176 @@ func (s *ContainerSource) processLayerForUKI(layer interface{ Uncompressed() (io
 			continue
 		}
 
-		// Look for UKI kernel
-		name := strings.ToLower(header.Name)
-		if strings.Contains(name, "install") && strings.Contains(name, "vmlinuz.efi") {
-			target := filepath.Join(s.tmpDir, filepath.Base(header.Name))
-			f, err := os.Create(target)
-			if err != nil {
-				return errors.Wrap(err, "create UKI file")
+		// Look for UKI kernels; a later layer replaces a UKI at the same
+		// path.
+		if isUKI(header.Name) {
+			name := strings.TrimPrefix(header.Name, "./")
+			target := filepath.Join(s.tmpDir, fmt.Sprintf("uki%d-%s", len(s.ukis), filepath.Base(name)))
+			if err := extractTarFile(tr, target); err != nil {
+				return err
 			}
+			s.ukis = slices.DeleteFunc(s.ukis, func(c ukiCandidate) bool {
+				if c.name == name {
+					os.Remove(c.path)
+				}
+				return c.name == name
+			})
+			s.ukis = append(s.ukis, ukiCandidate{name: name, path: target})
+			continue
+		}
 
-			if _, err := io.Copy(f, tr); err != nil {
-				f.Close()
-				return errors.Wrap(err, "extract UKI")
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if base := strings.ToLower(filepath.Base(header.Name)); strings.HasSuffix(base, ".efi") || strings.HasPrefix(base, "vmlinuz") {
+			if len(s.candidates) < maxUKICandidates {
+				s.candidates = append(s.candidates, header.Name)
 			}
-			f.Close()
-
-			s.ukiPath = target
-			return nil // Found UKI, stop processing
 		}
+		// A custom pattern has no kernel and initrd fallback.
+		if UKIGlob != "" || len(s.ukis) > 0 || foreignArchPath(header.Name) {
+			continue
+		}
+		var target *string
+		switch {
+		case s.kernelPath == "" && matchBootFile(header.Name, kernelPaths):
+			target = &s.kernelPath
+		case s.initrdPath == "" && matchBootFile(header.Name, initrdPaths):
+			target = &s.initrdPath
+		default:
+			continue
+		}
+		path := filepath.Join(s.tmpDir, filepath.Base(header.Name))
+		if err := extractTarFile(tr, path); err != nil {
+			return err
+		}
+		*target = path
 	}
 }
 
-// GetBootAssets extracts kernel and initrd from UKI in container image.
-func (s *ContainerSource) GetBootAssets() (*types.BootAssets, error) {
-	if err := s.extractUKIFromImage(); err != nil {
-		return nil, err
+// isUKI reports whether the tar entry name is the UKI, see UKIGlob.
+func isUKI(name string) bool {
+	if UKIGlob == "" {
+		name = strings.ToLower(name)
+		return strings.Contains(name, "install") && strings.Contains(name, "vmlinuz.efi")
 	}
+	return matchUKIPattern(UKIGlob, name)
+}
 
-	// Extract UKI sections
-	ukiAssets, err := uki.Extract(s.ukiPath)
+// chooseUKI sets ukiPath to the UKI to boot among those found. With several,
+// UKIs in a directory of another architecture are dropped before the choice
+// of the package-level chooseUKI.
+func (s *ContainerSource) chooseUKI() error {
+	ukis := s.ukis
+	if len(ukis) > 1 {
+		native := slices.DeleteFunc(slices.Clone(ukis), func(c ukiCandidate) bool { return foreignArchPath(c.name) })
+		if len(native) > 0 {
+			ukis = native
+		}
+	}
+	if len(ukis) == 0 {
+		return nil
+	}
+	if len(ukis) > 1 {
+		for i := range ukis {
+			ukis[i].version = uki.Uname(ukis[i].path)
+		}
+	}
+	c, err := chooseUKI(ukis, len(s.ukis))
 	if err != nil {
-		return nil, errors.Wrap(err, "extract UKI")
+		return err
 	}
+	s.ukiPath = c.path
+	return nil
+}
 
-	// Read cmdline
-	cmdlineBytes, err := io.ReadAll(ukiAssets.Cmdline)
+// logUKICandidates logs the entries considered while looking for the UKI.
+func (s *ContainerSource) logUKICandidates() {
+	if len(s.candidates) == 0 {
+		log.Print("no EFI binaries or kernels found in the image layers")
+		return
+	}
+	log.Print("EFI binaries and kernels found in the image layers:")
+	for _, name := range s.candidates {
+		log.Printf("  %s", name)
+	}
+	if len(s.candidates) == maxUKICandidates {
+		log.Printf("  (only the first %d are listed)", maxUKICandidates)
+	}
+}
+
+// matchBootFile reports whether the tar entry name is one of paths or ends
+// with one of them, e.g. usr/install/amd64/vmlinuz matches vmlinuz.
+func matchBootFile(name string, paths []string) bool {
+	name = strings.TrimPrefix(name, "./")
+	for _, p := range paths {
+		if name == p || strings.HasSuffix(name, "/"+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignArchPath reports whether the tar entry lives in a directory named
+// after an architecture other than the pulled one, as in multi-arch installer
+// images (usr/install/amd64, usr/install/arm64).
+func foreignArchPath(name string) bool {
+	arch := imagePlatform().Architecture
+	for _, dir := range strings.Split(filepath.Dir(name), "/") {
+		if (dir == "amd64" || dir == "arm64") && dir != arch {
+			return true
+		}
+	}
+	return false
+}
+
+// extractTarFile writes the current tar entry to path.
+func extractTarFile(tr io.Reader, path string) error {
+	f, err := os.Create(path)
 	if err != nil {
-		ukiAssets.Close()
-		return nil, errors.Wrap(err, "read cmdline")
+		return errors.Wrapf(err, "create %s", path)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, tr); err != nil {
+		return errors.Wrapf(err, "extract %s", filepath.Base(path))
+	}
+	return nil
+}
+
+// GetBootAssets extracts kernel and initrd from UKI in container image.
+func (s *ContainerSource) GetBootAssets(trust *uki.TrustStore) (*types.BootAssets, error) {
+	if err := s.extractUKIFromImage(); err != nil {
+		return nil, err
+	}
+	if s.ukiPath == "" {
+		return s.kernelInitrdBootAssets(trust)
 	}
-	cmdline := strings.TrimRight(string(cmdlineBytes), "\x00")
-	cmdline = strings.TrimSpace(cmdline)
 
-	// Create BootAssets with readers
-	// Note: We need to reopen the UKI to get fresh readers since ukiAssets.Cmdline was consumed
-	ukiAssets.Close()
+	return buildBootAssetsFromUKI(s.ukiPath, "", trust)
+}
 
-	ukiAssets2, err := uki.Extract(s.ukiPath)
+// kernelInitrdBootAssets returns boot assets from the separate kernel and
+// initrd extracted from the image. They are removed with tmpDir on Close.
+func (s *ContainerSource) kernelInitrdBootAssets(trust *uki.TrustStore) (*types.BootAssets, error) {
+	if trust != nil {
+		return nil, errUnsignedKernel
+	}
+	kernelFile, err := os.Open(s.kernelPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open kernel")
+	}
+	initrdFile, err := os.Open(s.initrdPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "extract UKI for readers")
+		kernelFile.Close()
+		return nil, errors.Wrap(err, "open initrd")
 	}
 
-	// Create shared closer to prevent double-close when both Kernel and Initrd
-	// are closed (they share the same underlying PE file)
-	shared := &sharedCloser{closer: ukiAssets2}
+	shared := newFilesCloser([]*os.File{kernelFile, initrdFile}, "")
 
 	return &types.BootAssets{
-		Kernel:  &readerCloser{reader: ukiAssets2.Kernel, closer: shared},
-		Initrd:  &readerCloser{reader: ukiAssets2.Initrd, closer: shared},
-		Cmdline: cmdline,
+		Kernel:  &readerCloser{reader: kernelFile, closer: shared},
+		Initrd:  &readerCloser{reader: initrdFile, closer: shared},
+		Cmdline: "", // No cmdline for separate kernel/initrd
 	}, nil
 }
 
@@ -198,16 +506,9 @@ func (s *ContainerSource) GetInstallAssets(tmpDir string, _ uint64) (*types.Inst
 	ctx, cancel := context.WithTimeout(context.Background(), containerPullTimeout)
 	defer cancel()
 
-	transport := setupTransportWithProxy()
-	img, err := crane.Pull(s.ref, crane.WithTransport(transport), crane.WithContext(ctx))
+	layers, err := pullLayers(ctx, s.ref)
 	if err != nil {
-		return nil, errors.Wrapf(err, "pull image %s", s.ref)
-	}
-
-	// Get layers
-	layers, err := img.Layers()
-	if err != nil {
-		return nil, errors.Wrap(err, "get layers")
+		return nil, err
 	}
 
 	// Create destination directory for rootfs
@@ -217,10 +518,15 @@ func (s *ContainerSource) GetInstallAssets(tmpDir string, _ uint64) (*types.Inst
 	}
 
 	// Extract all layers to rootfs directory
-	for _, layer := range layers {
+	status.SetPhase(status.PhasePulling, s.ref)
+	for i, layer := range layers {
 		if err := extractLayer(layer, rootfsDir); err != nil {
-			return nil, errors.Wrap(err, "extract layer")
+			return nil, errors.Wrapf(err, "extract layer %d of %d", i+1, len(layers))
 		}
+		status.Progress(int64(i+1), int64(len(layers)))
+	}
+	if err := checkRootfs(rootfsDir); err != nil {
+		return nil, err
 	}
 
 	return &types.InstallAssets{
@@ -234,12 +540,16 @@ func (s *ContainerSource) GetInstallAssets(tmpDir string, _ uint64) (*types.Inst
 // extractLayer extracts a single container layer to destDir.
 //
 //nolint:gocognit
-func extractLayer(layer interface{ Uncompressed() (io.ReadCloser, error) }, destDir string) error {
+func extractLayer(layer interface{ Uncompressed() (io.ReadCloser, error) }, destDir string) (err error) {
 	r, err := layer.Uncompressed()
 	if err != nil {
 		return errors.Wrap(err, "uncompress layer")
 	}
-	defer r.Close()
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cerr, "close layer")
+		}
+	}()
 
 	tr := tar.NewReader(r)
 	for {
@@ -251,62 +561,78 @@ func extractLayer(layer interface{ Uncompressed() (io.ReadCloser, error) }, dest
 			return errors.Wrap(err, "read tar")
 		}
 
-		// Handle whiteout files (OCI layer deletions)
-		if suffix, found := strings.CutPrefix(filepath.Base(header.Name), ".wh."); found {
-			_ = os.RemoveAll(filepath.Join(destDir, filepath.Dir(header.Name), suffix))
+		// Security: prevent path traversal attacks. Entries are written
+		// below the directories they name as the rootfs resolves them, so
+		// symlinks extracted earlier cannot lead out of destDir either.
+		if !rootfs.WithinDir(destDir, filepath.Join(destDir, header.Name)) {
+			log.Printf("warning: skipping entry escaping the rootfs: %s", header.Name)
 			continue
 		}
+		parent, err := rootfs.Resolve(destDir, filepath.Dir(header.Name))
+		if err != nil {
+			return errors.Wrapf(err, "extract %s", header.Name)
+		}
+		base := filepath.Base(header.Name)
 
-		target := filepath.Join(destDir, header.Name)
-
-		// Security: prevent path traversal attacks
-		cleanTarget := filepath.Clean(target)
-		cleanDest := filepath.Clean(destDir)
-		if !strings.HasPrefix(cleanTarget, cleanDest+string(os.PathSeparator)) && cleanTarget != cleanDest {
-			continue // skip files that would escape destDir
+		// Handle whiteout files (OCI layer deletions)
+		if suffix, found := strings.CutPrefix(base, ".wh."); found {
+			_ = os.RemoveAll(filepath.Join(parent, suffix))
+			continue
 		}
 
+		target := filepath.Join(parent, base)
+
 		switch header.Typeflag {
 		case tar.TypeDir:
-			_ = os.MkdirAll(target, os.FileMode(header.Mode))
+			// A directory may be a symlink of an earlier layer, e.g. lib ->
+			// usr/lib: create what it points to.
+			if target, err = rootfs.Resolve(destDir, header.Name); err != nil {
+				return errors.Wrapf(err, "extract %s", header.Name)
+			}
+			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
+				return errors.Wrap(err, "create directory")
+			}
 		case tar.TypeReg:
 			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
 				return errors.Wrap(err, "create directory")
 			}
+			_ = os.Remove(target) // do not write through a symlink
 			f, err := os.Create(target)
 			if err != nil {
 				return errors.Wrap(err, "create file")
 			}
 			if _, err := io.Copy(f, tr); err != nil {
 				f.Close()
-				return errors.Wrap(err, "extract file")
+				return errors.Wrapf(err, "extract %s", header.Name)
+			}
+			if err := f.Close(); err != nil {
+				return errors.Wrapf(err, "extract %s", header.Name)
 			}
-			f.Close()
 			_ = os.Chmod(target, os.FileMode(header.Mode))
 		case tar.TypeSymlink:
-			// Validate symlink target doesn't escape destDir
-			linkTarget := header.Linkname
-			if !filepath.IsAbs(linkTarget) {
-				linkTarget = filepath.Join(filepath.Dir(target), linkTarget)
-			}
-			cleanLink := filepath.Clean(linkTarget)
-			if !strings.HasPrefix(cleanLink, cleanDest+string(os.PathSeparator)) && cleanLink != cleanDest {
-				log.Printf("warning: skipping symlink escape: %s -> %s", target, header.Linkname)
+			// Absolute targets are resolved within the rootfs like every
+			// path above; relative ones must not climb out of it.
+			link := header.Linkname
+			if !filepath.IsAbs(link) && !rootfs.WithinDir(destDir, filepath.Join(parent, link)) {
+				log.Printf("warning: skipping symlink escape: %s -> %s", header.Name, link)
 				continue
 			}
 			_ = os.MkdirAll(filepath.Dir(target), 0o755)
 			_ = os.Remove(target) // Remove existing symlink if any
-			if err := os.Symlink(header.Linkname, target); err != nil && !os.IsExist(err) {
-				log.Printf("warning: symlink %s -> %s: %v", target, header.Linkname, err)
+			if err := os.Symlink(link, target); err != nil && !os.IsExist(err) {
+				log.Printf("warning: symlink %s -> %s: %v", target, link, err)
 			}
 		case tar.TypeLink:
 			// Validate hardlink source doesn't escape destDir
-			linkSource := filepath.Join(destDir, header.Linkname)
-			cleanSource := filepath.Clean(linkSource)
-			if !strings.HasPrefix(cleanSource, cleanDest+string(os.PathSeparator)) && cleanSource != cleanDest {
-				log.Printf("warning: skipping hardlink escape: %s -> %s", target, header.Linkname)
+			if !rootfs.WithinDir(destDir, filepath.Join(destDir, header.Linkname)) {
+				log.Printf("warning: skipping hardlink escape: %s -> %s", header.Name, header.Linkname)
 				continue
 			}
+			sourceDir, err := rootfs.Resolve(destDir, filepath.Dir(header.Linkname))
+			if err != nil {
+				return errors.Wrapf(err, "extract %s", header.Name)
+			}
+			linkSource := filepath.Join(sourceDir, filepath.Base(header.Linkname))
 			_ = os.MkdirAll(filepath.Dir(target), 0o755)
 			if err := os.Link(linkSource, target); err != nil && !os.IsExist(err) {
 				log.Printf("warning: hardlink %s -> %s: %v", target, header.Linkname, err)
@@ -323,6 +649,31 @@ func extractLayer(layer interface{ Uncompressed() (io.ReadCloser, error) }, dest
 			_ = unix.Mknod(target, mode, dev)
 		}
 	}
+
+	// Read past the end of the tar archive, so that the layer reader
+	// checks the compressed stream and its digest.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return errors.Wrap(err, "read layer")
+	}
+	return nil
+}
+
+// rootfsRequired lists the paths of the installer rootfs the chroot install
+// needs; their absence means an incomplete extraction or a non-installer image.
+//
+//nolint:gochecknoglobals
+var rootfsRequired = []string{"usr/bin/installer", "lib"}
+
+// checkRootfs checks that the extracted rootfs has the files the installer
+// needs.
+func checkRootfs(rootfsDir string) error {
+	for _, name := range rootfsRequired {
+		// Lstat: absolute symlinks in the image point into the rootfs, not
+		// at the host.
+		if _, err := os.Lstat(filepath.Join(rootfsDir, name)); err != nil {
+			return errors.Newf("extracted image has no /%s: incomplete extraction or not a Talos installer image", name)
+		}
+	}
 	return nil
 }
 
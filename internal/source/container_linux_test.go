@@ -5,11 +5,29 @@ package source
 import (
 	"archive/tar"
 	"bytes"
+	"errors"
 	"io"
+	"log"
+	"net"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
 )
 
 // mockCloser tracks how many times Close was called.
@@ -88,7 +106,7 @@ func TestContainerSource_Close_CleansUpTmpDir(t *testing.T) {
 	source := NewContainerSource("invalid-registry.local/nonexistent:v0.0.0")
 
 	// Try to extract (this will fail, but might create tmpDir)
-	_, _ = source.GetBootAssets()
+	_, _ = source.GetBootAssets(nil)
 
 	// Save tmpDir path before Close
 	tmpDir := source.tmpDir
@@ -281,6 +299,43 @@ func TestExtractLayer_HardlinkEscape(t *testing.T) {
 	}
 }
 
+// TestExtractLayer_ThroughSymlink verifies that files below a symlink of an
+// earlier layer are written where the symlink points within destDir, not on
+// the host.
+func TestExtractLayer_ThroughSymlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	destDir := filepath.Join(tmpDir, "dest")
+	outside := filepath.Join(tmpDir, "outside")
+	for _, dir := range []string{destDir, outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tarData := range [][]byte{
+		createTarWithSymlink("abs", outside),
+		createTarWithSymlink("lib", "usr/lib"),
+		createTarWithFile("abs/file", []byte("abs")),
+		createTarWithFile("lib/module.ko", []byte("module")),
+	} {
+		if err := extractLayer(&mockLayer{data: tarData}, destDir); err != nil {
+			t.Fatalf("extractLayer error: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "file")); !os.IsNotExist(err) {
+		t.Errorf("file written through an absolute symlink to the host: %v", err)
+	}
+	for path, want := range map[string]string{
+		filepath.Join(destDir, outside, "file"):     "abs",
+		filepath.Join(destDir, "usr/lib/module.ko"): "module",
+	} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+
 // TestExtractLayer_ValidSymlink verifies that symlinks within destDir work correctly.
 func TestExtractLayer_ValidSymlink(t *testing.T) {
 	destDir := t.TempDir()
@@ -326,3 +381,416 @@ func TestExtractLayer_ValidSymlink(t *testing.T) {
 		t.Errorf("symlink target = %q, want %q", resolved, "../target.txt")
 	}
 }
+
+// createTarWithFiles creates a tar archive containing regular files in order.
+func createTarWithFiles(files [][2]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		_ = tw.WriteHeader(&tar.Header{
+			Name:     f[0],
+			Mode:     0o644,
+			Size:     int64(len(f[1])),
+			Typeflag: tar.TypeReg,
+		})
+		_, _ = tw.Write([]byte(f[1]))
+	}
+	_ = tw.Close()
+	return buf.Bytes()
+}
+
+// TestProcessLayerForUKI_KernelInitrdFallback verifies that images without a
+// UKI yield the kernel and initrd for the running architecture.
+func TestProcessLayerForUKI_KernelInitrdFallback(t *testing.T) {
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+
+	layer := &mockLayer{data: createTarWithFiles([][2]string{
+		{"usr/install/" + other + "/vmlinuz", "foreign-kernel"},
+		{"usr/install/" + other + "/initramfs.xz", "foreign-initrd"},
+		{"usr/install/" + runtime.GOARCH + "/vmlinuz", "kernel"},
+		{"usr/install/" + runtime.GOARCH + "/initramfs.xz", "initrd"},
+	})}
+
+	s := &ContainerSource{tmpDir: t.TempDir()}
+	if err := s.processLayerForUKI(layer); err != nil {
+		t.Fatalf("processLayerForUKI error: %v", err)
+	}
+	if s.ukiPath != "" {
+		t.Errorf("ukiPath = %q, want empty", s.ukiPath)
+	}
+
+	assets, err := s.GetBootAssets(nil)
+	if err != nil {
+		t.Fatalf("GetBootAssets error: %v", err)
+	}
+	defer assets.Close()
+
+	for name, tc := range map[string]struct {
+		r    io.Reader
+		want string
+	}{
+		"kernel": {assets.Kernel, "kernel"},
+		"initrd": {assets.Initrd, "initrd"},
+	} {
+		data, err := io.ReadAll(tc.r)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(data) != tc.want {
+			t.Errorf("%s = %q, want %q", name, data, tc.want)
+		}
+	}
+}
+
+func TestMatchBootFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"usr/install/amd64/vmlinuz", true},
+		{"./boot/vmlinuz", true},
+		{"vmlinuz", true},
+		{"usr/install/amd64/vmlinuz.efi", false},
+		{"usr/bin/notvmlinuz", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchBootFile(tt.name, kernelPaths); got != tt.want {
+			t.Errorf("matchBootFile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsUKI(t *testing.T) {
+	tests := []struct {
+		glob string
+		name string
+		want bool
+	}{
+		{"", "usr/install/amd64/vmlinuz.efi", true},
+		{"", "boot/vmlinuz.efi", false},
+		{"talos-*.efi", "./opt/custom/talos-v1.12.efi", true},
+		{"opt/*/talos.efi", "./opt/custom/talos.efi", true},
+		{"opt/*/talos.efi", "opt/talos.efi", false},
+		{"talos-*.efi", "usr/install/amd64/vmlinuz.efi", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.name, func(t *testing.T) {
+			orig := UKIGlob
+			UKIGlob = tt.glob
+			t.Cleanup(func() { UKIGlob = orig })
+			if got := isUKI(tt.name); got != tt.want {
+				t.Errorf("isUKI(%q) with glob %q = %v, want %v", tt.name, tt.glob, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestProcessLayerForUKI_Glob verifies that a custom pattern selects the UKI
+// and records the EFI binaries it skipped.
+func TestProcessLayerForUKI_Glob(t *testing.T) {
+	orig := UKIGlob
+	UKIGlob = "opt/talos/*.efi"
+	t.Cleanup(func() { UKIGlob = orig })
+
+	layer := &mockLayer{data: createTarWithFiles([][2]string{
+		{"usr/install/" + runtime.GOARCH + "/vmlinuz.efi", "default"},
+		{"opt/talos/custom.efi", "custom"},
+	})}
+	s := &ContainerSource{tmpDir: t.TempDir()}
+	if err := s.processLayerForUKI(layer); err != nil {
+		t.Fatalf("processLayerForUKI error: %v", err)
+	}
+	if err := s.chooseUKI(); err != nil {
+		t.Fatalf("chooseUKI error: %v", err)
+	}
+	data, err := os.ReadFile(s.ukiPath)
+	if err != nil || string(data) != "custom" {
+		t.Errorf("extracted UKI = %q, %v; want the custom one", data, err)
+	}
+	if want := []string{"usr/install/" + runtime.GOARCH + "/vmlinuz.efi"}; !slices.Equal(s.candidates, want) {
+		t.Errorf("candidates = %q, want %q", s.candidates, want)
+	}
+}
+
+// TestChooseUKI verifies that the UKI for the pulled architecture and
+// -uki-prefer pick among several, and that -yes refuses to guess.
+func TestChooseUKI(t *testing.T) {
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+	origGlob, origPrefer, origYes := UKIGlob, UKIPrefer, cli.YesFlag
+	t.Cleanup(func() { UKIGlob, UKIPrefer, cli.YesFlag = origGlob, origPrefer, origYes })
+	UKIGlob = "*.efi"
+	cli.YesFlag = true
+
+	tests := []struct {
+		name    string
+		prefer  string
+		files   []string
+		want    string
+		wantErr bool
+	}{
+		{"host architecture", "", []string{"usr/install/" + other + "/vmlinuz.efi", "usr/install/" + runtime.GOARCH + "/vmlinuz.efi"}, "usr/install/" + runtime.GOARCH + "/vmlinuz.efi", false},
+		{"preferred", "*-secure.efi", []string{"boot/talos.efi", "boot/talos-secure.efi"}, "boot/talos-secure.efi", false},
+		{"ambiguous", "", []string{"boot/talos.efi", "boot/talos-secure.efi"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UKIPrefer = tt.prefer
+			var files [][2]string
+			for _, name := range tt.files {
+				files = append(files, [2]string{name, name})
+			}
+			s := &ContainerSource{tmpDir: t.TempDir()}
+			if err := s.processLayerForUKI(&mockLayer{data: createTarWithFiles(files)}); err != nil {
+				t.Fatalf("processLayerForUKI error: %v", err)
+			}
+			err := s.chooseUKI()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "boot/talos-secure.efi") {
+					t.Errorf("chooseUKI error = %v, want one listing the UKIs", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("chooseUKI error: %v", err)
+			}
+			if data, _ := os.ReadFile(s.ukiPath); string(data) != tt.want {
+				t.Errorf("chosen UKI = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlatformManifest(t *testing.T) {
+	manifests := []v1.Descriptor{
+		{Digest: v1.Hash{Algorithm: "sha256", Hex: "aa"}, Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: v1.Hash{Algorithm: "sha256", Hex: "bb"}, Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"}},
+	}
+
+	m, err := platformManifest(manifests, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil || m.Digest.Hex != "aa" {
+		t.Errorf("platformManifest(amd64) = %v, %v; want the amd64 manifest", m.Digest, err)
+	}
+
+	_, err = platformManifest(manifests, v1.Platform{OS: "linux", Architecture: "arm64"})
+	if err == nil || !strings.Contains(err.Error(), "no linux/arm64 manifest") || !strings.Contains(err.Error(), "available: linux/amd64)") {
+		t.Errorf("platformManifest(arm64) error = %v, want missing arm64 manifest listing linux/amd64", err)
+	}
+}
+
+func TestParseImagePlatform(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *v1.Platform
+	}{
+		{"linux/arm64", &v1.Platform{OS: "linux", Architecture: "arm64"}},
+		{"linux/arm/v7", &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{"arm64", nil},
+		{"linux/", nil},
+		{"linux/arm64/v8/extra", nil},
+		{"linux/amd64:10.0", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseImagePlatform(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("ParseImagePlatform(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equals(*tt.want) {
+			t.Errorf("ParseImagePlatform(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestPlatformManifest_Variant(t *testing.T) {
+	manifests := []v1.Descriptor{
+		{Digest: v1.Hash{Algorithm: "sha256", Hex: "v6"}, Platform: &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{Digest: v1.Hash{Algorithm: "sha256", Hex: "v7"}, Platform: &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+	}
+	m, err := platformManifest(manifests, v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"})
+	if err != nil || m.Digest.Hex != "v7" {
+		t.Errorf("platformManifest(arm/v7) = %v, %v; want the v7 manifest", m.Digest, err)
+	}
+	_, err = platformManifest(manifests, v1.Platform{OS: "linux", Architecture: "arm", Variant: "v8"})
+	if err == nil || !strings.Contains(err.Error(), "available: linux/arm/v6, linux/arm/v7)") {
+		t.Errorf("platformManifest(arm/v8) error = %v, want missing manifest listing the variants", err)
+	}
+}
+
+func TestCheckImagePlatform(t *testing.T) {
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("random image: %v", err)
+	}
+	img, err = mutate.ConfigFile(img, &v1.ConfigFile{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("set config: %v", err)
+	}
+	if err := checkImagePlatform(img); err != nil {
+		t.Errorf("checkImagePlatform without -image-platform error: %v", err)
+	}
+
+	t.Cleanup(func() { ImagePlatform = nil })
+	ImagePlatform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	if err := checkImagePlatform(img); err != nil {
+		t.Errorf("checkImagePlatform(linux/amd64) error: %v", err)
+	}
+	ImagePlatform = &v1.Platform{OS: "linux", Architecture: "arm64"}
+	if err := checkImagePlatform(img); err == nil || !strings.Contains(err.Error(), "built for linux/amd64, not linux/arm64") {
+		t.Errorf("checkImagePlatform(linux/arm64) error = %v, want a platform mismatch", err)
+	}
+}
+
+func TestPullLayers_Index(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("random image: %v", err)
+	}
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        img,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: other}},
+	})
+	ref, err := name.ParseReference(host + "/talos/installer:v1")
+	if err != nil {
+		t.Fatalf("parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(ref, idx); err != nil {
+		t.Fatalf("push index: %v", err)
+	}
+
+	_, err = pullLayers(t.Context(), ref.String())
+	if err == nil || !strings.Contains(err.Error(), "no linux/"+runtime.GOARCH+" manifest") {
+		t.Errorf("pullLayers error = %v, want missing %s manifest", err, runtime.GOARCH)
+	}
+
+	ImagePlatform = &v1.Platform{OS: "linux", Architecture: other}
+	t.Cleanup(func() { ImagePlatform = nil })
+	layers, err := pullLayers(t.Context(), ref.String())
+	if err != nil || len(layers) != 1 {
+		t.Errorf("pullLayers with -image-platform linux/%s = %d layers, %v; want the forced platform image", other, len(layers), err)
+	}
+	ImagePlatform = nil
+
+	idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+		Add:        img,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: runtime.GOARCH}},
+	})
+	if err := remote.WriteIndex(ref, idx); err != nil {
+		t.Fatalf("push index: %v", err)
+	}
+	layers, err = pullLayers(t.Context(), ref.String())
+	if err != nil || len(layers) != 1 {
+		t.Errorf("pullLayers = %d layers, %v; want the host platform image", len(layers), err)
+	}
+}
+
+// errLayer is a layer whose stream fails after data, like a download that
+// breaks off or a digest mismatch detected at the end of the blob.
+type errLayer struct {
+	data []byte
+	err  error
+}
+
+func (l *errLayer) Uncompressed() (io.ReadCloser, error) {
+	return io.NopCloser(io.MultiReader(bytes.NewReader(l.data), iotest.ErrReader(l.err))), nil
+}
+
+func TestExtractLayer_Errors(t *testing.T) {
+	data := createTarWithFile("usr/bin/installer", bytes.Repeat([]byte("x"), 4096))
+
+	// Truncated in the middle of a file.
+	if err := extractLayer(&mockLayer{data: data[:1024]}, t.TempDir()); err == nil {
+		t.Error("extractLayer accepted a truncated layer")
+	}
+	// Stream error after the end of the tar archive.
+	errDigest := errors.New("digest mismatch")
+	if err := extractLayer(&errLayer{data: data, err: errDigest}, t.TempDir()); !errors.Is(err, errDigest) {
+		t.Errorf("extractLayer error = %v, want %v", err, errDigest)
+	}
+}
+
+func TestCheckRootfs(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkRootfs(dir); err == nil {
+		t.Error("checkRootfs accepted an empty rootfs")
+	}
+	if err := extractLayer(&mockLayer{data: createTarWithFile("usr/bin/installer", []byte("elf"))}, dir); err != nil {
+		t.Fatalf("extractLayer error: %v", err)
+	}
+	if err := os.Symlink("/usr/lib", filepath.Join(dir, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRootfs(dir); err != nil {
+		t.Errorf("checkRootfs error: %v", err)
+	}
+}
+
+func TestWithLocalContent(t *testing.T) {
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatalf("random image: %v", err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	store := containerdStore{socket: filepath.Join(dir, "containerd.sock"), root: filepath.Join(dir, "root")}
+	old := containerdStores
+	t.Cleanup(func() { containerdStores = old })
+	containerdStores = []containerdStore{store}
+
+	// Without containerd listening, the store is not used.
+	digest, _ := layers[0].Digest()
+	blobDir := filepath.Join(store.blobsDir(), digest.Algorithm)
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rc, _ := layers[0].Compressed()
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if err := os.WriteFile(filepath.Join(blobDir, digest.Hex), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := withLocalContent(slices.Clone(layers)); got[0] != layers[0] {
+		t.Error("withLocalContent used the content store without containerd running")
+	}
+
+	l, err := net.Listen("unix", store.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got := withLocalContent(slices.Clone(layers))
+	if got[0] == layers[0] || got[1] != layers[1] {
+		t.Fatal("withLocalContent did not replace exactly the stored layer")
+	}
+	want, _ := layers[0].Uncompressed()
+	wantData, _ := io.ReadAll(want)
+	r, err := got[0].Uncompressed()
+	if err != nil {
+		t.Fatalf("stored layer Uncompressed error: %v", err)
+	}
+	gotData, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(gotData, wantData) {
+		t.Error("stored layer content differs from the registry layer")
+	}
+}
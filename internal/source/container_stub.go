@@ -6,6 +6,7 @@ import (
 	"github.com/cockroachdb/errors"
 
 	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/cozystack/boot-to-talos/internal/uki"
 )
 
 // ContainerSource implements ImageSource for container registry images.
@@ -28,7 +29,7 @@ func (s *ContainerSource) Reference() string {
 }
 
 // GetBootAssets extracts kernel and initrd from UKI in container image.
-func (s *ContainerSource) GetBootAssets() (*types.BootAssets, error) {
+func (s *ContainerSource) GetBootAssets(_ *uki.TrustStore) (*types.BootAssets, error) {
 	return nil, errors.New("container source not supported on this platform")
 }
 
@@ -37,6 +38,11 @@ func (s *ContainerSource) GetInstallAssets(tmpDir string, sizeGiB uint64) (*type
 	return nil, errors.New("container source not supported on this platform")
 }
 
+// Probe checks that the image is reachable.
+func (s *ContainerSource) Probe() error {
+	return errors.New("container source not supported on this platform")
+}
+
 func (s *ContainerSource) Close() error {
 	return nil
 }
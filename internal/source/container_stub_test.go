@@ -36,7 +36,7 @@ func TestContainerSource_Reference(t *testing.T) {
 
 func TestContainerSource_GetBootAssets_ReturnsError(t *testing.T) {
 	source := NewContainerSource("test:latest")
-	assets, err := source.GetBootAssets()
+	assets, err := source.GetBootAssets(nil)
 	if err == nil {
 		t.Error("GetBootAssets should return error on non-Linux platform")
 	}
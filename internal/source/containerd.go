@@ -0,0 +1,108 @@
+//go:build linux
+
+package source
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+)
+
+// containerdStore is a containerd installation: its socket and root
+// directory. The content store below the root keeps the blobs of pulled
+// images by digest.
+type containerdStore struct {
+	socket string
+	root   string
+}
+
+// containerdStores are the containerd installations looked for, variables
+// so tests can point them at fixtures.
+//
+//nolint:gochecknoglobals
+var containerdStores = []containerdStore{
+	{"/run/containerd/containerd.sock", "/var/lib/containerd"},
+	{"/run/k3s/containerd/containerd.sock", "/var/lib/rancher/k3s/agent/containerd"},
+	{"/run/k3s/containerd/containerd.sock", "/var/lib/rancher/rke2/agent/containerd"},
+}
+
+// blobsDir returns the sha256 blob directory of the content store.
+func (c containerdStore) blobsDir() string {
+	return filepath.Join(c.root, "io.containerd.content.v1.content", "blobs")
+}
+
+// running reports whether containerd listens on the socket and the content
+// store exists.
+func (c containerdStore) running() bool {
+	fi, err := os.Stat(c.socket)
+	if err != nil || fi.Mode()&fs.ModeSocket == 0 {
+		return false
+	}
+	fi, err = os.Stat(c.blobsDir())
+	return err == nil && fi.IsDir()
+}
+
+// blob returns the path of the blob with digest h if the store has it
+// complete, "" otherwise.
+func (c containerdStore) blob(h v1.Hash, size int64) string {
+	p := filepath.Join(c.blobsDir(), h.Algorithm, h.Hex)
+	if fi, err := os.Stat(p); err != nil || !fi.Mode().IsRegular() || fi.Size() != size {
+		return ""
+	}
+	return p
+}
+
+// storedLayer is a layer whose compressed blob is read from a containerd
+// content store instead of the registry.
+type storedLayer struct {
+	v1.Layer
+
+	path string
+}
+
+func (l *storedLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+// withLocalContent replaces the layers found in the content store of a
+// running containerd by readers of the stored blobs, so that images the
+// host already pulled are not downloaded again. containerd verifies blobs
+// when it stores them. Layers it does not have are pulled as before.
+func withLocalContent(layers []v1.Layer) []v1.Layer {
+	for _, store := range containerdStores {
+		if !store.running() {
+			continue
+		}
+		local := 0
+		for i, layer := range layers {
+			digest, err := layer.Digest()
+			if err != nil {
+				continue
+			}
+			size, err := layer.Size()
+			if err != nil {
+				continue
+			}
+			p := store.blob(digest, size)
+			if p == "" {
+				continue
+			}
+			l, err := partial.CompressedToLayer(&storedLayer{Layer: layer, path: p})
+			if err != nil {
+				continue
+			}
+			layers[i] = l
+			local++
+		}
+		if local > 0 {
+			log.Printf("using %d of %d layers from the containerd content store in %s", local, len(layers), store.root)
+			return layers
+		}
+	}
+	return layers
+}
@@ -0,0 +1,104 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+
+	"github.com/cockroachdb/errors"
+)
+
+// HashReader computes the sha256 of all bytes read through it, so a stream
+// can be verified without reading it a second time.
+type HashReader struct {
+	reader io.Reader
+	hash   hash.Hash
+}
+
+// NewHashReader wraps r, hashing everything read from it.
+func NewHashReader(r io.Reader) *HashReader {
+	h := sha256.New()
+	return &HashReader{reader: io.TeeReader(r, h), hash: h}
+}
+
+func (r *HashReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// Sum returns the hex-encoded sha256 of the bytes read so far.
+func (r *HashReader) Sum() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
+
+// Digests exposes the sha256 digests of a decompressed stream opened with
+// OpenDecompressedWithDigests. They are complete once the stream was read
+// to EOF.
+type Digests struct {
+	compressed   *HashReader
+	uncompressed *HashReader
+}
+
+// Compressed returns the sha256 of the file as stored (the compressed bytes).
+// Trailing bytes the decompressor did not consume are hashed first, so the
+// digest always covers the whole file.
+func (d *Digests) Compressed() string {
+	_, _ = io.Copy(io.Discard, d.compressed)
+	return d.compressed.Sum()
+}
+
+// Uncompressed returns the sha256 of the decompressed bytes read so far.
+func (d *Digests) Uncompressed() string {
+	return d.uncompressed.Sum()
+}
+
+// hashReadCloser hashes the decompressed stream and closes the underlying reader.
+type hashReadCloser struct {
+	*HashReader
+	closer io.Closer
+}
+
+func (r *hashReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+// openImage opens the image at path decompressed, like OpenDecompressed, and
+// logs its sha256 digests once it was read to EOF and is closed, so that the
+// image that was written or booted can be checked against a published one.
+func openImage(path string) (io.ReadCloser, int64, error) {
+	rc, size, digests, err := OpenDecompressedWithDigests(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &digestLogger{ReadCloser: rc, path: path, digests: digests}, size, nil
+}
+
+// digestLogger logs the digests of a completely read image on Close.
+type digestLogger struct {
+	io.ReadCloser
+	path    string
+	digests *Digests
+	eof     bool
+}
+
+func (r *digestLogger) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *digestLogger) Close() error {
+	if r.eof {
+		// The compressed digest reads the rest of the file, before it is closed.
+		compressed, uncompressed := r.digests.Compressed(), r.digests.Uncompressed()
+		if compressed == uncompressed {
+			log.Printf("image %s: sha256 %s", r.path, compressed)
+		} else {
+			log.Printf("image %s: sha256 %s, uncompressed sha256 %s", r.path, compressed, uncompressed)
+		}
+	}
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,140 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHashReader(t *testing.T) {
+	data := bytes.Repeat([]byte("talos"), 1000)
+	r := NewHashReader(bytes.NewReader(data))
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatalf("Copy error: %v", err)
+	}
+	if got, want := r.Sum(), sha256Hex(data); got != want {
+		t.Errorf("Sum() = %s, want %s", got, want)
+	}
+}
+
+func TestOpenDecompressedWithDigests(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := bytes.Repeat([]byte("raw disk image content "), 4096)
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter error: %v", err)
+	}
+	compressed := enc.EncodeAll(content, nil)
+	enc.Close()
+
+	tests := []struct {
+		name string
+		file string
+		data []byte
+	}{
+		{"zstd", "image.raw.zst", compressed},
+		{"xz", "image.raw.xz", xzCompress(t, content)},
+		{"uncompressed", "image.raw", content},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, tt.file)
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatalf("WriteFile error: %v", err)
+			}
+
+			rc, _, digests, err := OpenDecompressedWithDigests(path)
+			if err != nil {
+				t.Fatalf("OpenDecompressedWithDigests error: %v", err)
+			}
+			defer rc.Close()
+
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("ReadAll error: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatal("decompressed content mismatch")
+			}
+
+			if d := digests.Uncompressed(); d != sha256Hex(content) {
+				t.Errorf("Uncompressed() = %s, want %s", d, sha256Hex(content))
+			}
+			if d := digests.Compressed(); d != sha256Hex(tt.data) {
+				t.Errorf("Compressed() = %s, want %s", d, sha256Hex(tt.data))
+			}
+		})
+	}
+}
+
+func TestOpenImageLogsDigests(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	content := []byte("raw disk image content")
+	compressed := xzCompress(t, content)
+	path := filepath.Join(t.TempDir(), "image.raw.xz")
+	if err := os.WriteFile(path, compressed, 0o644); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+
+	rc, _, err := openImage(path)
+	if err != nil {
+		t.Fatalf("openImage error: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		t.Fatalf("Copy error: %v", err)
+	}
+	rc.Close()
+	want := "sha256 " + sha256Hex(compressed) + ", uncompressed sha256 " + sha256Hex(content)
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("log = %q, want %q", logs.String(), want)
+	}
+
+	// A partly read image has no digest to report.
+	logs.Reset()
+	rc, _, err = openImage(path)
+	if err != nil {
+		t.Fatalf("openImage error: %v", err)
+	}
+	rc.Close()
+	if strings.Contains(logs.String(), "sha256") {
+		t.Errorf("log = %q, want no digest for an unread image", logs.String())
+	}
+}
+
+func TestDownloadToFileWithDigest(t *testing.T) {
+	content := []byte("remote image bytes")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "download")
+	digest, err := DownloadToFileWithDigest(context.Background(), ts.URL, dest, nil)
+	if err != nil {
+		t.Fatalf("DownloadToFileWithDigest error: %v", err)
+	}
+	if digest != sha256Hex(content) {
+		t.Errorf("digest = %s, want %s", digest, sha256Hex(content))
+	}
+}
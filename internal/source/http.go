@@ -1,8 +1,13 @@
 package source
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -10,12 +15,53 @@ import (
 
 	"github.com/cockroachdb/errors"
 
+	"github.com/cozystack/boot-to-talos/internal/status"
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
 	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/cozystack/boot-to-talos/internal/uki"
 )
 
 // downloadTimeout is the maximum time allowed for downloading an image.
 const downloadTimeout = 30 * time.Minute
 
+// maxRedirects is the maximum number of redirects followed for one request.
+const maxRedirects = 10
+
+// maxResumes is how often an interrupted download is resumed with a range
+// request before it fails.
+const maxResumes = 5
+
+//nolint:gochecknoglobals
+var (
+	// ForbidRedirectDowngrade rejects redirects from https to http.
+	ForbidRedirectDowngrade bool
+	// HTTPCacheDir is the directory of the persistent download cache for
+	// HTTP sources, "" disables the cache.
+	HTTPCacheDir string
+
+	httpClient = &http.Client{CheckRedirect: checkRedirect}
+)
+
+// checkRedirect caps the redirect chain, optionally rejects https to http
+// downgrades and logs every redirect target.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.Newf("stopped after %d redirects", maxRedirects)
+	}
+	prev := via[len(via)-1].URL
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return errors.Newf("redirect to unsupported scheme %q", req.URL.Scheme)
+	}
+	if prev.Scheme == "https" && req.URL.Scheme == "http" {
+		if ForbidRedirectDowngrade {
+			return errors.Newf("refusing redirect from https to http (%s)", req.URL.Redacted())
+		}
+		log.Printf("warning: redirect downgrades https to http: %s", req.URL.Redacted())
+	}
+	log.Printf("redirected to %s", req.URL.Redacted())
+	return nil
+}
+
 // ProgressFunc is called during download to report progress.
 type ProgressFunc func(current, total int64)
 
@@ -40,51 +86,118 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 
 // DownloadToFile downloads a URL to a local file with optional progress reporting.
 func DownloadToFile(ctx context.Context, url, destPath string, onProgress ProgressFunc) error {
+	_, err := DownloadToFileWithDigest(ctx, url, destPath, onProgress)
+	return err
+}
+
+// DownloadToFileWithDigest is like DownloadToFile and also returns the
+// hex-encoded sha256 of the downloaded bytes, computed while writing them.
+func DownloadToFileWithDigest(ctx context.Context, url, destPath string, onProgress ProgressFunc) (string, error) {
+	_, digest, err := fetch(ctx, url, nil, destPath, onProgress)
+	return digest, err
+}
+
+// fetch downloads url to destPath like DownloadToFileWithDigest, sending the
+// extra request headers. A 304 Not Modified answer to a conditional request
+// is returned without writing destPath.
+func fetch(ctx context.Context, url string, header http.Header, destPath string, onProgress ProgressFunc) (*http.Response, string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
-		return errors.Wrap(err, "create request")
+		return nil, "", errors.Wrap(err, "create request")
+	}
+	for key, values := range header {
+		req.Header[key] = values
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return errors.Wrapf(err, "download %s", url)
+		return nil, "", errors.Wrapf(err, "download %s", url)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && len(header) > 0 {
+		return resp, "", nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return errors.Newf("download %s: HTTP %d %s", url, resp.StatusCode, resp.Status)
+		return nil, "", errors.Newf("download %s: HTTP %d %s", url, resp.StatusCode, resp.Status)
 	}
 
 	// Validate Content-Type to catch error pages served as 200 OK
 	contentType := resp.Header.Get("Content-Type")
 	if contentType != "" && strings.HasPrefix(contentType, "text/html") {
-		return errors.Newf("download %s: unexpected Content-Type %s (server may have returned error page)", url, contentType)
+		return nil, "", errors.Newf("download %s: unexpected Content-Type %s (server may have returned error page)", url, contentType)
 	}
 
 	// Create destination file
 	file, err := os.Create(destPath)
 	if err != nil {
-		return errors.Wrapf(err, "create file %s", destPath)
+		return nil, "", errors.Wrapf(err, "create file %s", destPath)
 	}
 	defer file.Close()
 
-	// Wrap reader with progress reporting
-	var reader io.Reader = resp.Body
-	if onProgress != nil {
-		reader = &progressReader{
-			reader:     resp.Body,
-			total:      resp.ContentLength,
-			onProgress: onProgress,
+	// Copy data, resuming where an interrupted transfer stopped
+	hash := sha256.New()
+	dst := io.MultiWriter(file, hash)
+	body := resp.Body
+	defer func() { body.Close() }()
+	var written int64
+	for attempt := 1; ; attempt++ {
+		reader := io.Reader(body)
+		if onProgress != nil {
+			reader = &progressReader{
+				reader:     body,
+				total:      resp.ContentLength,
+				current:    written,
+				onProgress: onProgress,
+			}
+		}
+		n, err := io.Copy(dst, reader)
+		written += n
+		if err == nil {
+			break
+		}
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return nil, "", errors.Wrapf(err, "write file %s", destPath)
+		}
+		if attempt > maxResumes || ctx.Err() != nil || resp.Header.Get("Accept-Ranges") != "bytes" {
+			return nil, "", errors.Wrapf(err, "download %s", url)
+		}
+		log.Printf("download of %s interrupted after %d bytes (%v), resuming", url, written, err)
+		body.Close()
+		if body, err = resumeDownload(ctx, url, resp.Header, written); err != nil {
+			body = http.NoBody
+			return nil, "", err
 		}
 	}
 
-	// Copy data
-	_, err = io.Copy(file, reader)
+	return resp, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// resumeDownload requests the rest of url from byte offset on, for a
+// transfer that was interrupted. The server must send exactly that range
+// of the same file: If-Range falls back to the whole, changed file, which
+// cannot be appended.
+func resumeDownload(ctx context.Context, url string, first http.Header, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
-		return errors.Wrapf(err, "write file %s", destPath)
+		return nil, errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if validator := cmp.Or(first.Get("ETag"), first.Get("Last-Modified")); validator != "" {
+		req.Header.Set("If-Range", validator)
 	}
 
-	return nil
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resume download %s", url)
+	}
+	if resp.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+		resp.Body.Close()
+		return nil, errors.Newf("resume download %s at byte %d: HTTP %s", url, offset, resp.Status)
+	}
+	return resp.Body, nil
 }
 
 // HTTPSource wraps a remote image that needs to be downloaded first.
@@ -92,6 +205,7 @@ type HTTPSource struct {
 	url             string
 	targetType      types.ImageSourceType
 	tempFile        string            // path to downloaded file
+	cached          bool              // tempFile belongs to the download cache
 	delegatedSource types.ImageSource // source created for delegation
 }
 
@@ -111,8 +225,36 @@ func (s *HTTPSource) Reference() string {
 	return s.url
 }
 
+// httpProbeTimeout is the maximum time allowed for checking the remote image.
+const httpProbeTimeout = 30 * time.Second
+
+// Probe sends a HEAD request to check that the remote image exists.
+func (s *HTTPSource) Probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url, http.NoBody)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "check %s", s.url)
+	}
+	resp.Body.Close()
+
+	// Some servers do not implement HEAD; the download will tell.
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Newf("check %s: HTTP %s", s.url, resp.Status)
+	}
+	return nil
+}
+
 // GetBootAssets downloads the image and delegates to appropriate source.
-func (s *HTTPSource) GetBootAssets() (*types.BootAssets, error) {
+func (s *HTTPSource) GetBootAssets(trust *uki.TrustStore) (*types.BootAssets, error) {
 	// Download to temp file
 	if err := s.ensureDownloaded(); err != nil {
 		return nil, err
@@ -123,11 +265,11 @@ func (s *HTTPSource) GetBootAssets() (*types.BootAssets, error) {
 	case types.ImageSourceRAW:
 		rawSource := NewRAWSource(s.tempFile)
 		s.delegatedSource = rawSource
-		return rawSource.GetBootAssets()
+		return rawSource.GetBootAssets(trust)
 	case types.ImageSourceISO:
 		isoSource := NewISOSource(s.tempFile)
 		s.delegatedSource = isoSource
-		return isoSource.GetBootAssets()
+		return isoSource.GetBootAssets(trust)
 	case types.ImageSourceContainer:
 		return nil, errors.New("HTTP source cannot handle container images - use container source directly")
 	}
@@ -161,8 +303,21 @@ func (s *HTTPSource) ensureDownloaded() error {
 		return nil // already downloaded
 	}
 
+	status.SetPhase(status.PhasePulling, s.url)
+	if HTTPCacheDir != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
+		defer cancel()
+		path, _, cached, err := cachedDownload(ctx, HTTPCacheDir, s.url, status.Progress)
+		if err != nil {
+			return errors.Wrap(err, "download")
+		}
+		s.tempFile = path
+		s.cached = cached
+		return nil
+	}
+
 	// Create temp file
-	tmpFile, err := os.CreateTemp("", "http-source-*")
+	tmpFile, err := tempdir.CreateTemp("http-source-*")
 	if err != nil {
 		return errors.Wrap(err, "create temp file")
 	}
@@ -172,10 +327,12 @@ func (s *HTTPSource) ensureDownloaded() error {
 	// Download with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
 	defer cancel()
-	if err := DownloadToFile(ctx, s.url, tmpPath, nil); err != nil {
+	digest, err := DownloadToFileWithDigest(ctx, s.url, tmpPath, status.Progress)
+	if err != nil {
 		os.Remove(tmpPath)
 		return errors.Wrap(err, "download")
 	}
+	log.Printf("downloaded %s (sha256 %s)", s.url, digest)
 
 	s.tempFile = tmpPath
 	return nil
@@ -192,13 +349,14 @@ func (s *HTTPSource) Close() error {
 		s.delegatedSource = nil
 	}
 
-	// Clean up downloaded temp file if any
-	if s.tempFile != "" {
+	// Clean up downloaded temp file if any, cached downloads are kept
+	if s.tempFile != "" && !s.cached {
 		if err := os.Remove(s.tempFile); err != nil && !os.IsNotExist(err) {
 			errs = append(errs, err)
 		}
-		s.tempFile = ""
 	}
+	s.tempFile = ""
+	s.cached = false
 
 	return errors.Join(errs...)
 }
@@ -3,14 +3,19 @@ package source
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cozystack/boot-to-talos/internal/testutil"
 	"github.com/cozystack/boot-to-talos/internal/types"
@@ -108,6 +113,75 @@ func TestDownloadToFileWithProgress(t *testing.T) {
 	}
 }
 
+func TestDownloadToFile_Resume(t *testing.T) {
+	content := bytes.Repeat([]byte("talos image "), 10000)
+	modTime := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+		if r.Header.Get("Range") == "" {
+			// Send the first half, then drop the connection.
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "image")
+	var last int64
+	digest, err := DownloadToFileWithDigest(context.Background(), ts.URL, dest, func(current, total int64) {
+		last = current
+	})
+	if err != nil {
+		t.Fatalf("DownloadToFileWithDigest: %v", err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded %d bytes, want the %d-byte image", len(got), len(content))
+	}
+	sum := sha256.Sum256(content)
+	if digest != hex.EncodeToString(sum[:]) {
+		t.Errorf("digest = %s, want the sha256 of the whole image", digest)
+	}
+	if last != int64(len(content)) {
+		t.Errorf("last progress = %d, want %d", last, len(content))
+	}
+	if len(requests) != 2 || requests[1] != fmt.Sprintf("bytes=%d-", len(content)/2) {
+		t.Errorf("requests with Range %q, want one resume at byte %d", requests, len(content)/2)
+	}
+}
+
+func TestDownloadToFile_NoResumeWithoutRanges(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	err := DownloadToFile(context.Background(), ts.URL, filepath.Join(t.TempDir(), "image"), nil)
+	if err == nil {
+		t.Fatal("DownloadToFile succeeded with a truncated transfer")
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, want no resume without Accept-Ranges", requests)
+	}
+}
+
 func TestDownloadToFile_NotFound(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
@@ -230,7 +304,7 @@ func TestHTTPSource_GetBootAssets_RAW(t *testing.T) {
 	source := NewHTTPSource(ts.URL+"/test.raw", types.ImageSourceRAW)
 	defer source.Close()
 
-	assets, err := source.GetBootAssets()
+	assets, err := source.GetBootAssets(nil)
 	if err != nil {
 		t.Fatalf("GetBootAssets error: %v", err)
 	}
@@ -281,7 +355,7 @@ func TestHTTPSource_GetInstallAssets_RAW(t *testing.T) {
 
 func TestHTTPSource_GetBootAssets_ISO_NotSupported(t *testing.T) {
 	source := NewHTTPSource("https://example.com/test.iso", types.ImageSourceISO)
-	_, err := source.GetBootAssets()
+	_, err := source.GetBootAssets(nil)
 	if err == nil {
 		t.Error("GetBootAssets for ISO should return error (not supported)")
 	}
@@ -336,3 +410,108 @@ func TestHTTPSource_Close_WithTempFile(t *testing.T) {
 		t.Error("tempFile field should be cleared after Close()")
 	}
 }
+
+func TestHTTPSourceProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"not found", http.StatusNotFound, true},
+		{"head not allowed", http.StatusMethodNotAllowed, false},
+		{"server error", http.StatusBadGateway, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					t.Errorf("expected HEAD request, got %s", r.Method)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			err := Probe(NewHTTPSource(ts.URL+"/talos.raw.xz", types.ImageSourceRAW))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeLocalSource(t *testing.T) {
+	if err := Probe(NewRAWSource("/nonexistent.raw")); err != nil {
+		t.Errorf("Probe() of local source should not fail: %v", err)
+	}
+}
+
+func TestDownloadToFile_Redirects(t *testing.T) {
+	content := "redirected content"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/final":
+			w.Write([]byte(content))
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	tmpPath := filepath.Join(t.TempDir(), "download")
+
+	if err := DownloadToFile(context.Background(), ts.URL+"/start", tmpPath, nil); err != nil {
+		t.Fatalf("DownloadToFile error: %v", err)
+	}
+	data, err := os.ReadFile(tmpPath)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("Downloaded content mismatch: got %q, want %q", data, content)
+	}
+
+	err = DownloadToFile(context.Background(), ts.URL+"/loop", tmpPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("expected redirect limit error, got %v", err)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	newReq := func(url string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		forbid  bool
+		wantErr bool
+	}{
+		{"https to https", "https://a.example/x", "https://b.example/y", true, false},
+		{"http to https", "http://a.example/x", "https://b.example/y", true, false},
+		{"downgrade allowed", "https://a.example/x", "http://b.example/y", false, false},
+		{"downgrade forbidden", "https://a.example/x", "http://b.example/y", true, true},
+		{"unsupported scheme", "https://a.example/x", "ftp://b.example/y", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ForbidRedirectDowngrade = tt.forbid
+			defer func() { ForbidRedirectDowngrade = false }()
+
+			err := checkRedirect(newReq(tt.to), []*http.Request{newReq(tt.from)})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRedirect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
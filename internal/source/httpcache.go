@@ -0,0 +1,148 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+)
+
+// cacheEntry describes a download in the HTTP cache. It is stored as JSON
+// next to the downloaded file.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Size         int64  `json:"size"`
+	Digest       string `json:"sha256"`
+}
+
+// cachePaths returns the data and metadata files of url in the cache dir.
+func cachePaths(dir, url string) (data, meta string) {
+	sum := sha256.Sum256([]byte(url))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, key+".img"), filepath.Join(dir, key+".json")
+}
+
+// loadCacheEntry returns the cache entry of url if there is one and its file
+// has the recorded size.
+func loadCacheEntry(dir, url string) (*cacheEntry, bool) {
+	data, meta := cachePaths(dir, url)
+	b, err := os.ReadFile(meta)
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil || entry.URL != url {
+		return nil, false
+	}
+	fi, err := os.Stat(data)
+	if err != nil || fi.Size() != entry.Size {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// fileDigest returns the hex-encoded sha256 of the file at path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hashed := NewHashReader(f)
+	if _, err := io.Copy(io.Discard, hashed); err != nil {
+		return "", errors.Wrapf(err, "read %s", path)
+	}
+	return hashed.Sum(), nil
+}
+
+// cachedDownload returns a file with the content of url from the cache in
+// dir. A cached copy is revalidated with a conditional request and its
+// sha256 is checked against the one recorded at download time; it is only
+// downloaded again when the server reports a change. cached is false when
+// the server sends neither ETag nor Last-Modified: the file cannot be
+// revalidated and is left for the caller to remove. Download progress is
+// reported to onProgress, which may be nil.
+func cachedDownload(ctx context.Context, dir, url string, onProgress ProgressFunc) (path, digest string, cached bool, err error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", false, errors.Wrap(err, "create cache directory")
+	}
+	data, meta := cachePaths(dir, url)
+
+	header := http.Header{}
+	entry, ok := loadCacheEntry(dir, url)
+	if ok {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	part := data + ".part"
+	resp, digest, err := fetch(ctx, url, header, part, onProgress)
+	if err != nil {
+		os.Remove(part)
+		return "", "", false, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		sum, err := fileDigest(data)
+		if err != nil {
+			return "", "", false, errors.Wrap(err, "verify cached download")
+		}
+		if sum == entry.Digest {
+			log.Printf("using cached %s (sha256 %s)", url, sum)
+			return data, sum, true, nil
+		}
+		log.Printf("warning: cached %s is corrupt (sha256 %s, expected %s), downloading again", url, sum, entry.Digest)
+		if err := os.Remove(meta); err != nil {
+			return "", "", false, errors.Wrap(err, "remove cache entry")
+		}
+		return cachedDownload(ctx, dir, url, onProgress)
+	}
+
+	fi, err := os.Stat(part)
+	if err != nil {
+		return "", "", false, err
+	}
+	if err := os.Remove(meta); err != nil && !os.IsNotExist(err) {
+		return "", "", false, errors.Wrap(err, "remove cache entry")
+	}
+	if err := os.Rename(part, data); err != nil {
+		os.Remove(part)
+		return "", "", false, errors.Wrap(err, "store download in cache")
+	}
+	log.Printf("downloaded %s (sha256 %s)", url, digest)
+
+	entry = &cacheEntry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         fi.Size(),
+		Digest:       digest,
+	}
+	if entry.ETag == "" && entry.LastModified == "" {
+		log.Printf("not caching %s: the server sends neither ETag nor Last-Modified", url)
+		return data, digest, false, nil
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return "", "", false, err
+	}
+	if err := os.WriteFile(meta, b, 0o600); err != nil {
+		log.Printf("warning: cannot record %s in the download cache: %v", url, err)
+		return data, digest, false, nil
+	}
+	return data, digest, true, nil
+}
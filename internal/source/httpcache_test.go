@@ -0,0 +1,85 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCachedDownload(t *testing.T) {
+	content := "talos image v1"
+	etag := `"v1"`
+	var downloads int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if etag != "" && r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	ctx := context.Background()
+	var progress int64
+	get := func() (string, bool) {
+		t.Helper()
+		path, digest, cached, err := cachedDownload(ctx, dir, ts.URL, func(current, _ int64) { progress = current })
+		if err != nil {
+			t.Fatalf("cachedDownload: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Fatalf("content = %q, want %q", data, content)
+		}
+		if want, _ := fileDigest(path); digest != want {
+			t.Fatalf("digest = %s, want %s", digest, want)
+		}
+		return path, cached
+	}
+
+	path, cached := get()
+	if !cached || downloads != 1 {
+		t.Fatalf("first run: cached = %v, downloads = %d", cached, downloads)
+	}
+	if progress != int64(len(content)) {
+		t.Errorf("first run: progress = %d, want %d", progress, len(content))
+	}
+
+	// Unchanged: revalidated, not downloaded again.
+	if again, cached := get(); again != path || !cached || downloads != 1 {
+		t.Fatalf("unchanged: path = %s, cached = %v, downloads = %d", again, cached, downloads)
+	}
+
+	// A corrupted copy of the same size is detected and replaced.
+	if err := os.WriteFile(path, []byte("talos image XX"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, cached := get(); !cached || downloads != 2 {
+		t.Fatalf("corrupt: cached = %v, downloads = %d", cached, downloads)
+	}
+
+	// Changed on the server.
+	content, etag = "talos image v2", `"v2"`
+	if _, cached := get(); !cached || downloads != 3 {
+		t.Fatalf("changed: cached = %v, downloads = %d", cached, downloads)
+	}
+
+	// Without validators the download cannot be reused.
+	etag = ""
+	if _, cached := get(); cached || downloads != 4 {
+		t.Fatalf("no validators: cached = %v, downloads = %d", cached, downloads)
+	}
+	if _, cached := get(); cached || downloads != 5 {
+		t.Fatalf("no validators again: cached = %v, downloads = %d", cached, downloads)
+	}
+}
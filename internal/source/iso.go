@@ -10,10 +10,18 @@ import (
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/filesystem"
 
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
 	"github.com/cozystack/boot-to-talos/internal/types"
 	"github.com/cozystack/boot-to-talos/internal/uki"
 )
 
+// errUnsignedKernel refuses separate kernel and initrd images when UKI
+// signatures are verified (-secure): only a UKI covers the initrd and the
+// command line with its signature.
+//
+//nolint:gochecknoglobals
+var errUnsignedKernel = errors.New("-secure requires a UKI; the image has a separate kernel and initrd")
+
 // Common paths where kernel and initrd are located in ISO images.
 //
 //nolint:gochecknoglobals
@@ -33,6 +41,7 @@ var initrdPaths = []string{
 	"boot/initrd.img",
 	"boot/initrd",
 	"boot/initramfs-linux.img",
+	"initramfs.xz",
 	"EFI/BOOT/initrd.img",
 	"isolinux/initrd.img",
 	"initrd.img",
@@ -103,7 +112,7 @@ func FindInitrdPath(mountPoint string) string {
 }
 
 // GetBootAssets extracts kernel and initrd from ISO.
-func (s *ISOSource) GetBootAssets() (*types.BootAssets, error) {
+func (s *ISOSource) GetBootAssets(trust *uki.TrustStore) (*types.BootAssets, error) {
 	// Open ISO file
 	disk, err := diskfs.Open(s.path, diskfs.WithOpenMode(diskfs.ReadOnly))
 	if err != nil {
@@ -117,102 +126,24 @@ func (s *ISOSource) GetBootAssets() (*types.BootAssets, error) {
 		return nil, errors.Wrap(err, "get ISO filesystem")
 	}
 
-	// Try to find UKI first (Talos uses UKI)
-	ukiPath, err := findUKIInISO(fs)
-	if err == nil {
-		return s.extractUKIFromISO(fs, ukiPath)
+	// Try to find UKI first (Talos uses UKI), also behind systemd-boot
+	entry, err := findBootEntry(fs)
+	switch {
+	case err == nil:
+		return entryBootAssets(fs, entry, trust)
+	case errors.Is(err, errSeveralUKIs):
+		return nil, err
 	}
 
 	// Fall back to separate kernel/initrd
-	return s.extractKernelInitrdFromISO(fs)
-}
-
-// findUKIInISO searches for UKI file in ISO filesystem.
-func findUKIInISO(fs filesystem.FileSystem) (string, error) {
-	searchPaths := []string{
-		"/EFI/BOOT",
-		"/efi/boot",
-	}
-
-	for _, dir := range searchPaths {
-		entries, err := fs.ReadDir(dir)
-		if err != nil {
-			continue
-		}
-
-		for _, entry := range entries {
-			name := strings.ToLower(entry.Name())
-			if strings.HasSuffix(name, ".efi") && strings.Contains(name, "vmlinuz") {
-				return filepath.Join(dir, entry.Name()), nil
-			}
-		}
-	}
-
-	return "", errors.New("UKI not found in ISO")
-}
-
-// extractUKIFromISO extracts boot assets from UKI file in ISO.
-func (s *ISOSource) extractUKIFromISO(fs filesystem.FileSystem, ukiPath string) (*types.BootAssets, error) {
-	// Copy UKI to temp file
-	ukiFile, err := fs.OpenFile(ukiPath, os.O_RDONLY)
-	if err != nil {
-		return nil, errors.Wrap(err, "open UKI in ISO")
-	}
-	defer ukiFile.Close()
-
-	tmpDir, err := os.MkdirTemp("", "iso-uki-*")
-	if err != nil {
-		return nil, errors.Wrap(err, "create temp dir")
-	}
-
-	ukiTempPath := filepath.Join(tmpDir, "boot.efi")
-	ukiOut, err := os.Create(ukiTempPath)
-	if err != nil {
-		os.RemoveAll(tmpDir)
-		return nil, errors.Wrap(err, "create temp UKI file")
-	}
-
-	if _, err := io.Copy(ukiOut, ukiFile); err != nil {
-		ukiOut.Close()
-		os.RemoveAll(tmpDir)
-		return nil, errors.Wrap(err, "copy UKI")
-	}
-	ukiOut.Close()
-
-	// Extract UKI sections
-	ukiAssets, err := uki.Extract(ukiTempPath)
-	if err != nil {
-		os.RemoveAll(tmpDir)
-		return nil, errors.Wrap(err, "extract UKI")
-	}
-
-	// Read cmdline
-	cmdlineBytes, err := io.ReadAll(ukiAssets.Cmdline)
-	ukiAssets.Close()
-	if err != nil {
-		os.RemoveAll(tmpDir)
-		return nil, errors.Wrap(err, "read cmdline")
-	}
-	cmdline := strings.TrimSpace(strings.TrimRight(string(cmdlineBytes), "\x00"))
-
-	// Reopen for readers
-	ukiAssets2, err := uki.Extract(ukiTempPath)
-	if err != nil {
-		os.RemoveAll(tmpDir)
-		return nil, errors.Wrap(err, "extract UKI for readers")
-	}
-
-	shared := newSharedCloser(ukiAssets2, tmpDir)
-
-	return &types.BootAssets{
-		Kernel:  &readerCloser{reader: ukiAssets2.Kernel, closer: shared},
-		Initrd:  &readerCloser{reader: ukiAssets2.Initrd, closer: shared},
-		Cmdline: cmdline,
-	}, nil
+	return s.extractKernelInitrdFromISO(fs, trust)
 }
 
 // extractKernelInitrdFromISO extracts separate kernel and initrd from ISO.
-func (s *ISOSource) extractKernelInitrdFromISO(fs filesystem.FileSystem) (*types.BootAssets, error) {
+func (s *ISOSource) extractKernelInitrdFromISO(fs filesystem.FileSystem, trust *uki.TrustStore) (*types.BootAssets, error) {
+	if trust != nil {
+		return nil, errUnsignedKernel
+	}
 	// Find kernel
 	kernelPath := findFileInISO(fs, kernelPaths)
 	if kernelPath == "" {
@@ -226,7 +157,7 @@ func (s *ISOSource) extractKernelInitrdFromISO(fs filesystem.FileSystem) (*types
 	}
 
 	// Copy to temp files
-	tmpDir, err := os.MkdirTemp("", "iso-boot-*")
+	tmpDir, err := tempdir.MkdirTemp("iso-boot-*")
 	if err != nil {
 		return nil, errors.Wrap(err, "create temp dir")
 	}
@@ -6,6 +6,11 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cockroachdb/errors"
+	"github.com/diskfs/go-diskfs"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+	"github.com/cozystack/boot-to-talos/internal/testutil"
 	"github.com/cozystack/boot-to-talos/internal/types"
 )
 
@@ -182,7 +187,7 @@ func TestFindInitrdPath_DynamicSearch(t *testing.T) {
 
 func TestISOSource_GetBootAssets_InvalidPath(t *testing.T) {
 	source := NewISOSource("/nonexistent/path/to/test.iso")
-	assets, err := source.GetBootAssets()
+	assets, err := source.GetBootAssets(nil)
 	if err == nil {
 		t.Error("GetBootAssets should return error for invalid path")
 	}
@@ -191,6 +196,77 @@ func TestISOSource_GetBootAssets_InvalidPath(t *testing.T) {
 	}
 }
 
+// TestISOSource_SeveralUKIs verifies that an ISO with several UKIs at the
+// removable-media path goes through the UKI choice: -uki-prefer picks one,
+// and -yes fails listing them instead of taking the first or falling back
+// to a kernel and initrd.
+func TestISOSource_SeveralUKIs(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string][]byte{
+		"/boot/vmlinuz":    []byte("kernel"),
+		"/boot/initrd.img": []byte("initrd"),
+	}
+	for name, version := range map[string]string{"TALOS.EFI": "6.12.1-talos", "SECURE.EFI": "6.12.1-talos-secure"} {
+		pePath := filepath.Join(tmpDir, name)
+		if err := testutil.CreateMinimalPEFile(pePath, map[string][]byte{".linux": []byte("kernel"), ".uname": []byte(version)}); err != nil {
+			t.Fatalf("create UKI: %v", err)
+		}
+		data, err := os.ReadFile(pePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files["/EFI/BOOT/"+name] = data
+	}
+	isoPath := filepath.Join(tmpDir, "talos.iso")
+	if err := testutil.CreateTestISOImage(isoPath, files); err != nil {
+		t.Fatalf("create ISO: %v", err)
+	}
+
+	origPrefer, origYes := UKIPrefer, cli.YesFlag
+	t.Cleanup(func() { UKIPrefer, cli.YesFlag = origPrefer, origYes })
+	cli.YesFlag = true
+
+	tests := []struct {
+		name   string
+		prefer string
+		want   string
+	}{
+		{"preferred", "SECURE*", "/EFI/BOOT/SECURE.EFI"},
+		{"ambiguous", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UKIPrefer = tt.prefer
+			d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			fs, err := d.GetFilesystem(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entry, err := findBootEntry(fs)
+			if tt.want == "" {
+				if !errors.Is(err, errSeveralUKIs) || !strings.Contains(err.Error(), "/EFI/BOOT/TALOS.EFI") {
+					t.Errorf("findBootEntry error = %v, want one listing the UKIs", err)
+				}
+				if _, err := NewISOSource(isoPath).GetBootAssets(nil); !errors.Is(err, errSeveralUKIs) {
+					t.Errorf("GetBootAssets error = %v, want the UKI choice to fail", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findBootEntry error: %v", err)
+			}
+			if !strings.EqualFold(entry.UKI, tt.want) {
+				t.Errorf("UKI = %q, want %q", entry.UKI, tt.want)
+			}
+		})
+	}
+}
+
 func TestISOSource_GetInstallAssets_NotSupported(t *testing.T) {
 	source := NewISOSource("/path/to/test.iso")
 	assets, err := source.GetInstallAssets("/tmp", 10)
@@ -3,6 +3,7 @@ package source
 import (
 	"compress/gzip"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,9 +13,12 @@ import (
 	diskType "github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
 
+	"github.com/cozystack/boot-to-talos/internal/fat"
+	"github.com/cozystack/boot-to-talos/internal/tempdir"
 	"github.com/cozystack/boot-to-talos/internal/types"
 	"github.com/cozystack/boot-to-talos/internal/uki"
 )
@@ -54,25 +58,59 @@ func DetectCompression(path string) string {
 
 // OpenDecompressed opens a file and returns a decompressed reader.
 // Returns the reader, uncompressed size (-1 if unknown), and error.
+// For xz and zstd the uncompressed size is taken from the stream metadata
+// when the compressor recorded it.
 func OpenDecompressed(path string) (io.ReadCloser, int64, error) {
+	return openDecompressed(path, nil)
+}
+
+// OpenDecompressedWithDigests is like OpenDecompressed but also computes the
+// sha256 of the compressed and decompressed bytes while they are read.
+func OpenDecompressedWithDigests(path string) (io.ReadCloser, int64, *Digests, error) {
+	digests := &Digests{}
+	rc, size, err := openDecompressed(path, digests)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return rc, size, digests, nil
+}
+
+// openDecompressed implements OpenDecompressed, filling digests if not nil.
+func openDecompressed(path string, digests *Digests) (io.ReadCloser, int64, error) {
+	rc, size, err := openDecompressedReader(path, digests)
+	if err != nil || digests == nil {
+		return rc, size, err
+	}
+	digests.uncompressed = NewHashReader(rc)
+	return &hashReadCloser{HashReader: digests.uncompressed, closer: rc}, size, nil
+}
+
+func openDecompressedReader(path string, digests *Digests) (io.ReadCloser, int64, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, 0, errors.Wrapf(err, "open %s", path)
 	}
 
+	// Decompressors read through the compressed-bytes hasher.
+	var compressed io.Reader = file
+	if digests != nil {
+		digests.compressed = NewHashReader(file)
+		compressed = digests.compressed
+	}
+
 	compression := DetectCompression(path)
 	switch compression {
 	case "xz":
-		reader, err := xz.NewReader(file)
+		reader, err := xz.NewReader(compressed)
 		if err != nil {
 			file.Close()
 			return nil, 0, errors.Wrap(err, "xz reader")
 		}
 		// Wrap to close underlying file when done
-		return &xzReadCloser{reader: reader, file: file}, -1, nil
+		return &xzReadCloser{reader: reader, file: file}, compressedImageSize(file, compression), nil
 
 	case "gz":
-		reader, err := gzip.NewReader(file)
+		reader, err := gzip.NewReader(compressed)
 		if err != nil {
 			file.Close()
 			return nil, 0, errors.Wrap(err, "gzip reader")
@@ -81,12 +119,12 @@ func OpenDecompressed(path string) (io.ReadCloser, int64, error) {
 		return &gzReadCloser{reader: reader, file: file}, -1, nil
 
 	case "zst":
-		reader, err := zstd.NewReader(file)
+		reader, err := zstd.NewReader(compressed)
 		if err != nil {
 			file.Close()
 			return nil, 0, errors.Wrap(err, "zstd reader")
 		}
-		return &zstReadCloser{reader: reader, file: file}, -1, nil
+		return &zstReadCloser{reader: reader, file: file}, compressedImageSize(file, compression), nil
 
 	default:
 		// Uncompressed - return file directly with its size
@@ -95,6 +133,9 @@ func OpenDecompressed(path string) (io.ReadCloser, int64, error) {
 			file.Close()
 			return nil, 0, errors.Wrapf(err, "stat %s", path)
 		}
+		if digests != nil {
+			return &hashReadCloser{HashReader: digests.compressed, closer: file}, info.Size(), nil
+		}
 		return file, info.Size(), nil
 	}
 }
@@ -144,7 +185,7 @@ func (r *zstReadCloser) Close() error {
 }
 
 // GetBootAssets extracts kernel and initrd from UKI in RAW image.
-func (s *RAWSource) GetBootAssets() (*types.BootAssets, error) {
+func (s *RAWSource) GetBootAssets(trust *uki.TrustStore) (*types.BootAssets, error) {
 	// Prepare image path (decompress if needed)
 	imagePath, tempImageDir, err := s.prepareImagePath()
 	if err != nil {
@@ -157,18 +198,10 @@ func (s *RAWSource) GetBootAssets() (*types.BootAssets, error) {
 		}
 	}
 
-	// Extract UKI from disk image to temp file
-	ukiTempPath, ukiTempDir, err := extractUKIFromDisk(imagePath)
-	if err != nil {
-		cleanup()
-		return nil, err
-	}
-
-	// Build boot assets from UKI
-	assets, err := buildBootAssetsFromUKI(ukiTempPath, ukiTempDir, tempImageDir)
+	// The UKI, or kernel and initrd, are copied out of the image.
+	assets, err := bootAssetsFromDisk(imagePath, trust)
+	cleanup()
 	if err != nil {
-		os.RemoveAll(ukiTempDir)
-		cleanup()
 		return nil, err
 	}
 
@@ -182,7 +215,7 @@ func (s *RAWSource) prepareImagePath() (string, string, error) {
 		return s.path, "", nil
 	}
 
-	tmpDir, err := os.MkdirTemp("", "raw-source-*")
+	tmpDir, err := tempdir.MkdirTemp("raw-source-*")
 	if err != nil {
 		return "", "", errors.Wrap(err, "create temp dir")
 	}
@@ -198,7 +231,7 @@ func (s *RAWSource) prepareImagePath() (string, string, error) {
 
 // decompressFile decompresses src to dst.
 func decompressFile(src, dst string) error {
-	reader, _, err := OpenDecompressed(src)
+	reader, _, err := openImage(src)
 	if err != nil {
 		return errors.Wrap(err, "open compressed image")
 	}
@@ -217,69 +250,125 @@ func decompressFile(src, dst string) error {
 	return nil
 }
 
-// extractUKIFromDisk opens disk image, finds EFI partition, extracts UKI to temp.
-// Returns: ukiTempPath, ukiTempDir, error.
-func extractUKIFromDisk(imagePath string) (string, string, error) {
-	disk, err := diskfs.Open(imagePath, diskfs.WithOpenMode(diskfs.ReadOnly))
+// bootAssetsFromDisk opens disk image, finds EFI partition and copies the
+// UKI, or the kernel and initrd of the default loader entry, to temp.
+func bootAssetsFromDisk(imagePath string, trust *uki.TrustStore) (*types.BootAssets, error) {
+	disk, err := openDiskImage(imagePath)
 	if err != nil {
-		return "", "", errors.Wrap(err, "open disk image")
+		return nil, err
 	}
 	defer disk.Close()
 
 	// Find EFI partition
 	efiPartNum, err := findEFIPartition(disk)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	// Get filesystem and find UKI
-	fs, err := disk.GetFilesystem(efiPartNum)
+	fs, err := openESPFilesystem(disk, efiPartNum)
 	if err != nil {
-		return "", "", errors.Wrap(err, "get EFI filesystem")
+		return nil, errors.Wrap(err, "get EFI filesystem")
 	}
 
-	ukiPath, err := findUKIFile(fs)
+	entry, err := findBootEntry(fs)
 	if err != nil {
-		return "", "", errors.Wrap(err, "find UKI file")
+		return nil, errors.Wrap(err, "find UKI in EFI partition")
 	}
+	return entryBootAssets(fs, entry, trust)
+}
 
-	// Copy UKI to temp file
-	return copyUKIToTemp(fs, ukiPath)
+// openDiskImage opens a disk image read-only. Image files carry no sector size
+// information, so if no GPT is found assuming 512-byte sectors the image is
+// reopened as a 4Kn (4096-byte sector) disk. Block devices report their
+// logical sector size themselves.
+func openDiskImage(imagePath string) (*diskType.Disk, error) {
+	disk, err := diskfs.Open(imagePath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return nil, errors.Wrap(err, "open disk image")
+	}
+	if _, ok := disk.Table.(*gpt.Table); ok || !disk.DefaultBlocks {
+		return disk, nil
+	}
+
+	disk4k, err := diskfs.Open(imagePath, diskfs.WithOpenMode(diskfs.ReadOnly), diskfs.WithSectorSize(diskfs.SectorSize4k))
+	if err != nil {
+		return disk, nil //nolint:nilerr // keep the 512-byte view, findEFIPartition reports the error
+	}
+	if _, ok := disk4k.Table.(*gpt.Table); ok {
+		disk.Close()
+		return disk4k, nil
+	}
+	disk4k.Close()
+	return disk, nil
 }
 
-// findEFIPartition finds EFI System Partition number in GPT table.
+// openESPFilesystem opens the FAT filesystem of the EFI System Partition.
+// go-diskfs only reads FAT32 with 512-byte sectors, while mkfs.vfat formats
+// the ESPs of 4Kn images with 4 KiB sectors; fat.Open reads both.
+func openESPFilesystem(disk *diskType.Disk, part int) (filesystem.FileSystem, error) {
+	partitions := disk.Table.GetPartitions()
+	if part < 1 || part > len(partitions) {
+		return nil, errors.Newf("partition %d not found", part)
+	}
+	p := partitions[part-1]
+
+	fs, err := fat.Open(disk.Backend, p.GetSize(), p.GetStart())
+	if err != nil {
+		return nil, errors.Wrapf(err, "read FAT filesystem on %d-byte sector disk", disk.LogicalBlocksize)
+	}
+	return fs, nil
+}
+
+// findEFIPartition finds the number of the EFI System Partition: the
+// partition typed as ESP in the GPT, or in the MBR of images go-diskfs reads
+// as MBR, e.g. a hybrid layout whose GPT header it rejects. If no partition
+// has the ESP type, the first one with a FAT filesystem holding an /EFI
+// directory is taken, as some image builders mark the ESP as plain FAT.
 func findEFIPartition(disk *diskType.Disk) (int, error) {
 	table, err := disk.GetPartitionTable()
 	if err != nil {
 		return 0, errors.Wrap(err, "get partition table")
 	}
 
-	gptTable, ok := table.(*gpt.Table)
-	if !ok {
-		return 0, errors.New("disk does not have GPT partition table")
+	switch t := table.(type) {
+	case *gpt.Table:
+		for i, part := range t.Partitions {
+			if part != nil && part.Type == gpt.EFISystemPartition {
+				return i + 1, nil
+			}
+		}
+	case *mbr.Table:
+		for i, part := range t.Partitions {
+			if part != nil && part.Type == mbr.EFISystem {
+				log.Printf("using the EFI System Partition %d of the MBR partition table", i+1)
+				return i + 1, nil
+			}
+		}
 	}
 
-	for i, part := range gptTable.Partitions {
-		if part == nil {
+	for i := range table.GetPartitions() {
+		fs, err := openESPFilesystem(disk, i+1)
+		if err != nil {
 			continue
 		}
-		if part.Type == gpt.EFISystemPartition {
+		if _, err := fs.ReadDir("/EFI"); err == nil {
+			log.Printf("no partition is typed as EFI System Partition, using partition %d with a FAT filesystem and an EFI directory", i+1)
 			return i + 1, nil
 		}
 	}
 
-	return 0, errors.New("EFI partition not found")
+	return 0, errors.Newf("EFI partition not found in the %s partition table", table.Type())
 }
 
 // copyUKIToTemp copies UKI file from filesystem to temp directory.
-func copyUKIToTemp(fs filesystem.FileSystem, ukiPath string) (string, string, error) {
+func copyUKIToTemp(fs espFS, ukiPath string) (string, string, error) {
 	ukiFile, err := fs.OpenFile(ukiPath, os.O_RDONLY)
 	if err != nil {
 		return "", "", errors.Wrapf(err, "open UKI file %s", ukiPath)
 	}
 	defer ukiFile.Close()
 
-	ukiTempDir, err := os.MkdirTemp("", "uki-extract-*")
+	ukiTempDir, err := tempdir.MkdirTemp("uki-extract-*")
 	if err != nil {
 		return "", "", errors.Wrap(err, "create UKI temp dir")
 	}
@@ -300,80 +389,39 @@ func copyUKIToTemp(fs filesystem.FileSystem, ukiPath string) (string, string, er
 	return ukiTempPath, ukiTempDir, nil
 }
 
-// buildBootAssetsFromUKI extracts UKI and creates BootAssets.
-func buildBootAssetsFromUKI(ukiTempPath, ukiTempDir, imageDir string) (assets *types.BootAssets, err error) {
-	// Extract to read cmdline
-	ukiAssets, err := uki.Extract(ukiTempPath)
+// buildBootAssetsFromUKI extracts UKI and creates BootAssets. With trust set,
+// the UKI must be signed by a key it trusts.
+func buildBootAssetsFromUKI(ukiTempPath, ukiTempDir string, trust *uki.TrustStore) (*types.BootAssets, error) {
+	ukiAssets, err := uki.Extract(ukiTempPath, trust)
 	if err != nil {
 		return nil, errors.Wrap(err, "extract UKI")
 	}
 
+	// The sections are read independently, reading the cmdline leaves the
+	// kernel and initrd readers at their start.
 	cmdlineBytes, err := io.ReadAll(ukiAssets.Cmdline)
-	ukiAssets.Close()
 	if err != nil {
+		ukiAssets.Close()
 		return nil, errors.Wrap(err, "read cmdline")
 	}
 	cmdline := strings.TrimSpace(strings.TrimRight(string(cmdlineBytes), "\x00"))
+	osrel := ukiAssets.OSRelease()
 
-	// Reopen for readers
-	ukiAssets2, err := uki.Extract(ukiTempPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "extract UKI for readers")
-	}
-	// Close ukiAssets2 if we return an error after this point
-	defer func() {
-		if err != nil {
-			ukiAssets2.Close()
-		}
-	}()
-
-	cleanupDirs := []string{ukiTempDir}
-	if imageDir != "" {
-		cleanupDirs = append(cleanupDirs, imageDir)
-	}
-	shared := newSharedCloserMulti(ukiAssets2, cleanupDirs)
+	shared := newSharedCloser(ukiAssets, ukiTempDir)
 
 	return &types.BootAssets{
-		Kernel:  &readerCloser{reader: ukiAssets2.Kernel, closer: shared},
-		Initrd:  &readerCloser{reader: ukiAssets2.Initrd, closer: shared},
-		Cmdline: cmdline,
+		Kernel:    &readerCloser{reader: ukiAssets.Kernel, closer: shared},
+		Initrd:    &readerCloser{reader: ukiAssets.Initrd, closer: shared},
+		Cmdline:   cmdline,
+		OSRelease: osrel,
 	}, nil
 }
 
-// findUKIFile searches for UKI file in common locations.
-func findUKIFile(fs interface {
-	ReadDir(path string) ([]os.FileInfo, error)
-},
-) (string, error) {
-	// Common UKI locations
-	searchPaths := []string{
-		"/EFI/BOOT",
-		"/EFI/boot",
-		"/efi/boot",
-	}
-
-	for _, dir := range searchPaths {
-		entries, err := fs.ReadDir(dir)
-		if err != nil {
-			continue
-		}
-
-		for _, entry := range entries {
-			name := strings.ToLower(entry.Name())
-			if strings.HasSuffix(name, ".efi") {
-				return filepath.Join(dir, entry.Name()), nil
-			}
-		}
-	}
-
-	return "", errors.New("UKI file not found in EFI partition")
-}
-
 // rawSharedCloser handles cleanup for RAW source boot assets.
 
 // GetInstallAssets returns the RAW image for direct writing to disk.
 func (s *RAWSource) GetInstallAssets(tmpDir string, sizeGiB uint64) (*types.InstallAssets, error) {
-	reader, size, err := OpenDecompressed(s.path)
+	reader, size, err := openImage(s.path)
 	if err != nil {
 		return nil, errors.Wrap(err, "open RAW image")
 	}
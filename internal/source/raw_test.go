@@ -10,6 +10,7 @@ import (
 
 	"github.com/cozystack/boot-to-talos/internal/testutil"
 	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
 )
@@ -279,7 +280,7 @@ func TestRAWSource_GetBootAssets(t *testing.T) {
 	source := NewRAWSource(rawPath)
 	defer source.Close()
 
-	assets, err := source.GetBootAssets()
+	assets, err := source.GetBootAssets(nil)
 	if err != nil {
 		t.Fatalf("GetBootAssets error: %v", err)
 	}
@@ -309,6 +310,208 @@ func TestRAWSource_GetBootAssets(t *testing.T) {
 	}
 }
 
+func TestRAWSource_GetBootAssets_4Kn(t *testing.T) {
+	tmpDir := t.TempDir()
+	rawPath := filepath.Join(tmpDir, "test-4kn.raw")
+
+	ukiPath := filepath.Join(tmpDir, "test.efi")
+	expectedCmdline := "console=ttyS0 talos.platform=metal"
+	if err := testutil.CreateTestUKIFile(ukiPath, expectedCmdline, "kernel-4kn", "initrd-4kn"); err != nil {
+		t.Fatalf("Failed to create test UKI: %v", err)
+	}
+
+	ukiContent, err := os.ReadFile(ukiPath)
+	if err != nil {
+		t.Fatalf("Failed to read UKI: %v", err)
+	}
+
+	files := map[string][]byte{
+		"/EFI/BOOT/BOOTX64.EFI": ukiContent,
+	}
+	if err := testutil.CreateTestRAWImageWithSectorSize(rawPath, 64, 4096, files); err != nil {
+		t.Fatalf("Failed to create 4Kn RAW image: %v", err)
+	}
+
+	source := NewRAWSource(rawPath)
+	defer source.Close()
+
+	assets, err := source.GetBootAssets(nil)
+	if err != nil {
+		t.Fatalf("GetBootAssets error: %v", err)
+	}
+	defer assets.Close()
+
+	if assets.Cmdline != expectedCmdline {
+		t.Errorf("cmdline = %q, want %q", assets.Cmdline, expectedCmdline)
+	}
+}
+
+func TestRAWSource_GetBootAssets_MBR(t *testing.T) {
+	tmpDir := t.TempDir()
+	ukiPath := filepath.Join(tmpDir, "test.efi")
+	expectedCmdline := "console=ttyS0 talos.platform=metal"
+	if err := testutil.CreateTestUKIFile(ukiPath, expectedCmdline, "kernel-mbr", "initrd-mbr"); err != nil {
+		t.Fatalf("Failed to create test UKI: %v", err)
+	}
+	ukiContent, err := os.ReadFile(ukiPath)
+	if err != nil {
+		t.Fatalf("Failed to read UKI: %v", err)
+	}
+	files := map[string][]byte{"/EFI/BOOT/BOOTX64.EFI": ukiContent}
+
+	tests := []struct {
+		name     string
+		partType mbr.Type
+	}{
+		{"ESP type", mbr.EFISystem},
+		{"plain FAT32", mbr.Fat32LBA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawPath := filepath.Join(t.TempDir(), "test-mbr.raw")
+			if err := testutil.CreateTestMBRImage(rawPath, 64, tt.partType, files); err != nil {
+				t.Fatalf("Failed to create MBR image: %v", err)
+			}
+
+			source := NewRAWSource(rawPath)
+			defer source.Close()
+
+			assets, err := source.GetBootAssets(nil)
+			if err != nil {
+				t.Fatalf("GetBootAssets error: %v", err)
+			}
+			defer assets.Close()
+
+			if assets.Cmdline != expectedCmdline {
+				t.Errorf("cmdline = %q, want %q", assets.Cmdline, expectedCmdline)
+			}
+		})
+	}
+}
+
+func TestRAWSource_GetBootAssets_LoaderEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	readFile := func(path string) []byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	createUKI := func(name, cmdline string) []byte {
+		path := filepath.Join(tmpDir, name)
+		if err := testutil.CreateTestUKIFile(path, cmdline, "kernel-"+name, "initrd-"+name); err != nil {
+			t.Fatal(err)
+		}
+		return readFile(path)
+	}
+	// systemd-boot and shim have no .linux section.
+	stubPath := filepath.Join(tmpDir, "stub.efi")
+	if err := testutil.CreateMinimalPEFile(stubPath, map[string][]byte{".text": []byte("loader"), ".sdmagic": []byte("#### LoaderInfo")}); err != nil {
+		t.Fatal(err)
+	}
+	stub := readFile(stubPath)
+	older, newer := createUKI("a.efi", "talos.older"), createUKI("b.efi", "talos.newer")
+
+	tests := []struct {
+		name        string
+		files       map[string][]byte
+		wantCmdline string
+		wantKernel  string
+		wantInitrd  string
+	}{
+		{
+			name: "latest type #2 entry",
+			files: map[string][]byte{
+				"/EFI/BOOT/BOOTX64.EFI":  stub,
+				"/EFI/Linux/Talos-a.efi": older,
+				"/EFI/Linux/Talos-b.efi": newer,
+			},
+			wantCmdline: "talos.newer",
+			wantKernel:  "kernel-b.efi",
+			wantInitrd:  "initrd-b.efi",
+		},
+		{
+			name: "newest version, not the last in byte order",
+			files: map[string][]byte{
+				"/EFI/BOOT/BOOTX64.EFI":      stub,
+				"/EFI/Linux/Talos-v1.9.efi":  older,
+				"/EFI/Linux/Talos-v1.10.efi": newer,
+			},
+			wantCmdline: "talos.newer",
+			wantKernel:  "kernel-b.efi",
+			wantInitrd:  "initrd-b.efi",
+		},
+		{
+			name: "loader.conf default",
+			files: map[string][]byte{
+				"/EFI/BOOT/BOOTX64.EFI":  stub,
+				"/EFI/Linux/Talos-a.efi": older,
+				"/EFI/Linux/Talos-b.efi": newer,
+				"/loader/loader.conf":    []byte("timeout 3\ndefault Talos-a*\n"),
+			},
+			wantCmdline: "talos.older",
+			wantKernel:  "kernel-a.efi",
+			wantInitrd:  "initrd-a.efi",
+		},
+		{
+			name: "type #1 entry with kernel and initrds",
+			files: map[string][]byte{
+				"/EFI/BOOT/BOOTX64.EFI":      stub,
+				"/loader/entries/talos.conf": []byte("title Talos\nlinux /talos/vmlinuz\ninitrd /talos/ucode.img\ninitrd talos/initramfs.xz\noptions console=ttyS0\noptions talos.platform=metal\n"),
+				"/talos/vmlinuz":             []byte("kernel"),
+				"/talos/ucode.img":           []byte("ucode+"),
+				"/talos/initramfs.xz":        []byte("initramfs"),
+			},
+			wantCmdline: "console=ttyS0 talos.platform=metal",
+			wantKernel:  "kernel",
+			wantInitrd:  "ucode+initramfs",
+		},
+		{
+			name: "type #1 entry chainloading shim is skipped",
+			files: map[string][]byte{
+				"/EFI/BOOT/BOOTX64.EFI":      stub,
+				"/EFI/shim/shimx64.efi":      stub,
+				"/loader/entries/z.conf":     []byte("efi /EFI/shim/shimx64.efi\n"),
+				"/loader/entries/talos.conf": []byte("efi /EFI/Linux/Talos-a.efi\n"),
+				"/EFI/Linux/Talos-a.efi":     older,
+			},
+			wantCmdline: "talos.older",
+			wantKernel:  "kernel-a.efi",
+			wantInitrd:  "initrd-a.efi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawPath := filepath.Join(t.TempDir(), "test.raw")
+			if err := testutil.CreateTestRAWImage(rawPath, 64, tt.files); err != nil {
+				t.Fatalf("Failed to create test RAW image: %v", err)
+			}
+			source := NewRAWSource(rawPath)
+			defer source.Close()
+
+			assets, err := source.GetBootAssets(nil)
+			if err != nil {
+				t.Fatalf("GetBootAssets error: %v", err)
+			}
+			defer assets.Close()
+
+			if assets.Cmdline != tt.wantCmdline {
+				t.Errorf("cmdline = %q, want %q", assets.Cmdline, tt.wantCmdline)
+			}
+			for name, got := range map[string]io.Reader{tt.wantKernel: assets.Kernel, tt.wantInitrd: assets.Initrd} {
+				data, err := io.ReadAll(got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != name {
+					t.Errorf("read %q, want %q", data, name)
+				}
+			}
+		})
+	}
+}
+
 func TestRAWSource_GetBootAssets_InvalidFile(t *testing.T) {
 	// Test that GetBootAssets returns error for invalid disk image
 	tmpDir := t.TempDir()
@@ -322,7 +525,7 @@ func TestRAWSource_GetBootAssets_InvalidFile(t *testing.T) {
 	source := NewRAWSource(testFile)
 	defer source.Close()
 
-	_, err := source.GetBootAssets()
+	_, err := source.GetBootAssets(nil)
 	if err == nil {
 		t.Error("Expected error for invalid disk image")
 	}
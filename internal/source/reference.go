@@ -0,0 +1,20 @@
+package source
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// NormalizeReference validates a container image reference and returns it
+// in full form, with the default registry and tag filled in, e.g.
+// "alpine" becomes "index.docker.io/library/alpine:latest". A leading
+// https:// (as copied from a registry web UI) is dropped.
+func NormalizeReference(ref string) (string, error) {
+	r, err := name.ParseReference(strings.TrimPrefix(ref, "https://"))
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid container image reference %q", ref)
+	}
+	return r.Name(), nil
+}
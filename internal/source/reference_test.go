@@ -0,0 +1,42 @@
+package source
+
+import "testing"
+
+func TestNormalizeReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "ghcr.io/cozystack/cozystack/talos:v1.11.6", want: "ghcr.io/cozystack/cozystack/talos:v1.11.6"},
+		{ref: "alpine", want: "index.docker.io/library/alpine:latest"},
+		{ref: "ghcr.io/siderolabs/installer", want: "ghcr.io/siderolabs/installer:latest"},
+		{ref: "https://registry.example.com/talos:v1.11", want: "registry.example.com/talos:v1.11"},
+		{
+			ref:  "ghcr.io/siderolabs/installer@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			want: "ghcr.io/siderolabs/installer@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		{ref: "ghcr.io/siderolabs/installer:v1.11:extra", wantErr: true},
+		{ref: "Ghcr.io/UPPER/Case", wantErr: true},
+		{ref: "ghcr.io/siderolabs/installer@sha256:short", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeReference(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeReference(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeReference(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestDetect_MalformedReference(t *testing.T) {
+	if _, err := Detect("ghcr.io/siderolabs/installer:v1:bad"); err == nil {
+		t.Error("Detect() should reject a malformed container reference")
+	}
+	if _, err := DetectImageSource("ghcr.io/siderolabs/installer:v1:bad"); err == nil {
+		t.Error("DetectImageSource() should reject a malformed container reference")
+	}
+}
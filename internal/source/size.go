@@ -0,0 +1,235 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+)
+
+// xz container constants (see https://tukaani.org/xz/xz-file-format.txt).
+const (
+	xzHeaderSize = 12
+	xzFooterSize = 12
+)
+
+//nolint:gochecknoglobals
+var (
+	xzHeaderMagic = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+)
+
+// zstd frame constants (see RFC 8878).
+const (
+	zstdMagic              = 0xFD2FB528
+	zstdSkippableMagicMask = 0xFFFFFFF0
+	zstdSkippableMagic     = 0x184D2A50
+)
+
+// compressedImageSize returns the uncompressed size of a compressed image
+// using the metadata stored by the compressor, or -1 if it is not available.
+func compressedImageSize(f *os.File, compression string) int64 {
+	var (
+		size int64
+		err  error
+	)
+	switch compression {
+	case "xz":
+		size, err = xzUncompressedSize(f)
+	case "zst":
+		size, err = zstdContentSize(f)
+	default:
+		return -1
+	}
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// xzUncompressedSize sums the uncompressed sizes recorded in the index of
+// every stream of an xz file, walking the streams backwards from the end.
+func xzUncompressedSize(r io.ReaderAt) (int64, error) {
+	end, err := readerSize(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for end > 0 {
+		// Skip stream padding (multiples of four zero bytes).
+		var pad [4]byte
+		if end >= 4 {
+			if _, err := r.ReadAt(pad[:], end-4); err != nil {
+				return 0, errors.Wrap(err, "read xz stream padding")
+			}
+			if pad == [4]byte{} {
+				end -= 4
+				continue
+			}
+		}
+
+		if end < xzHeaderSize+xzFooterSize {
+			return 0, errors.New("xz file too short")
+		}
+
+		footer := make([]byte, xzFooterSize)
+		if _, err := r.ReadAt(footer, end-xzFooterSize); err != nil {
+			return 0, errors.Wrap(err, "read xz stream footer")
+		}
+		if !bytes.Equal(footer[10:], xzFooterMagic) {
+			return 0, errors.New("invalid xz stream footer")
+		}
+		indexSize := (int64(binary.LittleEndian.Uint32(footer[4:8])) + 1) * 4
+
+		indexStart := end - xzFooterSize - indexSize
+		if indexStart < xzHeaderSize {
+			return 0, errors.New("invalid xz index size")
+		}
+		index := make([]byte, indexSize)
+		if _, err := r.ReadAt(index, indexStart); err != nil {
+			return 0, errors.Wrap(err, "read xz index")
+		}
+
+		blocksSize, uncompressed, err := parseXZIndex(index)
+		if err != nil {
+			return 0, err
+		}
+		total += uncompressed
+
+		start := indexStart - blocksSize - xzHeaderSize
+		if start < 0 {
+			return 0, errors.New("invalid xz block sizes")
+		}
+		header := make([]byte, len(xzHeaderMagic))
+		if _, err := r.ReadAt(header, start); err != nil {
+			return 0, errors.Wrap(err, "read xz stream header")
+		}
+		if !bytes.Equal(header, xzHeaderMagic) {
+			return 0, errors.New("invalid xz stream header")
+		}
+		end = start
+	}
+
+	return total, nil
+}
+
+// parseXZIndex parses an xz index and returns the total (padded) size of the
+// blocks it describes and their total uncompressed size.
+func parseXZIndex(index []byte) (int64, int64, error) {
+	if len(index) == 0 || index[0] != 0x00 {
+		return 0, 0, errors.New("invalid xz index indicator")
+	}
+	r := bytes.NewReader(index[1:])
+
+	records, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "read xz index record count")
+	}
+
+	var blocksSize, uncompressed int64
+	for range records {
+		unpadded, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "read xz index record")
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "read xz index record")
+		}
+		blocksSize += int64((unpadded + 3) &^ 3)
+		uncompressed += int64(size)
+	}
+
+	return blocksSize, uncompressed, nil
+}
+
+// zstdContentSize returns the content size stored in the header of the first
+// zstd frame. Skippable frames in front of it are ignored.
+func zstdContentSize(r io.ReaderAt) (int64, error) {
+	var off int64
+	for {
+		var hdr [4]byte
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return 0, errors.Wrap(err, "read zstd frame magic")
+		}
+		magic := binary.LittleEndian.Uint32(hdr[:])
+		if magic&zstdSkippableMagicMask != zstdSkippableMagic {
+			if magic != zstdMagic {
+				return 0, errors.New("invalid zstd frame magic")
+			}
+			break
+		}
+		if _, err := r.ReadAt(hdr[:], off+4); err != nil {
+			return 0, errors.Wrap(err, "read zstd skippable frame size")
+		}
+		off += 8 + int64(binary.LittleEndian.Uint32(hdr[:]))
+	}
+
+	// Frame_Header_Descriptor, optional Window_Descriptor, Dictionary_ID and
+	// Frame_Content_Size: at most 1+1+4+8 bytes.
+	var header [14]byte
+	n, err := r.ReadAt(header[:], off+4)
+	if err != nil && err != io.EOF {
+		return 0, errors.Wrap(err, "read zstd frame header")
+	}
+	if n < 1 {
+		return 0, errors.New("zstd frame header too short")
+	}
+
+	descriptor := header[0]
+	fcsFlag := descriptor >> 6
+	singleSegment := descriptor&0x20 != 0
+	dictIDSize := [4]int{0, 1, 2, 4}[descriptor&0x03]
+
+	pos := 1 + dictIDSize
+	if !singleSegment {
+		pos++ // Window_Descriptor
+	}
+
+	var fcsSize int
+	switch fcsFlag {
+	case 0:
+		if !singleSegment {
+			return 0, errors.New("zstd frame content size not stored")
+		}
+		fcsSize = 1
+	case 1:
+		fcsSize = 2
+	case 2:
+		fcsSize = 4
+	case 3:
+		fcsSize = 8
+	}
+	if pos+fcsSize > n {
+		return 0, errors.New("zstd frame header too short")
+	}
+
+	field := header[pos : pos+fcsSize]
+	switch fcsSize {
+	case 1:
+		return int64(field[0]), nil
+	case 2:
+		return int64(binary.LittleEndian.Uint16(field)) + 256, nil
+	case 4:
+		return int64(binary.LittleEndian.Uint32(field)), nil
+	default:
+		return int64(binary.LittleEndian.Uint64(field)), nil
+	}
+}
+
+func readerSize(r io.ReaderAt) (int64, error) {
+	if f, ok := r.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return 0, errors.Wrap(err, "stat")
+		}
+		return info.Size(), nil
+	}
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return s.Size(), nil
+	}
+	return 0, errors.New("cannot determine reader size")
+}
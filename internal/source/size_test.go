@@ -0,0 +1,104 @@
+package source
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func xzCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("xz.NewWriter error: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("xz write error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("xz close error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestXZUncompressedSize(t *testing.T) {
+	first := bytes.Repeat([]byte("talos"), 100000)
+	second := bytes.Repeat([]byte("x"), 12345)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int64
+	}{
+		{"single stream", xzCompress(t, first), int64(len(first))},
+		{"concatenated streams", append(xzCompress(t, first), xzCompress(t, second)...), int64(len(first) + len(second))},
+		{"stream padding", append(xzCompress(t, second), 0, 0, 0, 0), int64(len(second))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := xzUncompressedSize(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("xzUncompressedSize error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("xzUncompressedSize = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXZUncompressedSize_Invalid(t *testing.T) {
+	if _, err := xzUncompressedSize(bytes.NewReader([]byte("not an xz file at all"))); err == nil {
+		t.Error("expected error for invalid xz data")
+	}
+}
+
+func TestZstdContentSize(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter error: %v", err)
+	}
+	defer enc.Close()
+
+	for _, size := range []int{1000, 70000, 1 << 20} {
+		data := bytes.Repeat([]byte{0xAB}, size)
+		got, err := zstdContentSize(bytes.NewReader(enc.EncodeAll(data, nil)))
+		if err != nil {
+			t.Fatalf("zstdContentSize(%d) error: %v", size, err)
+		}
+		if got != int64(size) {
+			t.Errorf("zstdContentSize = %d, want %d", got, size)
+		}
+	}
+}
+
+func TestZstdContentSize_NotStored(t *testing.T) {
+	// Frame header descriptor 0x04: checksum only, no Frame_Content_Size.
+	frame := []byte{0x28, 0xB5, 0x2F, 0xFD, 0x04, 0x00, 0x23, 0x03, 0x00}
+	if _, err := zstdContentSize(bytes.NewReader(frame)); err == nil {
+		t.Error("expected error when content size is not stored")
+	}
+}
+
+func TestZstdContentSize_SkippableFrame(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter error: %v", err)
+	}
+	defer enc.Close()
+
+	skippable := []byte{0x50, 0x2A, 0x4D, 0x18, 3, 0, 0, 0, 'a', 'b', 'c'}
+	data := append(skippable, enc.EncodeAll(bytes.Repeat([]byte{1}, 5000), nil)...)
+
+	got, err := zstdContentSize(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("zstdContentSize error: %v", err)
+	}
+	if got != 5000 {
+		t.Errorf("zstdContentSize = %d, want 5000", got)
+	}
+}
@@ -11,63 +11,280 @@ import (
 	"github.com/cozystack/boot-to-talos/internal/types"
 )
 
+// ForcedType, when set, is the type image references are taken as instead
+// of detecting it, for references the heuristics misclassify.
+//
+//nolint:gochecknoglobals
+var ForcedType *types.ImageSourceType
+
+// ParseType parses a -source-type value: container, iso or raw.
+func ParseType(s string) (types.ImageSourceType, error) {
+	for _, t := range []types.ImageSourceType{types.ImageSourceContainer, types.ImageSourceISO, types.ImageSourceRAW} {
+		if s == t.String() {
+			return t, nil
+		}
+	}
+	return 0, errors.Newf("unknown source type %q (supported: container, iso, raw)", s)
+}
+
+// Detection describes how an image reference is classified.
+type Detection struct {
+	Type   types.ImageSourceType
+	Remote bool   // image is downloaded over HTTP(S) first
+	Stdin  bool   // image is streamed from standard input
+	Reason string // which detection rule matched
+
+	// Archive is the kind of archive a local image is in (ArchiveSplit,
+	// ArchiveTar), "" for a plain image. Path is then the first part or the
+	// tar archive.
+	Archive string
+	Path    string
+
+	// Ref is the normalized reference of container images, see
+	// NormalizeReference.
+	Ref string
+}
+
+// Handler returns a human-readable name of the source implementation that
+// handles the image.
+func (d Detection) Handler() string {
+	var handler string
+	switch d.Type {
+	case types.ImageSourceContainer:
+		return "container registry pull (crane)"
+	case types.ImageSourceISO:
+		handler = "ISO image"
+	case types.ImageSourceRAW:
+		handler = "RAW disk image"
+		if d.Stdin {
+			return "RAW disk image streamed from stdin"
+		}
+	}
+	switch {
+	case d.Remote:
+		return "HTTP download, then " + handler
+	case d.Archive == ArchiveSplit:
+		return "split parts reassembled, then " + handler
+	case d.Archive == ArchiveTar:
+		return "tar extraction, then " + handler
+	}
+	return handler
+}
+
 // DetectImageSource detects the image type and returns an appropriate ImageSource.
 func DetectImageSource(ref string) (types.ImageSource, error) {
-	// Check if it's a URL
-	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
-		return detectHTTPImageSource(ref)
+	d, err := Detect(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ref == StdinRef:
+		return NewStdinSource(), nil
+	case d.Archive != "":
+		return NewArchiveSource(d.Path, d.Archive, d.Type), nil
+	case d.Type == types.ImageSourceContainer:
+		return NewContainerSource(d.Ref), nil
+	case d.Remote:
+		return NewHTTPSource(ref, d.Type), nil
+	case d.Type == types.ImageSourceISO:
+		return NewISOSource(ref), nil
+	default:
+		return NewRAWSource(ref), nil
+	}
+}
+
+// Detect classifies an image reference without accessing the image itself
+// (local files are only checked for existence, tar archives are listed).
+func Detect(ref string) (Detection, error) {
+	if ref == StdinRef {
+		if ForcedType != nil && *ForcedType != types.ImageSourceRAW {
+			return Detection{}, errors.Newf("\"-\" reads a RAW image from stdin, not %s", *ForcedType)
+		}
+		return Detection{Type: types.ImageSourceRAW, Stdin: true, Reason: "\"-\" reads a RAW image (possibly compressed) from stdin"}, nil
+	}
+	if ForcedType != nil {
+		return detectForced(ref, *ForcedType)
+	}
+
+	var (
+		d   Detection
+		err error
+	)
+	switch {
+	case strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://"):
+		d, err = detectHTTP(ref)
+	case splitImageName(ref) != "" && fileExists(ref):
+		d, err = detectSplit(ref)
+	case strings.HasSuffix(strings.ToLower(ref), ".tar") && fileExists(ref):
+		d, err = detectTar(ref)
+	case fileExists(ref):
+		d, err = detectLocal(ref)
+	case firstSplitPart(ref) != "":
+		d, err = detectSplit(firstSplitPart(ref))
+	case looksLikePath(ref):
+		return Detection{}, errors.Newf("%s: no such file", ref)
+	default:
+		d = Detection{
+			Type:   types.ImageSourceContainer,
+			Reason: "not a URL and no such local file, assuming container reference",
+		}
+	}
+	if err != nil || d.Type != types.ImageSourceContainer {
+		return d, err
+	}
+
+	// Reject malformed container references before any network access.
+	d.Ref, err = NormalizeReference(ref)
+	if err != nil {
+		return Detection{}, err
+	}
+	return d, nil
+}
+
+// detectForced classifies ref as type t, checking only that ref can be an
+// image of that type: a container reference that is no local file, or an
+// HTTP(S) URL or local file for ISO and RAW images, with the ISO 9660
+// signature for local ISOs. Split parts and tar archives are recognized as
+// they are without -source-type, the image in them being taken as type t.
+func detectForced(ref string, t types.ImageSourceType) (Detection, error) {
+	d := Detection{Type: t, Reason: "type forced with -source-type " + t.String()}
+	remote := strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+	switch {
+	case t == types.ImageSourceContainer:
+		if !remote && fileExists(ref) {
+			return Detection{}, errors.Newf("%s is a local file, not a container reference", ref)
+		}
+		ref, err := NormalizeReference(ref)
+		if err != nil {
+			return Detection{}, err
+		}
+		d.Ref = ref
+	case remote:
+		if u, err := url.Parse(ref); err != nil || u.Host == "" {
+			return Detection{}, errors.Newf("invalid URL %q", ref)
+		}
+		d.Remote = true
+	case splitImageName(ref) != "" && fileExists(ref):
+		d.Archive, d.Path = ArchiveSplit, ref
+	case strings.HasSuffix(strings.ToLower(ref), ".tar") && fileExists(ref):
+		if _, err := tarImage(ref); err != nil {
+			return Detection{}, err
+		}
+		d.Archive, d.Path = ArchiveTar, ref
+	case fileExists(ref):
+		if t == types.ImageSourceISO && !isISO9660(ref) {
+			return Detection{}, errors.Newf("%s is not an ISO 9660 image", ref)
+		}
+	case firstSplitPart(ref) != "":
+		d.Archive, d.Path = ArchiveSplit, firstSplitPart(ref)
+	default:
+		return Detection{}, errors.Newf("%s: no such file", ref)
+	}
+	return d, nil
+}
+
+// isISO9660 reports whether the file at path has the signature of the
+// ISO 9660 primary volume descriptor.
+func isISO9660(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
 	}
+	defer f.Close()
+	magic := make([]byte, 5)
+	if _, err := f.ReadAt(magic, 0x8001); err != nil {
+		return false
+	}
+	return string(magic) == "CD001"
+}
 
-	// Check if it's a local file
-	if _, err := os.Stat(ref); err == nil {
-		return detectLocalImageSource(ref)
+// looksLikePath reports whether ref is meant as a local file rather than a
+// container reference: an absolute or explicitly relative path, or a name
+// with an image extension.
+func looksLikePath(ref string) bool {
+	if strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "./") || strings.HasPrefix(ref, "../") {
+		return true
+	}
+	lower := strings.ToLower(ref)
+	for _, ext := range []string{".iso", ".raw", ".raw.xz", ".raw.gz", ".raw.zst", ".tar"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
 	}
+	return splitImageName(ref) != ""
+}
 
-	// Assume it's a container reference
-	return NewContainerSource(ref), nil
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
-// detectHTTPImageSource detects image type from HTTP URL.
-func detectHTTPImageSource(rawURL string) (types.ImageSource, error) {
+// detectHTTP detects image type from HTTP URL.
+func detectHTTP(rawURL string) (Detection, error) {
 	u, err := url.Parse(rawURL)
 	if err != nil {
-		return nil, errors.Wrap(err, "invalid URL")
+		return Detection{}, errors.Wrap(err, "invalid URL")
 	}
 
 	path := strings.ToLower(u.Path)
 
 	switch {
 	case strings.HasSuffix(path, ".iso"):
-		return NewHTTPSource(rawURL, types.ImageSourceISO), nil
+		return Detection{Type: types.ImageSourceISO, Remote: true, Reason: "URL path ends with .iso"}, nil
 	case strings.HasSuffix(path, ".raw.xz"),
 		strings.HasSuffix(path, ".raw.gz"),
 		strings.HasSuffix(path, ".raw.zst"),
 		strings.HasSuffix(path, ".raw"):
-		return NewHTTPSource(rawURL, types.ImageSourceRAW), nil
+		return Detection{
+			Type:   types.ImageSourceRAW,
+			Remote: true,
+			Reason: "URL path ends with " + path[strings.LastIndex(path, ".raw"):],
+		}, nil
 	default:
 		// Assume container reference for URLs without recognized extension
-		return NewContainerSource(rawURL), nil
+		return Detection{
+			Type:   types.ImageSourceContainer,
+			Reason: "URL path has no .iso/.raw[.xz|.gz|.zst] extension, assuming container reference",
+		}, nil
 	}
 }
 
-// detectLocalImageSource detects image type from local file.
-func detectLocalImageSource(path string) (types.ImageSource, error) {
+// detectLocal detects image type from local file.
+func detectLocal(path string) (Detection, error) {
 	lower := strings.ToLower(path)
 	base := strings.ToLower(filepath.Base(path))
 
 	switch {
 	case strings.HasSuffix(lower, ".iso"):
-		return NewISOSource(path), nil
+		return Detection{Type: types.ImageSourceISO, Reason: "local file with .iso extension"}, nil
 	case strings.HasSuffix(lower, ".raw.xz"),
 		strings.HasSuffix(lower, ".raw.gz"),
-		strings.HasSuffix(lower, ".raw.zst"):
-		return NewRAWSource(path), nil
-	case strings.HasSuffix(lower, ".raw"):
-		return NewRAWSource(path), nil
+		strings.HasSuffix(lower, ".raw.zst"),
+		strings.HasSuffix(lower, ".raw"):
+		return Detection{
+			Type:   types.ImageSourceRAW,
+			Reason: "local file with " + lower[strings.LastIndex(lower, ".raw"):] + " extension",
+		}, nil
 	case strings.Contains(base, ".raw"):
 		// Handle cases like "talos.raw.xz" when only checking extension
-		return NewRAWSource(path), nil
+		return Detection{Type: types.ImageSourceRAW, Reason: "local file name contains .raw"}, nil
 	default:
-		return nil, errors.Newf("unknown image format: %s (expected .iso, .raw, .raw.xz, .raw.zst, or container reference)", path)
+		return Detection{}, errors.Newf("unknown image format: %s (expected .iso, .raw, .raw.xz, .raw.zst, or container reference)", path)
+	}
+}
+
+// prober is implemented by remote sources that can cheaply check that the
+// image exists before any heavy download starts.
+type prober interface {
+	Probe() error
+}
+
+// Probe checks that the image behind src is reachable (registry manifest or
+// HTTP HEAD). Local sources are always considered reachable.
+func Probe(src types.ImageSource) error {
+	if p, ok := src.(prober); ok {
+		return p.Probe()
 	}
+	return nil
 }
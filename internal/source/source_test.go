@@ -1,6 +1,8 @@
 package source
 
 import (
+	"archive/tar"
+	"bytes"
 	"os"
 	"path/filepath"
 	"testing"
@@ -110,6 +112,26 @@ func TestDetectImageSourceType(t *testing.T) {
 			input:    "some-image:tag",
 			wantType: types.ImageSourceContainer,
 		},
+		{
+			name:    "nonexistent relative path",
+			input:   "./metal.raw.xz",
+			wantErr: true,
+		},
+		{
+			name:    "nonexistent absolute path",
+			input:   filepath.Join(tmpDir, "missing"),
+			wantErr: true,
+		},
+		{
+			name:    "nonexistent file with image extension",
+			input:   "metal-amd64.iso",
+			wantErr: true,
+		},
+		{
+			name:    "nonexistent split part",
+			input:   "metal-amd64.raw.xz.part0",
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -175,3 +197,144 @@ func TestImageSource_Reference(t *testing.T) {
 		})
 	}
 }
+
+func TestDetect(t *testing.T) {
+	tmpDir := t.TempDir()
+	rawXZFile := filepath.Join(tmpDir, "talos.raw.xz")
+	if err := os.WriteFile(rawXZFile, []byte("test"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		ref         string
+		wantType    types.ImageSourceType
+		wantRemote  bool
+		wantReason  string
+		wantHandler string
+	}{
+		{
+			name:        "container fallback",
+			ref:         "ghcr.io/cozystack/cozystack/talos:v1.11.6",
+			wantType:    types.ImageSourceContainer,
+			wantReason:  "not a URL and no such local file, assuming container reference",
+			wantHandler: "container registry pull (crane)",
+		},
+		{
+			name:        "extensionless URL",
+			ref:         "https://example.com/talos/latest",
+			wantType:    types.ImageSourceContainer,
+			wantReason:  "URL path has no .iso/.raw[.xz|.gz|.zst] extension, assuming container reference",
+			wantHandler: "container registry pull (crane)",
+		},
+		{
+			name:        "remote compressed RAW",
+			ref:         "https://example.com/metal-amd64.raw.zst",
+			wantType:    types.ImageSourceRAW,
+			wantRemote:  true,
+			wantReason:  "URL path ends with .raw.zst",
+			wantHandler: "HTTP download, then RAW disk image",
+		},
+		{
+			name:        "stdin",
+			ref:         "-",
+			wantType:    types.ImageSourceRAW,
+			wantReason:  "\"-\" reads a RAW image (possibly compressed) from stdin",
+			wantHandler: "RAW disk image streamed from stdin",
+		},
+		{
+			name:        "local compressed RAW",
+			ref:         rawXZFile,
+			wantType:    types.ImageSourceRAW,
+			wantReason:  "local file with .raw.xz extension",
+			wantHandler: "RAW disk image",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Detect(tt.ref)
+			if err != nil {
+				t.Fatalf("Detect error: %v", err)
+			}
+			if d.Type != tt.wantType || d.Remote != tt.wantRemote {
+				t.Errorf("Detect() = {%v, remote=%v}, want {%v, remote=%v}", d.Type, d.Remote, tt.wantType, tt.wantRemote)
+			}
+			if d.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", d.Reason, tt.wantReason)
+			}
+			if d.Handler() != tt.wantHandler {
+				t.Errorf("Handler() = %q, want %q", d.Handler(), tt.wantHandler)
+			}
+		})
+	}
+}
+
+func TestDetect_ForcedType(t *testing.T) {
+	tmpDir := t.TempDir()
+	image := filepath.Join(tmpDir, "image")
+	if err := os.WriteFile(image, make([]byte, 0x9000), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	iso := filepath.Join(tmpDir, "image.iso")
+	data := make([]byte, 0x9000)
+	copy(data[0x8001:], "CD001")
+	if err := os.WriteFile(iso, data, 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	writeParts(t, tmpDir, map[string]int{"disk.img.part0": 16, "disk.img.part1": 8})
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	tw.WriteHeader(&tar.Header{Name: "metal-amd64.raw", Mode: 0o644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("disk"))
+	tw.Close()
+	tarPath := filepath.Join(tmpDir, "metal-amd64.tar")
+	if err := os.WriteFile(tarPath, tarball.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ForcedType = nil })
+
+	tests := []struct {
+		name        string
+		forced      types.ImageSourceType
+		ref         string
+		wantRemote  bool
+		wantArchive string
+		wantErr     bool
+	}{
+		{"extensionless URL as RAW", types.ImageSourceRAW, "https://example.com/talos/latest", true, "", false},
+		{"container tag with .raw", types.ImageSourceContainer, "registry.example.com/talos:v1.raw", false, "", false},
+		{"extensionless local RAW", types.ImageSourceRAW, image, false, "", false},
+		{"local ISO", types.ImageSourceISO, iso, false, "", false},
+		{"local file as ISO without signature", types.ImageSourceISO, image, false, "", true},
+		{"missing local file", types.ImageSourceRAW, filepath.Join(tmpDir, "missing.raw"), false, "", true},
+		{"local file as container", types.ImageSourceContainer, image, false, "", true},
+		{"stdin as ISO", types.ImageSourceISO, "-", false, "", true},
+		{"split part as RAW", types.ImageSourceRAW, filepath.Join(tmpDir, "disk.img.part0"), false, ArchiveSplit, false},
+		{"split image name as RAW", types.ImageSourceRAW, filepath.Join(tmpDir, "disk.img"), false, ArchiveSplit, false},
+		{"tar archive as ISO", types.ImageSourceISO, tarPath, false, ArchiveTar, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ForcedType = &tt.forced
+			d, err := Detect(tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Detect(%q) as %s succeeded, want an error", tt.ref, tt.forced)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Detect error: %v", err)
+			}
+			if d.Type != tt.forced || d.Remote != tt.wantRemote || d.Archive != tt.wantArchive {
+				t.Errorf("Detect() = {%v, remote=%v, archive=%q}, want {%v, remote=%v, archive=%q}",
+					d.Type, d.Remote, d.Archive, tt.forced, tt.wantRemote, tt.wantArchive)
+			}
+		})
+	}
+
+	if _, err := ParseType("oci-layout"); err == nil {
+		t.Error("ParseType() accepted an unsupported type")
+	}
+}
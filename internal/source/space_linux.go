@@ -0,0 +1,11 @@
+//go:build linux
+
+package source
+
+import "github.com/cozystack/boot-to-talos/internal/host"
+
+// checkFreeSpace fails if the filesystem holding dir has less than needed
+// bytes available.
+func checkFreeSpace(dir string, needed uint64) error {
+	return host.CheckFreeSpace(dir, needed)
+}
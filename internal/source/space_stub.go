@@ -0,0 +1,8 @@
+//go:build !linux
+
+package source
+
+// checkFreeSpace does not check anything on non-Linux platforms.
+func checkFreeSpace(string, uint64) error {
+	return nil
+}
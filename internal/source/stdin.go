@@ -0,0 +1,95 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"github.com/cozystack/boot-to-talos/internal/types"
+	"github.com/cozystack/boot-to-talos/internal/uki"
+)
+
+// StdinRef is the image reference that reads a RAW image from standard input.
+const StdinRef = "-"
+
+// StdinSource implements ImageSource for a RAW disk image piped to standard
+// input. The stream cannot be seeked, so it can only be written to the disk
+// as is: boot mode is not supported.
+type StdinSource struct {
+	r io.Reader
+}
+
+// NewStdinSource creates a new StdinSource reading os.Stdin.
+func NewStdinSource() *StdinSource {
+	return &StdinSource{r: os.Stdin}
+}
+
+func (s *StdinSource) Type() types.ImageSourceType {
+	return types.ImageSourceRAW
+}
+
+func (s *StdinSource) Reference() string {
+	return "stdin"
+}
+
+// GetBootAssets fails: extracting the UKI needs random access to the image.
+func (s *StdinSource) GetBootAssets(_ *uki.TrustStore) (*types.BootAssets, error) {
+	return nil, errors.New("boot mode needs a seekable image, stdin is not supported: save the image to a file first")
+}
+
+// GetInstallAssets returns the decompressed stdin stream for direct writing
+// to disk. The compression is detected from the magic bytes.
+func (s *StdinSource) GetInstallAssets(tmpDir string, sizeGiB uint64) (*types.InstallAssets, error) {
+	reader, err := decompressStream(s.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "open RAW image from stdin")
+	}
+	return &types.InstallAssets{
+		DiskImage:     reader,
+		DiskImageSize: -1,
+	}, nil
+}
+
+func (s *StdinSource) Close() error {
+	return nil
+}
+
+//nolint:gochecknoglobals
+var gzMagic = []byte{0x1f, 0x8b}
+
+// decompressStream detects xz, gzip or zstd compression of r from its magic
+// bytes and returns a reader of the decompressed data.
+func decompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(xzHeaderMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, xzHeaderMagic):
+		reader, err := xz.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "xz reader")
+		}
+		return io.NopCloser(reader), nil
+	case bytes.HasPrefix(magic, gzMagic):
+		reader, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "gzip reader")
+		}
+		return reader, nil
+	case len(magic) >= 4 && binary.LittleEndian.Uint32(magic) == zstdMagic:
+		reader, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "zstd reader")
+		}
+		return reader.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
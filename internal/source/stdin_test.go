@@ -0,0 +1,81 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestDecompressStream(t *testing.T) {
+	payload := []byte(strings.Repeat("talos raw image ", 64))
+
+	compress := map[string]func(t *testing.T) []byte{
+		"plain": func(*testing.T) []byte { return payload },
+		"gzip": func(t *testing.T) []byte {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			return buf.Bytes()
+		},
+		"xz": func(t *testing.T) []byte {
+			var buf bytes.Buffer
+			w, err := xz.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			return buf.Bytes()
+		},
+		"zstd": func(t *testing.T) []byte {
+			var buf bytes.Buffer
+			w, err := zstd.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			return buf.Bytes()
+		},
+	}
+
+	for name, fn := range compress {
+		t.Run(name, func(t *testing.T) {
+			src := &StdinSource{r: bytes.NewReader(fn(t))}
+			assets, err := src.GetInstallAssets("", 0)
+			if err != nil {
+				t.Fatalf("GetInstallAssets error: %v", err)
+			}
+			defer assets.Close()
+			if assets.DiskImageSize != -1 {
+				t.Errorf("DiskImageSize = %d, want -1", assets.DiskImageSize)
+			}
+			got, err := io.ReadAll(assets.DiskImage)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("decompressed %d bytes, want the %d byte payload", len(got), len(payload))
+			}
+		})
+	}
+}
+
+func TestStdinSource_BootUnsupported(t *testing.T) {
+	src := &StdinSource{r: strings.NewReader("")}
+	if _, err := src.GetBootAssets(nil); err == nil || !strings.Contains(err.Error(), "seekable") {
+		t.Errorf("GetBootAssets error = %v, want seekable image error", err)
+	}
+}
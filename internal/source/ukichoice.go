@@ -0,0 +1,94 @@
+package source
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/cli"
+)
+
+// UKIPrefer is a pattern, matched like UKIGlob, that picks the UKI when the
+// image has several: for the pulled architecture in container images, or at
+// the removable-media path of an ISO or RAW image. Without it, the user is
+// asked to choose, or with -yes the run fails listing them.
+//
+//nolint:gochecknoglobals
+var UKIPrefer string
+
+// errSeveralUKIs marks the error of chooseUKI when it cannot pick a UKI.
+//
+//nolint:gochecknoglobals
+var errSeveralUKIs = errors.New("several UKIs")
+
+// ukiCandidate is a UKI found in an image.
+type ukiCandidate struct {
+	name    string // path in the image
+	path    string // extracted file, or path on the ESP or ISO
+	version string // kernel release, "" if unknown
+}
+
+// matchUKIPattern matches the path in the image against pattern, only its
+// file name if pattern has no slash.
+func matchUKIPattern(pattern, name string) bool {
+	name = strings.TrimPrefix(name, "./")
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// chooseUKI returns the UKI to boot among ukis, which must not be empty.
+// With several, those not matching UKIPrefer are dropped. If that leaves
+// more than one, the user picks one, or with -yes an error marked with
+// errSeveralUKIs lists them. found is the number of UKIs in the image, for
+// the log.
+//
+//nolint:forbidigo
+func chooseUKI(ukis []ukiCandidate, found int) (ukiCandidate, error) {
+	if len(ukis) > 1 && UKIPrefer != "" {
+		preferred := slices.DeleteFunc(slices.Clone(ukis), func(c ukiCandidate) bool { return !matchUKIPattern(UKIPrefer, c.name) })
+		if len(preferred) == 0 {
+			log.Printf("warning: no UKI matches -uki-prefer %q", UKIPrefer)
+		} else {
+			ukis = preferred
+		}
+	}
+	if len(ukis) == 1 {
+		if found > 1 {
+			log.Printf("using UKI %s of the %d found in the image", ukis[0].name, found)
+		}
+		return ukis[0], nil
+	}
+
+	names := make([]string, len(ukis))
+	fmt.Println("\nThe image has several UKIs:")
+	for i, c := range ukis {
+		names[i] = c.name
+		version := c.version
+		if version == "" {
+			version = "unknown kernel version"
+		}
+		fmt.Printf("  %d. %s (%s)\n", i+1, c.name, version)
+	}
+	if cli.YesFlag {
+		return ukiCandidate{}, errors.Mark(errors.Newf("%d UKIs found in image (%s); pick one with -uki-prefer",
+			len(ukis), strings.Join(names, ", ")), errSeveralUKIs)
+	}
+	for {
+		answer := cli.Ask("UKI to boot", "1")
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(ukis) {
+			return ukis[n-1], nil
+		}
+		if i := slices.Index(names, answer); i >= 0 {
+			return ukis[i], nil
+		}
+		fmt.Printf("Enter a number from 1 to %d.\n", len(ukis))
+	}
+}
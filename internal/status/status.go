@@ -0,0 +1,166 @@
+// Package status publishes the phase and progress of a run as JSON over
+// HTTP, for orchestration that watches a remote install without a console.
+// The state is kept whether or not the server runs, so callers update it
+// unconditionally.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Phases of a run.
+const (
+	PhaseStarting   = "starting"   // validating flags and asking questions
+	PhasePulling    = "pulling"    // downloading or pulling the image
+	PhaseExtracting = "extracting" // unpacking the image or its boot assets
+	PhaseInstalling = "installing" // running the Talos installer
+	PhaseCopying    = "copying"    // writing the image to the target disks
+	PhaseBooting    = "booting"    // loading the Talos kernel with kexec
+	PhaseRebooting  = "rebooting"
+	PhaseDone       = "done" // an image file was written, nothing to reboot
+	PhaseFailed     = "failed"
+)
+
+// failureGrace is how long a failed run keeps answering, so that a poller
+// sees the error before the process exits. Runs nobody polled exit at once.
+const failureGrace = 30 * time.Second
+
+// Status is the JSON document served.
+type Status struct {
+	Phase   string    `json:"phase"`
+	Detail  string    `json:"detail,omitempty"`
+	Percent int       `json:"percent"` // -1 while unknown
+	Done    int64     `json:"done,omitempty"`
+	Total   int64     `json:"total,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+//nolint:gochecknoglobals
+var (
+	mu      sync.Mutex
+	current = Status{Phase: PhaseStarting, Percent: -1, Updated: time.Now()}
+	server  *http.Server
+	polled  bool          // the status was served at least once
+	served  chan struct{} // closed once the failed status was served
+)
+
+// Start serves the status on addr, e.g. ":8080", at / and /status until
+// Stop.
+func Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listen")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handle)
+	mu.Lock()
+	server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	srv := server
+	mu.Unlock()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("warning: status server: %v", err)
+		}
+	}()
+	log.Printf("serving status on http://%s/status", ln.Addr())
+	return nil
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+	s := Get()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s)
+	mu.Lock()
+	polled = true
+	if s.Phase == PhaseFailed && served != nil {
+		close(served)
+		served = nil
+	}
+	mu.Unlock()
+}
+
+// Get returns the current status.
+func Get() Status {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// SetPhase starts phase, with progress unknown until Progress is called.
+func SetPhase(phase, detail string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if current.Phase == PhaseFailed {
+		return
+	}
+	current = Status{Phase: phase, Detail: detail, Percent: -1, Updated: time.Now()}
+}
+
+// Progress reports done of total units (bytes, layers) of the current
+// phase. A total of 0 or less leaves the percentage unknown.
+func Progress(done, total int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	current.Done, current.Total = done, total
+	current.Percent = -1
+	if total > 0 {
+		current.Percent = int(done * 100 / total)
+	}
+	current.Updated = time.Now()
+}
+
+// Fail records that the run failed with msg. If the server runs and was
+// polled before, it waits until the failure was served once or failureGrace
+// passed, so that the caller can exit right after.
+func Fail(msg string) {
+	mu.Lock()
+	current.Phase = PhaseFailed
+	current.Error = msg
+	current.Updated = time.Now()
+	if server == nil {
+		mu.Unlock()
+		return
+	}
+	if !polled {
+		mu.Unlock()
+		Stop()
+		return
+	}
+	done := make(chan struct{})
+	served = done
+	mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(failureGrace):
+	}
+	Stop()
+}
+
+// Stop shuts the server down, letting requests in flight finish. It is
+// called before the reboot.
+func Stop() {
+	mu.Lock()
+	srv := server
+	server = nil
+	mu.Unlock()
+	if srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(ctx)
+}
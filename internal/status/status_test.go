@@ -0,0 +1,96 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func reset() {
+	mu.Lock()
+	current = Status{Phase: PhaseStarting, Percent: -1}
+	polled = false
+	mu.Unlock()
+}
+
+func TestHandle(t *testing.T) {
+	reset()
+	SetPhase(PhaseCopying, "/dev/sda")
+	Progress(512, 2048)
+
+	rec := httptest.NewRecorder()
+	handle(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status = %d", rec.Code)
+	}
+	var got Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if got.Phase != PhaseCopying || got.Detail != "/dev/sda" || got.Percent != 25 || got.Done != 512 || got.Total != 2048 {
+		t.Errorf("status = %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handle(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /other = %d, want 404", rec.Code)
+	}
+}
+
+func TestFail(t *testing.T) {
+	reset()
+	SetPhase(PhasePulling, "ghcr.io/siderolabs/installer")
+	Progress(1, 0)
+	if got := Get(); got.Percent != -1 {
+		t.Errorf("Percent with unknown total = %d, want -1", got.Percent)
+	}
+
+	Fail("pull failed")
+	SetPhase(PhaseRebooting, "")
+	if got := Get(); got.Phase != PhaseFailed || got.Error != "pull failed" {
+		t.Errorf("status after Fail = %+v", got)
+	}
+}
+
+func TestFail_NotPolled(t *testing.T) {
+	reset()
+	if err := Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	Fail("no one is watching")
+	if elapsed := time.Since(start); elapsed > failureGrace/2 {
+		t.Errorf("Fail waited %s without a client", elapsed)
+	}
+}
+
+func TestFail_Polled(t *testing.T) {
+	reset()
+	if err := Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	done := make(chan struct{})
+	go func() {
+		Fail("install failed")
+		close(done)
+	}()
+	for Get().Phase != PhaseFailed {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("Fail returned before the failure was served")
+	case <-time.After(50 * time.Millisecond):
+	}
+	handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/status", nil))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fail did not return after the failure was served")
+	}
+}
@@ -0,0 +1,45 @@
+// Package tempdir selects where temporary files and directories are created.
+package tempdir
+
+import "os"
+
+// EnvVar names the environment variable that sets the temp directory when
+// -temp-dir is not given. It takes precedence over TMPDIR.
+const EnvVar = "BOOT_TO_TALOS_TMPDIR"
+
+//nolint:gochecknoglobals
+var dir string
+
+// Set sets the temp directory. An empty dir restores the default.
+func Set(d string) {
+	dir = d
+}
+
+// Dir returns the directory temporary files are created in: the directory
+// passed to Set, else $BOOT_TO_TALOS_TMPDIR, else the system default ($TMPDIR
+// or /tmp).
+func Dir() string {
+	if dir != "" {
+		return dir
+	}
+	if d := os.Getenv(EnvVar); d != "" {
+		return d
+	}
+	return os.TempDir()
+}
+
+// Configured reports whether the temp directory was chosen explicitly with
+// Set or the environment variable.
+func Configured() bool {
+	return dir != "" || os.Getenv(EnvVar) != ""
+}
+
+// MkdirTemp creates a new temporary directory in Dir, see os.MkdirTemp.
+func MkdirTemp(pattern string) (string, error) {
+	return os.MkdirTemp(Dir(), pattern)
+}
+
+// CreateTemp creates a new temporary file in Dir, see os.CreateTemp.
+func CreateTemp(pattern string) (*os.File, error) {
+	return os.CreateTemp(Dir(), pattern)
+}
@@ -0,0 +1,48 @@
+package tempdir
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	t.Cleanup(func() { Set("") })
+	t.Setenv("TMPDIR", "/sys-tmp")
+
+	tests := []struct {
+		name string
+		set  string
+		env  string
+		want string
+	}{
+		{"default", "", "", "/sys-tmp"},
+		{"env", "", "/env-tmp", "/env-tmp"},
+		{"flag wins", "/flag-tmp", "/env-tmp", "/flag-tmp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvVar, tt.env)
+			Set(tt.set)
+			if got := Dir(); got != tt.want {
+				t.Errorf("Dir() = %q, want %q", got, tt.want)
+			}
+			if got, want := Configured(), tt.set != "" || tt.env != ""; got != want {
+				t.Errorf("Configured() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMkdirTemp(t *testing.T) {
+	t.Cleanup(func() { Set("") })
+	base := t.TempDir()
+	Set(base)
+
+	d, err := MkdirTemp("test-*")
+	if err != nil {
+		t.Fatalf("MkdirTemp error: %v", err)
+	}
+	if _, err := os.Stat(d); err != nil || len(d) <= len(base) || d[:len(base)] != base {
+		t.Errorf("MkdirTemp created %q, want a directory in %q", d, base)
+	}
+}
@@ -1,14 +1,20 @@
 package testutil
 
 import (
+	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+
+	"github.com/cozystack/boot-to-talos/internal/fat"
 )
 
 // EFISystemPartitionGUID is the GUID for EFI System Partition.
@@ -17,19 +23,27 @@ const EFISystemPartitionGUID = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
 // CreateTestRAWImage creates a RAW disk image with GPT partition table and EFI partition.
 // The EFI partition contains the provided files map (path -> content).
 func CreateTestRAWImage(path string, sizeMB int64, files map[string][]byte) error {
+	return CreateTestRAWImageWithSectorSize(path, sizeMB, 512, files)
+}
+
+// CreateTestRAWImageWithSectorSize is like CreateTestRAWImage but lays out the
+// GPT for the given logical sector size (512 or 4096).
+func CreateTestRAWImageWithSectorSize(path string, sizeMB int64, sectorSize int64, files map[string][]byte) error {
 	// Create disk image
-	diskImg, err := diskfs.Create(path, sizeMB*1024*1024, diskfs.SectorSizeDefault)
+	diskImg, err := diskfs.Create(path, sizeMB*1024*1024, diskfs.SectorSize(sectorSize))
 	if err != nil {
 		return err
 	}
 
-	// Create GPT partition table
+	// Create GPT partition table, first partition aligned to 1 MiB
 	table := &gpt.Table{
-		ProtectiveMBR: true,
+		ProtectiveMBR:      true,
+		LogicalSectorSize:  int(sectorSize),
+		PhysicalSectorSize: int(sectorSize),
 		Partitions: []*gpt.Partition{
 			{
-				Start: 2048,
-				End:   uint64(sizeMB*1024*1024/512) - 34,
+				Start: uint64(1024 * 1024 / sectorSize),
+				End:   uint64(sizeMB*1024*1024/sectorSize) - 34,
 				Type:  gpt.EFISystemPartition,
 				Name:  "EFI",
 			},
@@ -47,11 +61,173 @@ func CreateTestRAWImage(path string, sizeMB int64, files map[string][]byte) erro
 		VolumeLabel: "EFI",
 	}
 
-	fs, err := diskImg.CreateFilesystem(spec)
+	var fs filesystem.FileSystem
+	if sectorSize == 512 {
+		fs, err = diskImg.CreateFilesystem(spec)
+	} else {
+		// go-diskfs only creates FAT32 with 512-byte logical sectors; format
+		// the partition with 4Kn sectors as mkfs.vfat does and open it the
+		// way boot-to-talos does.
+		part := table.Partitions[0]
+		start := int64(part.Start) * sectorSize
+		size := int64(part.End-part.Start+1) * sectorSize
+		if err = formatFAT32(diskImg.Backend, start, size, sectorSize, spec.VolumeLabel); err != nil {
+			return err
+		}
+		fs, err = fat.Open(diskImg.Backend, size, start)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeFiles(fs, files)
+}
+
+// CreateTestTwoESPImage creates a GPT disk image with two partitions typed
+// as ESP, as left by some image builders. Partition 1 only has an empty /EFI
+// directory, partition 2 holds the provided files.
+func CreateTestTwoESPImage(path string, sizeMB int64, files map[string][]byte) error {
+	diskImg, err := diskfs.Create(path, sizeMB*1024*1024, diskfs.SectorSize512)
+	if err != nil {
+		return err
+	}
+
+	half := uint64(sizeMB * 1024 * 1024 / 512 / 2)
+	table := &gpt.Table{
+		ProtectiveMBR:      true,
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: half - 1, Type: gpt.Type(EFISystemPartitionGUID), Name: "DATA"},
+			{Start: half, End: uint64(sizeMB*1024*1024/512) - 34, Type: gpt.Type(EFISystemPartitionGUID), Name: "EFI"},
+		},
+	}
+	if err := diskImg.Partition(table); err != nil {
+		return err
+	}
+
+	data, err := diskImg.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32, VolumeLabel: "DATA"})
 	if err != nil {
 		return err
 	}
+	if err := data.Mkdir("/EFI"); err != nil {
+		return err
+	}
+
+	fs, err := diskImg.CreateFilesystem(disk.FilesystemSpec{Partition: 2, FSType: filesystem.TypeFat32, VolumeLabel: "EFI"})
+	if err != nil {
+		return err
+	}
+	return writeFiles(fs, files)
+}
+
+// CreateTestMBRImage creates a RAW disk image with an MBR partition table
+// holding one FAT32 partition of the given type with the provided files, as
+// image builders that mark the ESP as 0xef or as plain FAT do.
+func CreateTestMBRImage(path string, sizeMB int64, partType mbr.Type, files map[string][]byte) error {
+	diskImg, err := diskfs.Create(path, sizeMB*1024*1024, diskfs.SectorSize512)
+	if err != nil {
+		return err
+	}
+
+	table := &mbr.Table{
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		Partitions: []*mbr.Partition{
+			{
+				Start: 2048,
+				Size:  uint32(sizeMB*1024*1024/512) - 2048,
+				Type:  partType,
+			},
+		},
+	}
+	if err := diskImg.Partition(table); err != nil {
+		return err
+	}
+
+	fs, err := diskImg.CreateFilesystem(disk.FilesystemSpec{
+		Partition:   1,
+		FSType:      filesystem.TypeFat32,
+		VolumeLabel: "EFI",
+	})
+	if err != nil {
+		return err
+	}
+	return writeFiles(fs, files)
+}
+
+// formatFAT32 creates an empty FAT32 filesystem of size bytes at start with
+// sectorSize-byte logical sectors and one sector per cluster, laid out as
+// mkfs.vfat lays it out: 32 reserved sectors with the FS information sector
+// in sector 1 and the backup boot sector in sector 6, then two FATs.
+func formatFAT32(b backend.Storage, start, size, sectorSize int64, label string) error {
+	const reserved, backup = 32, 6
+
+	total := size / sectorSize
+	perFAT := ((total-reserved)*4 + sectorSize - 1) / sectorSize
+
+	boot := make([]byte, sectorSize)
+	copy(boot, []byte{0xeb, 0x58, 0x90})
+	copy(boot[0x03:], "mkfs.fat")
+	binary.LittleEndian.PutUint16(boot[0x0b:], uint16(sectorSize))
+	boot[0x0d] = 1
+	binary.LittleEndian.PutUint16(boot[0x0e:], reserved)
+	boot[0x10] = 2
+	boot[0x15] = 0xf8
+	binary.LittleEndian.PutUint16(boot[0x18:], 32)
+	binary.LittleEndian.PutUint16(boot[0x1a:], 64)
+	binary.LittleEndian.PutUint32(boot[0x1c:], uint32(start/sectorSize))
+	binary.LittleEndian.PutUint32(boot[0x20:], uint32(total))
+	binary.LittleEndian.PutUint32(boot[0x24:], uint32(perFAT))
+	binary.LittleEndian.PutUint32(boot[0x2c:], 2)
+	binary.LittleEndian.PutUint16(boot[0x30:], 1)
+	binary.LittleEndian.PutUint16(boot[0x32:], backup)
+	boot[0x40] = 0x80
+	boot[0x42] = 0x29
+	binary.LittleEndian.PutUint32(boot[0x43:], 0x12345678)
+	copy(boot[0x47:], fmt.Sprintf("%-11s", label))
+	copy(boot[0x52:], "FAT32   ")
+	boot[0x1fe], boot[0x1ff] = 0x55, 0xaa
+
+	fsInfo := make([]byte, sectorSize)
+	copy(fsInfo, "RRaA")
+	copy(fsInfo[0x1e4:], "rrAa")
+	binary.LittleEndian.PutUint32(fsInfo[0x1e8:], 0xffffffff)
+	binary.LittleEndian.PutUint32(fsInfo[0x1ec:], 2)
+	fsInfo[0x1fe], fsInfo[0x1ff] = 0x55, 0xaa
+
+	// Media descriptor, end-of-chain marker and the root directory cluster.
+	table := make([]byte, sectorSize)
+	binary.LittleEndian.PutUint32(table[0:], 0x0ffffff8)
+	binary.LittleEndian.PutUint32(table[4:], 0x0fffffff)
+	binary.LittleEndian.PutUint32(table[8:], 0x0fffffff)
+
+	w, err := b.Writable()
+	if err != nil {
+		return err
+	}
+	for _, s := range []struct {
+		sector int64
+		data   []byte
+	}{
+		{0, boot},
+		{1, fsInfo},
+		{backup, boot},
+		{backup + 1, fsInfo},
+		{reserved, table},
+		{reserved + perFAT, table},
+		{reserved + 2*perFAT, make([]byte, sectorSize)}, // root directory
+	} {
+		if _, err := w.WriteAt(s.data, start+s.sector*sectorSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// writeFiles writes files (path -> content) to fs, creating parent
+// directories.
+func writeFiles(fs filesystem.FileSystem, files map[string][]byte) error {
 	// Write files to filesystem
 	for filePath, content := range files {
 		// Create parent directories recursively
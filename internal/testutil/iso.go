@@ -20,6 +20,9 @@ func CreateTestISOImage(path string, files map[string][]byte) error {
 		return err
 	}
 
+	// ISO9660 needs 2048-byte logical blocks
+	diskImg.LogicalBlocksize = 2048
+
 	// Create ISO9660 filesystem
 	spec := disk.FilesystemSpec{
 		Partition:   0, // ISO doesn't use partitions
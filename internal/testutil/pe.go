@@ -1,8 +1,10 @@
 package testutil
 
 import (
+	"debug/pe"
 	"encoding/binary"
 	"os"
+	"runtime"
 	"sort"
 )
 
@@ -37,19 +39,21 @@ func CreateMinimalPEFile(path string, sections map[string][]byte) error {
 	// COFF header (20 bytes)
 	numSections := uint16(len(sections))
 	coffHeader := make([]byte, 20)
-	binary.LittleEndian.PutUint16(coffHeader[0:], 0x8664)      // AMD64
+	binary.LittleEndian.PutUint16(coffHeader[0:], peMachine()) // host architecture
 	binary.LittleEndian.PutUint16(coffHeader[2:], numSections) // Number of sections
-	binary.LittleEndian.PutUint16(coffHeader[16:], 112)        // Optional header size
+	binary.LittleEndian.PutUint16(coffHeader[16:], 240)        // Optional header size
 	binary.LittleEndian.PutUint16(coffHeader[18:], 0x22)       // Characteristics
 
-	// Optional header (112 bytes for PE32+)
-	optHeader := make([]byte, 112)
+	// Optional header (112 bytes for PE32+ and 16 empty data directories)
+	optHeader := make([]byte, 240)
 	binary.LittleEndian.PutUint16(optHeader[0:], 0x20b) // PE32+ magic
 	optHeader[2] = 1                                    // Major linker version
 
 	// Calculate data start (aligned to 512)
-	headerSize := 64 + 4 + 20 + 112 + int(numSections)*40
+	headerSize := 64 + 4 + 20 + 240 + int(numSections)*40
 	dataStart := ((headerSize + 511) / 512) * 512
+	binary.LittleEndian.PutUint32(optHeader[60:], uint32(dataStart)) // SizeOfHeaders
+	binary.LittleEndian.PutUint32(optHeader[108:], 16)               // NumberOfRvaAndSizes
 
 	// Section headers (40 bytes each)
 	sectionHeaders := make([]byte, 0, int(numSections)*40)
@@ -120,3 +124,11 @@ func CreateMinimalPEFile(path string, sections map[string][]byte) error {
 
 	return nil
 }
+
+// peMachine returns the PE machine type of the host architecture.
+func peMachine() uint16 {
+	if runtime.GOARCH == "arm64" {
+		return pe.IMAGE_FILE_MACHINE_ARM64
+	}
+	return pe.IMAGE_FILE_MACHINE_AMD64
+}
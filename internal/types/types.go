@@ -4,6 +4,8 @@ import (
 	"io"
 
 	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/boot-to-talos/internal/uki"
 )
 
 // ImageSourceType represents the type of image source.
@@ -30,9 +32,10 @@ func (t ImageSourceType) String() string {
 
 // BootAssets contains kernel, initrd, and cmdline for kexec boot.
 type BootAssets struct {
-	Kernel  io.ReadCloser
-	Initrd  io.ReadCloser
-	Cmdline string
+	Kernel    io.ReadCloser
+	Initrd    io.ReadCloser
+	Cmdline   string
+	OSRelease string // os-release of a UKI, "" for a separate kernel
 }
 
 // Close releases all resources.
@@ -61,7 +64,7 @@ type InstallAssets struct {
 
 	// For RAW: reader for disk image (possibly decompressed)
 	DiskImage     io.ReadCloser
-	DiskImageSize int64
+	DiskImageSize int64 // uncompressed size, -1 if unknown
 
 	// Cleanup function to call after installation
 	Cleanup func() error
@@ -94,8 +97,9 @@ type ImageSource interface {
 	// Reference returns the original image reference (path, URL, or container ref).
 	Reference() string
 
-	// GetBootAssets returns kernel, initrd, and cmdline for kexec boot.
-	GetBootAssets() (*BootAssets, error)
+	// GetBootAssets returns kernel, initrd, and cmdline for kexec boot. With
+	// trust set, only a UKI signed by a key it trusts is returned.
+	GetBootAssets(trust *uki.TrustStore) (*BootAssets, error)
 
 	// GetInstallAssets returns data needed for installation.
 	GetInstallAssets(tmpDir string, sizeGiB uint64) (*InstallAssets, error)
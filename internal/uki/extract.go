@@ -3,6 +3,7 @@ package uki
 import (
 	"debug/pe"
 	"io"
+	"os"
 	"strings"
 
 	"github.com/cockroachdb/errors"
@@ -15,17 +16,61 @@ type AssetInfo struct {
 	Kernel  io.Reader
 	Initrd  io.Reader
 	Cmdline io.Reader
+	OSRel   io.Reader // nil if the UKI has no .osrel section
 }
 
-// Extract extracts kernel, initrd and cmdline from UKI file.
-func Extract(ukiPath string) (*AssetInfo, error) {
-	peFile, err := pe.Open(ukiPath)
+// OSRelease returns the os-release file embedded in the UKI, "" if it has
+// none or it cannot be read.
+func (a *AssetInfo) OSRelease() string {
+	if a.OSRel == nil {
+		return ""
+	}
+	data, err := io.ReadAll(a.OSRel)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
+
+// Extract extracts kernel, initrd and cmdline from UKI file. With trust set,
+// the UKI must be signed by a key it trusts. The file is opened once, so the
+// sections are read from the image that was validated and verified even if
+// ukiPath is replaced meanwhile.
+func Extract(ukiPath string, trust *TrustStore) (*AssetInfo, error) {
+	f, err := os.Open(ukiPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open PE file")
+	}
+	assetInfo, err := extract(f, ukiPath, trust)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return assetInfo, nil
+}
+
+// extract is Extract of the open UKI f, which the returned AssetInfo closes.
+func extract(f *os.File, ukiPath string, trust *TrustStore) (*AssetInfo, error) {
+	if err := validate(f, ukiPath); err != nil {
+		return nil, err
+	}
+	if trust != nil {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, errors.Wrap(err, "stat PE file")
+		}
+		if err := trust.Verify(f, info.Size()); err != nil {
+			return nil, err
+		}
+	}
+
+	peFile, err := pe.NewFile(f)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open PE file")
 	}
 
 	assetInfo := &AssetInfo{
-		Closer: peFile,
+		Closer: f,
 	}
 
 	sectionMap := map[string]*io.Reader{
@@ -42,15 +87,68 @@ func Extract(ukiPath string) (*AssetInfo, error) {
 			// Use VirtualSize instead of Size to exclude alignment
 			*reader = io.LimitReader(section.Open(), int64(section.VirtualSize))
 		}
+		if sectionName == ".osrel" && assetInfo.OSRel == nil {
+			assetInfo.OSRel = io.LimitReader(section.Open(), int64(section.VirtualSize))
+		}
 	}
 
 	// Check that all required sections are found
 	for name, reader := range sectionMap {
 		if *reader == nil {
-			peFile.Close()
 			return nil, errors.Newf("%s not found in PE file", name)
 		}
 	}
 
 	return assetInfo, nil
 }
+
+// Uname returns the kernel release in the .uname section of the UKI at
+// ukiPath, "" if it has none or is not a PE file.
+func Uname(ukiPath string) string {
+	f, err := os.Open(ukiPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return UnameFrom(f)
+}
+
+// UnameFrom is Uname for a UKI read from r.
+func UnameFrom(r io.ReaderAt) string {
+	peFile, err := pe.NewFile(r)
+	if err != nil {
+		return ""
+	}
+	defer peFile.Close()
+	section := peFile.Section(".uname")
+	if section == nil {
+		return ""
+	}
+	data, err := io.ReadAll(io.LimitReader(section.Open(), int64(section.VirtualSize)))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
+
+// ReaderAt returns an io.ReaderAt over rs, for files that only support
+// seeking, like the ones on the FAT and ISO filesystems of go-diskfs. It is
+// not safe for concurrent use.
+func ReaderAt(rs io.ReadSeeker) io.ReaderAt {
+	return &seekReaderAt{rs}
+}
+
+type seekReaderAt struct {
+	rs io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.rs, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -11,7 +11,18 @@ import (
 
 // ReadCmdline reads the kernel command line from a UKI PE file.
 func ReadCmdline(ukiPath string) (string, error) {
-	peFile, err := pe.Open(ukiPath)
+	f, err := os.Open(ukiPath)
+	if err != nil {
+		return "", errors.Wrap(err, "open PE file")
+	}
+	defer f.Close()
+
+	return CmdlineFrom(f)
+}
+
+// CmdlineFrom reads the kernel command line from a UKI PE file in r.
+func CmdlineFrom(r io.ReaderAt) (string, error) {
+	peFile, err := pe.NewFile(r)
 	if err != nil {
 		return "", errors.Wrap(err, "open PE file")
 	}
@@ -0,0 +1,436 @@
+package uki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	_ "crypto/sha512" // SHA-384 and SHA-512 Authenticode hashes
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"log"
+	"math/big"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+)
+
+// SignatureDB is the content of a UEFI signature database: X.509
+// certificates and SHA-256 Authenticode hashes of images.
+type SignatureDB struct {
+	Certs  []*x509.Certificate
+	Hashes [][sha256.Size]byte
+}
+
+// TrustStore holds the allowed (db) and forbidden (dbx) signature databases
+// the firmware verifies images against.
+type TrustStore struct {
+	DB  SignatureDB
+	DBX SignatureDB
+}
+
+// Verify checks the Authenticode signature of the UKI of size bytes in r like
+// the firmware does with Secure Boot enabled: the image hash must not be in
+// dbx, and either the hash is in db or the image is signed by a certificate
+// that is in db or chains to one, with no certificate of the chain in dbx.
+// Callers read the image from the same r, so that what runs is what was
+// verified.
+func (t *TrustStore) Verify(r io.ReaderAt, size int64) error {
+	trustedBy, err := t.verify(r, size)
+	if err != nil {
+		return errors.Wrap(err, "UKI signature")
+	}
+	log.Printf("verified UKI signature: %s", trustedBy)
+	return nil
+}
+
+// verify returns what the image in r is trusted by.
+func (t *TrustStore) verify(r io.ReaderAt, size int64) (string, error) {
+	img, err := parseSignedPE(r, size)
+	if err != nil {
+		return "", err
+	}
+
+	sha, err := img.digest(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(t.DBX.Hashes, [sha256.Size]byte(sha)) {
+		return "", errors.New("image hash is revoked in dbx")
+	}
+	if slices.Contains(t.DB.Hashes, [sha256.Size]byte(sha)) {
+		return "image hash is in db", nil
+	}
+	if len(img.certs) == 0 {
+		return "", errors.New("image is not signed")
+	}
+
+	var errs error
+	for _, sig := range img.certs {
+		trustedBy, err := t.verifySignature(img, sig)
+		if err == nil {
+			return trustedBy, nil
+		}
+		errs = errors.CombineErrors(errs, err)
+	}
+	return "", errs
+}
+
+// verifySignature verifies one PKCS#7 signature of img.
+func (t *TrustStore) verifySignature(img *signedPE, der []byte) (string, error) {
+	sd, err := parseSignedData(der)
+	if err != nil {
+		return "", err
+	}
+	if len(sd.SignerInfos) != 1 {
+		return "", errors.Newf("signature has %d signers, expected 1", len(sd.SignerInfos))
+	}
+	signer := sd.SignerInfos[0]
+
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &raw); err != nil {
+		return "", errors.Wrap(err, "parse SpcIndirectDataContent")
+	}
+	var content spcIndirectDataContent
+	if _, err := asn1.Unmarshal(raw.FullBytes, &content); err != nil {
+		return "", errors.Wrap(err, "parse SpcIndirectDataContent")
+	}
+	imageHash, ok := hashByOID(content.MessageDigest.DigestAlgorithm.Algorithm)
+	if !ok {
+		return "", errors.Newf("unsupported digest algorithm %s", content.MessageDigest.DigestAlgorithm.Algorithm)
+	}
+	digest, err := img.digest(imageHash)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(digest, content.MessageDigest.Digest) {
+		return "", errors.New("image hash does not match the signed hash, the image was modified after signing")
+	}
+
+	// The signer signs its authenticated attributes, which include the hash
+	// of the content octets of SpcIndirectDataContent, both with its own
+	// digest algorithm.
+	hash, ok := hashByOID(signer.DigestAlgorithm.Algorithm)
+	if !ok {
+		return "", errors.Newf("unsupported signer digest algorithm %s", signer.DigestAlgorithm.Algorithm)
+	}
+	signedDigest, err := signer.messageDigest()
+	if err != nil {
+		return "", err
+	}
+	h := hash.New()
+	h.Write(raw.Bytes)
+	if !bytes.Equal(h.Sum(nil), signedDigest) {
+		return "", errors.New("signed attributes do not match the signed content")
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return "", errors.Wrap(err, "parse signature certificates")
+	}
+	cert := signer.certificate(certs)
+	if cert == nil {
+		return "", errors.New("signer certificate is not included in the signature")
+	}
+	algo, ok := signatureAlgorithm(cert.PublicKeyAlgorithm, hash)
+	if !ok {
+		return "", errors.Newf("unsupported signature algorithm %s with %s", cert.PublicKeyAlgorithm, hash)
+	}
+	attrs := slices.Clone(signer.AuthenticatedAttributes.FullBytes)
+	attrs[0] = 0x31 // signed as a SET OF, not as the [0] IMPLICIT field
+	if err := cert.CheckSignature(algo, attrs, signer.EncryptedDigest); err != nil {
+		return "", errors.Wrapf(err, "signature of %s", cert.Subject)
+	}
+
+	return t.trustChain(cert, certs)
+}
+
+// trustChain checks that cert is in db or chains to a certificate in db,
+// and that no certificate of the chain is in dbx.
+func (t *TrustStore) trustChain(cert *x509.Certificate, certs []*x509.Certificate) (string, error) {
+	if c := findCert(t.DBX.Certs, cert); c != nil {
+		return "", errors.Newf("signer %s is revoked in dbx", cert.Subject)
+	}
+	if c := findCert(t.DB.Certs, cert); c != nil {
+		return "signed by " + cert.Subject.String() + ", which is in db", nil
+	}
+
+	roots := x509.NewCertPool()
+	for _, c := range t.DB.Certs {
+		roots.AddCert(c)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+	// The firmware does not check validity periods.
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   cert.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return "", errors.Newf("signer %s does not chain to a certificate in db: %v", cert.Subject, err)
+	}
+	for _, chain := range chains {
+		revoked := slices.ContainsFunc(chain, func(c *x509.Certificate) bool { return findCert(t.DBX.Certs, c) != nil })
+		if !revoked {
+			root := chain[len(chain)-1]
+			return "signed by " + cert.Subject.String() + ", trusted via " + root.Subject.String() + " in db", nil
+		}
+	}
+	return "", errors.Newf("the certificate chain of %s is revoked in dbx", cert.Subject)
+}
+
+// findCert returns the certificate in certs equal to c.
+func findCert(certs []*x509.Certificate, c *x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		if cert.Equal(c) {
+			return cert
+		}
+	}
+	return nil
+}
+
+// signedPE is a PE image with the ranges the Authenticode hash covers and
+// the PKCS#7 signatures of its certificate table.
+type signedPE struct {
+	r      io.ReaderAt
+	ranges [][2]int64 // [offset, end) ranges hashed in order
+	certs  [][]byte   // DER PKCS#7 SignedData of each WIN_CERTIFICATE
+}
+
+// Authenticode certificate table constants.
+const (
+	winCertRevision2      = 0x0200
+	winCertTypePKCSSigned = 0x0002
+)
+
+// parseSignedPE reads the layout and certificate table of the PE image in r.
+func parseSignedPE(r io.ReaderAt, size int64) (*signedPE, error) {
+	f, err := pe.NewFile(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, errors.Wrap(err, "open PE file")
+	}
+	defer f.Close()
+	oh, ok := f.OptionalHeader.(*pe.OptionalHeader64)
+	if !ok {
+		return nil, errors.New("not a PE32+ image")
+	}
+	if oh.NumberOfRvaAndSizes <= pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+		return nil, errors.New("PE image has no certificate table entry")
+	}
+
+	checksumOff, _ := peChecksum(r)
+	if checksumOff == 0 {
+		return nil, errors.New("cannot locate the PE optional header")
+	}
+	// The data directories follow the 112 fixed bytes of the PE32+ optional
+	// header; each entry is 8 bytes.
+	secDirOff := checksumOff - peChecksumOffset + 112 + 8*pe.IMAGE_DIRECTORY_ENTRY_SECURITY
+	sec := oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+	certOff, certSize := int64(sec.VirtualAddress), int64(sec.Size)
+	if certSize != 0 && (certOff < int64(oh.SizeOfHeaders) || certOff+certSize > size) {
+		return nil, errors.New("certificate table lies outside the image")
+	}
+
+	// Authenticode: the headers without CheckSum and the certificate table
+	// entry, the sections in file order, then any data after the sections
+	// but the certificate table.
+	img := &signedPE{r: r}
+	headers := int64(oh.SizeOfHeaders)
+	img.ranges = append(img.ranges,
+		[2]int64{0, checksumOff},
+		[2]int64{checksumOff + 4, secDirOff},
+		[2]int64{secDirOff + 8, headers})
+	sections := slices.Clone(f.Sections)
+	slices.SortFunc(sections, func(a, b *pe.Section) int { return int(a.Offset) - int(b.Offset) })
+	hashed := headers
+	for _, s := range sections {
+		if s.Size == 0 {
+			continue
+		}
+		img.ranges = append(img.ranges, [2]int64{int64(s.Offset), int64(s.Offset) + int64(s.Size)})
+		hashed += int64(s.Size)
+	}
+	if rest := size - certSize - hashed; rest > 0 {
+		img.ranges = append(img.ranges, [2]int64{hashed, hashed + rest})
+	}
+
+	for off := certOff; off+8 <= certOff+certSize; {
+		var hdr [8]byte
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return nil, errors.Wrap(err, "read certificate table")
+		}
+		length := int64(binary.LittleEndian.Uint32(hdr[0:4]))
+		if length < 8 || off+length > certOff+certSize {
+			return nil, errors.New("malformed certificate table")
+		}
+		if binary.LittleEndian.Uint16(hdr[4:6]) == winCertRevision2 && binary.LittleEndian.Uint16(hdr[6:8]) == winCertTypePKCSSigned {
+			der := make([]byte, length-8)
+			if _, err := r.ReadAt(der, off+8); err != nil {
+				return nil, errors.Wrap(err, "read certificate table")
+			}
+			img.certs = append(img.certs, der)
+		}
+		off += (length + 7) &^ 7
+	}
+	return img, nil
+}
+
+// digest computes the Authenticode hash of the image.
+func (p *signedPE) digest(hash crypto.Hash) ([]byte, error) {
+	h := hash.New()
+	for _, rg := range p.ranges {
+		if rg[1] <= rg[0] {
+			continue
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(p.r, rg[0], rg[1]-rg[0])); err != nil {
+			return nil, errors.Wrap(err, "hash PE image")
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// PKCS#7 and Authenticode ASN.1 structures (RFC 2315, Authenticode PE).
+type (
+	// Content is the [0] EXPLICIT wrapper: encoding/asn1 does not unwrap
+	// explicit tags of RawValue fields.
+	contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"optional,tag:0"`
+	}
+
+	signedData struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      contentInfo
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+		CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+		SignerInfos      []signerInfo  `asn1:"set"`
+	}
+
+	issuerAndSerial struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}
+
+	signerInfo struct {
+		Version                   int
+		IssuerAndSerialNumber     issuerAndSerial
+		DigestAlgorithm           pkix.AlgorithmIdentifier
+		AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+		DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+		EncryptedDigest           []byte
+		UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+	}
+
+	attribute struct {
+		Type   asn1.ObjectIdentifier
+		Values asn1.RawValue
+	}
+
+	spcIndirectDataContent struct {
+		Data          asn1.RawValue
+		MessageDigest digestInfo
+	}
+
+	digestInfo struct {
+		DigestAlgorithm pkix.AlgorithmIdentifier
+		Digest          []byte
+	}
+)
+
+//nolint:gochecknoglobals
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSpcIndirect   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+
+	hashOIDs = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+)
+
+// parseSignedData parses a PKCS#7 SignedData with Authenticode content.
+func parseSignedData(der []byte) (*signedData, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, errors.Wrap(err, "parse PKCS#7 signature")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.Newf("signature is %s, not PKCS#7 SignedData", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, errors.Wrap(err, "parse PKCS#7 SignedData")
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirect) {
+		return nil, errors.Newf("signed content is %s, not Authenticode", sd.ContentInfo.ContentType)
+	}
+	return &sd, nil
+}
+
+// messageDigest returns the messageDigest authenticated attribute.
+func (s *signerInfo) messageDigest() ([]byte, error) {
+	if len(s.AuthenticatedAttributes.FullBytes) == 0 {
+		return nil, errors.New("signature has no authenticated attributes")
+	}
+	for rest := s.AuthenticatedAttributes.Bytes; len(rest) > 0; {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, errors.Wrap(err, "parse authenticated attributes")
+		}
+		if !attr.Type.Equal(oidMessageDigest) {
+			continue
+		}
+		var digest []byte
+		if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+			return nil, errors.Wrap(err, "parse messageDigest attribute")
+		}
+		return digest, nil
+	}
+	return nil, errors.New("signature has no messageDigest attribute")
+}
+
+// certificate returns the certificate of the signer among certs.
+func (s *signerInfo) certificate(certs []*x509.Certificate) *x509.Certificate {
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, s.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(s.IssuerAndSerialNumber.SerialNumber) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// hashByOID returns the hash of a digest algorithm OID.
+func hashByOID(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	h, ok := hashOIDs[oid.String()]
+	return h, ok
+}
+
+// signatureAlgorithm returns the x509 signature algorithm for a key type and
+// hash.
+func signatureAlgorithm(key x509.PublicKeyAlgorithm, hash crypto.Hash) (x509.SignatureAlgorithm, bool) {
+	algos := map[x509.PublicKeyAlgorithm]map[crypto.Hash]x509.SignatureAlgorithm{
+		x509.RSA: {
+			crypto.SHA1: x509.SHA1WithRSA, crypto.SHA256: x509.SHA256WithRSA,
+			crypto.SHA384: x509.SHA384WithRSA, crypto.SHA512: x509.SHA512WithRSA,
+		},
+		x509.ECDSA: {
+			crypto.SHA1: x509.ECDSAWithSHA1, crypto.SHA256: x509.ECDSAWithSHA256,
+			crypto.SHA384: x509.ECDSAWithSHA384, crypto.SHA512: x509.ECDSAWithSHA512,
+		},
+	}
+	algo, ok := algos[key][hash]
+	return algo, ok
+}
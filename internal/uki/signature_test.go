@@ -0,0 +1,311 @@
+package uki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozystack/boot-to-talos/internal/testutil"
+)
+
+// testSigner is a certificate and its key.
+type testSigner struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestSigner creates a certificate issued by parent, or a self-signed CA
+// if parent is nil.
+func newTestSigner(t *testing.T, name string, parent *testSigner) *testSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	issuer, issuerKey := tmpl, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{cert: cert, key: key}
+}
+
+// Marshal-side PKCS#7 structures: the parse-side ones have optional fields
+// encoding/asn1 cannot omit.
+type (
+	testContentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+
+	testSignedData struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      testContentInfo
+		Certificates     asn1.RawValue
+		SignerInfos      []testSignerInfo `asn1:"set"`
+	}
+
+	testSignerInfo struct {
+		Version                   int
+		IssuerAndSerialNumber     issuerAndSerial
+		DigestAlgorithm           pkix.AlgorithmIdentifier
+		AuthenticatedAttributes   asn1.RawValue
+		DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+		EncryptedDigest           []byte
+	}
+)
+
+// explicit wraps der in a context-specific constructed tag 0.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// authenticodeSign returns a PKCS#7 Authenticode signature of the SHA-256
+// digest by signer, which signs with signerHash, with the signer's issuers
+// included.
+func authenticodeSign(t *testing.T, digest []byte, signerHash crypto.Hash, signer *testSigner, chain ...*testSigner) []byte {
+	t.Helper()
+	sha256Algo := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, Parameters: asn1.NullRawValue}
+	signerAlgo := sha256Algo
+	if signerHash == crypto.SHA384 {
+		signerAlgo = pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, Parameters: asn1.NullRawValue}
+	}
+	sum := func(b []byte) []byte {
+		h := signerHash.New()
+		h.Write(b)
+		return h.Sum(nil)
+	}
+
+	spcPEImageData := mustMarshal(t, struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}})
+	content := mustMarshal(t, spcIndirectDataContent{
+		Data:          asn1.RawValue{FullBytes: spcPEImageData},
+		MessageDigest: digestInfo{DigestAlgorithm: sha256Algo, Digest: digest},
+	})
+	var inner asn1.RawValue
+	if _, err := asn1.Unmarshal(content, &inner); err != nil {
+		t.Fatal(err)
+	}
+	contentDigest := sum(inner.Bytes)
+
+	set := func(der []byte) asn1.RawValue {
+		return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+	}
+	attrs := append(
+		mustMarshal(t, attribute{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}, Values: set(mustMarshal(t, oidSpcIndirect))}),
+		mustMarshal(t, attribute{Type: oidMessageDigest, Values: set(mustMarshal(t, contentDigest))})...)
+	sig, err := ecdsa.SignASN1(rand.Reader, signer.key, sum(mustMarshal(t, set(attrs))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := signer.cert.Raw
+	for _, c := range chain {
+		certs = append(bytes.Clone(certs), c.cert.Raw...)
+	}
+	sd := mustMarshal(t, testSignedData{
+		Version:          1,
+		DigestAlgorithms: set(mustMarshal(t, signerAlgo)),
+		ContentInfo:      testContentInfo{ContentType: oidSpcIndirect, Content: explicit(content)},
+		Certificates:     explicit(certs),
+		SignerInfos: []testSignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: signer.cert.RawIssuer},
+				SerialNumber: signer.cert.SerialNumber,
+			},
+			DigestAlgorithm:           signerAlgo,
+			AuthenticatedAttributes:   explicit(attrs),
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}},
+			EncryptedDigest:           sig,
+		}},
+	})
+	return mustMarshal(t, testContentInfo{ContentType: oidSignedData, Content: explicit(sd)})
+}
+
+// createSignedUKI writes a test UKI and returns its contents signed by
+// signer, or unsigned if signer is nil, and its SHA-256 Authenticode hash.
+func createSignedUKI(t *testing.T, signer *testSigner, chain ...*testSigner) ([]byte, [sha256.Size]byte) {
+	t.Helper()
+	return createSignedUKIWith(t, crypto.SHA256, signer, chain...)
+}
+
+// createSignedUKIWith is createSignedUKI with the signer signing with
+// signerHash.
+func createSignedUKIWith(t *testing.T, signerHash crypto.Hash, signer *testSigner, chain ...*testSigner) ([]byte, [sha256.Size]byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.efi")
+	if err := testutil.CreateTestUKIFile(path, "console=ttyS0", "kernel", "initrd"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := parseSignedPE(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.digest(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer == nil {
+		return data, [sha256.Size]byte(digest)
+	}
+
+	der := authenticodeSign(t, digest, signerHash, signer, chain...)
+	entry := make([]byte, (8+len(der)+7)&^7)
+	binary.LittleEndian.PutUint32(entry[0:], uint32(8+len(der)))
+	binary.LittleEndian.PutUint16(entry[4:], winCertRevision2)
+	binary.LittleEndian.PutUint16(entry[6:], winCertTypePKCSSigned)
+	copy(entry[8:], der)
+
+	// Certificate table entry of the data directories.
+	secDirOff := 64 + 4 + 20 + 112 + 8*4
+	binary.LittleEndian.PutUint32(data[secDirOff:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(data[secDirOff+4:], uint32(len(entry)))
+	return append(data, entry...), [sha256.Size]byte(digest)
+}
+
+func TestTrustStoreVerify(t *testing.T) {
+	ca := newTestSigner(t, "Test CA", nil)
+	signer := newTestSigner(t, "Test UKI signing", ca)
+	other := newTestSigner(t, "Other CA", nil)
+
+	signed, hash := createSignedUKI(t, signer, ca)
+	signed384, _ := createSignedUKIWith(t, crypto.SHA384, signer, ca)
+	unsigned, _ := createSignedUKI(t, nil)
+	modified := bytes.Clone(signed)
+	i := bytes.Index(modified, []byte("console=ttyS0"))
+	copy(modified[i:], "console=ttyS1")
+
+	tests := []struct {
+		name    string
+		image   []byte
+		trust   *TrustStore
+		wantErr string
+	}{
+		{
+			name:  "signer chains to db",
+			image: signed,
+			trust: &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{other.cert, ca.cert}}},
+		},
+		{
+			name:  "signer digest differs from the image hash",
+			image: signed384,
+			trust: &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{ca.cert}}},
+		},
+		{
+			name:  "signer in db",
+			image: signed,
+			trust: &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{signer.cert}}},
+		},
+		{
+			name:  "hash in db",
+			image: unsigned,
+			trust: &TrustStore{DB: SignatureDB{Hashes: [][sha256.Size]byte{hash}}},
+		},
+		{
+			name:    "untrusted signer",
+			image:   signed,
+			trust:   &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{other.cert}}},
+			wantErr: "does not chain",
+		},
+		{
+			name:    "CA in dbx",
+			image:   signed,
+			trust:   &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{ca.cert}}, DBX: SignatureDB{Certs: []*x509.Certificate{ca.cert}}},
+			wantErr: "revoked in dbx",
+		},
+		{
+			name:    "hash in dbx",
+			image:   signed,
+			trust:   &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{ca.cert}}, DBX: SignatureDB{Hashes: [][sha256.Size]byte{hash}}},
+			wantErr: "revoked in dbx",
+		},
+		{
+			name:    "unsigned",
+			image:   unsigned,
+			trust:   &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{ca.cert}}},
+			wantErr: "not signed",
+		},
+		{
+			name:    "modified after signing",
+			image:   modified,
+			trust:   &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{ca.cert}}},
+			wantErr: "modified after signing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.trust.verify(bytes.NewReader(tt.image), int64(len(tt.image)))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtract_Trust(t *testing.T) {
+	ca := newTestSigner(t, "Test CA", nil)
+	signed, _ := createSignedUKI(t, newTestSigner(t, "Test UKI signing", ca), ca)
+	path := filepath.Join(t.TempDir(), "signed.efi")
+	if err := os.WriteFile(path, signed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	trust := &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{ca.cert}}}
+	if _, err := Extract(path, trust); err != nil {
+		t.Fatalf("Extract() of a trusted UKI error = %v", err)
+	}
+
+	trust = &TrustStore{DB: SignatureDB{Certs: []*x509.Certificate{newTestSigner(t, "Other CA", nil).cert}}}
+	if _, err := Extract(path, trust); err == nil {
+		t.Fatal("Extract() of an untrusted UKI succeeded")
+	}
+}
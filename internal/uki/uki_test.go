@@ -2,9 +2,11 @@ package uki
 
 import (
 	"debug/pe"
+	"encoding/binary"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -96,7 +98,7 @@ func TestExtract(t *testing.T) {
 		t.Fatalf("Failed to create test UKI: %v", err)
 	}
 
-	assets, err := Extract(ukiPath)
+	assets, err := Extract(ukiPath, nil)
 	if err != nil {
 		t.Fatalf("Extract error: %v", err)
 	}
@@ -139,7 +141,7 @@ func TestExtract_InvalidFile(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	_, err := Extract(ukiPath)
+	_, err := Extract(ukiPath, nil)
 	if err == nil {
 		t.Error("Expected error for invalid PE file")
 	}
@@ -157,7 +159,7 @@ func TestExtract_MissingSection(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	_, err := Extract(ukiPath)
+	_, err := Extract(ukiPath, nil)
 	if err == nil {
 		t.Error("Expected error for missing sections")
 	}
@@ -232,7 +234,7 @@ func TestPatchCmdline_NoCmdlineSection(t *testing.T) {
 }
 
 func TestExtract_NonExistentFile(t *testing.T) {
-	_, err := Extract("/nonexistent/path/to/file.efi")
+	_, err := Extract("/nonexistent/path/to/file.efi", nil)
 	if err == nil {
 		t.Error("Expected error for nonexistent file")
 	}
@@ -261,3 +263,92 @@ func createTestUKIFile(path, cmdline string) error {
 func createMinimalPEFile(path string, sections map[string][]byte) error {
 	return testutil.CreateMinimalPEFile(path, sections)
 }
+
+func TestExtract_Truncated(t *testing.T) {
+	tmpDir := t.TempDir()
+	ukiPath := filepath.Join(tmpDir, "truncated.efi")
+
+	if err := createTestUKIFile(ukiPath, "console=ttyS0"); err != nil {
+		t.Fatalf("Failed to create test UKI: %v", err)
+	}
+	info, err := os.Stat(ukiPath)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if err := os.Truncate(ukiPath, info.Size()-100); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	_, err = Extract(ukiPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("Extract() error = %v, want truncation error", err)
+	}
+}
+
+func TestValidate_Checksum(t *testing.T) {
+	tmpDir := t.TempDir()
+	ukiPath := filepath.Join(tmpDir, "test.efi")
+
+	if err := createTestUKIFile(ukiPath, "console=ttyS0"); err != nil {
+		t.Fatalf("Failed to create test UKI: %v", err)
+	}
+
+	f, err := os.OpenFile(ukiPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	off, stored := peChecksum(f)
+	if off == 0 || stored != 0 {
+		t.Fatalf("peChecksum() = %d, 0x%x; want field offset and zero checksum", off, stored)
+	}
+	sum, err := computePEChecksum(f, info.Size(), off)
+	if err != nil {
+		t.Fatalf("computePEChecksum error: %v", err)
+	}
+
+	// The stored checksum is excluded from the computation.
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], sum)
+	if _, err := f.WriteAt(buf[:], off); err != nil {
+		t.Fatalf("write checksum: %v", err)
+	}
+	if again, _ := computePEChecksum(f, info.Size(), off); again != sum {
+		t.Errorf("checksum after storing it = 0x%x, want 0x%x", again, sum)
+	}
+
+	if err := Validate(ukiPath); err != nil {
+		t.Errorf("Validate() error: %v", err)
+	}
+}
+
+func TestValidate_ForeignMachine(t *testing.T) {
+	ukiPath := filepath.Join(t.TempDir(), "foreign.efi")
+	if err := createTestUKIFile(ukiPath, "console=ttyS0"); err != nil {
+		t.Fatalf("Failed to create test UKI: %v", err)
+	}
+
+	// COFF Machine field follows the PE signature at offset 64.
+	foreign := []byte{0x64, 0xaa} // ARM64
+	if runtime.GOARCH == "arm64" {
+		foreign = []byte{0x64, 0x86} // AMD64
+	}
+	f, err := os.OpenFile(ukiPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := f.WriteAt(foreign, 68); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	f.Close()
+
+	err = Validate(ukiPath)
+	if err == nil || !strings.Contains(err.Error(), "PE machine") {
+		t.Errorf("Validate() error = %v, want machine mismatch", err)
+	}
+}
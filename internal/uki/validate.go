@@ -0,0 +1,183 @@
+package uki
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/cockroachdb/errors"
+)
+
+// peChecksumOffset is the offset of CheckSum in the PE optional header, the
+// same for PE32 and PE32+.
+const peChecksumOffset = 64
+
+// Validate checks that the PE file at ukiPath is a PE32+ image for the host
+// architecture and complete: every section and the certificate table must lie
+// within the file. A mismatching PE checksum is only logged, as some signing
+// tools do not update it.
+func Validate(ukiPath string) error {
+	f, err := os.Open(ukiPath)
+	if err != nil {
+		return errors.Wrap(err, "open PE file")
+	}
+	defer f.Close()
+	return validate(f, ukiPath)
+}
+
+// validate is Validate of the open UKI f.
+func validate(f *os.File, ukiPath string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat PE file")
+	}
+
+	peFile, err := pe.NewFile(f)
+	if err != nil {
+		return errors.Wrap(err, "failed to open PE file")
+	}
+	defer peFile.Close()
+
+	if err := checkMachine(peFile, runtime.GOARCH); err != nil {
+		return err
+	}
+
+	if expected := peFileSize(peFile); expected > info.Size() {
+		return errors.Newf("UKI appears truncated: expected %d bytes, file is %d", expected, info.Size())
+	}
+
+	checksumOff, stored := peChecksum(f)
+	if stored != 0 {
+		if computed, err := computePEChecksum(f, info.Size(), checksumOff); err != nil {
+			return err
+		} else if computed != stored {
+			log.Printf("warning: %s: PE checksum mismatch (header 0x%08x, computed 0x%08x)", ukiPath, stored, computed)
+		}
+	}
+	return nil
+}
+
+// peMachines maps Go architectures to PE machine types.
+//
+//nolint:gochecknoglobals
+var peMachines = map[string]uint16{
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+// checkMachine verifies that f is a 64-bit PE image that runs on goarch.
+func checkMachine(f *pe.File, goarch string) error {
+	if _, ok := f.OptionalHeader.(*pe.OptionalHeader64); !ok {
+		return errors.New("UKI is not a 64-bit (PE32+) image")
+	}
+	if want := peMachines[goarch]; f.Machine != want {
+		return errors.Newf("UKI is built for PE machine 0x%04x, this %s host needs 0x%04x", f.Machine, goarch, want)
+	}
+	return nil
+}
+
+// peFileSize returns the minimal file size implied by the section table and
+// the certificate table.
+func peFileSize(f *pe.File) int64 {
+	var end int64
+	for _, s := range f.Sections {
+		if e := int64(s.Offset) + int64(s.Size); e > end {
+			end = e
+		}
+	}
+
+	// The certificate table entry holds a file offset, not an RVA.
+	var dirs []pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs = oh.DataDirectory[:oh.NumberOfRvaAndSizes]
+	case *pe.OptionalHeader64:
+		dirs = oh.DataDirectory[:oh.NumberOfRvaAndSizes]
+	}
+	if len(dirs) > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+		sec := dirs[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		if e := int64(sec.VirtualAddress) + int64(sec.Size); sec.Size != 0 && e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// HeadSize is the length of the start of a PE file IsUKI needs: the headers
+// and the section table.
+const HeadSize = 64 << 10
+
+// IsUKI reports whether the PE image starting with head has a .linux
+// section, telling a UKI from the boot loaders stored next to it, such as
+// systemd-boot or shim.
+func IsUKI(head []byte) bool {
+	if len(head) < 0x40 || head[0] != 'M' || head[1] != 'Z' {
+		return false
+	}
+	off := int(binary.LittleEndian.Uint32(head[0x3c:]))
+	if off < 0 || off+24 > len(head) || string(head[off:off+4]) != "PE\x00\x00" {
+		return false
+	}
+	// Section headers of 40 bytes, starting with the 8-byte name, follow
+	// the optional header.
+	count := int(binary.LittleEndian.Uint16(head[off+6:]))
+	table := off + 24 + int(binary.LittleEndian.Uint16(head[off+20:]))
+	for i := range count {
+		s := table + i*40
+		if s+40 > len(head) {
+			return false
+		}
+		if string(bytes.TrimRight(head[s:s+8], "\x00")) == ".linux" {
+			return true
+		}
+	}
+	return false
+}
+
+// peChecksum returns the file offset and value of the CheckSum field.
+func peChecksum(r io.ReaderAt) (int64, uint32) {
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], 0x3c); err != nil {
+		return 0, 0
+	}
+	// PE signature (4 bytes) and COFF file header (20 bytes) precede the
+	// optional header.
+	off := int64(binary.LittleEndian.Uint32(buf[:])) + 4 + 20 + peChecksumOffset
+	if _, err := r.ReadAt(buf[:], off); err != nil {
+		return 0, 0
+	}
+	return off, binary.LittleEndian.Uint32(buf[:])
+}
+
+// computePEChecksum computes the PE image checksum: the 16-bit one's
+// complement style sum of the file with the CheckSum field taken as zero,
+// plus the file length.
+func computePEChecksum(r io.ReaderAt, size, checksumOff int64) (uint32, error) {
+	var sum uint64
+	buf := make([]byte, 1<<20)
+	for off := int64(0); off < size; {
+		n, err := r.ReadAt(buf, off)
+		if n == 0 && err != nil {
+			return 0, errors.Wrap(err, "read PE file")
+		}
+		chunk := buf[:n]
+		for i := 0; i < len(chunk); i += 2 {
+			pos := off + int64(i)
+			if pos >= checksumOff && pos < checksumOff+4 {
+				continue
+			}
+			word := uint64(chunk[i])
+			if i+1 < len(chunk) {
+				word |= uint64(chunk[i+1]) << 8
+			}
+			sum += word
+			sum = (sum & 0xffff) + (sum >> 16)
+		}
+		off += int64(n)
+	}
+	return uint32(sum&0xffff) + uint32(size), nil
+}